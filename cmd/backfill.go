@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+	"github.com/speshak/grizzl-e-monitor/internal/timescale"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+// Publish the whole transaction history of the account's stations to
+// TimescaleDB, checkpointing as it goes so it can be interrupted and resumed.
+//
+//	backfill [-station ID,...] [-rate N] [-page-size N] [-restart]
+//
+// Connect and TimescaleDB are configured with the same environment variables
+// as the monitor.
+func runBackfill(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	flags.SetOutput(out)
+	stations := flags.String("station", "", "Comma separated station IDs to backfill, rather than all of them")
+	rate := flags.Float64("rate", 2, "Most Connect API calls to make per second")
+	pageSize := flags.Int("page-size", monitor.DefaultBackfillPageSize, "Transactions to fetch per page")
+	concurrency := flags.Int("concurrency", monitor.DefaultFetchConcurrency, "Most transactions to fetch at once, within the -rate")
+	restart := flags.Bool("restart", false, "Start again from the beginning, ignoring checkpoints")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("-rate must be positive")
+	}
+	if *pageSize <= 0 {
+		return fmt.Errorf("-page-size must be positive")
+	}
+	if *concurrency <= 0 {
+		return fmt.Errorf("-concurrency must be positive")
+	}
+
+	config, timescaleConfig, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if timescaleConfig == nil {
+		return fmt.Errorf("backfill needs TIMESCALE_URL to publish to")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := config.NewConnectAPI()
+	client.SetRateLimit(time.Duration(float64(time.Second) / *rate))
+	defer client.Logout()
+
+	publisher := timescale.NewTimescalePublisher(timescaleConfig)
+	defer publisher.Close()
+
+	stationIds, err := backfillStations(ctx, client, *stations)
+	if err != nil {
+		return err
+	}
+
+	backfill := &monitor.Backfill{
+		Connect:     client,
+		Publisher:   publisher,
+		Checkpoints: publisher,
+		PageSize:    *pageSize,
+		Concurrency: *concurrency,
+		Restart:     *restart,
+		Progress:    out,
+	}
+
+	err = backfill.Run(ctx, stationIds)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(out, "Interrupted, run backfill again to resume")
+	}
+	return err
+}
+
+// The stations named on the command line, or all of the account's stations
+func backfillStations(ctx context.Context, client connect.ConnectAPI, stations string) ([]string, error) {
+	if stations != "" {
+		return strings.Split(stations, ","), nil
+	}
+
+	all, err := client.GetStations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting stations: %w", err)
+	}
+
+	var ids []string
+	for _, station := range all {
+		ids = append(ids, station.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackfillFlags(t *testing.T) {
+	for name, args := range map[string][]string{
+		"rate":        {"-rate", "0"},
+		"page size":   {"-page-size", "-1"},
+		"concurrency": {"-concurrency", "0"},
+		"unknown":     {"-nope"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, runBackfill(args, &bytes.Buffer{}))
+		})
+	}
+}
+
+func TestBackfillNeedsTimescale(t *testing.T) {
+	t.Setenv("GRIZZLE_CONNECT_API_USERNAME", "testuser")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD", "testpass")
+	t.Setenv("TIMESCALE_URL", "")
+
+	err := runBackfill(nil, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "TIMESCALE_URL")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+	"github.com/speshak/grizzl-e-monitor/internal/notify"
+	"github.com/speshak/grizzl-e-monitor/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// The optional config file named by GRIZZLE_CONFIG. Environment variables
+// take precedence over anything it sets. e.g.
+//
+//	connect:
+//	  username: me@example.com
+//	  password: secret
+//	  budget: 500
+//	  rate: 2
+//	  fetch_concurrency: 4
+//	polling:
+//	  charging: 30s
+//	  plugged_in: 3m
+//	  idle: 30m
+//	  session: 15s
+//	  stale_polls: 3
+//	stations:
+//	  CP001:
+//	    nickname: Garage
+//	    polling:
+//	      charging: 15s
+//	  CP002:
+//	    disabled: true
+//	publishers:
+//	  timescale:
+//	    url: postgres://monitor@db/grizzle
+//	notifications:
+//	  channels:
+//	    phone: {type: ntfy, topic: my-charger}
+//	  routes:
+//	    - events: [session_finished]
+//	      channels: [phone]
+type ConfigFile struct {
+	Connect       ConnectFileConfig            `yaml:"connect"`
+	Polling       PollingFileConfig            `yaml:"polling"`
+	Stations      map[string]StationFileConfig `yaml:"stations"`
+	Publishers    PublishersFileConfig         `yaml:"publishers"`
+	Notifications *notify.Config               `yaml:"notifications"`
+}
+
+type ConnectFileConfig struct {
+	APIURL   string `yaml:"api_url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Debug    bool   `yaml:"debug"`
+	// Connect API calls an hour
+	Budget int `yaml:"budget"`
+	// Connect API calls a second
+	Rate float64 `yaml:"rate"`
+	// Full transactions fetched at once
+	FetchConcurrency int `yaml:"fetch_concurrency"`
+}
+
+type IntervalsFileConfig struct {
+	Charging  time.Duration `yaml:"charging"`
+	PluggedIn time.Duration `yaml:"plugged_in"`
+	Idle      time.Duration `yaml:"idle"`
+	Session   time.Duration `yaml:"session"`
+}
+
+type PollingFileConfig struct {
+	IntervalsFileConfig `yaml:",inline"`
+	StalePolls          int `yaml:"stale_polls"`
+}
+
+type StationFileConfig struct {
+	Nickname string              `yaml:"nickname"`
+	Polling  IntervalsFileConfig `yaml:"polling"`
+	Disabled bool                `yaml:"disabled"`
+}
+
+type PublishersFileConfig struct {
+	Timescale struct {
+		URL string `yaml:"url"`
+	} `yaml:"timescale"`
+}
+
+// Load and validate the config file at a path. Without a path, or with an
+// empty file, nothing is set.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	file := &ConfigFile{}
+	if path == "" {
+		return file, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	defer f.Close()
+
+	// Misspelt settings would otherwise be ignored without a word
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	if err := file.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+func (c *ConfigFile) validate() error {
+	if c.Connect.Budget < 0 {
+		return fmt.Errorf("connect.budget must be a number of calls per hour")
+	}
+	if c.Connect.Rate < 0 {
+		return fmt.Errorf("connect.rate must be a number of calls per second")
+	}
+	if c.Connect.FetchConcurrency < 0 {
+		return fmt.Errorf("connect.fetch_concurrency must be a positive number of transactions")
+	}
+	if err := c.Polling.validate("polling"); err != nil {
+		return err
+	}
+	if c.Polling.StalePolls < 0 {
+		return fmt.Errorf("polling.stale_polls must be a positive number of poll intervals")
+	}
+
+	for id, station := range c.Stations {
+		if err := station.Polling.validate("stations." + id + ".polling"); err != nil {
+			return err
+		}
+	}
+
+	if c.Notifications != nil {
+		if _, err := c.Notifications.Notifier(); err != nil {
+			return fmt.Errorf("notifications: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c IntervalsFileConfig) validate(section string) error {
+	for name, interval := range map[string]time.Duration{
+		"charging":   c.Charging,
+		"plugged_in": c.PluggedIn,
+		"idle":       c.Idle,
+		"session":    c.Session,
+	} {
+		if interval < 0 {
+			return fmt.Errorf("%s.%s must be a positive duration, e.g. 30s or 5m", section, name)
+		}
+	}
+	return nil
+}
+
+// Unset intervals are left at zero, for the monitor's to be used
+func (c IntervalsFileConfig) intervals() monitor.PollIntervals {
+	return monitor.PollIntervals{
+		Charging:  c.Charging,
+		PluggedIn: c.PluggedIn,
+		Idle:      c.Idle,
+		Session:   c.Session,
+	}
+}
+
+func (c *ConfigFile) stations() map[string]monitor.StationConfig {
+	if len(c.Stations) == 0 {
+		return nil
+	}
+
+	stations := map[string]monitor.StationConfig{}
+	for id, station := range c.Stations {
+		stations[id] = monitor.StationConfig{
+			Nickname:      station.Nickname,
+			PollIntervals: station.Polling.intervals(),
+			Disabled:      station.Disabled,
+		}
+	}
+	return stations
+}
+
+// An environment variable, or the config file's value if it isn't set
+func setting(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// A secret from environment variables, or the config file's value if none
+// of them are set. Returns nil if neither has it.
+func secretSetting(name string, fallback string) secrets.Secret {
+	if secret := secrets.FromEnv(name); secret != nil {
+		return secret
+	}
+	if fallback != "" {
+		return secrets.NewLiteral(fallback)
+	}
+	return nil
+}
+
+// Load the notification rules from the config file's notifications section,
+// or the separate GRIZZLE_NOTIFY_CONFIG file. Without either there's no
+// notifier.
+func LoadNotifier() (*notify.Notifier, error) {
+	if path := os.Getenv("GRIZZLE_NOTIFY_CONFIG"); path != "" {
+		return notify.LoadNotifier(path)
+	}
+
+	file, err := LoadConfigFile(os.Getenv("GRIZZLE_CONFIG"))
+	if err != nil || file.Notifications == nil {
+		return nil, err
+	}
+	return file.Notifications.Notifier()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+	"github.com/speshak/grizzl-e-monitor/internal/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func secretValue(t *testing.T, secret secrets.Secret) string {
+	require.NotNil(t, secret)
+	value, err := secret.Value(context.Background())
+	require.NoError(t, err)
+	return value
+}
+
+func TestLoadConfig_File(t *testing.T) {
+	t.Setenv("GRIZZLE_CONNECT_API_URL", "")
+	t.Setenv("GRIZZLE_CONNECT_API_USERNAME", "")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD", "envpass")
+	t.Setenv("GRIZZLE_CONNECT_DEBUG", "")
+	t.Setenv("TIMESCALE_URL", "")
+	t.Setenv("GRIZZLE_CONFIG", writeConfigFile(t, `
+connect:
+  username: fileuser
+  password: filepass
+  budget: 500
+  rate: 2
+polling:
+  charging: 15s
+  stale_polls: 5
+stations:
+  CP001:
+    nickname: Garage
+    polling:
+      idle: 1h
+  CP002:
+    disabled: true
+publishers:
+  timescale:
+    url: postgres://monitor@db/grizzle
+`))
+
+	config, timescaleConfig, err := LoadConfig()
+	require.NoError(t, err)
+
+	assert.Equal(t, "fileuser", config.Username)
+	assert.Equal(t, "envpass", secretValue(t, config.Password), "Environment variables should take precedence over the file")
+	assert.Equal(t, DefaultConnectApiHost, config.APIHost)
+	assert.False(t, config.Debug)
+	assert.Equal(t, 500, config.APICallBudget)
+	assert.Equal(t, 2.0, config.APIRate)
+	assert.Equal(t, 5, config.StalePolls)
+	assert.Equal(t, 15*time.Second, config.PollIntervals.Charging)
+	assert.Equal(t, 3*time.Minute, config.PollIntervals.PluggedIn, "Intervals the file doesn't set should use the default")
+	assert.Equal(t, map[string]monitor.StationConfig{
+		"CP001": {Nickname: "Garage", PollIntervals: monitor.PollIntervals{Idle: time.Hour}},
+		"CP002": {Disabled: true},
+	}, config.Stations)
+
+	require.NotNil(t, timescaleConfig)
+	assert.Equal(t, "postgres://monitor@db/grizzle", secretValue(t, timescaleConfig.Url))
+}
+
+func TestLoadConfigFile_Invalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		err     string
+	}{
+		"unknown setting": {"polling:\n  charge: 15s\n", "field charge not found"},
+		"bad duration":    {"polling:\n  idle: soon\n", "error parsing config file"},
+		"negative budget": {"connect:\n  budget: -1\n", "connect.budget"},
+		"station interval": {
+			"stations:\n  CP001:\n    polling:\n      charging: -1s\n",
+			"stations.CP001.polling.charging",
+		},
+		"notification route": {
+			"notifications:\n  routes:\n    - channels: [phone]\n",
+			"notifications: route 1 refers to unknown channel phone",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeConfigFile(t, tc.content))
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestLoadConfigFile_Empty(t *testing.T) {
+	file, err := LoadConfigFile(writeConfigFile(t, ""))
+	require.NoError(t, err)
+	assert.Nil(t, file.stations())
+}
+
+func TestLoadNotifier_File(t *testing.T) {
+	t.Setenv("GRIZZLE_NOTIFY_CONFIG", "")
+	t.Setenv("GRIZZLE_CONFIG", writeConfigFile(t, `
+notifications:
+  channels:
+    phone: {type: ntfy, topic: my-charger}
+  routes:
+    - events: [session_finished]
+      channels: [phone]
+  cooldown: 5m
+`))
+
+	notifier, err := LoadNotifier()
+	require.NoError(t, err)
+	require.NotNil(t, notifier)
+	assert.Contains(t, notifier.Channels, "phone")
+	assert.Equal(t, 5*time.Minute, notifier.Cooldown)
+
+	t.Setenv("GRIZZLE_CONFIG", "")
+	notifier, err = LoadNotifier()
+	require.NoError(t, err)
+	assert.Nil(t, notifier, "There should be no notifier without notification config")
+}
+
+func TestLoadConfig_SecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	urlFile := filepath.Join(dir, "timescale_url")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("filepass\n"), 0600))
+	require.NoError(t, os.WriteFile(urlFile, []byte("postgres://monitor:secret@db/grizzle\n"), 0600))
+
+	t.Setenv("GRIZZLE_CONFIG", "")
+	t.Setenv("GRIZZLE_CONNECT_API_USERNAME", "testuser")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD", "")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD_FILE", passwordFile)
+	t.Setenv("TIMESCALE_URL", "")
+	t.Setenv("TIMESCALE_URL_FILE", urlFile)
+
+	config, timescaleConfig, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "filepass", secretValue(t, config.Password))
+	assert.Equal(t, "postgres://monitor:secret@db/grizzle", secretValue(t, timescaleConfig.Url))
+	assert.NotContains(t, fmt.Sprintf("%v %+v", config, timescaleConfig), "secret", "Credentials shouldn't be printable")
+
+	// A rotated password is read on the next login
+	require.NoError(t, os.WriteFile(passwordFile, []byte("rotated-pass\n"), 0600))
+	assert.Equal(t, "rotated-pass", secretValue(t, config.Password))
+
+	// A secret that can't be read stops the monitor starting
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	_, _, err = LoadConfig()
+	require.ErrorContains(t, err, "error reading GRIZZLE_CONNECT_API_PASSWORD")
+}
+
+func TestLoadConfig_SecretCommand(t *testing.T) {
+	t.Setenv("GRIZZLE_CONFIG", "")
+	t.Setenv("GRIZZLE_CONNECT_API_USERNAME", "testuser")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD", "")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD_FILE", "")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD_COMMAND", "echo commandpass")
+
+	config, _, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "commandpass", secretValue(t, config.Password))
+}
+
+func TestReloadConfig(t *testing.T) {
+	t.Setenv("GRIZZLE_CONNECT_API_USERNAME", "")
+	t.Setenv("GRIZZLE_CONNECT_API_PASSWORD", "")
+	t.Setenv("TIMESCALE_URL", "")
+	path := writeConfigFile(t, `
+connect:
+  username: fileuser
+  password: oldpass
+`)
+	t.Setenv("GRIZZLE_CONFIG", path)
+
+	config, _, err := LoadConfig()
+	require.NoError(t, err)
+	stationMonitor := monitor.NewStationMonitor(config)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+connect:
+  username: fileuser
+  password: newpass
+`), 0600))
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	reloadConfig(stationMonitor, nil)
+
+	assert.Equal(t, "newpass", secretValue(t, stationMonitor.Config.Password), "A changed password should be applied")
+	assert.NotContains(t, logs.String(), "TimescaleDB", "The publishers aren't reloaded, so their config shouldn't be reported")
+}
@@ -2,14 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+	"github.com/speshak/grizzl-e-monitor/internal/notify"
 	"github.com/speshak/grizzl-e-monitor/internal/prometheus"
+	"github.com/speshak/grizzl-e-monitor/internal/secrets"
 	"github.com/speshak/grizzl-e-monitor/internal/timescale"
+	"github.com/speshak/grizzl-e-monitor/internal/tracing"
+	"github.com/speshak/grizzl-e-monitor/pkg/ocpp"
+	"go.opentelemetry.io/otel"
 )
 
 // Default values for paramters
@@ -22,33 +34,105 @@ var buildDate string
 var buildVersion string
 var buildCommit string
 
-// LoadConfig loads configuration from environment variables
+// LoadConfig loads configuration from environment variables, falling back
+// on the config file named by GRIZZLE_CONFIG if there is one
 func LoadConfig() (*monitor.Config, *timescale.Config, error) {
-	apiHost := os.Getenv("GRIZZLE_CONNECT_API_URL")
+	config, file, err := loadMonitorConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timescaleConfig, err := LoadTimescaleConfig()
+	if err != nil && file.Publishers.Timescale.URL != "" {
+		timescaleConfig, err = &timescale.Config{Url: secrets.NewLiteral(file.Publishers.Timescale.URL)}, nil
+	}
+	if err != nil {
+		log.Printf("Error loading TimescaleDB config: %v\n", err)
+		log.Println("TimescaleDB will not be used")
+		timescaleConfig = nil
+	}
+
+	return config, timescaleConfig, nil
+}
+
+// Load the monitor's configuration, without the publishers', along with the
+// config file it came from. A reload only applies the monitor's.
+func loadMonitorConfig() (*monitor.Config, *ConfigFile, error) {
+	file, err := LoadConfigFile(os.Getenv("GRIZZLE_CONFIG"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	apiHost := setting("GRIZZLE_CONNECT_API_URL", file.Connect.APIURL)
 	if apiHost == "" {
 		apiHost = DefaultConnectApiHost
 	}
 
-	username := os.Getenv("GRIZZLE_CONNECT_API_USERNAME")
+	username := setting("GRIZZLE_CONNECT_API_USERNAME", file.Connect.Username)
 	if username == "" {
 		return nil, nil, fmt.Errorf("GRIZZLE_CONNECT_API_USERNAME environment variable is required")
 	}
 
-	password := os.Getenv("GRIZZLE_CONNECT_API_PASSWORD")
-	if password == "" {
+	password := secretSetting("GRIZZLE_CONNECT_API_PASSWORD", file.Connect.Password)
+	if password == nil {
 		return nil, nil, fmt.Errorf("GRIZZLE_CONNECT_API_PASSWORD environment variable is required")
 	}
-
-	debug := os.Getenv("GRIZZLE_CONNECT_DEBUG")
-	if debug == "" {
-		debug = "false"
+	if err := secrets.Check(context.Background(), password); err != nil {
+		return nil, nil, fmt.Errorf("error reading GRIZZLE_CONNECT_API_PASSWORD: %w", err)
 	}
 
-	timescaleConfig, err := LoadTimescaleConfig()
+	debug := setting("GRIZZLE_CONNECT_DEBUG", strconv.FormatBool(file.Connect.Debug))
+
+	pollIntervals, err := LoadPollIntervals(file.Polling.intervals())
 	if err != nil {
-		log.Printf("Error loading TimescaleDB config: %v\n", err)
-		log.Println("TimescaleDB will not be used")
-		timescaleConfig = nil
+		return nil, nil, err
+	}
+
+	budget := file.Connect.Budget
+	if value := os.Getenv("GRIZZLE_CONNECT_API_BUDGET"); value != "" {
+		budget, err = strconv.Atoi(value)
+		if err != nil || budget < 0 {
+			return nil, nil, fmt.Errorf("GRIZZLE_CONNECT_API_BUDGET must be a number of calls per hour: %q", value)
+		}
+	}
+
+	rate := file.Connect.Rate
+	if value := os.Getenv("GRIZZLE_CONNECT_API_RATE"); value != "" {
+		rate, err = strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 {
+			return nil, nil, fmt.Errorf("GRIZZLE_CONNECT_API_RATE must be a number of calls per second: %q", value)
+		}
+	}
+
+	fetchConcurrency := monitor.DefaultFetchConcurrency
+	if file.Connect.FetchConcurrency > 0 {
+		fetchConcurrency = file.Connect.FetchConcurrency
+	}
+	if value := os.Getenv("GRIZZLE_FETCH_CONCURRENCY"); value != "" {
+		fetchConcurrency, err = strconv.Atoi(value)
+		if err != nil || fetchConcurrency < 1 {
+			return nil, nil, fmt.Errorf("GRIZZLE_FETCH_CONCURRENCY must be a positive number of transactions: %q", value)
+		}
+	}
+
+	stalePolls := monitor.DefaultStalePolls
+	if file.Polling.StalePolls > 0 {
+		stalePolls = file.Polling.StalePolls
+	}
+	if value := os.Getenv("GRIZZLE_READY_STALE_POLLS"); value != "" {
+		stalePolls, err = strconv.Atoi(value)
+		if err != nil || stalePolls < 1 {
+			return nil, nil, fmt.Errorf("GRIZZLE_READY_STALE_POLLS must be a positive number of poll intervals: %q", value)
+		}
+	}
+
+	leaderElection := os.Getenv("GRIZZLE_LEADER_ELECTION") == "true"
+	leaderLease := monitor.DefaultLeaderLease
+	if value := os.Getenv("GRIZZLE_LEADER_LEASE"); value != "" {
+		leaderLease, err = time.ParseDuration(value)
+		if err != nil || leaderLease < time.Second {
+			return nil, nil, fmt.Errorf("GRIZZLE_LEADER_LEASE must be a duration of at least 1s, e.g. 15s: %q", value)
+		}
 	}
 
 	return &monitor.Config{
@@ -56,22 +140,101 @@ func LoadConfig() (*monitor.Config, *timescale.Config, error) {
 			Username: username,
 			Password: password,
 			Debug:    debug == "true",
+
+			PollIntervals:    pollIntervals,
+			APICallBudget:    budget,
+			APIRate:          rate,
+			StalePolls:       stalePolls,
+			FetchConcurrency: fetchConcurrency,
+			Stations:         file.stations(),
+
+			LeaderElection: leaderElection,
+			LeaderLease:    leaderLease,
 		},
-		timescaleConfig, nil
+		file, nil
+}
+
+// Load the station polling intervals, falling back to the config file's
+// and then the defaults for any that aren't set
+func LoadPollIntervals(file monitor.PollIntervals) (monitor.PollIntervals, error) {
+	intervals := monitor.DefaultPollIntervals()
+	for _, interval := range []struct{ from, to *time.Duration }{
+		{&file.Charging, &intervals.Charging},
+		{&file.PluggedIn, &intervals.PluggedIn},
+		{&file.Idle, &intervals.Idle},
+		{&file.Session, &intervals.Session},
+	} {
+		if *interval.from > 0 {
+			*interval.to = *interval.from
+		}
+	}
+
+	for name, interval := range map[string]*time.Duration{
+		"GRIZZLE_POLL_INTERVAL_CHARGING":   &intervals.Charging,
+		"GRIZZLE_POLL_INTERVAL_PLUGGED_IN": &intervals.PluggedIn,
+		"GRIZZLE_POLL_INTERVAL_IDLE":       &intervals.Idle,
+		"GRIZZLE_POLL_INTERVAL_SESSION":    &intervals.Session,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return intervals, fmt.Errorf("%s must be a positive duration, e.g. 30s or 5m: %q", name, value)
+		}
+		*interval = parsed
+	}
+
+	return intervals, nil
 }
 
 func LoadTimescaleConfig() (*timescale.Config, error) {
-	url := os.Getenv("TIMESCALE_URL")
+	url := secrets.FromEnv("TIMESCALE_URL")
 
-	if url == "" {
+	if url == nil {
 		return nil, fmt.Errorf("TIMESCALE_URL environment variable is required")
 	}
+	if err := secrets.Check(context.Background(), url); err != nil {
+		return nil, fmt.Errorf("error reading TIMESCALE_URL: %w", err)
+	}
 
 	return &timescale.Config{
 		Url: url,
 	}, nil
 }
 
+func LoadTracingConfig() (*tracing.Config, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+
+	if endpoint == "" {
+		return nil, fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT environment variable is required")
+	}
+
+	return &tracing.Config{
+		Endpoint:       endpoint,
+		ServiceName:    "grizzl-e-monitor",
+		ServiceVersion: buildVersion,
+	}, nil
+}
+
+func LoadOCPPConfig() (*ocpp.Config, error) {
+	address := os.Getenv("OCPP_LISTEN_ADDRESS")
+
+	if address == "" {
+		return nil, fmt.Errorf("OCPP_LISTEN_ADDRESS environment variable is required")
+	}
+
+	return &ocpp.Config{
+		ListenAddress:    address,
+		ProfileStorePath: os.Getenv("OCPP_PROFILE_STORE"),
+		TagRegistryPath:  os.Getenv("OCPP_TAG_REGISTRY"),
+		Journal:          os.Getenv("OCPP_JOURNAL"),
+		UpstreamURL:      os.Getenv("OCPP_UPSTREAM_URL"),
+	}, nil
+}
+
 func versionHeader() {
 	const headerWidth = 80
 	fmt.Println(strings.Repeat("=", headerWidth))
@@ -83,6 +246,20 @@ func versionHeader() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string, io.Writer) error{
+			"replay":   runReplay,
+			"backfill": runBackfill,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	versionHeader()
 
 	config, timescaleConfig, err := LoadConfig()
@@ -91,28 +268,289 @@ func main() {
 		os.Exit(1)
 	}
 
+	// SIGTERM is how Kubernetes asks us to stop during a rollout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var closers shutdownStack
+
+	tracingConfig, err := LoadTracingConfig()
+	if err != nil {
+		log.Printf("Error loading tracing config: %v\n", err)
+		log.Println("Traces will not be exported")
+	} else {
+		provider, err := tracing.NewTracerProvider(ctx, tracingConfig)
+		if err != nil {
+			log.Fatalf("Error creating tracer provider: %v\n", err)
+		}
+		otel.SetTracerProvider(provider)
+
+		// Closed last, to export the spans of everything else shutting down
+		closers.push("tracer provider", func() error {
+			return provider.Shutdown(context.Background())
+		})
+	}
+
+	// What the monitor knows about stations is kept here across restarts.
+	// Closed after everything that might write to it.
+	var state monitor.StateStore
+	if path := os.Getenv("GRIZZLE_STATE_PATH"); path != "" {
+		store, err := monitor.OpenBoltStateStore(path)
+		if err != nil {
+			log.Fatalf("Error opening state store: %v\n", err)
+		}
+		closers.push("state store", store.Close)
+		state = store
+	}
+
 	// Start monitoring stations
 	monitor := monitor.NewStationMonitor(config)
+	monitor.State = state
+
+	// Everything collected, from the Connect API or OCPP, is fanned out to
+	// each of these. Sinks without a database of their own keep track of the
+	// transactions they've been sent in the state store.
+	publishers := monitor.Publishers
+	closers.push("publishers", publishers.Close)
 
 	prom := prometheus.NewPrometheusPublisher()
+	if err := monitor.RegisterPublisher("prometheus", prom); err != nil {
+		log.Fatalf("Error registering Prometheus publisher: %v\n", err)
+	}
 
+	var timescalePublisher *timescale.TimescalePublisher
 	if timescaleConfig != nil {
-		timescale := timescale.NewTimescalePublisher(timescaleConfig)
-		monitor.TransactionHistoryPublisher = timescale
+		timescalePublisher = timescale.NewTimescalePublisher(timescaleConfig)
+		if err := monitor.RegisterPublisher("timescale", timescalePublisher); err != nil {
+			log.Fatalf("Error registering TimescaleDB publisher: %v\n", err)
+		}
+	}
+
+	// Chargers pointed at us over OCPP report through the same publishers
+	var ocppServer http.Handler
+	ocppConfig, err := LoadOCPPConfig()
+	if err != nil {
+		log.Printf("Error loading OCPP config: %v\n", err)
+		log.Println("OCPP central system will not be started")
+	} else {
+		var journal ocpp.Journal
+		switch ocppConfig.Journal {
+		case "":
+		case ocpp.JournalTimescale:
+			if timescalePublisher == nil {
+				log.Fatalf("OCPP_JOURNAL=%s requires TIMESCALE_URL\n", ocpp.JournalTimescale)
+			}
+			journal = timescalePublisher
+		default:
+			fileJournal, err := ocpp.NewFileJournal(ocppConfig.Journal)
+			if err != nil {
+				log.Fatalf("Error opening OCPP journal: %v\n", err)
+			}
+			closers.push("OCPP journal", fileJournal.Close)
+			journal = fileJournal
+		}
+
+		if ocppConfig.UpstreamURL != "" {
+			// The upstream owns smart charging and authorization, we only
+			// listen in
+			proxy := ocpp.NewProxy(ocppConfig.UpstreamURL)
+			proxy.StationStatusPublisher = publishers
+			proxy.TransactionHistoryPublisher = publishers
+			proxy.SessionPublisher = publishers
+			proxy.Journal = journal
+			ocppServer = proxy
+		} else {
+			centralSystem := ocpp.NewCentralSystem()
+			centralSystem.StationStatusPublisher = publishers
+			centralSystem.TransactionHistoryPublisher = publishers
+			centralSystem.SessionPublisher = publishers
+			centralSystem.ChargingLimitPublisher = prom
+
+			if ocppConfig.ProfileStorePath != "" {
+				store, err := ocpp.NewFileProfileStore(ocppConfig.ProfileStorePath)
+				if err != nil {
+					log.Fatalf("Error opening charging profile store: %v\n", err)
+				}
+				centralSystem.Profiles = store
+			}
+
+			if ocppConfig.TagRegistryPath != "" {
+				tags, err := ocpp.LoadTagRegistry(ocppConfig.TagRegistryPath)
+				if err != nil {
+					log.Fatalf("Error loading tag registry: %v\n", err)
+				}
+				centralSystem.Tags = tags
+				centralSystem.AuthorizationPublisher = prom
+			}
+
+			centralSystem.Journal = journal
+			ocppServer = centralSystem
+		}
+	}
+
+	monitor.RegisterMetrics(prom.Registry)
+
+	// Replicas share a lease in TimescaleDB, and only the one holding it runs
+	// jobs. The lease is given up once the monitor's jobs have finished, so
+	// it's closed before the publishers.
+	if config.LeaderElection {
+		if timescalePublisher == nil {
+			log.Fatalf("GRIZZLE_LEADER_ELECTION requires TIMESCALE_URL\n")
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Error getting hostname for leader election: %v\n", err)
+		}
+		holder := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+
+		elector := monitor.ElectLeader(timescalePublisher, holder, config.LeaderLease)
+		electionCtx, cancelElection := context.WithCancel(context.Background())
+		electionDone := make(chan struct{})
+		go func() {
+			elector.Run(electionCtx)
+			close(electionDone)
+		}()
+
+		closers.push("leader election", func() error {
+			cancelElection()
+			<-electionDone
+			return nil
+		})
 	}
 
-	monitor.TransactionStatsPublisher = prom
-	monitor.StationStatusPublisher = prom
+	// Served alongside /metrics
+	http.Handle("/healthz", monitor.HealthHandler())
+	http.Handle("/readyz", monitor.ReadinessHandler())
 
-	ctx := context.Background()
-	errs := make(chan error, 1)
+	// Off unless there's a token to protect it with
+	if token := os.Getenv("GRIZZLE_ADMIN_TOKEN"); token != "" {
+		http.Handle("/admin/", monitor.AdminHandler(token))
+	}
+
+	// Tell people about station events, e.g. charging finishing
+	notifier, err := LoadNotifier()
+	if err != nil {
+		log.Fatalf("Error loading notification config: %v\n", err)
+	}
+	if notifier != nil {
+		notifier.State = monitor.State
+
+		events, unsubscribe := monitor.Events.Subscribe("notifications", 0)
+		notifyCtx, cancelNotify := context.WithCancel(context.Background())
+		notifierDone := make(chan struct{})
+		go func() {
+			notifier.Run(notifyCtx, events)
+			close(notifierDone)
+		}()
+
+		// Give notifications of the last events a chance to go out, but
+		// don't keep retrying them forever
+		closers.push("notifications", func() error {
+			unsubscribe()
+			select {
+			case <-notifierDone:
+			case <-time.After(monitor.ShutdownTimeout):
+				cancelNotify()
+				<-notifierDone
+			}
+			cancelNotify()
+			return nil
+		})
+	}
+
+	// Pick up changes to the config without restarting
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+	go func() {
+		for range reloads {
+			reloadConfig(monitor, notifier)
+		}
+	}()
+
+	monitorDone := make(chan error, 1)
 	go func() {
-		errs <- monitor.MonitorStations(ctx)
+		monitorDone <- monitor.MonitorStations(ctx)
 	}()
 
-	// Handle any errors
-	if err := <-errs; err != nil {
-		log.Fatal(err)
+	var server *http.Server
+	serverErrs := make(chan error, 1)
+	if ocppServer != nil {
+		server = &http.Server{Addr: ocppConfig.ListenAddress, Handler: ocppServer}
+		go func() {
+			if ocppConfig.UpstreamURL != "" {
+				log.Printf("Starting OCPP proxy to %s on %s", ocppConfig.UpstreamURL, ocppConfig.ListenAddress)
+			} else {
+				log.Printf("Starting OCPP central system on %s", ocppConfig.ListenAddress)
+			}
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErrs <- err
+			}
+		}()
+	}
+
+	// Run until we're asked to stop, or something fails
+	exitCode := 0
+	monitorStopped := false
+	select {
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	case err := <-monitorDone:
+		log.Printf("Error monitoring stations: %v", err)
+		monitorStopped = true
+		exitCode = 1
+	case err := <-serverErrs:
+		log.Printf("Error serving OCPP: %v", err)
+		exitCode = 1
 	}
+	stop()
 
+	// Stop taking in new data, and let what's in flight finish, before
+	// closing the publishers it's written to
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), monitor.ShutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down OCPP server: %v", err)
+		}
+		cancel()
+	}
+	if !monitorStopped {
+		<-monitorDone
+	}
+
+	closers.closeAll()
+	os.Exit(exitCode)
+}
+
+// Apply a changed config file and environment to the monitor and notifier.
+// A config that doesn't load is logged and the current one kept.
+func reloadConfig(stationMonitor *monitor.StationMonitor, notifier *notify.Notifier) {
+	log.Println("Reloading config")
+
+	config, _, err := loadMonitorConfig()
+	if err != nil {
+		log.Printf("Error reloading config, keeping the current one: %v", err)
+		return
+	}
+
+	current := stationMonitor.Config
+	// A rotated or changed password is picked up on the next login
+	if config.APIHost != current.APIHost || config.Username != current.Username || config.APICallBudget != current.APICallBudget {
+		log.Println("Changes to the Connect account and API call budget take effect on restart")
+	}
+	stationMonitor.Reconfigure(config)
+
+	if notifier == nil {
+		return
+	}
+	reloaded, err := LoadNotifier()
+	switch {
+	case err != nil:
+		log.Printf("Error reloading notification config, keeping the current one: %v", err)
+	case reloaded == nil:
+		log.Println("Notifications can't be turned off without restarting")
+	default:
+		notifier.Reconfigure(reloaded)
+	}
 }
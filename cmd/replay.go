@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/timescale"
+	"github.com/speshak/grizzl-e-monitor/pkg/ocpp"
+)
+
+// Mismatched responses found while replaying a journal
+var ErrReplayMismatch = errors.New("replayed responses differ from the journal")
+
+// Feed a recorded OCPP journal back through the central system's handlers and
+// report where the responses differ from the recorded ones.
+//
+//	replay [-station ID] [-since TIME] [-until TIME] [-tags FILE] [journal file]
+//
+// Without a journal file, the journal is read from TimescaleDB.
+func runReplay(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(out)
+	station := flags.String("station", "", "Only replay frames for this charge point ID")
+	since := flags.String("since", "", "Only replay frames from this time (RFC3339)")
+	until := flags.String("until", "", "Only replay frames before this time (RFC3339)")
+	tags := flags.String("tags", os.Getenv("OCPP_TAG_REGISTRY"), "Tag registry to authorize idTags against")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	sinceTime, untilTime, err := parseReplayRange(*since, *until)
+	if err != nil {
+		return err
+	}
+
+	var entries []ocpp.JournalEntry
+	if flags.NArg() > 0 {
+		entries, err = readJournalFile(flags.Arg(0))
+	} else {
+		entries, err = readJournalTimescale(*station, sinceTime, untilTime)
+	}
+	if err != nil {
+		return err
+	}
+
+	entries = filterJournal(entries, *station, sinceTime, untilTime)
+
+	cs := ocpp.NewCentralSystem()
+	if *tags != "" {
+		cs.Tags, err = ocpp.LoadTagRegistry(*tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	results := cs.Replay(entries)
+
+	mismatches := 0
+	for _, result := range results {
+		msg, _ := ocpp.ParseMessage(result.Entry.Frame)
+		prefix := fmt.Sprintf("%s %s %s %s", result.Entry.Time.Format(time.RFC3339), result.Entry.ChargePointId, msg.Action, msg.UniqueId)
+
+		replayed, _ := json.Marshal(result.Response)
+		switch {
+		case result.Recorded == nil:
+			fmt.Fprintf(out, "%s: no recorded response\n  replayed: %s\n", prefix, replayed)
+		case result.Matches():
+			fmt.Fprintf(out, "%s: ok\n", prefix)
+		default:
+			mismatches++
+			recorded, _ := json.Marshal(*result.Recorded)
+			fmt.Fprintf(out, "%s: MISMATCH\n  recorded: %s\n  replayed: %s\n", prefix, recorded, replayed)
+		}
+	}
+
+	fmt.Fprintf(out, "Replayed %d calls, %d mismatched\n", len(results), mismatches)
+
+	if mismatches > 0 {
+		return ErrReplayMismatch
+	}
+	return nil
+}
+
+func parseReplayRange(since string, until string) (time.Time, time.Time, error) {
+	sinceTime := time.Time{}
+	untilTime := time.Now()
+
+	var err error
+	if since != "" {
+		if sinceTime, err = time.Parse(time.RFC3339, since); err != nil {
+			return sinceTime, untilTime, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if untilTime, err = time.Parse(time.RFC3339, until); err != nil {
+			return sinceTime, untilTime, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+
+	return sinceTime, untilTime, nil
+}
+
+func readJournalFile(path string) ([]ocpp.JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ocpp.ReadJournal(file)
+}
+
+func readJournalTimescale(station string, since time.Time, until time.Time) ([]ocpp.JournalEntry, error) {
+	config, err := LoadTimescaleConfig()
+	if err != nil {
+		return nil, fmt.Errorf("a journal file or TimescaleDB is needed to replay: %w", err)
+	}
+
+	publisher := timescale.NewTimescalePublisher(config)
+	defer publisher.Close()
+
+	return publisher.JournalEntries(station, since, until)
+}
+
+func filterJournal(entries []ocpp.JournalEntry, station string, since time.Time, until time.Time) []ocpp.JournalEntry {
+	var filtered []ocpp.JournalEntry
+	for _, entry := range entries {
+		if station != "" && entry.ChargePointId != station {
+			continue
+		}
+		if entry.Time.Before(since) || !entry.Time.Before(until) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+
+	return filtered
+}
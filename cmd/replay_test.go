@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJournal = `{"time":"2025-06-01T18:00:00Z","chargePointId":"CP001","direction":"in","frame":[2,"1","Heartbeat",{}]}
+{"time":"2025-06-01T18:00:00Z","chargePointId":"CP001","direction":"out","frame":[3,"1",{"currentTime":"2025-06-01T18:00:00Z"}]}
+{"time":"2025-06-01T18:05:00Z","chargePointId":"CP002","direction":"in","frame":[2,"1","Heartbeat",{}]}
+{"time":"2025-06-01T18:05:00Z","chargePointId":"CP002","direction":"out","frame":[3,"1",{"currentTime":"2025-06-01T18:05:00Z"}]}
+{"time":"2025-06-01T18:10:00Z","chargePointId":"CP001","direction":"in","frame":[2,"2","Heartbeat",{}]}
+{"time":"2025-06-01T18:10:00Z","chargePointId":"CP001","direction":"out","frame":[3,"2",{"currentTime":"2025-06-01T17:00:00Z"}]}
+`
+
+func writeTestJournal(t *testing.T) string {
+	t.Setenv("OCPP_TAG_REGISTRY", "")
+
+	path := filepath.Join(t.TempDir(), "ocpp.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(testJournal), 0o600))
+	return path
+}
+
+func TestReplay(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := runReplay([]string{"-until", "2025-06-01T18:08:00Z", writeTestJournal(t)}, out)
+	require.NoError(t, err)
+
+	assert.Contains(t, out.String(), "CP001 Heartbeat 1: ok")
+	assert.Contains(t, out.String(), "CP002 Heartbeat 1: ok")
+	assert.Contains(t, out.String(), "Replayed 2 calls, 0 mismatched")
+}
+
+func TestReplay_Station(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := runReplay([]string{"-station", "CP002", writeTestJournal(t)}, out)
+	require.NoError(t, err)
+
+	assert.NotContains(t, out.String(), "CP001")
+	assert.Contains(t, out.String(), "Replayed 1 calls, 0 mismatched")
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := runReplay([]string{writeTestJournal(t)}, out)
+	require.ErrorIs(t, err, ErrReplayMismatch)
+
+	assert.Contains(t, out.String(), "CP001 Heartbeat 2: MISMATCH")
+	assert.Contains(t, out.String(), `recorded: [3,"2",{"currentTime":"2025-06-01T17:00:00Z"}]`)
+	assert.Contains(t, out.String(), `replayed: [3,"2",{"currentTime":"2025-06-01T18:10:00Z"}]`)
+}
+
+func TestReplay_InvalidSince(t *testing.T) {
+	err := runReplay([]string{"-since", "yesterday", writeTestJournal(t)}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "-since")
+}
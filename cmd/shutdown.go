@@ -0,0 +1,30 @@
+package main
+
+import "log"
+
+type closer struct {
+	name  string
+	close func() error
+}
+
+// Things to close on shutdown. Like deferred calls they're closed in the
+// reverse of the order they were added, so nothing is closed while something
+// opened after it could still write to it. Unlike deferred calls they're
+// closed before exiting with an error.
+type shutdownStack []closer
+
+func (s *shutdownStack) push(name string, close func() error) {
+	*s = append(*s, closer{name: name, close: close})
+}
+
+// Close everything, carrying on past any errors so one failure doesn't stop
+// the rest being flushed
+func (s shutdownStack) closeAll() {
+	for i := len(s) - 1; i >= 0; i-- {
+		log.Printf("Closing %s", s[i].name)
+
+		if err := s[i].close(); err != nil {
+			log.Printf("Error closing %s: %v", s[i].name, err)
+		}
+	}
+}
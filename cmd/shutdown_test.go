@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownStack(t *testing.T) {
+	var closed []string
+	closeFunc := func(name string, err error) func() error {
+		return func() error {
+			closed = append(closed, name)
+			return err
+		}
+	}
+
+	var closers shutdownStack
+	closers.push("tracer provider", closeFunc("tracer provider", nil))
+	closers.push("database", closeFunc("database", fmt.Errorf("connection reset")))
+	closers.push("journal", closeFunc("journal", nil))
+
+	closers.closeAll()
+
+	assert.Equal(t, []string{"journal", "database", "tracer provider"}, closed, "Should close in reverse order, carrying on past errors")
+}
@@ -0,0 +1,207 @@
+package monitor
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+)
+
+/**
+* Admin API for inspecting and steering the monitor's jobs, e.g. polling a
+* station's transactions straight after a charging session rather than
+* waiting up to an hour and a half for the next run.
+*
+*	GET  /admin/jobs                           list jobs with their last and next runs
+*	POST /admin/stations/{id}/poll             poll a station's status and stats now
+*	POST /admin/stations/{id}/transactions     publish a station's transactions now
+*	POST /admin/stations/{id}/pause            stop running a station's jobs
+*	POST /admin/stations/{id}/resume           start running them again
+*
+* Every request needs an "Authorization: Bearer <token>" header.
+ */
+
+var (
+	ErrUnknownStation = errors.New("station isn't being monitored")
+	ErrStationPaused  = errors.New("station is paused")
+	ErrJobNotFound    = errors.New("job not found")
+)
+
+// A job as listed by the admin API
+type JobStatus struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	StationID string    `json:"station_id,omitempty"`
+	Tags      []string  `json:"tags"`
+	LastRun   time.Time `json:"last_run,omitzero"`
+	NextRun   time.Time `json:"next_run,omitzero"`
+	Paused    bool      `json:"paused,omitempty"`
+}
+
+// List the scheduler's jobs. A job's name is its first tag, e.g.
+// station_stats.
+func (m *StationMonitor) Jobs() []JobStatus {
+	var jobs []JobStatus
+
+	for _, job := range m.Scheduler.Jobs() {
+		tags := job.Tags()
+		status := JobStatus{ID: job.ID().String(), Tags: tags}
+
+		if len(tags) > 0 {
+			status.Name = tags[0]
+		}
+		for _, tag := range tags {
+			if stationId, ok := strings.CutPrefix(tag, stationTag("")); ok {
+				status.StationID = stationId
+				status.Paused = m.polls.status(stationId).paused
+			}
+		}
+
+		// Both are zero before the job's first run or once it's removed
+		status.LastRun, _ = job.LastRun()
+		status.NextRun, _ = job.NextRun()
+
+		jobs = append(jobs, status)
+	}
+
+	return jobs
+}
+
+// Run one of a station's jobs now, e.g. "station_stats" or "transaction".
+// Runs still count against the API call budget.
+func (m *StationMonitor) TriggerJob(stationId string, name string) error {
+	if err := m.checkStation(stationId); err != nil {
+		return err
+	}
+	if m.polls.status(stationId).paused {
+		return ErrStationPaused
+	}
+
+	job := m.findJob(name, stationTag(stationId))
+	if job == nil {
+		return fmt.Errorf("%w: %s for station %s", ErrJobNotFound, name, stationId)
+	}
+
+	// Make the station due, or the poll would skip it
+	if name == "station_stats" {
+		m.polls.release(stationId)
+	}
+
+	log.Printf("Running %s job for station %s now", name, stationId)
+	return job.RunNow()
+}
+
+// Stop running a station's jobs until it's resumed
+func (m *StationMonitor) PauseStation(stationId string) error {
+	if err := m.checkStation(stationId); err != nil {
+		return err
+	}
+
+	log.Printf("Pausing jobs for station %s", stationId)
+	m.polls.setPaused(stationId, true)
+	return nil
+}
+
+func (m *StationMonitor) ResumeStation(stationId string) error {
+	if err := m.checkStation(stationId); err != nil {
+		return err
+	}
+
+	log.Printf("Resuming jobs for station %s", stationId)
+	m.polls.setPaused(stationId, false)
+	return nil
+}
+
+func (m *StationMonitor) checkStation(stationId string) error {
+	m.stationsMu.Lock()
+	defer m.stationsMu.Unlock()
+
+	if _, ok := m.stations[stationId]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownStation, stationId)
+	}
+	return nil
+}
+
+// Find the job with all of the given tags
+func (m *StationMonitor) findJob(tags ...string) gocron.Job {
+	for _, job := range m.Scheduler.Jobs() {
+		jobTags := job.Tags()
+		if !slices.ContainsFunc(tags, func(tag string) bool { return !slices.Contains(jobTags, tag) }) {
+			return job
+		}
+	}
+	return nil
+}
+
+// Serve the admin API, requiring the given bearer token on every request
+func (m *StationMonitor) AdminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Jobs())
+	})
+
+	actions := map[string]func(stationId string) error{
+		"poll": func(stationId string) error {
+			return m.TriggerJob(stationId, "station_stats")
+		},
+		"transactions": func(stationId string) error {
+			return m.TriggerJob(stationId, "transaction")
+		},
+		"pause":  m.PauseStation,
+		"resume": m.ResumeStation,
+	}
+	for action, fn := range actions {
+		mux.HandleFunc("POST /admin/stations/{id}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if err := fn(r.PathValue("id")); err != nil {
+				writeJSON(w, adminErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "ok"})
+		})
+	}
+
+	return requireBearerToken(token, mux)
+}
+
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownStation), errors.Is(err, ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrStationPaused):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Reject requests without the bearer token. An empty token rejects
+// everything, rather than letting everything through.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="grizzl-e-monitor"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
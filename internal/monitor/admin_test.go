@@ -0,0 +1,140 @@
+package monitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	gocronmocks "github.com/go-co-op/gocron/mocks/v2"
+)
+
+const adminToken = "s3cret"
+
+func mockJob(ctrl *gomock.Controller, lastRun time.Time, tags ...string) *gocronmocks.MockJob {
+	job := gocronmocks.NewMockJob(ctrl)
+	job.EXPECT().Tags().Return(tags).AnyTimes()
+	job.EXPECT().ID().Return(uuid.New()).AnyTimes()
+	job.EXPECT().LastRun().Return(lastRun, nil).AnyTimes()
+	job.EXPECT().NextRun().Return(lastRun.Add(time.Hour), nil).AnyTimes()
+	return job
+}
+
+// A monitor of station1 with its two jobs and the discovery job
+func newAdminMonitor(t *testing.T) (*StationMonitor, *gocronmocks.MockJob, *gocronmocks.MockJob) {
+	ctrl := gomock.NewController(t)
+	lastRun := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	stats := mockJob(ctrl, lastRun, "station_stats", stationTag("station1"))
+	transaction := mockJob(ctrl, lastRun, "transaction", stationTag("station1"))
+	discovery := mockJob(ctrl, lastRun, "discovery")
+
+	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
+	mockScheduler.EXPECT().Jobs().Return([]gocron.Job{stats, transaction, discovery}).AnyTimes()
+
+	return &StationMonitor{
+		Scheduler: mockScheduler,
+		stations:  map[string]connect.Station{"station1": {ID: "station1"}},
+	}, stats, transaction
+}
+
+func adminRequest(t *testing.T, handler http.Handler, method string, path string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAuth(t *testing.T) {
+	monitor, _, _ := newAdminMonitor(t)
+
+	for name, token := range map[string]string{"missing": "", "wrong": "guess"} {
+		t.Run(name, func(t *testing.T) {
+			rec := adminRequest(t, monitor.AdminHandler(adminToken), http.MethodGet, "/admin/jobs", token)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		})
+	}
+
+	rec := adminRequest(t, monitor.AdminHandler(""), http.MethodGet, "/admin/jobs", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "An empty token shouldn't let anyone in")
+}
+
+func TestAdminJobs(t *testing.T) {
+	monitor, _, _ := newAdminMonitor(t)
+	require.NoError(t, monitor.PauseStation("station1"))
+
+	rec := adminRequest(t, monitor.AdminHandler(adminToken), http.MethodGet, "/admin/jobs", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var jobs []JobStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 3)
+
+	assert.Equal(t, "station_stats", jobs[0].Name)
+	assert.Equal(t, "station1", jobs[0].StationID)
+	assert.True(t, jobs[0].Paused)
+	assert.Equal(t, time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC), jobs[0].LastRun)
+	assert.Equal(t, time.Date(2025, 6, 1, 19, 0, 0, 0, time.UTC), jobs[0].NextRun)
+
+	assert.Equal(t, "discovery", jobs[2].Name)
+	assert.Empty(t, jobs[2].StationID)
+}
+
+func TestAdminTrigger(t *testing.T) {
+	monitor, stats, transaction := newAdminMonitor(t)
+	handler := monitor.AdminHandler(adminToken)
+
+	// Hold the poll as though the station was just polled
+	monitor.polls.claim("station1", time.Now(), time.Hour)
+
+	stats.EXPECT().RunNow().Return(nil)
+	rec := adminRequest(t, handler, http.MethodPost, "/admin/stations/station1/poll", adminToken)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.True(t, monitor.polls.claim("station1", time.Now(), time.Hour), "A triggered poll should make the station due")
+
+	transaction.EXPECT().RunNow().Return(nil)
+	rec = adminRequest(t, handler, http.MethodPost, "/admin/stations/station1/transactions", adminToken)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = adminRequest(t, handler, http.MethodPost, "/admin/stations/station2/poll", adminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminPause(t *testing.T) {
+	monitor, _, _ := newAdminMonitor(t)
+	handler := monitor.AdminHandler(adminToken)
+
+	rec := adminRequest(t, handler, http.MethodPost, "/admin/stations/station1/pause", adminToken)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.True(t, monitor.polls.status("station1").paused)
+
+	rec = adminRequest(t, handler, http.MethodPost, "/admin/stations/station1/poll", adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code, "Paused stations can't be polled")
+
+	rec = adminRequest(t, handler, http.MethodPost, "/admin/stations/station1/resume", adminToken)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.False(t, monitor.polls.status("station1").paused)
+}
+
+func TestPausedStationIsNotPolled(t *testing.T) {
+	monitor, mockConnectAPI := newPollingMonitor(t, "Charging")
+	monitor.polls.setPaused("station1", true)
+
+	monitor.pollStation(t.Context(), connect.Station{ID: "station1"})
+	mockConnectAPI.AssertNotCalled(t, "GetStation", "station1")
+
+	monitor.stations = map[string]connect.Station{"station1": {ID: "station1"}}
+	assert.True(t, monitor.Health(t.Context()).Ready(), "Paused stations shouldn't be stale")
+}
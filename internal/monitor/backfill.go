@@ -0,0 +1,178 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+// Where a backfill records how far through each station's history it's got,
+// so an interrupted backfill picks up where it left off
+type BackfillCheckpoints interface {
+	// The next page of the station's history to fetch, and whether it's been
+	// walked to the end. A station without a checkpoint starts at page 0.
+	BackfillCheckpoint(stationId string) (page int, complete bool, err error)
+	SaveBackfillCheckpoint(stationId string, page int, complete bool) error
+}
+
+// Walks the whole transaction history of stations a page at a time,
+// publishing any transactions that haven't been published yet. Progress is
+// checkpointed after each page. Pages are newest first, so sessions added
+// while a backfill runs shift the pages along and some transactions are seen
+// twice, which is harmless as published ones are skipped.
+type Backfill struct {
+	Connect     connect.ConnectAPI
+	Publisher   TransactionHistoryPublisher
+	Checkpoints BackfillCheckpoints
+
+	// Transactions per page
+	PageSize int
+	// Most full transactions to fetch at once, still within the Connect
+	// client's rate limit
+	Concurrency int
+	// Walk stations again from the start, even if they're complete
+	Restart bool
+
+	// Where progress reports are written
+	Progress io.Writer
+}
+
+// Default number of transactions fetched per page
+const DefaultBackfillPageSize = 50
+
+// Backfill each of the stations in turn, stopping at the first error. Run it
+// again to resume.
+func (b *Backfill) Run(ctx context.Context, stationIds []string) error {
+	for _, stationId := range stationIds {
+		if err := b.station(ctx, stationId); err != nil {
+			return fmt.Errorf("error backfilling station %s: %w", stationId, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *Backfill) station(ctx context.Context, stationId string) error {
+	page, complete, err := b.Checkpoints.BackfillCheckpoint(stationId)
+	if err != nil {
+		return fmt.Errorf("error reading checkpoint: %w", err)
+	}
+
+	if b.Restart {
+		page, complete = 0, false
+	}
+	if complete {
+		b.report("%s: already backfilled, skipping", stationId)
+		return nil
+	}
+	if page > 0 {
+		b.report("%s: resuming from page %d", stationId, page)
+	}
+
+	pageSize := b.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultBackfillPageSize
+	}
+
+	// Only used for the ETA, so carry on without it
+	total := 0
+	if stats, err := b.Connect.GetTransactionStatistics(ctx, stationId); err != nil {
+		log.Printf("Error getting transaction statistics for station %s, no ETA will be given: %v", stationId, err)
+	} else {
+		total = stats.Sessions
+	}
+
+	progress := backfillProgress{start: time.Now(), startPage: page, pageSize: pageSize, total: total}
+
+	for {
+		transactions, err := b.Connect.GetTransactions(ctx, stationId, pageSize, page)
+		if err != nil {
+			return fmt.Errorf("error getting page %d: %w", page, err)
+		}
+
+		var unpublished []connect.Transaction
+		for _, transaction := range transactions {
+			if !b.Publisher.TransactionPublished(transaction) {
+				unpublished = append(unpublished, transaction)
+			}
+		}
+
+		fetch := func(ctx context.Context, transaction connect.Transaction) (connect.Transaction, error) {
+			return b.Connect.GetTransaction(ctx, transaction.ID)
+		}
+
+		var pageErr error
+		fetchInOrder(ctx, b.Concurrency, unpublished, fetch, func(transaction connect.Transaction, full connect.Transaction, err error) bool {
+			if err != nil {
+				pageErr = fmt.Errorf("error getting transaction %s: %w", transaction.ID, err)
+				return false
+			}
+			if err := b.Publisher.PublishTransactionHistory(stationId, full); err != nil {
+				pageErr = fmt.Errorf("error publishing transaction %s: %w", transaction.ID, err)
+				return false
+			}
+			progress.published++
+			return true
+		})
+		if pageErr != nil {
+			return pageErr
+		}
+
+		page++
+		complete = len(transactions) < pageSize
+		if err := b.Checkpoints.SaveBackfillCheckpoint(stationId, page, complete); err != nil {
+			return fmt.Errorf("error saving checkpoint: %w", err)
+		}
+
+		b.report("%s: %s", stationId, progress.at(page, complete))
+		if complete {
+			return nil
+		}
+	}
+}
+
+func (b *Backfill) report(format string, args ...any) {
+	if b.Progress == nil {
+		return
+	}
+	fmt.Fprintf(b.Progress, format+"\n", args...)
+}
+
+// How far a station's backfill has got, for progress reports
+type backfillProgress struct {
+	start     time.Time
+	startPage int
+	pageSize  int
+	// Sessions the station has had, or 0 if unknown
+	total     int
+	published int
+}
+
+func (p backfillProgress) at(page int, complete bool) string {
+	seen := page * p.pageSize
+	status := fmt.Sprintf("page %d, %d published", page, p.published)
+
+	switch {
+	case complete:
+		return status + ", done in " + time.Since(p.start).Round(time.Second).String()
+	case p.total == 0:
+		return status
+	}
+
+	seen = min(seen, p.total)
+	status += fmt.Sprintf(", %d/%d sessions (%d%%)", seen, p.total, seen*100/p.total)
+
+	// Extrapolate from the pages walked so far this run
+	walked := (page - p.startPage) * p.pageSize
+	if walked > 0 {
+		perSession := time.Since(p.start) / time.Duration(walked)
+		eta := perSession * time.Duration(p.total-seen)
+		status += ", ETA " + eta.Round(time.Second).String()
+	}
+
+	return status
+}
@@ -0,0 +1,92 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type checkpoint struct {
+	page     int
+	complete bool
+}
+
+type memoryCheckpoints map[string]checkpoint
+
+func (m memoryCheckpoints) BackfillCheckpoint(stationId string) (int, bool, error) {
+	return m[stationId].page, m[stationId].complete, nil
+}
+
+func (m memoryCheckpoints) SaveBackfillCheckpoint(stationId string, page int, complete bool) error {
+	m[stationId] = checkpoint{page, complete}
+	return nil
+}
+
+// Three transactions over two pages, the second of them already published
+func newBackfill(checkpoints memoryCheckpoints) (*Backfill, *MockConnectAPI, *MockTransactionHistoryPublisher, *bytes.Buffer) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetTransactionStatistics", "station1").Return(connect.TransactionStats{Sessions: 3}, nil)
+	mockConnectAPI.On("GetTransactions", "station1", 2, 0).Return([]connect.Transaction{{ID: "trans1"}, {ID: "trans2"}}, nil)
+	mockConnectAPI.On("GetTransactions", "station1", 2, 1).Return([]connect.Transaction{{ID: "trans3"}}, nil)
+	mockConnectAPI.On("GetTransaction", "trans1").Return(connect.Transaction{ID: "trans1"}, nil)
+
+	publisher := new(MockTransactionHistoryPublisher)
+	publisher.On("TransactionPublished", connect.Transaction{ID: "trans2"}).Return(true)
+	publisher.On("TransactionPublished", mock.Anything).Return(false)
+	publisher.On("PublishTransactionHistory", "station1", mock.Anything)
+
+	progress := &bytes.Buffer{}
+	return &Backfill{
+		Connect:     mockConnectAPI,
+		Publisher:   publisher,
+		Checkpoints: checkpoints,
+		PageSize:    2,
+		Concurrency: 2,
+		Progress:    progress,
+	}, mockConnectAPI, publisher, progress
+}
+
+func TestBackfill(t *testing.T) {
+	checkpoints := memoryCheckpoints{}
+	backfill, mockConnectAPI, publisher, progress := newBackfill(checkpoints)
+	mockConnectAPI.On("GetTransaction", "trans3").Return(connect.Transaction{ID: "trans3"}, nil)
+
+	require.NoError(t, backfill.Run(context.Background(), []string{"station1"}))
+
+	publisher.AssertNumberOfCalls(t, "PublishTransactionHistory", 2)
+	mockConnectAPI.AssertNotCalled(t, "GetTransaction", "trans2")
+	assert.Equal(t, checkpoint{page: 2, complete: true}, checkpoints["station1"])
+	assert.Contains(t, progress.String(), "station1: page 1, 1 published, 2/3 sessions (66%), ETA")
+	assert.Contains(t, progress.String(), "station1: page 2, 2 published, done in")
+
+	// Complete stations are skipped unless restarted
+	require.NoError(t, backfill.Run(context.Background(), []string{"station1"}))
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransactions", 2)
+
+	backfill.Restart = true
+	require.NoError(t, backfill.Run(context.Background(), []string{"station1"}))
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransactions", 4)
+}
+
+func TestBackfillResume(t *testing.T) {
+	checkpoints := memoryCheckpoints{}
+	backfill, mockConnectAPI, _, _ := newBackfill(checkpoints)
+	mockConnectAPI.On("GetTransaction", "trans3").Return(connect.Transaction{}, fmt.Errorf("Error getting transaction")).Once()
+
+	err := backfill.Run(context.Background(), []string{"station1"})
+	require.ErrorContains(t, err, "trans3")
+	assert.Equal(t, checkpoint{page: 1}, checkpoints["station1"], "The failed page should be checkpointed as next")
+
+	mockConnectAPI.On("GetTransaction", "trans3").Return(connect.Transaction{ID: "trans3"}, nil)
+	require.NoError(t, backfill.Run(context.Background(), []string{"station1"}))
+
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransactions", 3)
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransaction", 3)
+	assert.Equal(t, checkpoint{page: 2, complete: true}, checkpoints["station1"])
+}
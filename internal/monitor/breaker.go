@@ -0,0 +1,210 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+/**
+* Circuit breakers for each Connect API endpoint the monitor calls for each
+* station, so a station that's been unshared, or an ID the API keeps failing
+* for, stops being polled rather than logging errors and using up API calls
+* on every poll. Other stations keep their normal cadence.
+*
+* A breaker opens after BreakerThreshold failures in a row. While it's open,
+* calls are skipped. Once the backoff has passed a single probe call is let
+* through (half open): if it succeeds the breaker closes, and if it fails the
+* breaker opens again with the backoff doubled, up to BreakerMaxBackoff.
+ */
+
+// Returned instead of calling an endpoint whose breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Endpoints with a breaker for each station
+const (
+	endpointStation          = "station"
+	endpointTransactionStats = "transaction_stats"
+	endpointTransactions     = "transactions"
+	endpointTransaction      = "transaction"
+)
+
+// Defaults for the breaker settings
+const (
+	DefaultBreakerThreshold  = 5
+	DefaultBreakerBackoff    = 5 * time.Minute
+	DefaultBreakerMaxBackoff = 6 * time.Hour
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+type breakerKey struct {
+	stationId string
+	endpoint  string
+}
+
+type circuitBreaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	backoff  time.Duration
+}
+
+// Whether a call would be skipped, either because the breaker is open and
+// not due a probe, or a probe is already in flight
+func (b *circuitBreaker) blocked(now time.Time) bool {
+	switch b.state {
+	case breakerOpen:
+		return now.Before(b.openedAt.Add(b.backoff))
+	case breakerHalfOpen:
+		return true
+	default:
+		return false
+	}
+}
+
+// The breakers of every station and endpoint
+type breakers struct {
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	now        func() time.Time
+
+	// Called with each change of state
+	changed func(key breakerKey, state breakerState)
+
+	mu       sync.Mutex
+	breakers map[breakerKey]*circuitBreaker
+}
+
+// Must be called with the lock held
+func (b *breakers) get(key breakerKey) *circuitBreaker {
+	if b.breakers == nil {
+		b.breakers = map[breakerKey]*circuitBreaker{}
+	}
+
+	breaker, ok := b.breakers[key]
+	if !ok {
+		breaker = &circuitBreaker{}
+		b.breakers[key] = breaker
+	}
+	return breaker
+}
+
+// Whether a call to an endpoint for a station would be skipped, without
+// starting a probe
+func (b *breakers) blocked(stationId string, endpoint string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.get(breakerKey{stationId, endpoint}).blocked(b.now())
+}
+
+// Call an endpoint for a station through its breaker, returning
+// ErrCircuitOpen without calling it if the breaker is open
+func (b *breakers) call(stationId string, endpoint string, fn func() error) error {
+	key := breakerKey{stationId, endpoint}
+
+	b.mu.Lock()
+	breaker := b.get(key)
+	if breaker.blocked(b.now()) {
+		b.mu.Unlock()
+		return fmt.Errorf("%w for %s of station %s", ErrCircuitOpen, endpoint, stationId)
+	}
+	if breaker.state == breakerOpen {
+		log.Printf("Probing %s of station %s after %s", endpoint, stationId, breaker.backoff)
+		b.transition(key, breaker, breakerHalfOpen)
+	}
+	b.mu.Unlock()
+
+	err := fn()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The station may have been removed while the call was in flight
+	if b.breakers[key] != breaker {
+		return err
+	}
+
+	if err == nil {
+		if breaker.state != breakerClosed {
+			log.Printf("Circuit for %s of station %s closed, it's working again", endpoint, stationId)
+			b.transition(key, breaker, breakerClosed)
+		}
+		breaker.failures = 0
+		breaker.backoff = 0
+		return nil
+	}
+
+	breaker.failures++
+	switch {
+	case breaker.state == breakerHalfOpen:
+		breaker.backoff = min(breaker.backoff*2, b.maxBackoff)
+	case breaker.failures >= b.threshold:
+		breaker.backoff = b.backoff
+	default:
+		return err
+	}
+
+	log.Printf("Circuit for %s of station %s opened after %d failures, trying again in %s: %v", endpoint, stationId, breaker.failures, breaker.backoff, err)
+	breaker.openedAt = b.now()
+	b.transition(key, breaker, breakerOpen)
+	return err
+}
+
+// Must be called with the lock held
+func (b *breakers) transition(key breakerKey, breaker *circuitBreaker, state breakerState) {
+	breaker.state = state
+	if b.changed != nil {
+		b.changed(key, state)
+	}
+}
+
+// The state of each of a station's breakers that isn't closed, by endpoint
+func (b *breakers) tripped(stationId string) map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var tripped map[string]string
+	for key, breaker := range b.breakers {
+		if key.stationId != stationId || breaker.state == breakerClosed {
+			continue
+		}
+		if tripped == nil {
+			tripped = map[string]string{}
+		}
+		tripped[key.endpoint] = breaker.state.String()
+	}
+	return tripped
+}
+
+func (b *breakers) remove(stationId string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key := range b.breakers {
+		if key.stationId == stationId {
+			delete(b.breakers, key)
+		}
+	}
+}
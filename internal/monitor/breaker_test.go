@@ -0,0 +1,108 @@
+package monitor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	b := &breakers{threshold: 2, backoff: time.Minute, maxBackoff: 3 * time.Minute, now: func() time.Time { return now }}
+
+	calls := 0
+	failing := func() error { calls++; return fmt.Errorf("unavailable") }
+	working := func() error { calls++; return nil }
+
+	require.Error(t, b.call("station1", endpointStation, failing))
+	assert.False(t, b.blocked("station1", endpointStation), "One failure shouldn't open the breaker")
+	require.Error(t, b.call("station1", endpointStation, failing))
+	assert.True(t, b.blocked("station1", endpointStation))
+	assert.False(t, b.blocked("station1", endpointTransactionStats), "Other endpoints should have their own breakers")
+	assert.False(t, b.blocked("station2", endpointStation), "Other stations should have their own breakers")
+
+	assert.ErrorIs(t, b.call("station1", endpointStation, working), ErrCircuitOpen)
+	assert.Equal(t, 2, calls, "Calls should be skipped while the breaker is open")
+	assert.Equal(t, map[string]string{endpointStation: "open"}, b.tripped("station1"))
+
+	// A failed probe backs off for twice as long, up to the maximum
+	now = now.Add(time.Minute)
+	require.Error(t, b.call("station1", endpointStation, failing))
+	now = now.Add(time.Minute)
+	assert.True(t, b.blocked("station1", endpointStation))
+	now = now.Add(time.Minute)
+	require.Error(t, b.call("station1", endpointStation, failing))
+	now = now.Add(2 * time.Minute)
+	assert.True(t, b.blocked("station1", endpointStation), "The backoff should be capped at the maximum")
+	now = now.Add(time.Minute)
+
+	// Only one probe at a time
+	probing := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.call("station1", endpointStation, func() error {
+			close(probing)
+			<-release
+			return working()
+		})
+	}()
+	<-probing
+	assert.Equal(t, map[string]string{endpointStation: "half_open"}, b.tripped("station1"))
+	assert.ErrorIs(t, b.call("station1", endpointStation, working), ErrCircuitOpen)
+	close(release)
+	require.NoError(t, <-done)
+
+	assert.Nil(t, b.tripped("station1"), "A successful probe should close the breaker")
+	require.Error(t, b.call("station1", endpointStation, failing))
+	assert.False(t, b.blocked("station1", endpointStation), "Closing should reset the failures")
+}
+
+func TestPollStationCircuitBreaker(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetStation", "station1").Return(connect.Station{}, fmt.Errorf("Station not found"))
+	mockConnectAPI.On("GetTransactionStatistics", "station1").Return(connect.TransactionStats{}, fmt.Errorf("Station not found"))
+	mockConnectAPI.On("GetStation", "station2").Return(connect.Station{ID: "station2", Online: true}, nil)
+	mockConnectAPI.On("GetTransactionStatistics", "station2").Return(connect.TransactionStats{}, nil)
+
+	mockStationStatusPublisher := new(MockStationStatusPublisher)
+	mockStationStatusPublisher.On("PublishStationStatus", mock.Anything)
+	mockTransactionStatsPublisher := new(MockTransactionStatsPublisher)
+	mockTransactionStatsPublisher.On("PublishTransactionStats", "station2", mock.Anything)
+
+	monitor := &StationMonitor{
+		Connect:          mockConnectAPI,
+		Publishers:       publishersOf(t, mockStationStatusPublisher, mockTransactionStatsPublisher),
+		Metrics:          NewMetrics(prometheus.NewRegistry()),
+		BreakerThreshold: 3,
+		stations:         map[string]connect.Station{"station1": {ID: "station1"}, "station2": {ID: "station2"}},
+	}
+
+	for range 5 {
+		for _, id := range []string{"station1", "station2"} {
+			monitor.polls.release(id)
+			monitor.pollStation(t.Context(), connect.Station{ID: id})
+		}
+	}
+
+	mockConnectAPI.AssertNumberOfCalls(t, "GetStation", 3+5)
+	assert.Equal(t, 2.0, testutil.ToFloat64(monitor.Metrics.BreakerState.WithLabelValues("station1", endpointStation)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(monitor.Metrics.BreakerOpens.WithLabelValues("station1", endpointTransactionStats)))
+
+	report := monitor.Health(t.Context())
+	assert.True(t, report.Ready(), "One broken station shouldn't stop the monitor being ready")
+	assert.Equal(t, HealthDegraded, report.Status)
+	assert.Equal(t, HealthDegraded, report.Stations["station1"].Status)
+	assert.Equal(t, map[string]string{endpointStation: "open", endpointTransactionStats: "open"}, report.Stations["station1"].Circuits)
+	assert.Equal(t, HealthOK, report.Stations["station2"].Status)
+
+	monitor.circuitBreakers().remove("station1")
+	assert.Nil(t, monitor.circuitBreakers().tripped("station1"))
+}
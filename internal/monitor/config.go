@@ -1,9 +1,71 @@
 package monitor
 
+import (
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/secrets"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
 // Config holds the configuration values
 type Config struct {
 	APIHost  string
 	Username string
-	Password string
+	Password secrets.Secret
 	Debug    bool
+
+	// How often to poll stations, depending on what they're doing. Unset
+	// intervals use their defaults.
+	PollIntervals PollIntervals
+	// Maximum number of Connect API calls to make in an hour, or 0 for no
+	// limit
+	APICallBudget int
+	// Most Connect API calls to make a second, however many are made at
+	// once, or 0 for no limit
+	APIRate float64
+	// How many of its poll intervals a station can go without a successful
+	// poll before the monitor is no longer ready, or 0 for the default
+	StalePolls int
+	// Most full transactions to fetch at once, or 0 for the default
+	FetchConcurrency int
+
+	// Settings for particular stations, by ID
+	Stations map[string]StationConfig
+
+	// Whether to compete with other replicas to be the one running jobs,
+	// and how long the leader's lease lasts, or 0 for the default
+	LeaderElection bool
+	LeaderLease    time.Duration
+}
+
+// Create a Connect API client for the account, which reads the password
+// each time it logs in and keeps to the rate limit
+func (c *Config) NewConnectAPI() *connect.ConnectAPIClient {
+	client := connect.NewConnectAPI(c.Username, "", c.APIHost)
+	client.PasswordSource = c.Password.Value
+	client.SetRateLimit(c.rateLimit())
+
+	if c.Debug {
+		client.SetDebug()
+	}
+	return client
+}
+
+// The time between Connect API calls that keeps to APIRate, or 0 for no
+// limit
+func (c *Config) rateLimit() time.Duration {
+	if c.APIRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / c.APIRate)
+}
+
+// Settings for a station that differ from the rest
+type StationConfig struct {
+	// What people call the station, used alongside its ID
+	Nickname string
+	// How often to poll the station. Unset intervals use the monitor's.
+	PollIntervals PollIntervals
+	// Don't monitor the station at all
+	Disabled bool
 }
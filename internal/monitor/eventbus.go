@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"log"
+	"sync"
+)
+
+// Default number of events buffered for each subscriber
+const DefaultEventBuffer = 64
+
+// An in-process bus carrying station events to any number of subscribers.
+// Publishing never blocks: a subscriber that falls a full buffer behind
+// misses events rather than holding up polling.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscription]struct{}
+}
+
+type subscription struct {
+	name   string
+	events chan StationEvent
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: map[*subscription]struct{}{}}
+}
+
+// Subscribe to every event published from now on. The name is used in logs.
+// The returned function unsubscribes and closes the channel.
+func (b *EventBus) Subscribe(name string, buffer int) (<-chan StationEvent, func()) {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+
+	sub := &subscription{name: name, events: make(chan StationEvent, buffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = struct{}{}
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subscribers, sub)
+			close(sub.events)
+		})
+	}
+}
+
+// Send an event to every subscriber. Safe to call on a nil bus, which
+// drops the event.
+func (b *EventBus) Publish(event StationEvent) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Event subscriber %s is falling behind, dropping %s", sub.name, event)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+// The kinds of change detected between successive polls of a station
+type EventType string
+
+const (
+	// A vehicle was plugged into a connector
+	EventVehiclePluggedIn EventType = "vehicle_plugged_in"
+	// A vehicle was unplugged from a connector
+	EventVehicleUnplugged EventType = "vehicle_unplugged"
+	// A connector started, or resumed, delivering power
+	EventChargingStarted EventType = "charging_started"
+	// Charging was paused by the vehicle (SuspendedEV) or the station
+	// (SuspendedEVSE), e.g. once the battery is full or a schedule ends
+	EventChargingSuspended EventType = "charging_suspended"
+	// A charging session on a connector ended
+	EventSessionFinished EventType = "session_finished"
+	// The station lost, or regained, its connection to the Connect cloud
+	EventStationOffline EventType = "station_offline"
+	EventStationOnline  EventType = "station_online"
+	// The station or a connector reported an error, or stopped reporting one
+	EventErrorRaised  EventType = "error_raised"
+	EventErrorCleared EventType = "error_cleared"
+)
+
+// The state of a station, or one of its connectors, either side of a change
+type EventState struct {
+	Online    bool    `json:"online"`
+	Status    string  `json:"status"`
+	ErrorCode string  `json:"errorCode"`
+	Power     float64 `json:"power"`
+}
+
+// A change in a station's state
+type StationEvent struct {
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	StationID string    `json:"stationId"`
+	// The station's nickname, if it's been given one
+	StationName string `json:"stationName,omitempty"`
+	// The connector that changed, or 0 for changes to the whole station
+	ConnectorID int        `json:"connectorId"`
+	Before      EventState `json:"before"`
+	After       EventState `json:"after"`
+}
+
+func (e StationEvent) String() string {
+	if e.ConnectorID == 0 {
+		return fmt.Sprintf("%s on station %s", e.Type, e.StationID)
+	}
+	return fmt.Sprintf("%s on station %s connector %d", e.Type, e.StationID, e.ConnectorID)
+}
+
+// Connector statuses with a vehicle plugged in, using the OCPP
+// ChargePointStatus values
+func pluggedIn(status string) bool {
+	switch status {
+	case "Preparing", "Charging", "SuspendedEV", "SuspendedEVSE", "Finishing", "Occupied":
+		return true
+	}
+	return false
+}
+
+// Connector statuses during a charging session, before it finishes
+func inSession(status string) bool {
+	switch status {
+	case "Charging", "SuspendedEV", "SuspendedEVSE":
+		return true
+	}
+	return false
+}
+
+// Stations report "NoError" rather than nothing when all is well
+func hasError(errorCode string) bool {
+	return errorCode != "" && errorCode != "NoError"
+}
+
+// Work out what changed between two polls of a station. Connectors that
+// weren't in the earlier poll are compared against an empty state, so
+// nothing is reported for them unless they're busy or faulted.
+func DiffStation(before connect.Station, after connect.Station, at time.Time) []StationEvent {
+	var events []StationEvent
+
+	event := func(eventType EventType, connectorId int, from EventState, to EventState) {
+		events = append(events, StationEvent{
+			Type:        eventType,
+			Time:        at,
+			StationID:   after.ID,
+			ConnectorID: connectorId,
+			Before:      from,
+			After:       to,
+		})
+	}
+
+	stationBefore := EventState{Online: before.Online, Status: before.Status, ErrorCode: before.ErrorCode}
+	stationAfter := EventState{Online: after.Online, Status: after.Status, ErrorCode: after.ErrorCode}
+
+	switch {
+	case before.Online && !after.Online:
+		event(EventStationOffline, 0, stationBefore, stationAfter)
+	case !before.Online && after.Online:
+		event(EventStationOnline, 0, stationBefore, stationAfter)
+	}
+	diffErrors(before.ErrorCode, after.ErrorCode, func(eventType EventType) {
+		event(eventType, 0, stationBefore, stationAfter)
+	})
+
+	previous := map[int]connect.Connector{}
+	for _, connector := range before.Connectors {
+		previous[connector.ID] = connector
+	}
+
+	for _, connector := range after.Connectors {
+		was := previous[connector.ID]
+		from := EventState{Online: before.Online, Status: was.Status, ErrorCode: was.ErrorCode, Power: was.Power}
+		to := EventState{Online: after.Online, Status: connector.Status, ErrorCode: connector.ErrorCode, Power: connector.Power}
+		emit := func(eventType EventType) {
+			event(eventType, connector.ID, from, to)
+		}
+
+		if was.Status != connector.Status {
+			if !pluggedIn(was.Status) && pluggedIn(connector.Status) {
+				emit(EventVehiclePluggedIn)
+			}
+
+			switch connector.Status {
+			case "Charging":
+				emit(EventChargingStarted)
+			case "SuspendedEV", "SuspendedEVSE":
+				emit(EventChargingSuspended)
+			}
+
+			// A session ends by finishing, or by being unplugged part way
+			// through
+			if inSession(was.Status) && !inSession(connector.Status) && (connector.Status == "Finishing" || !pluggedIn(connector.Status)) {
+				emit(EventSessionFinished)
+			}
+
+			if pluggedIn(was.Status) && !pluggedIn(connector.Status) {
+				emit(EventVehicleUnplugged)
+			}
+		}
+
+		diffErrors(was.ErrorCode, connector.ErrorCode, emit)
+	}
+
+	return events
+}
+
+// Emit error events for a change in error code. A change from one error to
+// another is reported as the new error being raised.
+func diffErrors(before string, after string, emit func(EventType)) {
+	switch {
+	case before == after:
+	case hasError(after):
+		emit(EventErrorRaised)
+	case hasError(before):
+		emit(EventErrorCleared)
+	}
+}
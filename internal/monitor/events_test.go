@@ -0,0 +1,136 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func stationWith(online bool, status string, errorCode string) connect.Station {
+	return connect.Station{
+		ID:         "station1",
+		Online:     online,
+		ErrorCode:  "NoError",
+		Connectors: []connect.Connector{{ID: 1, Status: status, ErrorCode: errorCode}},
+	}
+}
+
+func eventTypes(events []StationEvent) []EventType {
+	var types []EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestDiffStation(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   connect.Station
+		after    connect.Station
+		expected []EventType
+	}{
+		{"no change", stationWith(true, "Available", "NoError"), stationWith(true, "Available", "NoError"), nil},
+		{"plugged in", stationWith(true, "Available", "NoError"), stationWith(true, "Preparing", "NoError"), []EventType{EventVehiclePluggedIn}},
+		{"plugged in and charging", stationWith(true, "Available", "NoError"), stationWith(true, "Charging", "NoError"), []EventType{EventVehiclePluggedIn, EventChargingStarted}},
+		{"charging started", stationWith(true, "Preparing", "NoError"), stationWith(true, "Charging", "NoError"), []EventType{EventChargingStarted}},
+		{"suspended by EV", stationWith(true, "Charging", "NoError"), stationWith(true, "SuspendedEV", "NoError"), []EventType{EventChargingSuspended}},
+		{"resumed", stationWith(true, "SuspendedEV", "NoError"), stationWith(true, "Charging", "NoError"), []EventType{EventChargingStarted}},
+		{"finished", stationWith(true, "Charging", "NoError"), stationWith(true, "Finishing", "NoError"), []EventType{EventSessionFinished}},
+		{"unplugged after finishing", stationWith(true, "Finishing", "NoError"), stationWith(true, "Available", "NoError"), []EventType{EventVehicleUnplugged}},
+		{"unplugged while charging", stationWith(true, "Charging", "NoError"), stationWith(true, "Available", "NoError"), []EventType{EventSessionFinished, EventVehicleUnplugged}},
+		{"offline", stationWith(true, "Available", "NoError"), stationWith(false, "Available", "NoError"), []EventType{EventStationOffline}},
+		{"online", stationWith(false, "Available", "NoError"), stationWith(true, "Available", "NoError"), []EventType{EventStationOnline}},
+		{"error raised", stationWith(true, "Available", "NoError"), stationWith(true, "Faulted", "GroundFailure"), []EventType{EventErrorRaised}},
+		{"error cleared", stationWith(true, "Faulted", "GroundFailure"), stationWith(true, "Available", "NoError"), []EventType{EventErrorCleared}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, eventTypes(DiffStation(test.before, test.after, time.Now())))
+		})
+	}
+}
+
+func TestDiffStationState(t *testing.T) {
+	at := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	before := stationWith(true, "Preparing", "NoError")
+	after := stationWith(true, "Charging", "NoError")
+	after.Connectors[0].Power = 7.2
+
+	events := DiffStation(before, after, at)
+	require.Len(t, events, 1)
+
+	assert.Equal(t, StationEvent{
+		Type:        EventChargingStarted,
+		Time:        at,
+		StationID:   "station1",
+		ConnectorID: 1,
+		Before:      EventState{Online: true, Status: "Preparing", ErrorCode: "NoError"},
+		After:       EventState{Online: true, Status: "Charging", ErrorCode: "NoError", Power: 7.2},
+	}, events[0])
+	assert.Equal(t, "charging_started on station station1 connector 1", events[0].String())
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	first, unsubscribeFirst := bus.Subscribe("first", 1)
+	second, unsubscribeSecond := bus.Subscribe("second", 1)
+	defer unsubscribeSecond()
+
+	event := StationEvent{Type: EventStationOffline, StationID: "station1"}
+	bus.Publish(event)
+	assert.Equal(t, event, <-first)
+	assert.Equal(t, event, <-second)
+
+	// The second subscriber isn't keeping up, which shouldn't block the
+	// first or the publisher
+	bus.Publish(event)
+	bus.Publish(event)
+	assert.Equal(t, event, <-first)
+	assert.Len(t, second, 1)
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	_, open := <-first
+	assert.False(t, open, "Unsubscribing should close the channel")
+	bus.Publish(event)
+
+	var nilBus *EventBus
+	assert.NotPanics(t, func() { nilBus.Publish(event) })
+}
+
+func TestStationStatsEvents(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetStation", "station1").Return(stationWith(true, "Available", "NoError"), nil).Once()
+	mockConnectAPI.On("GetStation", "station1").Return(stationWith(true, "Charging", "NoError"), nil).Once()
+
+	mockStationStatusPublisher := new(MockStationStatusPublisher)
+	mockStationStatusPublisher.On("PublishStationStatus", mock.Anything)
+
+	monitor := &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockStationStatusPublisher),
+		Events:     NewEventBus(),
+		Metrics:    NewMetrics(prometheus.NewRegistry()),
+	}
+	events, unsubscribe := monitor.Events.Subscribe("test", 0)
+	defer unsubscribe()
+
+	station := connect.Station{ID: "station1"}
+	require.NoError(t, monitor.stationStats(context.Background(), station))
+	assert.Empty(t, events, "The first poll is only a baseline")
+
+	require.NoError(t, monitor.stationStats(context.Background(), station))
+	require.Len(t, events, 2)
+	assert.Equal(t, EventVehiclePluggedIn, (<-events).Type)
+	assert.Equal(t, EventChargingStarted, (<-events).Type)
+	assert.Equal(t, 1.0, testutil.ToFloat64(monitor.Metrics.StationEvents.WithLabelValues(string(EventChargingStarted))))
+}
@@ -0,0 +1,68 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Default number of full transactions fetched at once
+const DefaultFetchConcurrency = 4
+
+// Fetch each of the items with up to concurrency fetches in flight, handing
+// each result to handle in the order of the items, so they're published in
+// order whichever finishes first. Fetches only run a little ahead of handle,
+// so results that are large, like full transactions, don't pile up.
+//
+// Handle returns false to stop: fetches that haven't started are skipped and
+// no more results are handed over.
+func fetchInOrder[T, R any](ctx context.Context, concurrency int, items []T, fetch func(context.Context, T) (R, error), handle func(T, R, error) bool) {
+	if len(items) == 0 {
+		return
+	}
+	concurrency = max(1, min(concurrency, len(items)))
+
+	type result struct {
+		value R
+		err   error
+	}
+	results := make([]chan result, len(items))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	// Each item takes a place in the window until it's been handled
+	window := make(chan struct{}, 2*concurrency)
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := range items {
+			window <- struct{}{}
+			next <- i
+		}
+	}()
+
+	var stopped atomic.Bool
+	var workers sync.WaitGroup
+	for range concurrency {
+		workers.Go(func() {
+			for i := range next {
+				var r result
+				if !stopped.Load() {
+					r.value, r.err = fetch(ctx, items[i])
+				}
+				results[i] <- r
+			}
+		})
+	}
+
+	for i, item := range items {
+		r := <-results[i]
+		if !stopped.Load() && !handle(item, r.value, r.err) {
+			stopped.Store(true)
+		}
+		<-window
+	}
+
+	workers.Wait()
+}
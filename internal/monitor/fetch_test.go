@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchInOrder(t *testing.T) {
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	var inFlight, maxInFlight atomic.Int32
+
+	fetch := func(ctx context.Context, item int) (string, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			highest := maxInFlight.Load()
+			if n <= highest || maxInFlight.CompareAndSwap(highest, n) {
+				break
+			}
+		}
+
+		// Finish in any order
+		time.Sleep(time.Duration(rand.IntN(5)) * time.Millisecond)
+		if item == 3 {
+			return "", fmt.Errorf("error fetching %d", item)
+		}
+		return fmt.Sprint("item", item), nil
+	}
+
+	var handled []string
+	fetchInOrder(context.Background(), 3, items, fetch, func(item int, value string, err error) bool {
+		if err != nil {
+			handled = append(handled, err.Error())
+		} else {
+			handled = append(handled, value)
+		}
+		return true
+	})
+
+	assert.Equal(t, []string{"item0", "item1", "item2", "error fetching 3", "item4", "item5", "item6", "item7", "item8", "item9"}, handled)
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(3))
+	assert.Greater(t, maxInFlight.Load(), int32(1), "Items should be fetched in parallel")
+}
+
+func TestFetchInOrderStops(t *testing.T) {
+	items := make([]int, 50)
+	for i := range items {
+		items[i] = i
+	}
+	var fetched atomic.Int32
+
+	fetch := func(ctx context.Context, item int) (int, error) {
+		fetched.Add(1)
+		return item, nil
+	}
+
+	var handled []int
+	fetchInOrder(context.Background(), 2, items, fetch, func(item int, value int, err error) bool {
+		handled = append(handled, value)
+		return item < 4
+	})
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, handled)
+	assert.Less(t, int(fetched.Load()), len(items), "Fetches shouldn't run far ahead of the results being handled")
+}
+
+func connectToken(t *testing.T, expires time.Time) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": expires.Unix()}).SignedString([]byte("key"))
+	require.NoError(t, err)
+	return token
+}
+
+// Fetching with the real client, which has to log in again part way through.
+// Run with -race.
+func TestFetchInOrderConnectClient(t *testing.T) {
+	fresh := connectToken(t, time.Now().Add(time.Hour))
+	var logins atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /client/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		// Slow enough that the other fetches find the token expired too
+		time.Sleep(10 * time.Millisecond)
+		json.NewEncoder(w).Encode(connect.LoginResponse{Token: fresh})
+	})
+	mux.HandleFunc("GET /client/transactions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fresh {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(connect.GetTransactionResponse{Transaction: connect.Transaction{ID: r.PathValue("id")}})
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Application-Version", `{"iosMinimalVersion": "0.9.2"}`)
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := connect.NewConnectAPI("user", "password", server.URL)
+	client.Token = connectToken(t, time.Now().Add(-time.Hour))
+
+	items := []string{"trans0", "trans1", "trans2", "trans3", "trans4", "trans5", "trans6", "trans7"}
+	var handled []string
+	fetchInOrder(context.Background(), 4, items, func(ctx context.Context, id string) (connect.Transaction, error) {
+		return client.GetTransaction(ctx, id)
+	}, func(id string, transaction connect.Transaction, err error) bool {
+		assert.NoError(t, err)
+		handled = append(handled, transaction.ID)
+		return true
+	})
+
+	assert.Equal(t, items, handled)
+	assert.Equal(t, int32(1), logins.Load(), "Fetches that find the token expired at once should share a login")
+}
@@ -0,0 +1,213 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+// Default number of poll intervals a station can go without a successful poll
+// before the monitor is no longer ready
+const DefaultStalePolls = 3
+
+// How long publishers have to answer a health check
+const healthCheckTimeout = 5 * time.Second
+
+// Component statuses in health reports. Degraded components are working
+// around a problem, and don't stop the monitor being ready.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthFailing  = "failing"
+)
+
+// What the monitor can see of its own health, served as JSON by the health
+// and readiness endpoints
+type HealthReport struct {
+	// HealthOK if every component is healthy, or the worst status of them
+	Status string `json:"status"`
+	// RoleLeader or RoleStandby when replicas elect a leader
+	Role       string                     `json:"role,omitempty"`
+	Connect    ConnectHealth              `json:"connect"`
+	Stations   map[string]StationHealth   `json:"stations"`
+	Publishers map[string]ComponentHealth `json:"publishers"`
+}
+
+type ComponentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ConnectHealth struct {
+	ComponentHealth
+	connect.Health
+}
+
+type StationHealth struct {
+	ComponentHealth
+	Nickname     string    `json:"nickname,omitempty"`
+	Activity     string    `json:"activity"`
+	PollInterval string    `json:"poll_interval"`
+	LastSuccess  time.Time `json:"last_success,omitzero"`
+	Paused       bool      `json:"paused,omitempty"`
+	// The state of each circuit breaker that isn't closed, by endpoint
+	Circuits map[string]string `json:"circuits,omitempty"`
+}
+
+// Replica roles in health reports
+const (
+	RoleLeader  = "leader"
+	RoleStandby = "standby"
+)
+
+func componentHealth(err error) ComponentHealth {
+	if err != nil {
+		return ComponentHealth{Status: HealthFailing, Error: err.Error()}
+	}
+	return ComponentHealth{Status: HealthOK}
+}
+
+func (r HealthReport) Ready() bool {
+	return r.Status != HealthFailing
+}
+
+// Check the health of the Connect API client, each station's polling and
+// each publisher
+func (m *StationMonitor) Health(ctx context.Context) HealthReport {
+	report := HealthReport{
+		Status:     HealthOK,
+		Stations:   map[string]StationHealth{},
+		Publishers: map[string]ComponentHealth{},
+	}
+	fail := func(health ComponentHealth) {
+		switch {
+		case health.Status == HealthFailing:
+			report.Status = HealthFailing
+		case health.Status == HealthDegraded && report.Status == HealthOK:
+			report.Status = HealthDegraded
+		}
+	}
+
+	clientHealth := m.Connect.Health()
+	report.Connect = ConnectHealth{componentHealth(clientHealth.Err()), clientHealth}
+	fail(report.Connect.ComponentHealth)
+
+	leader, leaderSince := m.leading()
+	if m.Leader != nil {
+		report.Role = RoleStandby
+		if leader {
+			report.Role = RoleLeader
+		}
+	}
+
+	now := time.Now()
+	for _, stationId := range m.monitoredStations() {
+		health := m.stationHealth(stationId, now, leader, leaderSince)
+		report.Stations[stationId] = health
+		fail(health.ComponentHealth)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	for name, err := range m.Publishers.CheckHealth(ctx) {
+		health := componentHealth(err)
+		report.Publishers[name] = health
+		fail(health)
+	}
+
+	return report
+}
+
+// Whether this replica runs jobs, and since when. Without an election it
+// always has.
+func (m *StationMonitor) leading() (bool, time.Time) {
+	if m.Leader == nil {
+		return true, time.Time{}
+	}
+	return m.Leader.Leading()
+}
+
+// The IDs of the stations being monitored
+func (m *StationMonitor) monitoredStations() []string {
+	m.stationsMu.Lock()
+	defer m.stationsMu.Unlock()
+
+	ids := make([]string, 0, len(m.stations))
+	for id := range m.stations {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// A station is stale once it's gone StalePolls of its current poll interval
+// without a successful poll, counting from when it was added if it's never
+// been polled, or from when this replica became the leader. Paused stations
+// and those of standby replicas are never stale, and stations with open
+// circuit breakers are degraded instead.
+func (m *StationMonitor) stationHealth(stationId string, now time.Time, leader bool, leaderSince time.Time) StationHealth {
+	poll := m.polls.status(stationId)
+	interval := m.stationIntervals(stationId).interval(poll.activity)
+
+	health := StationHealth{
+		ComponentHealth: componentHealth(nil),
+		Nickname:        m.stationConfig(stationId).Nickname,
+		Activity:        poll.activity.String(),
+		PollInterval:    interval.String(),
+		LastSuccess:     poll.lastSuccess,
+		Paused:          poll.paused,
+	}
+
+	// Not being polled is expected while it's paused
+	if poll.paused {
+		return health
+	}
+
+	// Or on a standby, which leaves polling to the leader
+	if !leader {
+		return health
+	}
+
+	// Nor is it while the breakers are skipping its calls
+	if health.Circuits = m.circuitBreakers().tripped(stationId); health.Circuits != nil {
+		health.Status = HealthDegraded
+		return health
+	}
+
+	since := poll.lastSuccess
+	if since.IsZero() {
+		since = poll.added
+	}
+	if leaderSince.After(since) {
+		since = leaderSince
+	}
+
+	stalePolls := m.stalePolls()
+	if !since.IsZero() && now.Sub(since) > time.Duration(stalePolls)*interval {
+		health.ComponentHealth = componentHealth(fmt.Errorf("no successful poll in %s", now.Sub(since).Round(time.Second)))
+	}
+
+	return health
+}
+
+// Serve the health report, always with a 200 while the process is up
+func (m *StationMonitor) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Health(r.Context()))
+	})
+}
+
+// Serve the health report, with a 503 if anything is failing
+func (m *StationMonitor) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := m.Health(r.Context())
+
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A station status publisher that can report its health
+type checkedStatusPublisher struct {
+	MockStationStatusPublisher
+	err error
+}
+
+func (c *checkedStatusPublisher) CheckHealth(ctx context.Context) error {
+	return c.err
+}
+
+func TestHealthStations(t *testing.T) {
+	monitor, _ := newPollingMonitor(t, "Charging")
+	monitor.stations = map[string]connect.Station{"station1": {ID: "station1"}, "station2": {ID: "station2"}}
+
+	monitor.pollStation(context.Background(), connect.Station{ID: "station1"})
+	monitor.polls.add("station2", time.Now().Add(-2*time.Hour))
+
+	report := monitor.Health(context.Background())
+	assert.False(t, report.Ready())
+
+	assert.Equal(t, HealthOK, report.Stations["station1"].Status)
+	assert.Equal(t, "charging", report.Stations["station1"].Activity)
+	assert.Equal(t, "30s", report.Stations["station1"].PollInterval)
+	assert.False(t, report.Stations["station1"].LastSuccess.IsZero())
+
+	assert.Equal(t, HealthFailing, report.Stations["station2"].Status, "A station never polled in 3 intervals should be stale")
+	assert.Contains(t, report.Stations["station2"].Error, "no successful poll in 2h0m0s")
+
+	// Allow the idle station more intervals
+	monitor.StalePolls = 5
+	assert.True(t, monitor.Health(context.Background()).Ready())
+}
+
+func TestHealthConnectAndPublishers(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	database := &checkedStatusPublisher{err: fmt.Errorf("connection refused")}
+
+	monitor := &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, &MockStationStatusPublisher{}, database),
+	}
+
+	report := monitor.Health(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, HealthOK, report.Connect.Status)
+	assert.Equal(t, HealthOK, report.Publishers["sink0"].Status, "Publishers without a health check should be healthy")
+	assert.Equal(t, ComponentHealth{Status: HealthFailing, Error: "connection refused"}, report.Publishers["sink1"])
+
+	database.err = nil
+	mockConnectAPI.health = connect.Health{LoginError: "bad password"}
+
+	report = monitor.Health(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, HealthFailing, report.Connect.Status)
+	assert.Equal(t, "login failing: bad password", report.Connect.Error)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	mockConnectAPI := &MockConnectAPI{health: connect.Health{VersionError: "unsupported API version 2.0.0"}}
+	monitor := &StationMonitor{Connect: mockConnectAPI}
+
+	for path, handler := range map[string]http.Handler{
+		"/healthz": monitor.HealthHandler(),
+		"/readyz":  monitor.ReadinessHandler(),
+	} {
+		t.Run(path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if path == "/healthz" {
+				assert.Equal(t, http.StatusOK, rec.Code, "The process is alive even when it isn't ready")
+			} else {
+				assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			}
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			body := map[string]any{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, HealthFailing, body["status"])
+			assert.Equal(t, "unsupported API version 2.0.0", body["connect"].(map[string]any)["version_error"])
+			assert.Contains(t, body, "stations")
+			assert.Contains(t, body, "publishers")
+		})
+	}
+}
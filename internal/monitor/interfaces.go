@@ -1,6 +1,8 @@
 package monitor
 
 import (
+	"context"
+
 	"github.com/speshak/grizzl-e-monitor/pkg/connect"
 )
 
@@ -13,8 +15,27 @@ type StationStatusPublisher interface {
 	Close() error
 }
 
+// Publishers holding per-station state, such as metric series, that should
+// be dropped once a station is no longer monitored
+type StationRemovalPublisher interface {
+	RemoveStation(stationId string)
+}
+
 type TransactionHistoryPublisher interface {
 	PublishTransactionHistory(stationId string, transaction connect.Transaction) error
 	TransactionPublished(transaction connect.Transaction) bool
 	Close() error
 }
+
+// Publishers of sessions as they happen. Each call has the transaction as it
+// stands, with only the meter values sampled since the last call, and the
+// last call has its stop time set.
+type SessionPublisher interface {
+	PublishSessionProgress(stationId string, transaction connect.Transaction) error
+}
+
+// Publishers that can check whether they're able to publish, e.g. that
+// their database is reachable
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
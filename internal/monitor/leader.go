@@ -0,0 +1,166 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+/**
+* Leader election for running more than one replica of the monitor. Each
+* replica tries to take a lease with a short expiry, and the one that has it
+* renews it every third of its TTL. Only the leader's scheduler runs jobs;
+* standbys keep serving metrics and health, and one of them takes over once
+* the leader's lease expires, within a TTL of it dying.
+*
+* A leader that can't renew its lease stands down straight away, before the
+* lease has expired, cancelling the jobs it's running and waiting for them to
+* finish, so two replicas are never both running jobs.
+ */
+
+// Returned by IsLeader on replicas that aren't the leader
+var ErrNotLeader = errors.New("not the leader")
+
+// Default time a leader's lease lasts without being renewed
+const DefaultLeaderLease = 15 * time.Second
+
+// Name of the lease replicas compete for
+const leaderLeaseName = "grizzl-e-monitor"
+
+// Somewhere replicas can share a lease, e.g. a database table
+type LeaseStore interface {
+	// Take the named lease for holder if it's free or has expired, or renew
+	// it if holder already has it, returning whether holder has it
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	// Give up the named lease, if holder has it
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+type LeaderElector struct {
+	Leases LeaseStore
+	// Identifies this replica, e.g. its hostname
+	Holder string
+	// How long the lease lasts without being renewed
+	TTL time.Duration
+
+	// Called when this replica becomes the leader or stands down
+	Changed func(leader bool)
+
+	mu      sync.Mutex
+	leader  bool
+	since   time.Time
+	expires time.Time
+}
+
+func NewLeaderElector(leases LeaseStore, holder string, ttl time.Duration) *LeaderElector {
+	return &LeaderElector{Leases: leases, Holder: holder, TTL: ttl}
+}
+
+func (e *LeaderElector) ttl() time.Duration {
+	if e.TTL <= 0 {
+		return DefaultLeaderLease
+	}
+	return e.TTL
+}
+
+// Compete for the lease until the context is cancelled, then give it up if
+// this replica has it
+func (e *LeaderElector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.ttl() / 3)
+	defer ticker.Stop()
+
+	for {
+		e.renew(ctx)
+
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElector) renew(ctx context.Context) {
+	ttl := e.ttl()
+	// The lease runs from before the call, in case it's slow
+	expires := time.Now().Add(ttl)
+
+	renewCtx, cancel := context.WithTimeout(ctx, ttl/3)
+	defer cancel()
+
+	held, err := e.Leases.AcquireLease(renewCtx, leaderLeaseName, e.Holder, ttl)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error renewing leader lease: %v", err)
+		}
+		held = false
+	}
+
+	e.set(held, expires)
+}
+
+func (e *LeaderElector) resign() {
+	e.mu.Lock()
+	leader := e.leader
+	e.mu.Unlock()
+
+	if !leader {
+		return
+	}
+
+	// Stand down before the lease is released, so there's no overlap
+	e.set(false, time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl()/3)
+	defer cancel()
+	if err := e.Leases.ReleaseLease(ctx, leaderLeaseName, e.Holder); err != nil {
+		log.Printf("Error releasing leader lease, the next leader will wait for it to expire: %v", err)
+	}
+}
+
+func (e *LeaderElector) set(leader bool, expires time.Time) {
+	e.mu.Lock()
+	changed := leader != e.leader
+	e.leader = leader
+	e.expires = expires
+	if changed && leader {
+		e.since = time.Now()
+	}
+	e.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if leader {
+		log.Printf("%s is now the leader, running jobs", e.Holder)
+	} else {
+		log.Printf("%s is no longer the leader, standing by", e.Holder)
+	}
+	if e.Changed != nil {
+		e.Changed(leader)
+	}
+}
+
+// Whether this replica is the leader, and since when. A lease that hasn't
+// been renewed in time isn't counted, even if the renewal is still running.
+func (e *LeaderElector) Leading() (bool, time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.leader || !time.Now().Before(e.expires) {
+		return false, time.Time{}
+	}
+	return true, e.since
+}
+
+// Satisfies gocron.Elector, so the scheduler only runs jobs on the leader
+func (e *LeaderElector) IsLeader(context.Context) error {
+	if leader, _ := e.Leading(); !leader {
+		return ErrNotLeader
+	}
+	return nil
+}
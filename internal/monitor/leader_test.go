@@ -0,0 +1,166 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A lease store shared by replicas in memory
+type memoryLeases struct {
+	mu      sync.Mutex
+	holder  string
+	expires time.Time
+	err     error
+}
+
+func (l *memoryLeases) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return false, l.err
+	}
+	if l.holder != holder && time.Now().Before(l.expires) {
+		return false, nil
+	}
+	l.holder = holder
+	l.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memoryLeases) ReleaseLease(ctx context.Context, name string, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder == holder {
+		l.holder = ""
+		l.expires = time.Time{}
+	}
+	return nil
+}
+
+func (l *memoryLeases) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+}
+
+func TestLeaderElection(t *testing.T) {
+	leases := &memoryLeases{}
+	ttl := 150 * time.Millisecond
+
+	a := NewLeaderElector(leases, "a", ttl)
+	aCtx, stopA := context.WithCancel(context.Background())
+	aDone := make(chan error)
+	go func() { aDone <- a.Run(aCtx) }()
+
+	require.Eventually(t, func() bool { return a.IsLeader(context.Background()) == nil }, time.Second, 10*time.Millisecond)
+
+	b := NewLeaderElector(leases, "b", ttl)
+	bCtx, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	go b.Run(bCtx)
+
+	// The leader keeps its lease renewed
+	time.Sleep(3 * ttl)
+	assert.NoError(t, a.IsLeader(context.Background()))
+	assert.ErrorIs(t, b.IsLeader(context.Background()), ErrNotLeader)
+
+	// The standby takes over once the leader stops
+	stopA()
+	<-aDone
+	assert.ErrorIs(t, a.IsLeader(context.Background()), ErrNotLeader)
+	require.Eventually(t, func() bool { return b.IsLeader(context.Background()) == nil }, time.Second, 10*time.Millisecond)
+
+	leader, since := b.Leading()
+	assert.True(t, leader)
+	assert.False(t, since.IsZero())
+}
+
+func TestLeaderStandsDownWhenRenewalFails(t *testing.T) {
+	leases := &memoryLeases{}
+	var changes []bool
+
+	elector := NewLeaderElector(leases, "a", time.Minute)
+	elector.Changed = func(leader bool) { changes = append(changes, leader) }
+
+	elector.renew(context.Background())
+	assert.NoError(t, elector.IsLeader(context.Background()))
+
+	// The lease may still be live in the database, but without renewing it
+	// there's no telling if it'll expire before the next renewal
+	leases.fail(fmt.Errorf("connection refused"))
+	elector.renew(context.Background())
+	assert.ErrorIs(t, elector.IsLeader(context.Background()), ErrNotLeader)
+
+	assert.Equal(t, []bool{true, false}, changes)
+}
+
+func TestStandingDownCancelsJobs(t *testing.T) {
+	leases := &memoryLeases{}
+	monitor := &StationMonitor{ShutdownTimeout: time.Second}
+	elector := monitor.ElectLeader(leases, "a", time.Minute)
+	elector.renew(context.Background())
+
+	started := make(chan struct{})
+	var jobErr error
+	var jobs sync.WaitGroup
+	jobs.Go(func() {
+		monitor.runJob(context.Background(), "poll", connect.Station{ID: "station1"}, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			jobErr = ctx.Err()
+			return jobErr
+		})
+	})
+	<-started
+
+	// Standing down doesn't return until the job has finished
+	leases.fail(fmt.Errorf("connection refused"))
+	elector.renew(context.Background())
+	assert.ErrorIs(t, jobErr, context.Canceled)
+	jobs.Wait()
+
+	// Nor do jobs that start late in the term run on
+	err := monitor.runJob(context.Background(), "poll", connect.Station{ID: "station1"}, func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The next term starts afresh
+	leases.fail(nil)
+	elector.renew(context.Background())
+	err = monitor.runJob(context.Background(), "poll", connect.Station{ID: "station1"}, func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	assert.NoError(t, err)
+}
+
+func TestHealthStandby(t *testing.T) {
+	monitor, _ := newPollingMonitor(t, "Charging")
+	monitor.stations = map[string]connect.Station{"station1": {ID: "station1"}}
+	monitor.polls.add("station1", time.Now().Add(-2*time.Hour))
+	monitor.ElectLeader(&memoryLeases{holder: "other", expires: time.Now().Add(time.Minute)}, "replica", time.Minute)
+
+	// Standbys don't poll, so their stations are never stale
+	monitor.Leader.renew(context.Background())
+	report := monitor.Health(context.Background())
+	assert.True(t, report.Ready())
+	assert.Equal(t, RoleStandby, report.Role)
+	assert.Equal(t, HealthOK, report.Stations["station1"].Status)
+
+	// Nor are they straight after taking over
+	monitor.Leader.Leases = &memoryLeases{}
+	monitor.Leader.renew(context.Background())
+	report = monitor.Health(context.Background())
+	assert.True(t, report.Ready())
+	assert.Equal(t, RoleLeader, report.Role)
+	assert.Equal(t, HealthOK, report.Stations["station1"].Status)
+}
@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Self-instrumentation metrics for the monitor's jobs. All methods are safe to
+// call on a nil *Metrics, so a monitor without metrics records nothing.
+type Metrics struct {
+	JobLastSuccess *prometheus.GaugeVec
+	JobDuration    *prometheus.HistogramVec
+	PublishErrors  *prometheus.CounterVec
+	PollInterval   *prometheus.GaugeVec
+	JobsDeferred   *prometheus.CounterVec
+	StationEvents  *prometheus.CounterVec
+	BreakerState   *prometheus.GaugeVec
+	BreakerOpens   *prometheus.CounterVec
+	Leader         prometheus.Gauge
+}
+
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	jobLabels := []string{"job", "station_id"}
+
+	return &Metrics{
+		JobLastSuccess: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "job_last_success_timestamp_seconds",
+			Help:      "The last time the job completed without errors",
+		}, jobLabels),
+		JobDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "job_duration_seconds",
+			Help:      "How long each job run took",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+		}, jobLabels),
+		PublishErrors: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "publish_errors_total",
+			Help:      "The number of errors publishing events to each publisher",
+		}, []string{"publisher"}),
+		PollInterval: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "poll_interval_seconds",
+			Help:      "How often the station is being polled, based on what it's doing",
+		}, []string{"station_id"}),
+		JobsDeferred: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "jobs_deferred_total",
+			Help:      "The number of job runs put off because the API call budget was used up",
+		}, []string{"job"}),
+		StationEvents: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "station_events_total",
+			Help:      "The number of station state changes seen, by type",
+		}, []string{"type"}),
+		BreakerState: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "circuit_breaker_state",
+			Help:      "The state of the circuit breaker on each of a station's endpoints: 0 closed, 1 half open, 2 open",
+		}, []string{"station_id", "endpoint"}),
+		BreakerOpens: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "circuit_breaker_opens_total",
+			Help:      "The number of times the circuit breaker on each of a station's endpoints has opened",
+		}, []string{"station_id", "endpoint"}),
+		Leader: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "monitor",
+			Name:      "leader",
+			Help:      "1 if this replica is the leader running jobs, 0 if it's standing by",
+		}),
+	}
+}
+
+func (m *Metrics) observeJob(job string, stationId string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.JobDuration.WithLabelValues(job, stationId).Observe(duration.Seconds())
+
+	if err == nil {
+		m.JobLastSuccess.WithLabelValues(job, stationId).SetToCurrentTime()
+	}
+}
+
+func (m *Metrics) removeStation(stationId string) {
+	if m == nil {
+		return
+	}
+
+	m.JobLastSuccess.DeletePartialMatch(prometheus.Labels{"station_id": stationId})
+	m.JobDuration.DeletePartialMatch(prometheus.Labels{"station_id": stationId})
+	m.PollInterval.DeleteLabelValues(stationId)
+	m.BreakerState.DeletePartialMatch(prometheus.Labels{"station_id": stationId})
+	m.BreakerOpens.DeletePartialMatch(prometheus.Labels{"station_id": stationId})
+}
+
+func (m *Metrics) observeBreaker(stationId string, endpoint string, state breakerState) {
+	if m == nil {
+		return
+	}
+
+	m.BreakerState.WithLabelValues(stationId, endpoint).Set(float64(state))
+	if state == breakerOpen {
+		m.BreakerOpens.WithLabelValues(stationId, endpoint).Inc()
+	}
+}
+
+func (m *Metrics) observeLeader(leader bool) {
+	if m == nil {
+		return
+	}
+
+	if leader {
+		m.Leader.Set(1)
+	} else {
+		m.Leader.Set(0)
+	}
+}
+
+func (m *Metrics) observePollInterval(stationId string, interval time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.PollInterval.WithLabelValues(stationId).Set(interval.Seconds())
+}
+
+func (m *Metrics) observeEvent(eventType EventType) {
+	if m == nil {
+		return
+	}
+
+	m.StationEvents.WithLabelValues(string(eventType)).Inc()
+}
+
+func (m *Metrics) observeDeferred(job string) {
+	if m == nil {
+		return
+	}
+
+	m.JobsDeferred.WithLabelValues(job).Inc()
+}
+
+func (m *Metrics) observePublishError(publisher string) {
+	if m == nil {
+		return
+	}
+
+	m.PublishErrors.WithLabelValues(publisher).Inc()
+}
@@ -2,180 +2,915 @@ package monitor
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-co-op/gocron/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Name of the tracer used for monitor job spans
+const TracerName = "github.com/speshak/grizzl-e-monitor/internal/monitor"
+
 type StationMonitor struct {
 	Config    *Config
 	Connect   connect.ConnectAPI
 	Scheduler gocron.Scheduler
+	Tracer    trace.Tracer
+	Metrics   *Metrics
+
+	// Where everything collected is published. Nothing is published until
+	// sinks are registered.
+	Publishers *Publishers
+
+	// Changes in station state seen between polls are published here
+	Events *EventBus
+
+	// Where station state is kept across restarts, or nil to only keep it in
+	// memory
+	State StateStore
+
+	// Elects which of several replicas runs jobs, or nil to always run them
+	Leader *LeaderElector
 
-	TransactionHistoryPublisher TransactionHistoryPublisher
-	TransactionStatsPublisher   TransactionStatsPublisher
-	StationStatusPublisher      StationStatusPublisher
+	// How often to poll each station's status, depending on what it's doing
+	PollIntervals PollIntervals
 
-	// Time periods for the different collection jobs
-	StationIntervalMin     time.Duration
-	StationIntervalMax     time.Duration
+	// Settings for particular stations, by ID
+	Stations map[string]StationConfig
+
+	// Time period for the transaction history job
 	TransactionIntervalMin time.Duration
 	TransactionIntervalMax time.Duration
+
+	// How often to look for stations that have been added or removed
+	DiscoveryInterval time.Duration
+
+	// How long to wait for running jobs to finish when shutting down, before
+	// they're cancelled
+	ShutdownTimeout time.Duration
+
+	// Maximum number of Connect API calls to make in an hour, or 0 for no
+	// limit. Jobs that would go over it are put off until there's room.
+	APICallBudget int
+
+	// How many of its poll intervals a station can go without a successful
+	// poll before the monitor is no longer ready
+	StalePolls int
+
+	// Circuit breakers on each station's API calls open after this many
+	// failures in a row, skipping calls for BreakerBackoff, doubling each
+	// time a probe fails up to BreakerMaxBackoff
+	BreakerThreshold  int
+	BreakerBackoff    time.Duration
+	BreakerMaxBackoff time.Duration
+
+	// Most full transactions to fetch from the Connect API at once
+	FetchConcurrency int
+
+	// Guards Config, PollIntervals, StalePolls, Stations and
+	// FetchConcurrency, which can be changed by Reconfigure while monitoring
+	configMu sync.RWMutex
+
+	// Stations with jobs scheduled, by ID
+	stationsMu sync.Mutex
+	stations   map[string]connect.Station
+
+	polls pollSchedule
+
+	// Sessions in progress, tracked live
+	sessions liveSessions
+
+	// The last poll of each station, to compare the next one with
+	snapshotsMu sync.Mutex
+	snapshots   map[string]connect.Station
+
+	budgetOnce sync.Once
+	budget     *callBudget
+
+	breakersOnce sync.Once
+	breakers     *breakers
+
+	// Jobs run in a context of their own rather than the scheduler's, which
+	// is cancelled as soon as it shuts down, so they can finish what they're
+	// doing
+	jobCtx     context.Context
+	jobsActive sync.WaitGroup
+
+	// With leader election, jobs are also cancelled when the term as leader
+	// they started in ends
+	termMu sync.Mutex
+	term   *leaderTerm
 }
 
 func NewStationMonitor(config *Config) *StationMonitor {
-	connect := connect.NewConnectAPI(config.Username, config.Password, config.APIHost)
+	client := config.NewConnectAPI()
+	ret := StationMonitor{
+		Config:  config,
+		Connect: client,
+		Tracer:  otel.Tracer(TracerName),
+
+		Publishers: NewPublishers(),
+		Events:     NewEventBus(),
 
-	if config.Debug {
-		connect.SetDebug()
+		// Set sensible default interval values
+		PollIntervals:          config.PollIntervals.withDefaults(),
+		Stations:               config.Stations,
+		TransactionIntervalMin: 60 * time.Minute,
+		TransactionIntervalMax: 90 * time.Minute,
+		DiscoveryInterval:      15 * time.Minute,
+		ShutdownTimeout:        20 * time.Second,
+		APICallBudget:          config.APICallBudget,
+		StalePolls:             config.StalePolls,
+		BreakerThreshold:       DefaultBreakerThreshold,
+		BreakerBackoff:         DefaultBreakerBackoff,
+		BreakerMaxBackoff:      DefaultBreakerMaxBackoff,
+		FetchConcurrency:       config.FetchConcurrency,
+	}
+	if ret.FetchConcurrency <= 0 {
+		ret.FetchConcurrency = DefaultFetchConcurrency
 	}
+	// The password comes from the current config, so a new one in a
+	// reloaded config file is used the next time the client logs in
+	client.PasswordSource = ret.password
 
-	s, err := gocron.NewScheduler()
+	// Jobs are only run while this replica is the leader, if there's an
+	// election
+	s, err := gocron.NewScheduler(gocron.WithDistributedElector(monitorElector{&ret}))
 
 	if err != nil {
 		log.Fatalf("Error creating scheduler %v", err)
 	}
+	ret.Scheduler = s
 
-	ret := StationMonitor{
-		Config:    config,
-		Connect:   connect,
-		Scheduler: s,
+	return &ret
+}
 
-		// Set sensible default interval values
-		StationIntervalMin:     5 * time.Minute,
-		StationIntervalMax:     20 * time.Minute,
-		TransactionIntervalMin: 60 * time.Minute,
-		TransactionIntervalMax: 90 * time.Minute,
+// Asks the monitor's leader elector, if it has one, whether to run jobs
+type monitorElector struct {
+	m *StationMonitor
+}
+
+func (e monitorElector) IsLeader(ctx context.Context) error {
+	if e.m.Leader == nil {
+		return nil
 	}
+	return e.m.Leader.IsLeader(ctx)
+}
 
-	return &ret
+// Register self-instrumentation metrics for the monitor and its API client
+func (m *StationMonitor) RegisterMetrics(reg prometheus.Registerer) {
+	m.Metrics = NewMetrics(reg)
+	m.Publishers.SetMetrics(m.Metrics)
+	m.Connect.SetMetrics(connect.NewClientMetrics(reg))
+}
+
+// Compete with other replicas to be the one running jobs. The elector has to
+// be run, until after MonitorStations returns. Standing down cancels any jobs
+// still running, and waits for them to finish before the lease can be given
+// up.
+func (m *StationMonitor) ElectLeader(leases LeaseStore, holder string, ttl time.Duration) *LeaderElector {
+	m.Leader = NewLeaderElector(leases, holder, ttl)
+	m.Leader.Changed = func(leader bool) {
+		m.Metrics.observeLeader(leader)
+		if leader {
+			m.startTerm()
+		} else {
+			m.endTerm()
+		}
+	}
+	return m.Leader
+}
+
+// A spell as the leader, and the jobs started during it
+type leaderTerm struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   sync.WaitGroup
+	ended  bool
+}
+
+func (m *StationMonitor) startTerm() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m.termMu.Lock()
+	defer m.termMu.Unlock()
+	m.term = &leaderTerm{ctx: ctx, cancel: cancel}
+}
+
+// Cancel the jobs started during the current term and wait for them to
+// finish, for up to ShutdownTimeout
+func (m *StationMonitor) endTerm() {
+	m.termMu.Lock()
+	term := m.term
+	if term != nil {
+		term.ended = true
+	}
+	m.termMu.Unlock()
+
+	if term == nil {
+		return
+	}
+	term.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		term.jobs.Wait()
+		close(drained)
+	}()
+
+	timeout := m.shutdownTimeout()
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		log.Printf("Jobs still running %s after standing down", timeout)
+	}
+}
+
+// Tie a job to the current term as leader, if there is one, so it's
+// cancelled when the term ends. A job started after it has is cancelled
+// straight away.
+func (m *StationMonitor) joinTerm(ctx context.Context) (context.Context, func()) {
+	m.termMu.Lock()
+	defer m.termMu.Unlock()
+
+	term := m.term
+	if term == nil {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if term.ended {
+		cancel()
+		return ctx, func() {}
+	}
+
+	term.jobs.Add(1)
+	stop := context.AfterFunc(term.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		term.jobs.Done()
+	}
 }
 
+// Monitor stations until the context is cancelled. Jobs that are running
+// when it is are given ShutdownTimeout to finish, then the Connect session is
+// logged out.
 func (m *StationMonitor) MonitorStations(ctx context.Context) error {
 	log.Printf("Monitoring stations")
 
-	// I've decided that station creation/removal is a rare event, so we'll just create the jobs once
-	// If someone changes the station configuration, they'll need to restart the monitor
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+	m.jobCtx = jobCtx
 
-	err := m.CreateJobsForStations()
+	err := m.CreateJobsForStations(ctx)
 	if err != nil {
 		return err
 	}
 
-	m.Scheduler.Start()
+	// Stations are added and removed rarely, so pick up changes on a slow
+	// schedule of their own rather than on every poll
+	_, err = m.Scheduler.NewJob(
+		gocron.DurationJob(m.discoveryInterval()),
+		gocron.NewTask(
+			func() {
+				if !m.withinBudget("discovery", "", 1) {
+					return
+				}
+				m.runJob(m.jobContext(), "discovery", connect.Station{}, m.CreateJobsForStations)
+			},
+		),
+		gocron.WithTags("discovery"),
+	)
+	if err != nil {
+		return err
+	}
 
-	defer func() {
-		err := m.Scheduler.Shutdown()
-		log.Printf("Error shutting down scheduler: %v", err)
-	}()
+	m.Scheduler.Start()
 
 	// Check if the context has been cancelled
 	<-ctx.Done()
 	log.Println("Context cancelled, stopping monitoring")
+
+	m.shutdown(cancelJobs)
 	return ctx.Err()
 }
 
-func (m *StationMonitor) CreateJobsForStations() error {
+// Stop scheduling jobs and wait for any that are running to finish, cancelling
+// them if they take too long
+func (m *StationMonitor) shutdown(cancelJobs context.CancelFunc) {
+	timeout := m.shutdownTimeout()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := m.Scheduler.ShutdownWithContext(shutdownCtx); err != nil {
+		log.Printf("Error shutting down scheduler: %v", err)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		m.jobsActive.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("All jobs finished")
+	case <-shutdownCtx.Done():
+		log.Printf("Jobs still running after %s, cancelling them", timeout)
+		cancelJobs()
+		<-drained
+	}
+
+	if err := m.Connect.Logout(); err != nil {
+		log.Printf("Error logging out of Connect: %v", err)
+	}
+}
+
+func (m *StationMonitor) shutdownTimeout() time.Duration {
+	if m.ShutdownTimeout <= 0 {
+		return 20 * time.Second
+	}
+	return m.ShutdownTimeout
+}
+
+// The context jobs run in. Outside of MonitorStations, e.g. in tests, that's
+// the background context.
+func (m *StationMonitor) jobContext() context.Context {
+	if m.jobCtx == nil {
+		return context.Background()
+	}
+	return m.jobCtx
+}
+
+func (m *StationMonitor) discoveryInterval() time.Duration {
+	if m.DiscoveryInterval <= 0 {
+		return 15 * time.Minute
+	}
+	return m.DiscoveryInterval
+}
+
+func (m *StationMonitor) pollIntervals() PollIntervals {
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+
+	return m.PollIntervals.withDefaults()
+}
+
+func (m *StationMonitor) stationConfig(stationId string) StationConfig {
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+
+	return m.Stations[stationId]
+}
+
+// How often to poll a station, with any intervals of its own in place of
+// the monitor's
+func (m *StationMonitor) stationIntervals(stationId string) PollIntervals {
+	return m.pollIntervals().overriddenBy(m.stationConfig(stationId).PollIntervals)
+}
+
+func (m *StationMonitor) password(ctx context.Context) (string, error) {
+	m.configMu.RLock()
+	password := m.Config.Password
+	m.configMu.RUnlock()
+
+	return password.Value(ctx)
+}
+
+func (m *StationMonitor) stalePolls() int {
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+
+	if m.StalePolls <= 0 {
+		return DefaultStalePolls
+	}
+	return m.StalePolls
+}
+
+func (m *StationMonitor) fetchConcurrency() int {
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+
+	if m.FetchConcurrency <= 0 {
+		return DefaultFetchConcurrency
+	}
+	return m.FetchConcurrency
+}
+
+// Apply a changed config while monitoring: the poll intervals, staleness,
+// station settings, fetch concurrency, API rate limit and, from the next
+// login, the Connect password. Stations that have been disabled stop being
+// monitored straight away, and those that have been enabled are picked up by
+// the next discovery. The Connect account and API call budget only change on
+// a restart.
+func (m *StationMonitor) Reconfigure(config *Config) {
+	m.stationsMu.Lock()
+	defer m.stationsMu.Unlock()
+
+	before := map[string]PollIntervals{}
+	for id := range m.stations {
+		before[id] = m.stationIntervals(id)
+	}
+
+	m.configMu.Lock()
+	m.Config = config
+	m.PollIntervals = config.PollIntervals.withDefaults()
+	m.StalePolls = config.StalePolls
+	m.Stations = config.Stations
+	m.FetchConcurrency = config.FetchConcurrency
+	m.configMu.Unlock()
+
+	m.Connect.SetRateLimit(config.rateLimit())
+
+	for id, station := range m.stations {
+		switch {
+		case m.stationConfig(id).Disabled:
+			log.Printf("Station %s has been disabled", id)
+			m.removeStation(id)
+
+		// Poll jobs check whether their station is due at its shortest
+		// interval, so they're replaced to check at the new one, as are
+		// session jobs for a new session interval
+		case m.stationIntervals(id).shortest() != before[id].shortest(), m.stationIntervals(id).Session != before[id].Session:
+			m.Scheduler.RemoveByTags(stationTag(id))
+			if err := m.createJobsForStation(station); err != nil {
+				log.Printf("Error replacing the jobs of station %s, leaving it to the next discovery: %v", id, err)
+				m.removeStation(id)
+			}
+		}
+	}
+}
+
+func (m *StationMonitor) callBudget() *callBudget {
+	m.budgetOnce.Do(func() {
+		m.budget = newCallBudget(m.APICallBudget)
+	})
+	return m.budget
+}
+
+func (m *StationMonitor) circuitBreakers() *breakers {
+	m.breakersOnce.Do(func() {
+		m.breakers = &breakers{
+			threshold:  m.BreakerThreshold,
+			backoff:    m.BreakerBackoff,
+			maxBackoff: m.BreakerMaxBackoff,
+			now:        time.Now,
+			changed: func(key breakerKey, state breakerState) {
+				m.Metrics.observeBreaker(key.stationId, key.endpoint, state)
+			},
+		}
+		if m.breakers.threshold <= 0 {
+			m.breakers.threshold = DefaultBreakerThreshold
+		}
+		if m.breakers.backoff <= 0 {
+			m.breakers.backoff = DefaultBreakerBackoff
+		}
+		if m.breakers.maxBackoff < m.breakers.backoff {
+			m.breakers.maxBackoff = max(DefaultBreakerMaxBackoff, m.breakers.backoff)
+		}
+	})
+	return m.breakers
+}
+
+// Call one of a station's endpoints through its circuit breaker
+func (m *StationMonitor) callEndpoint(stationId string, endpoint string, fn func() error) error {
+	return m.circuitBreakers().call(stationId, endpoint, fn)
+}
+
+// Treat a call skipped by an open circuit breaker as nothing to do, as
+// failing the job for it again and again adds nothing
+func skipOpenCircuit(err error) error {
+	if errors.Is(err, ErrCircuitOpen) {
+		return nil
+	}
+	return err
+}
+
+// Check whether a job can make the given number of API calls without going
+// over the budget, taking them from it if so
+func (m *StationMonitor) withinBudget(job string, stationId string, calls int) bool {
+	if m.callBudget().take(calls) {
+		return true
+	}
+
+	if stationId == "" {
+		log.Printf("API call budget used up, putting off %s job", job)
+	} else {
+		log.Printf("API call budget used up, putting off %s job for station %s", job, stationId)
+	}
+	m.Metrics.observeDeferred(job)
+	return false
+}
+
+// Create jobs for any stations we aren't monitoring yet, and remove the jobs
+// of any that have been removed or unshared since we last looked
+func (m *StationMonitor) CreateJobsForStations(ctx context.Context) error {
 	// Get the list of stations
-	stations, err := m.Connect.GetStations()
+	stations, err := m.Connect.GetStations(ctx)
 	if err != nil {
 		return err
 	}
 
-	log.Print("Creating monitor jobs for stations")
+	m.stationsMu.Lock()
+	defer m.stationsMu.Unlock()
+
+	if m.stations == nil {
+		m.stations = map[string]connect.Station{}
+	}
 
-	// Iterate over the stations
+	current := map[string]bool{}
 	for _, station := range stations {
-		log.Printf("Creating monitor jobs for station %s", station.ID)
-
-		// Stats
-		_, err := m.Scheduler.NewJob(
-			gocron.DurationRandomJob(m.StationIntervalMin, m.StationIntervalMax),
-			gocron.NewTask(
-				func() {
-					m.stationStats(station)
-					m.transactionStats(station)
-				},
-			),
-			gocron.WithTags("station_stats"),
-		)
+		if m.stationConfig(station.ID).Disabled {
+			continue
+		}
 
-		if err != nil {
+		current[station.ID] = true
+		if _, ok := m.stations[station.ID]; ok {
+			continue
+		}
+
+		if err := m.createJobsForStation(station); err != nil {
+			// Don't leave half the station's jobs behind
+			m.Scheduler.RemoveByTags(stationTag(station.ID))
 			return err
 		}
+		m.stations[station.ID] = station
+		m.polls.add(station.ID, time.Now())
+	}
+
+	for id := range m.stations {
+		if !current[id] {
+			log.Printf("Station %s is no longer available or has been disabled", id)
+			m.removeStation(id)
+		}
+	}
+
+	return nil
+}
+
+// Tag on every job for a station, so they can be removed together
+func stationTag(stationId string) string {
+	return "station:" + stationId
+}
+
+func (m *StationMonitor) createJobsForStation(station connect.Station) error {
+	log.Printf("Creating monitor jobs for station %s", station.ID)
+
+	// Stats. The job checks at the shortest interval whether the station is
+	// due, as how often it's polled depends on what it was last doing.
+	_, err := m.Scheduler.NewJob(
+		gocron.DurationJob(m.stationIntervals(station.ID).shortest()),
+		gocron.NewTask(
+			func() {
+				m.pollStation(m.jobContext(), station)
+			},
+		),
+		gocron.WithTags("station_stats", stationTag(station.ID)),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	// Transactions
+	_, err = m.Scheduler.NewJob(
+		gocron.DurationRandomJob(m.TransactionIntervalMin, m.TransactionIntervalMax),
+		gocron.NewTask(
+			func() {
+				if m.polls.status(station.ID).paused || m.circuitBreakers().blocked(station.ID, endpointTransactions) {
+					return
+				}
+				if !m.withinBudget("transaction", station.ID, 1) {
+					return
+				}
+				m.runJob(m.jobContext(), "transaction", station, func(ctx context.Context) error {
+					return m.transactionHistory(ctx, station)
+				})
+			},
+		),
+		gocron.WithTags("transaction", stationTag(station.ID)),
+	)
+
+	if err != nil {
+		return err
+	}
 
-		// Transactions
-		_, err = m.Scheduler.NewJob(
-			gocron.DurationRandomJob(m.TransactionIntervalMin, m.TransactionIntervalMax),
-			gocron.NewTask(
-				func() {
-					m.transactionHistory(station)
-				},
-			),
-			gocron.WithTags("transaction"),
+	// Sessions in progress
+	_, err = m.Scheduler.NewJob(
+		gocron.DurationJob(m.stationIntervals(station.ID).Session),
+		gocron.NewTask(
+			func() {
+				m.pollSession(m.jobContext(), station)
+			},
+		),
+		gocron.WithTags("session", stationTag(station.ID)),
+	)
+
+	return err
+}
+
+// Endpoints called by each poll of a station's stats
+var stationPollEndpoints = []string{endpointStation, endpointTransactionStats}
+
+// Poll a station's status and stats if it's due, then schedule the next poll
+// based on what it's doing
+func (m *StationMonitor) pollStation(ctx context.Context, station connect.Station) {
+	intervals := m.stationIntervals(station.ID)
+	now := time.Now()
+
+	if m.polls.status(station.ID).paused {
+		return
+	}
+
+	if !m.polls.claim(station.ID, now, intervals.longest()) {
+		return
+	}
+
+	// Only count the calls that won't be skipped by an open breaker
+	calls := 0
+	for _, endpoint := range stationPollEndpoints {
+		if !m.circuitBreakers().blocked(station.ID, endpoint) {
+			calls++
+		}
+	}
+	if calls == 0 {
+		m.polls.scheduleNext(station.ID, now, intervals)
+		return
+	}
+
+	if !m.withinBudget("station_stats", station.ID, calls) {
+		m.polls.release(station.ID)
+		return
+	}
+
+	err := m.runJob(ctx, "station_stats", station, func(ctx context.Context) error {
+		return errors.Join(
+			skipOpenCircuit(m.stationStats(ctx, station)),
+			skipOpenCircuit(m.transactionStats(ctx, station)),
 		)
+	})
+	if err == nil {
+		m.polls.succeeded(station.ID, time.Now())
+	}
 
-		if err != nil {
-			return err
+	interval := m.polls.scheduleNext(station.ID, now, intervals)
+	m.Metrics.observePollInterval(station.ID, interval)
+}
+
+// Stop monitoring a station, dropping anything published about it that
+// would otherwise be left reporting its last values
+func (m *StationMonitor) removeStation(stationId string) {
+	log.Printf("Removing the monitor jobs of station %s", stationId)
+
+	m.Scheduler.RemoveByTags(stationTag(stationId))
+	delete(m.stations, stationId)
+	m.polls.remove(stationId)
+	m.sessions.remove(stationId)
+	m.circuitBreakers().remove(stationId)
+
+	m.snapshotsMu.Lock()
+	delete(m.snapshots, stationId)
+	m.snapshotsMu.Unlock()
+
+	if m.State != nil {
+		if err := m.State.RemoveStation(stationId); err != nil {
+			log.Printf("Error removing the state of station %s: %v", stationId, err)
 		}
 	}
 
-	return nil
+	m.Metrics.removeStation(stationId)
+	m.Publishers.RemoveStation(stationId)
+}
+
+// Run a job for a station, wrapping it in a span and recording its duration
+// and last success time
+func (m *StationMonitor) runJob(ctx context.Context, name string, station connect.Station, job func(context.Context) error) error {
+	m.jobsActive.Add(1)
+	defer m.jobsActive.Done()
+
+	ctx, leave := m.joinTerm(ctx)
+	defer leave()
+
+	ctx, span := m.startSpan(ctx, name, station.ID)
+	defer span.End()
+
+	start := time.Now()
+	err := job(ctx)
+	m.Metrics.observeJob(name, station.ID, time.Since(start), err)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// Start a span for a monitor operation on a station. Monitors built without
+// a tracer (e.g. in tests) fall back to the global tracer provider.
+func (m *StationMonitor) startSpan(ctx context.Context, name string, stationId string) (context.Context, trace.Span) {
+	tracer := m.Tracer
+	if tracer == nil {
+		tracer = otel.Tracer(TracerName)
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String("station.id", stationId)))
 }
 
 // Get the station's transaction stats
-func (m *StationMonitor) transactionStats(station connect.Station) {
+func (m *StationMonitor) transactionStats(ctx context.Context, station connect.Station) error {
 	// Get the transaction statistics for the station
-	stats, err := m.Connect.GetTransactionStatistics(station.ID)
+	var stats connect.TransactionStats
+	err := m.callEndpoint(station.ID, endpointTransactionStats, func() (err error) {
+		stats, err = m.Connect.GetTransactionStatistics(ctx, station.ID)
+		return err
+	})
 	if err != nil {
-		log.Printf("Error getting transaction statistics for station %s: %v", station.ID, err)
-		return
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("Error getting transaction statistics for station %s: %v", station.ID, err)
+		}
+		return err
 	}
 
 	log.Printf("Station %s statistics: %+v", station.ID, stats)
-	m.TransactionStatsPublisher.PublishTransactionStats(station.ID, stats)
+	m.Publishers.PublishTransactionStats(station.ID, stats)
+	return nil
 }
 
 // Get the station's stats
-func (m *StationMonitor) stationStats(station connect.Station) {
-	station, err := m.Connect.GetStation(station.ID)
+func (m *StationMonitor) stationStats(ctx context.Context, station connect.Station) error {
+	stationId := station.ID
+	err := m.callEndpoint(stationId, endpointStation, func() (err error) {
+		station, err = m.Connect.GetStation(ctx, stationId)
+		return err
+	})
 	if err != nil {
-		log.Printf("Error getting station %s: %v", station.ID, err)
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("Error getting station %s: %v", stationId, err)
+		}
+		return err
+	}
+
+	m.detectEvents(station, time.Now())
+
+	if activity := activityOf(station); m.polls.setActivity(station.ID, activity) {
+		log.Printf("Station %s is now %s, polling every %s", station.ID, activity, m.stationIntervals(station.ID).interval(activity))
+	}
+
+	m.Publishers.PublishStationStatus(station)
+	return nil
+}
+
+// Compare a station with its last poll, publishing anything that's changed
+// on the event bus. The first poll of a station, unless there's one from
+// before a restart in the state store, is the baseline for the next.
+func (m *StationMonitor) detectEvents(station connect.Station, at time.Time) {
+	m.snapshotsMu.Lock()
+	if m.snapshots == nil {
+		m.snapshots = map[string]connect.Station{}
+	}
+	before, seen := m.snapshots[station.ID]
+	m.snapshots[station.ID] = station
+	m.snapshotsMu.Unlock()
+
+	// Pick up from the last poll before a restart
+	if m.State != nil {
+		if !seen {
+			var err error
+			before, seen, err = m.State.Snapshot(station.ID)
+			if err != nil {
+				log.Printf("Error loading the last state of station %s: %v", station.ID, err)
+			}
+		}
+		if err := m.State.SaveSnapshot(station); err != nil {
+			log.Printf("Error saving the state of station %s: %v", station.ID, err)
+		}
+	}
+
+	if !seen {
 		return
 	}
 
-	m.StationStatusPublisher.PublishStationStatus(station)
+	nickname := m.stationConfig(station.ID).Nickname
+	for _, event := range DiffStation(before, station, at) {
+		event.StationName = nickname
+		log.Printf("Station event: %s (%q -> %q)", event, event.Before.Status, event.After.Status)
+		m.Metrics.observeEvent(event.Type)
+		m.Events.Publish(event)
+	}
 }
 
-func (m *StationMonitor) transactionHistory(station connect.Station) {
+// Publish the history of any transactions that haven't been published yet.
+// Failures of individual transactions don't stop the others, they are joined
+// into the returned error.
+func (m *StationMonitor) transactionHistory(ctx context.Context, station connect.Station) error {
 	// Get all transactions for the station
-	transactions, err := m.Connect.GetAllTransactions(station.ID)
+	var transactions []connect.Transaction
+	err := m.callEndpoint(station.ID, endpointTransactions, func() (err error) {
+		transactions, err = m.Connect.GetAllTransactions(ctx, station.ID)
+		return err
+	})
 	if err != nil {
-		log.Printf("Error getting all transactions for station %s: %v", station.ID, err)
-		return
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("Error getting all transactions for station %s: %v", station.ID, err)
+		}
+		return err
 	}
 
+	// If we've already published the history, don't do it again
+	// This is up to the registered TransactionHistoryPublishers to check.
+	var unpublished []connect.Transaction
 	for _, transaction := range transactions {
-		// If we've already published the history, don't do it again
-		// This is up to the implementation of the TransactionHistoryPublisher to check.
-		if !m.TransactionHistoryPublisher.TransactionPublished(transaction) {
-			log.Printf("Publishing transaction history for transaction %s", transaction.ID)
-			// The all transactions endpoint gets a subset of the transaction data, so we need to get the full transaction
-			fullTrans, err := m.Connect.GetTransaction(transaction.ID)
+		if m.Publishers.TransactionPublished(transaction) {
+			log.Printf("Transaction %s already published", transaction.ID)
+			continue
+		}
+		if transaction.StopAt == "" && m.sessions.tracking(transaction.ID) {
+			log.Printf("Transaction %s is in progress and tracked live", transaction.ID)
+			continue
+		}
+		unpublished = append(unpublished, transaction)
+	}
 
-			if err != nil {
-				log.Printf("Error getting full transaction %s: %v", transaction.ID, err)
-				continue
-			}
-			err = m.TransactionHistoryPublisher.PublishTransactionHistory(station.ID, fullTrans)
+	// Transactions are listed newest first, but are published oldest first.
+	// Budget is taken up front and in that order, so anything left over for
+	// the next run is newer than what's published.
+	slices.Reverse(unpublished)
+	for i := range unpublished {
+		if !m.withinBudget("transaction", station.ID, 1) {
+			unpublished = unpublished[:i]
+			break
+		}
+	}
 
-			if err != nil {
-				log.Printf("Error publishing transaction history for transaction %s: %v", transaction.ID, err)
-			}
-		} else {
-			log.Printf("Transaction %s already published", transaction.ID)
+	var errs []error
+
+	// The all transactions endpoint gets a subset of the transaction data, so
+	// we need to get each full transaction. They're fetched a few at a time
+	// but published in order.
+	var called atomic.Int32
+	fetch := func(ctx context.Context, transaction connect.Transaction) (fullTrans connect.Transaction, err error) {
+		err = m.callEndpoint(station.ID, endpointTransaction, func() (err error) {
+			called.Add(1)
+			fullTrans, err = m.Connect.GetTransaction(ctx, transaction.ID)
+			return err
+		})
+		return fullTrans, err
+	}
+
+	fetchInOrder(ctx, m.fetchConcurrency(), unpublished, fetch, func(transaction connect.Transaction, fullTrans connect.Transaction, err error) bool {
+		// Anything left over is picked up once the breaker closes
+		if errors.Is(err, ErrCircuitOpen) {
+			return false
+		}
+		if err != nil {
+			log.Printf("Error getting full transaction %s: %v", transaction.ID, err)
+			errs = append(errs, fmt.Errorf("error getting transaction %s: %w", transaction.ID, err))
+			return true
+		}
+
+		log.Printf("Publishing transaction history for transaction %s", transaction.ID)
+		if err := m.publishTransactionHistory(ctx, station.ID, fullTrans); err != nil {
+			errs = append(errs, fmt.Errorf("error publishing transaction %s: %w", transaction.ID, err))
 		}
+		return true
+	})
+
+	// Budget taken for fetches skipped once the breaker opened is given back
+	m.callBudget().refund(len(unpublished) - int(called.Load()))
+
+	return errors.Join(errs...)
+}
+
+// Publish a transaction's history, wrapped in a span so slow database writes
+// show up in the job trace
+func (m *StationMonitor) publishTransactionHistory(ctx context.Context, stationId string, transaction connect.Transaction) error {
+	_, span := m.startSpan(ctx, "PublishTransactionHistory", stationId)
+	span.SetAttributes(
+		attribute.String("transaction.id", transaction.ID),
+		attribute.Int("transaction.meter_values", len(transaction.MeterValues.Date)),
+	)
+	defer span.End()
+
+	err := m.Publishers.PublishTransactionHistory(stationId, transaction)
+
+	if err != nil {
+		log.Printf("Error publishing transaction history for transaction %s: %v", transaction.ID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+
+	return err
 }
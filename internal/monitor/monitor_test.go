@@ -7,10 +7,15 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/speshak/grizzl-e-monitor/internal/secrets"
 	"github.com/speshak/grizzl-e-monitor/pkg/connect"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/mock/gomock"
 
 	gocronmocks "github.com/go-co-op/gocron/mocks/v2"
@@ -19,14 +24,16 @@ import (
 // Create mocks for the ConnectAPI, TransactionHistoryPublisher, TransactionStatsPublisher, and StationStatusPublisher
 type MockConnectAPI struct {
 	mock.Mock
+
+	health connect.Health
 }
 
-func (m *MockConnectAPI) GetStations() ([]connect.Station, error) {
+func (m *MockConnectAPI) GetStations(ctx context.Context) ([]connect.Station, error) {
 	args := m.Called()
 	return args.Get(0).([]connect.Station), args.Error(1)
 }
 
-func (m *MockConnectAPI) AssertValidToken() error {
+func (m *MockConnectAPI) AssertValidToken(ctx context.Context) error {
 	// Just short cut this
 	return nil
 }
@@ -36,39 +43,49 @@ func (m *MockConnectAPI) ParseToken() (*jwt.Token, connect.TokenClaims, error) {
 	return nil, connect.TokenClaims{}, nil
 }
 
-func (m *MockConnectAPI) Login() error {
+func (m *MockConnectAPI) Login(ctx context.Context) error {
 	// Just short cut this too
 	return nil
 }
 
 func (m *MockConnectAPI) Logout() error {
-	// Just short cut this too
-	return nil
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockConnectAPI) Health() connect.Health {
+	return m.health
 }
 
 func (m *MockConnectAPI) SetDebug() {}
 
-func (m *MockConnectAPI) GetTransactionStatistics(stationID string) (connect.TransactionStats, error) {
+func (m *MockConnectAPI) SetMetrics(metrics *connect.ClientMetrics) {}
+
+func (m *MockConnectAPI) SetRateLimit(interval time.Duration) {
+	m.Called(interval)
+}
+
+func (m *MockConnectAPI) GetTransactionStatistics(ctx context.Context, stationID string) (connect.TransactionStats, error) {
 	args := m.Called(stationID)
 	return args.Get(0).(connect.TransactionStats), args.Error(1)
 }
 
-func (m *MockConnectAPI) GetStation(stationID string) (connect.Station, error) {
+func (m *MockConnectAPI) GetStation(ctx context.Context, stationID string) (connect.Station, error) {
 	args := m.Called(stationID)
 	return args.Get(0).(connect.Station), args.Error(1)
 }
 
-func (m *MockConnectAPI) GetAllTransactions(stationID string) ([]connect.Transaction, error) {
+func (m *MockConnectAPI) GetAllTransactions(ctx context.Context, stationID string) ([]connect.Transaction, error) {
 	args := m.Called(stationID)
 	return args.Get(0).([]connect.Transaction), args.Error(1)
 }
 
-func (m *MockConnectAPI) GetTransaction(transactionID string) (connect.Transaction, error) {
+func (m *MockConnectAPI) GetTransaction(ctx context.Context, transactionID string) (connect.Transaction, error) {
 	args := m.Called(transactionID)
 	return args.Get(0).(connect.Transaction), args.Error(1)
 }
 
-func (m *MockConnectAPI) GetTransactions(stationId string, limit int, offset int) ([]connect.Transaction, error) {
+func (m *MockConnectAPI) GetTransactions(ctx context.Context, stationId string, limit int, offset int) ([]connect.Transaction, error) {
 	args := m.Called(stationId, limit, offset)
 
 	return args.Get(0).([]connect.Transaction), args.Error(1)
@@ -120,16 +137,36 @@ func (m *MockSingleStationMonitor) MonitorStation(ctx context.Context, station c
 	m.Called(ctx, station)
 }
 
+// A publisher registry with each of the given sinks registered
+func publishersOf(t *testing.T, sinks ...any) *Publishers {
+	publishers := NewPublishers()
+	for i, sink := range sinks {
+		require.NoError(t, publishers.Register(fmt.Sprintf("sink%d", i), sink))
+	}
+	return publishers
+}
+
 func TestMonitorConstructor(t *testing.T) {
 	monitor := NewStationMonitor(&Config{
 		APIHost:  "https://example.com",
 		Username: "myUser",
-		Password: "myPass",
+		Password: secrets.NewLiteral("myPass"),
 		Debug:    true,
 	})
 
 	assert.NotNil(t, monitor)
 	assert.NotNil(t, monitor.Connect)
+
+	// The client logs in with the password of the config applied last
+	client := monitor.Connect.(*connect.ConnectAPIClient)
+	password, err := client.PasswordSource(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "myPass", password)
+
+	monitor.Reconfigure(&Config{Password: secrets.NewLiteral("newPass")})
+	password, err = client.PasswordSource(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "newPass", password)
 }
 
 func TestTransactionStats(t *testing.T) {
@@ -140,12 +177,12 @@ func TestTransactionStats(t *testing.T) {
 	mockTransactionStatsPublisher.On("PublishTransactionStats", "station1", mock.Anything)
 
 	monitor := &StationMonitor{
-		Connect:                   mockConnectAPI,
-		TransactionStatsPublisher: mockTransactionStatsPublisher,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionStatsPublisher),
 	}
 
 	station := connect.Station{ID: "station1"}
-	monitor.transactionStats(station)
+	monitor.transactionStats(context.Background(), station)
 
 	mockConnectAPI.AssertExpectations(t)
 	mockTransactionStatsPublisher.AssertExpectations(t)
@@ -159,12 +196,12 @@ func TestStationStats(t *testing.T) {
 	mockStationStatusPublisher.On("PublishStationStatus", mock.Anything)
 
 	monitor := &StationMonitor{
-		Connect:                mockConnectAPI,
-		StationStatusPublisher: mockStationStatusPublisher,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockStationStatusPublisher),
 	}
 
 	station := connect.Station{ID: "station1"}
-	monitor.stationStats(station)
+	monitor.stationStats(context.Background(), station)
 
 	mockConnectAPI.AssertExpectations(t)
 	mockStationStatusPublisher.AssertExpectations(t)
@@ -180,17 +217,98 @@ func TestTransactionHistory(t *testing.T) {
 	mockTransactionHistoryPublisher.On("PublishTransactionHistory", "station1", mock.Anything)
 
 	monitor := &StationMonitor{
-		Connect:                     mockConnectAPI,
-		TransactionHistoryPublisher: mockTransactionHistoryPublisher,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionHistoryPublisher),
 	}
 
 	station := connect.Station{ID: "station1"}
-	monitor.transactionHistory(station)
+	monitor.transactionHistory(context.Background(), station)
 
 	mockConnectAPI.AssertExpectations(t)
 	mockTransactionHistoryPublisher.AssertExpectations(t)
 }
 
+func TestTransactionHistoryInOrder(t *testing.T) {
+	transactions := []connect.Transaction{{ID: "trans1"}, {ID: "trans2"}, {ID: "trans3"}, {ID: "trans4"}, {ID: "trans5"}}
+
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetAllTransactions", "station1").Return(transactions, nil)
+	mockConnectAPI.On("GetTransaction", "trans2").Return(connect.Transaction{}, fmt.Errorf("Error getting transaction"))
+	for _, transaction := range transactions {
+		mockConnectAPI.On("GetTransaction", transaction.ID).Return(transaction, nil)
+	}
+
+	mockTransactionHistoryPublisher := new(MockTransactionHistoryPublisher)
+	mockTransactionHistoryPublisher.On("TransactionPublished", mock.Anything).Return(false)
+	mockTransactionHistoryPublisher.On("PublishTransactionHistory", "station1", mock.Anything)
+
+	monitor := &StationMonitor{
+		Connect:          mockConnectAPI,
+		Publishers:       publishersOf(t, mockTransactionHistoryPublisher),
+		FetchConcurrency: 3,
+	}
+
+	err := monitor.transactionHistory(context.Background(), connect.Station{ID: "station1"})
+	assert.ErrorContains(t, err, "error getting transaction trans2", "Errors should say which transaction they're for")
+
+	var published []string
+	for _, call := range mockTransactionHistoryPublisher.Calls {
+		if call.Method == "PublishTransactionHistory" {
+			published = append(published, call.Arguments.Get(1).(connect.Transaction).ID)
+		}
+	}
+	assert.Equal(t, []string{"trans5", "trans4", "trans3", "trans1"}, published, "The rest should still be published in order, oldest first")
+}
+
+func TestTransactionHistoryBudget(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetAllTransactions", "station1").Return([]connect.Transaction{{ID: "trans3"}, {ID: "trans2"}, {ID: "trans1"}}, nil)
+	mockConnectAPI.On("GetTransaction", "trans1").Return(connect.Transaction{ID: "trans1"}, nil)
+
+	mockTransactionHistoryPublisher := new(MockTransactionHistoryPublisher)
+	mockTransactionHistoryPublisher.On("TransactionPublished", mock.Anything).Return(false)
+	mockTransactionHistoryPublisher.On("PublishTransactionHistory", "station1", mock.Anything)
+
+	monitor := &StationMonitor{
+		Connect:          mockConnectAPI,
+		Publishers:       publishersOf(t, mockTransactionHistoryPublisher),
+		FetchConcurrency: 3,
+		APICallBudget:    1,
+	}
+
+	// The oldest is fetched, and the rest are left for the next run, without
+	// an error
+	assert.NoError(t, monitor.transactionHistory(context.Background(), connect.Station{ID: "station1"}))
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransaction", 1)
+	mockTransactionHistoryPublisher.AssertCalled(t, "PublishTransactionHistory", "station1", connect.Transaction{ID: "trans1"})
+}
+
+func TestTransactionHistoryBreakerRefundsBudget(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetAllTransactions", "station1").Return([]connect.Transaction{{ID: "trans3"}, {ID: "trans2"}, {ID: "trans1"}}, nil)
+	mockConnectAPI.On("GetTransaction", "trans1").Return(connect.Transaction{}, fmt.Errorf("Error getting transaction"))
+
+	mockTransactionHistoryPublisher := new(MockTransactionHistoryPublisher)
+	mockTransactionHistoryPublisher.On("TransactionPublished", mock.Anything).Return(false)
+
+	monitor := &StationMonitor{
+		Connect:          mockConnectAPI,
+		Publishers:       publishersOf(t, mockTransactionHistoryPublisher),
+		FetchConcurrency: 1,
+		APICallBudget:    4,
+		BreakerThreshold: 1,
+		BreakerBackoff:   time.Minute,
+	}
+
+	require.Error(t, monitor.transactionHistory(context.Background(), connect.Station{ID: "station1"}))
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransaction", 1)
+
+	// Only the fetch that opened the breaker is counted, the rest are given
+	// back
+	assert.True(t, monitor.callBudget().take(3))
+	assert.False(t, monitor.callBudget().take(1))
+}
+
 func TestExistingTransactionHistory(t *testing.T) {
 	mockConnectAPI := new(MockConnectAPI)
 	mockConnectAPI.On("GetAllTransactions", "station1").Return([]connect.Transaction{{ID: "trans1"}}, nil)
@@ -199,17 +317,67 @@ func TestExistingTransactionHistory(t *testing.T) {
 	mockTransactionHistoryPublisher.On("TransactionPublished", connect.Transaction{ID: "trans1"}).Return(true)
 
 	monitor := &StationMonitor{
-		Connect:                     mockConnectAPI,
-		TransactionHistoryPublisher: mockTransactionHistoryPublisher,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionHistoryPublisher),
 	}
 
 	station := connect.Station{ID: "station1"}
-	monitor.transactionHistory(station)
+	monitor.transactionHistory(context.Background(), station)
 
 	mockConnectAPI.AssertExpectations(t)
 	mockTransactionHistoryPublisher.AssertExpectations(t)
 }
 
+func TestTransactionHistorySpans(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetAllTransactions", "station1").Return([]connect.Transaction{{ID: "trans1"}}, nil)
+	mockConnectAPI.On("GetTransaction", "trans1").Return(connect.Transaction{ID: "trans1"}, nil)
+
+	mockTransactionHistoryPublisher := new(MockTransactionHistoryPublisher)
+	mockTransactionHistoryPublisher.On("TransactionPublished", connect.Transaction{ID: "trans1"}).Return(false)
+	mockTransactionHistoryPublisher.On("PublishTransactionHistory", "station1", mock.Anything)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	monitor := &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionHistoryPublisher),
+		Tracer:     provider.Tracer("test"),
+	}
+
+	ctx, span := monitor.startSpan(context.Background(), "transaction", "station1")
+	monitor.transactionHistory(ctx, connect.Station{ID: "station1"})
+	span.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "PublishTransactionHistory", spans[0].Name())
+	assert.Equal(t, "transaction", spans[1].Name())
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID(), "Publish span should be a child of the job span")
+}
+
+func TestRunJobMetrics(t *testing.T) {
+	monitor := &StationMonitor{
+		Metrics: NewMetrics(prometheus.NewRegistry()),
+	}
+	station := connect.Station{ID: "station1"}
+
+	monitor.runJob(context.Background(), "station_stats", station, func(ctx context.Context) error {
+		return nil
+	})
+
+	lastSuccess := testutil.ToFloat64(monitor.Metrics.JobLastSuccess.WithLabelValues("station_stats", "station1"))
+	assert.Greater(t, lastSuccess, 0.0, "Successful job should set the last success time")
+
+	monitor.runJob(context.Background(), "transaction", station, func(ctx context.Context) error {
+		return fmt.Errorf("Error getting transactions")
+	})
+
+	assert.Equal(t, 1, testutil.CollectAndCount(monitor.Metrics.JobLastSuccess), "Failed job should not set the last success time")
+	assert.Equal(t, 2, testutil.CollectAndCount(monitor.Metrics.JobDuration), "Both job runs should record a duration")
+}
+
 func TestTransactionStatsError(t *testing.T) {
 	mockConnectAPI := new(MockConnectAPI)
 	mockConnectAPI.On("GetTransactionStatistics", "station1").Return(connect.TransactionStats{}, fmt.Errorf("Error getting transaction statistics"))
@@ -218,12 +386,12 @@ func TestTransactionStatsError(t *testing.T) {
 	// No need to set expectations on the publisher since it should not be called
 
 	monitor := &StationMonitor{
-		Connect:                   mockConnectAPI,
-		TransactionStatsPublisher: mockTransactionStatsPublisher,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionStatsPublisher),
 	}
 
 	station := connect.Station{ID: "station1"}
-	monitor.transactionStats(station)
+	monitor.transactionStats(context.Background(), station)
 
 	mockConnectAPI.AssertExpectations(t)
 	mockTransactionStatsPublisher.AssertExpectations(t)
@@ -238,22 +406,61 @@ func TestCreateJobsForStations(t *testing.T) {
 
 	ctrl := gomock.NewController(t)
 	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
-	mockScheduler.EXPECT().NewJob(gomock.Any(), gomock.Any(), gomock.Any()).Times(4)
+	mockScheduler.EXPECT().NewJob(gomock.Any(), gomock.Any(), gomock.Any()).Times(6)
 
 	monitor := &StationMonitor{
-		Connect:                     mockConnectAPI,
-		TransactionStatsPublisher:   mockTransactionStatsPublisher,
-		TransactionHistoryPublisher: mockTransactionHistoryPublisher,
-		StationStatusPublisher:      mockStationStatusPublisher,
-		Scheduler:                   mockScheduler,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionStatsPublisher, mockTransactionHistoryPublisher, mockStationStatusPublisher),
+		Scheduler:  mockScheduler,
 	}
 
-	err := monitor.CreateJobsForStations()
+	err := monitor.CreateJobsForStations(context.Background())
 	require.NoError(t, err)
 
 	mockConnectAPI.AssertExpectations(t)
 }
 
+type MockStationRemovalPublisher struct {
+	mock.Mock
+}
+
+func (m *MockStationRemovalPublisher) RemoveStation(stationID string) {
+	m.Called(stationID)
+}
+
+func TestCreateJobsForStationsRediscovery(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetStations").Return([]connect.Station{{ID: "station1"}, {ID: "station2"}}, nil).Once()
+	mockConnectAPI.On("GetStations").Return([]connect.Station{{ID: "station2"}, {ID: "station3"}}, nil).Once()
+
+	mockStationRemovalPublisher := new(MockStationRemovalPublisher)
+	mockStationRemovalPublisher.On("RemoveStation", "station1")
+
+	ctrl := gomock.NewController(t)
+	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
+	// Three jobs each for station1 and station2, then three for station3
+	mockScheduler.EXPECT().NewJob(gomock.Any(), gomock.Any(), gomock.Any()).Times(9)
+	mockScheduler.EXPECT().RemoveByTags("station:station1").Times(1)
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	metrics.JobLastSuccess.WithLabelValues("station_stats", "station1").SetToCurrentTime()
+	metrics.JobLastSuccess.WithLabelValues("station_stats", "station2").SetToCurrentTime()
+
+	monitor := &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockStationRemovalPublisher),
+		Scheduler:  mockScheduler,
+		Metrics:    metrics,
+	}
+
+	require.NoError(t, monitor.CreateJobsForStations(context.Background()))
+	require.NoError(t, monitor.CreateJobsForStations(context.Background()))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.JobLastSuccess), "Removed station's job metrics should be dropped")
+	mockConnectAPI.AssertExpectations(t)
+	mockStationRemovalPublisher.AssertExpectations(t)
+}
+
 func TestCreateJobsForStationsError(t *testing.T) {
 	mockConnectAPI := new(MockConnectAPI)
 	mockConnectAPI.On("GetStations").Return([]connect.Station{}, fmt.Errorf("Error getting stations"))
@@ -266,14 +473,12 @@ func TestCreateJobsForStationsError(t *testing.T) {
 	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
 
 	monitor := &StationMonitor{
-		Connect:                     mockConnectAPI,
-		TransactionStatsPublisher:   mockTransactionStatsPublisher,
-		TransactionHistoryPublisher: mockTransactionHistoryPublisher,
-		StationStatusPublisher:      mockStationStatusPublisher,
-		Scheduler:                   mockScheduler,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionStatsPublisher, mockTransactionHistoryPublisher, mockStationStatusPublisher),
+		Scheduler:  mockScheduler,
 	}
 
-	err := monitor.CreateJobsForStations()
+	err := monitor.CreateJobsForStations(context.Background())
 	require.Error(t, err)
 
 	mockConnectAPI.AssertExpectations(t)
@@ -282,6 +487,7 @@ func TestCreateJobsForStationsError(t *testing.T) {
 func TestMonitorStations(t *testing.T) {
 	mockConnectAPI := new(MockConnectAPI)
 	mockConnectAPI.On("GetStations").Return([]connect.Station{{ID: "station1"}, {ID: "station2"}}, nil)
+	mockConnectAPI.On("Logout").Return(nil)
 
 	mockTransactionStatsPublisher := new(MockTransactionStatsPublisher)
 	mockTransactionHistoryPublisher := new(MockTransactionHistoryPublisher)
@@ -292,16 +498,14 @@ func TestMonitorStations(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
 	mockScheduler.EXPECT().Start().Times(1)
-	mockScheduler.EXPECT().Shutdown().Times(1).Return(nil)
+	mockScheduler.EXPECT().ShutdownWithContext(gomock.Any()).Times(1).Return(nil)
 	// Just accept any job creation, we're not testing that here
 	mockScheduler.EXPECT().NewJob(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
 
 	monitor := &StationMonitor{
-		Connect:                     mockConnectAPI,
-		TransactionStatsPublisher:   mockTransactionStatsPublisher,
-		TransactionHistoryPublisher: mockTransactionHistoryPublisher,
-		StationStatusPublisher:      mockStationStatusPublisher,
-		Scheduler:                   mockScheduler,
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionStatsPublisher, mockTransactionHistoryPublisher, mockStationStatusPublisher),
+		Scheduler:  mockScheduler,
 	}
 
 	// Set a timer to cancel the context. Otherwise this will continue
@@ -316,3 +520,138 @@ func TestMonitorStations(t *testing.T) {
 	require.EqualError(t, err, "context canceled")
 	mockConnectAPI.AssertExpectations(t)
 }
+
+// Start monitoring with a job that blocks until released, cancelling the
+// monitor's context once the job is running
+func monitorWithRunningJob(t *testing.T, timeout time.Duration, job func(context.Context) error) (*MockConnectAPI, error) {
+	started := make(chan struct{})
+
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetStations").Return([]connect.Station{}, nil).Run(func(mock.Arguments) { close(started) })
+	mockConnectAPI.On("Logout").Return(nil)
+
+	ctrl := gomock.NewController(t)
+	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
+	mockScheduler.EXPECT().Start()
+	mockScheduler.EXPECT().ShutdownWithContext(gomock.Any()).Return(nil)
+	mockScheduler.EXPECT().NewJob(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	monitor := &StationMonitor{
+		Connect:         mockConnectAPI,
+		Scheduler:       mockScheduler,
+		ShutdownTimeout: timeout,
+	}
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	go func() {
+		<-started
+		monitor.runJob(monitor.jobContext(), "station_stats", connect.Station{ID: "station1"}, func(ctx context.Context) error {
+			close(running)
+			return job(ctx)
+		})
+	}()
+	go func() {
+		<-running
+		cancelCtx()
+	}()
+
+	return mockConnectAPI, monitor.MonitorStations(ctx)
+}
+
+func TestMonitorStationsDrainsJobs(t *testing.T) {
+	var jobErr error
+	finished := false
+
+	mockConnectAPI, err := monitorWithRunningJob(t, 5*time.Second, func(ctx context.Context) error {
+		// Still running after monitoring is cancelled
+		time.Sleep(200 * time.Millisecond)
+		jobErr = ctx.Err()
+		finished = true
+		return nil
+	})
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.True(t, finished, "Running job should finish before monitoring stops")
+	assert.NoError(t, jobErr, "Running job shouldn't be cancelled while it drains")
+	mockConnectAPI.AssertCalled(t, "Logout")
+}
+
+func TestMonitorStationsShutdownTimeout(t *testing.T) {
+	var jobErr error
+
+	mockConnectAPI, err := monitorWithRunningJob(t, 100*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		jobErr = ctx.Err()
+		return jobErr
+	})
+
+	require.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, jobErr, context.Canceled, "Jobs still running after the timeout should be cancelled")
+	mockConnectAPI.AssertCalled(t, "Logout")
+}
+
+func TestCreateJobsForStationsDisabled(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetStations").Return([]connect.Station{{ID: "station1"}, {ID: "station2"}}, nil)
+
+	ctrl := gomock.NewController(t)
+	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
+	mockScheduler.EXPECT().NewJob(gomock.Any(), gomock.Any(), gomock.Any()).Times(3)
+
+	monitor := &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: NewPublishers(),
+		Scheduler:  mockScheduler,
+		Stations:   map[string]StationConfig{"station2": {Disabled: true}},
+	}
+
+	require.NoError(t, monitor.CreateJobsForStations(context.Background()))
+	assert.ElementsMatch(t, []string{"station1"}, monitor.monitoredStations())
+}
+
+func TestReconfigure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockScheduler := gocronmocks.NewMockScheduler(ctrl)
+	// station1's jobs are replaced to check at its new shortest interval,
+	// and station2's removed
+	mockScheduler.EXPECT().RemoveByTags("station:station1").Times(1)
+	mockScheduler.EXPECT().NewJob(gomock.Any(), gomock.Any(), gomock.Any()).Times(3)
+	mockScheduler.EXPECT().RemoveByTags("station:station2").Times(1)
+
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("SetRateLimit", 500*time.Millisecond)
+
+	monitor := &StationMonitor{
+		Config:     &Config{},
+		Connect:    mockConnectAPI,
+		Publishers: NewPublishers(),
+		Scheduler:  mockScheduler,
+		stations: map[string]connect.Station{
+			"station1": {ID: "station1"},
+			"station2": {ID: "station2"},
+			"station3": {ID: "station3"},
+		},
+	}
+
+	config := &Config{
+		PollIntervals:    PollIntervals{Idle: time.Hour},
+		StalePolls:       5,
+		APIRate:          2,
+		FetchConcurrency: 8,
+		Stations: map[string]StationConfig{
+			"station1": {Nickname: "Garage", PollIntervals: PollIntervals{Charging: 10 * time.Second}},
+			"station2": {Disabled: true},
+		},
+	}
+	monitor.Reconfigure(config)
+
+	assert.ElementsMatch(t, []string{"station1", "station3"}, monitor.monitoredStations())
+	assert.Equal(t, PollIntervals{Charging: 10 * time.Second, PluggedIn: 3 * time.Minute, Idle: time.Hour, Session: 15 * time.Second}, monitor.stationIntervals("station1"))
+	assert.Equal(t, PollIntervals{Charging: 30 * time.Second, PluggedIn: 3 * time.Minute, Idle: time.Hour, Session: 15 * time.Second}, monitor.stationIntervals("station3"))
+	assert.Equal(t, 5, monitor.stalePolls())
+	assert.Equal(t, 8, monitor.fetchConcurrency())
+	assert.Equal(t, "Garage", monitor.Health(context.Background()).Stations["station1"].Nickname)
+	assert.Same(t, config, monitor.Config, "The next reload should be compared against the applied config")
+	mockConnectAPI.AssertExpectations(t)
+}
@@ -0,0 +1,316 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+// How often to poll a station's status, depending on what it's doing. A
+// charging session's power curve needs frequent samples, while an idle
+// charger rarely has anything new to report.
+type PollIntervals struct {
+	// A vehicle is drawing power
+	Charging time.Duration
+	// A vehicle is plugged in but not drawing power, e.g. waiting for a
+	// schedule or a full battery
+	PluggedIn time.Duration
+	// Nothing is plugged in, or the station is offline
+	Idle time.Duration
+
+	// How often to poll the transaction of a session in progress, for its
+	// meter values
+	Session time.Duration
+}
+
+func DefaultPollIntervals() PollIntervals {
+	return PollIntervals{
+		Charging:  30 * time.Second,
+		PluggedIn: 3 * time.Minute,
+		Idle:      30 * time.Minute,
+		Session:   15 * time.Second,
+	}
+}
+
+// Fill in any intervals that haven't been set with their defaults
+func (p PollIntervals) withDefaults() PollIntervals {
+	defaults := DefaultPollIntervals()
+
+	if p.Charging <= 0 {
+		p.Charging = defaults.Charging
+	}
+	if p.PluggedIn <= 0 {
+		p.PluggedIn = defaults.PluggedIn
+	}
+	if p.Idle <= 0 {
+		p.Idle = defaults.Idle
+	}
+	if p.Session <= 0 {
+		p.Session = defaults.Session
+	}
+
+	return p
+}
+
+// The intervals, with any that are set in other in their place
+func (p PollIntervals) overriddenBy(other PollIntervals) PollIntervals {
+	if other.Charging > 0 {
+		p.Charging = other.Charging
+	}
+	if other.PluggedIn > 0 {
+		p.PluggedIn = other.PluggedIn
+	}
+	if other.Idle > 0 {
+		p.Idle = other.Idle
+	}
+	if other.Session > 0 {
+		p.Session = other.Session
+	}
+
+	return p
+}
+
+func (p PollIntervals) interval(activity stationActivity) time.Duration {
+	switch activity {
+	case activityCharging:
+		return p.Charging
+	case activityPluggedIn:
+		return p.PluggedIn
+	default:
+		return p.Idle
+	}
+}
+
+// The shortest status interval, which is how often the poll jobs check
+// whether their station is due
+func (p PollIntervals) shortest() time.Duration {
+	return min(p.Charging, p.PluggedIn, p.Idle)
+}
+
+// The longest status interval, used to hold a station's poll while it's in flight
+func (p PollIntervals) longest() time.Duration {
+	return max(p.Charging, p.PluggedIn, p.Idle)
+}
+
+type stationActivity int
+
+const (
+	activityIdle stationActivity = iota
+	activityPluggedIn
+	activityCharging
+)
+
+func (a stationActivity) String() string {
+	switch a {
+	case activityCharging:
+		return "charging"
+	case activityPluggedIn:
+		return "plugged in"
+	default:
+		return "idle"
+	}
+}
+
+// Work out what a station is doing from its connector statuses, which use
+// the OCPP ChargePointStatus values. The busiest connector wins.
+func activityOf(station connect.Station) stationActivity {
+	if !station.Online {
+		return activityIdle
+	}
+
+	statuses := []string{station.Status}
+	for _, connector := range station.Connectors {
+		statuses = append(statuses, connector.Status)
+	}
+
+	activity := activityIdle
+	for _, status := range statuses {
+		switch {
+		case status == "Charging":
+			activity = max(activity, activityCharging)
+		case pluggedIn(status):
+			activity = max(activity, activityPluggedIn)
+		}
+	}
+
+	return activity
+}
+
+// What a station was last seen doing, when it's next due to be polled, and
+// when it was last polled successfully
+type stationPoll struct {
+	next     time.Time
+	activity stationActivity
+
+	added       time.Time
+	lastSuccess time.Time
+
+	// Paused stations aren't polled until they're resumed
+	paused bool
+}
+
+type pollSchedule struct {
+	mu       sync.Mutex
+	stations map[string]*stationPoll
+}
+
+// Must be called with the lock held
+func (s *pollSchedule) get(stationId string) *stationPoll {
+	if s.stations == nil {
+		s.stations = map[string]*stationPoll{}
+	}
+
+	poll, ok := s.stations[stationId]
+	if !ok {
+		poll = &stationPoll{}
+		s.stations[stationId] = poll
+	}
+	return poll
+}
+
+// Check whether a station is due to be polled. If it is, the poll is held
+// for the given time, so checks made while it's in flight don't poll it
+// again.
+func (s *pollSchedule) claim(stationId string, now time.Time, hold time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	poll := s.get(stationId)
+	if now.Before(poll.next) {
+		return false
+	}
+
+	poll.next = now.Add(hold)
+	return true
+}
+
+// Give up a claimed poll, so it's tried again on the next check
+func (s *pollSchedule) release(stationId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(stationId).next = time.Time{}
+}
+
+// Record what a station is doing, returning whether that has changed
+func (s *pollSchedule) setActivity(stationId string, activity stationActivity) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	poll := s.get(stationId)
+	changed := poll.activity != activity
+	poll.activity = activity
+	return changed
+}
+
+// Schedule a station's next poll based on what it was last seen doing,
+// returning the interval used
+func (s *pollSchedule) scheduleNext(stationId string, from time.Time, intervals PollIntervals) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	poll := s.get(stationId)
+	interval := intervals.interval(poll.activity)
+	poll.next = from.Add(interval)
+	return interval
+}
+
+// Start tracking a station, which hasn't been polled yet
+func (s *pollSchedule) add(stationId string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(stationId).added = at
+}
+
+// Record a successful poll of a station
+func (s *pollSchedule) succeeded(stationId string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(stationId).lastSuccess = at
+}
+
+// Pause or resume a station's jobs
+func (s *pollSchedule) setPaused(stationId string, paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(stationId).paused = paused
+}
+
+// Get a copy of a station's poll state
+func (s *pollSchedule) status(stationId string) stationPoll {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *s.get(stationId)
+}
+
+func (s *pollSchedule) remove(stationId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.stations, stationId)
+}
+
+// A limit on the number of Connect API calls made in any hour, shared by all
+// of the monitor's jobs. A nil budget is unlimited.
+type callBudget struct {
+	limit int
+	now   func() time.Time
+
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+func newCallBudget(limit int) *callBudget {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &callBudget{limit: limit, now: time.Now}
+}
+
+// Take n calls from the budget, returning false without taking any if that
+// would go over it
+func (b *callBudget) take(n int) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	// Drop calls that have aged out of the window
+	cutoff := now.Add(-time.Hour)
+	expired := 0
+	for expired < len(b.calls) && !b.calls[expired].After(cutoff) {
+		expired++
+	}
+	b.calls = b.calls[expired:]
+
+	if len(b.calls)+n > b.limit {
+		return false
+	}
+
+	for range n {
+		b.calls = append(b.calls, now)
+	}
+	return true
+}
+
+// Give back n calls taken from the budget that were never made
+func (b *callBudget) refund(n int) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls = b.calls[:len(b.calls)-min(n, len(b.calls))]
+}
@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestActivityOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		station  connect.Station
+		expected stationActivity
+	}{
+		{"available", connect.Station{Online: true, Connectors: []connect.Connector{{Status: "Available"}}}, activityIdle},
+		{"charging", connect.Station{Online: true, Connectors: []connect.Connector{{Status: "Charging"}}}, activityCharging},
+		{"suspended", connect.Station{Online: true, Connectors: []connect.Connector{{Status: "SuspendedEV"}}}, activityPluggedIn},
+		{"station status", connect.Station{Online: true, Status: "Preparing"}, activityPluggedIn},
+		{"busiest connector", connect.Station{Online: true, Connectors: []connect.Connector{{Status: "Finishing"}, {Status: "Charging"}}}, activityCharging},
+		{"offline", connect.Station{Online: false, Connectors: []connect.Connector{{Status: "Charging"}}}, activityIdle},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, activityOf(test.station))
+		})
+	}
+}
+
+func TestPollIntervalsDefaults(t *testing.T) {
+	intervals := PollIntervals{Charging: 10 * time.Second}.withDefaults()
+
+	assert.Equal(t, 10*time.Second, intervals.Charging)
+	assert.Equal(t, DefaultPollIntervals().PluggedIn, intervals.PluggedIn)
+	assert.Equal(t, DefaultPollIntervals().Idle, intervals.Idle)
+	assert.Equal(t, 10*time.Second, intervals.shortest())
+	assert.Equal(t, DefaultPollIntervals().Idle, intervals.longest())
+}
+
+func TestCallBudget(t *testing.T) {
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	budget := newCallBudget(3)
+	budget.now = func() time.Time { return now }
+
+	assert.True(t, budget.take(2))
+	assert.False(t, budget.take(2), "Calls over the budget should be refused")
+	assert.True(t, budget.take(1))
+	assert.False(t, budget.take(1))
+
+	now = now.Add(time.Hour)
+	assert.True(t, budget.take(3), "Calls older than an hour shouldn't count")
+
+	assert.Nil(t, newCallBudget(0))
+	assert.True(t, newCallBudget(0).take(100), "No budget should be unlimited")
+}
+
+func newPollingMonitor(t *testing.T, status string) (*StationMonitor, *MockConnectAPI) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetStation", "station1").Return(connect.Station{
+		ID:         "station1",
+		Online:     true,
+		Connectors: []connect.Connector{{ID: 1, Status: status}},
+	}, nil)
+	mockConnectAPI.On("GetTransactionStatistics", "station1").Return(connect.TransactionStats{}, nil)
+
+	mockStationStatusPublisher := new(MockStationStatusPublisher)
+	mockStationStatusPublisher.On("PublishStationStatus", mock.Anything)
+	mockTransactionStatsPublisher := new(MockTransactionStatsPublisher)
+	mockTransactionStatsPublisher.On("PublishTransactionStats", "station1", mock.Anything)
+
+	return &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockStationStatusPublisher, mockTransactionStatsPublisher),
+		Metrics:    NewMetrics(prometheus.NewRegistry()),
+	}, mockConnectAPI
+}
+
+func TestPollStationAdaptsToActivity(t *testing.T) {
+	for status, expected := range map[string]time.Duration{
+		"Charging":    30 * time.Second,
+		"SuspendedEV": 3 * time.Minute,
+		"Available":   30 * time.Minute,
+	} {
+		t.Run(status, func(t *testing.T) {
+			monitor, mockConnectAPI := newPollingMonitor(t, status)
+			station := connect.Station{ID: "station1"}
+
+			monitor.pollStation(context.Background(), station)
+			// Not due again yet
+			monitor.pollStation(context.Background(), station)
+
+			mockConnectAPI.AssertNumberOfCalls(t, "GetStation", 1)
+			assert.Equal(t, expected.Seconds(), testutil.ToFloat64(monitor.Metrics.PollInterval.WithLabelValues("station1")))
+		})
+	}
+}
+
+func TestPollStationBudget(t *testing.T) {
+	monitor, mockConnectAPI := newPollingMonitor(t, "Charging")
+	monitor.APICallBudget = 3
+	station := connect.Station{ID: "station1"}
+
+	monitor.pollStation(context.Background(), station)
+
+	// Make the station due again; the budget only has room for one more call
+	monitor.polls.release("station1")
+	monitor.pollStation(context.Background(), station)
+
+	mockConnectAPI.AssertNumberOfCalls(t, "GetStation", 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(monitor.Metrics.JobsDeferred.WithLabelValues("station_stats")))
+}
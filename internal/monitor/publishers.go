@@ -0,0 +1,203 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+// A registry of publishers that fans each event out to every sink registered
+// for it. A sink only needs to implement the publisher interfaces for the
+// events it wants, and a sink failing, or panicking, doesn't stop the others
+// getting the event. With no sinks, events are dropped.
+//
+// Publishers implements all of the publisher interfaces itself, so it can be
+// used anywhere a single publisher is expected, e.g. by the OCPP central
+// system.
+type Publishers struct {
+	mu      sync.RWMutex
+	sinks   []sink
+	metrics *Metrics
+}
+
+type sink struct {
+	name      string
+	publisher any
+}
+
+func NewPublishers() *Publishers {
+	return &Publishers{}
+}
+
+// Register a sink under a name used in errors and logs. The sink must
+// implement at least one of the publisher interfaces.
+func (p *Publishers) Register(name string, publisher any) error {
+	switch publisher.(type) {
+	case TransactionHistoryPublisher, TransactionStatsPublisher, StationStatusPublisher, StationRemovalPublisher, SessionPublisher:
+	default:
+		return fmt.Errorf("publisher %s doesn't implement any publisher interfaces", name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sinks = append(p.sinks, sink{name: name, publisher: publisher})
+	return nil
+}
+
+// Count errors publishing to each sink, by the sink's name
+func (p *Publishers) SetMetrics(metrics *Metrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metrics = metrics
+}
+
+// Call fn for every sink, recovering from any panic so it doesn't take the
+// other sinks, or the job publishing, down with it
+func (p *Publishers) each(event string, fn func(sink) error) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.RLock()
+	sinks := p.sinks
+	metrics := p.metrics
+	p.mu.RUnlock()
+
+	var errs []error
+	for _, s := range sinks {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return fn(s)
+		}()
+
+		if err != nil {
+			log.Printf("Error publishing %s to %s: %v", event, s.name, err)
+			metrics.observePublishError(s.name)
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Publishers) PublishStationStatus(station connect.Station) {
+	p.each("station status", func(s sink) error {
+		if publisher, ok := s.publisher.(StationStatusPublisher); ok {
+			publisher.PublishStationStatus(station)
+		}
+		return nil
+	})
+}
+
+func (p *Publishers) PublishTransactionStats(stationId string, stats connect.TransactionStats) {
+	p.each("transaction stats", func(s sink) error {
+		if publisher, ok := s.publisher.(TransactionStatsPublisher); ok {
+			publisher.PublishTransactionStats(stationId, stats)
+		}
+		return nil
+	})
+}
+
+func (p *Publishers) RemoveStation(stationId string) {
+	p.each("station removal", func(s sink) error {
+		if publisher, ok := s.publisher.(StationRemovalPublisher); ok {
+			publisher.RemoveStation(stationId)
+		}
+		return nil
+	})
+}
+
+// Publish a transaction's history to every history sink, returning the
+// errors of any that failed
+func (p *Publishers) PublishTransactionHistory(stationId string, transaction connect.Transaction) error {
+	return p.each("transaction history", func(s sink) error {
+		if publisher, ok := s.publisher.(TransactionHistoryPublisher); ok {
+			return publisher.PublishTransactionHistory(stationId, transaction)
+		}
+		return nil
+	})
+}
+
+// Publish an in-progress session's new meter values to every session sink,
+// returning the errors of any that failed
+func (p *Publishers) PublishSessionProgress(stationId string, transaction connect.Transaction) error {
+	return p.each("session progress", func(s sink) error {
+		if publisher, ok := s.publisher.(SessionPublisher); ok {
+			return publisher.PublishSessionProgress(stationId, transaction)
+		}
+		return nil
+	})
+}
+
+// A transaction is published once every history sink has it, so a sink
+// that's added later, or failed, gets it on the next run. With no history
+// sinks there's nothing to publish it to.
+func (p *Publishers) TransactionPublished(transaction connect.Transaction) bool {
+	published := true
+
+	err := p.each("transaction published check", func(s sink) error {
+		if publisher, ok := s.publisher.(TransactionHistoryPublisher); ok && !publisher.TransactionPublished(transaction) {
+			published = false
+		}
+		return nil
+	})
+
+	return published && err == nil
+}
+
+// Check the health of every sink, by name. Sinks that can't check their
+// health are assumed to be healthy.
+func (p *Publishers) CheckHealth(ctx context.Context) map[string]error {
+	health := map[string]error{}
+	if p == nil {
+		return health
+	}
+
+	p.mu.RLock()
+	sinks := p.sinks
+	p.mu.RUnlock()
+
+	for _, s := range sinks {
+		health[s.name] = nil
+		if checker, ok := s.publisher.(HealthChecker); ok {
+			health[s.name] = checker.CheckHealth(ctx)
+		}
+	}
+
+	return health
+}
+
+// Close every sink that can be closed, in the reverse of the order they were
+// registered
+func (p *Publishers) Close() error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var errs []error
+	for i := len(p.sinks) - 1; i >= 0; i-- {
+		closer, ok := p.sinks[i].publisher.(interface{ Close() error })
+		if !ok {
+			continue
+		}
+
+		if err := closer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.sinks[i].name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
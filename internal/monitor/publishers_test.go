@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// A history sink that fails to publish
+type failingHistoryPublisher struct {
+	MockTransactionHistoryPublisher
+}
+
+func (f *failingHistoryPublisher) PublishTransactionHistory(stationID string, transaction connect.Transaction) error {
+	f.Called(stationID, transaction)
+	return fmt.Errorf("database unavailable")
+}
+
+// A status sink with a bug
+type panickingStatusPublisher struct{}
+
+func (panickingStatusPublisher) PublishStationStatus(station connect.Station) {
+	panic("nil map")
+}
+
+func (panickingStatusPublisher) Close() error {
+	return nil
+}
+
+func TestPublishersFanOut(t *testing.T) {
+	first := new(MockStationStatusPublisher)
+	first.On("PublishStationStatus", connect.Station{ID: "station1"})
+	second := new(MockStationStatusPublisher)
+	second.On("PublishStationStatus", connect.Station{ID: "station1"})
+	stats := new(MockTransactionStatsPublisher)
+	stats.On("PublishTransactionStats", "station1", connect.TransactionStats{Sessions: 2})
+
+	publishers := publishersOf(t, first, stats, second)
+	publishers.PublishStationStatus(connect.Station{ID: "station1"})
+	publishers.PublishTransactionStats("station1", connect.TransactionStats{Sessions: 2})
+
+	first.AssertExpectations(t)
+	second.AssertExpectations(t)
+	stats.AssertExpectations(t)
+}
+
+func TestPublishersIsolateErrors(t *testing.T) {
+	transaction := connect.Transaction{ID: "trans1"}
+
+	failing := new(failingHistoryPublisher)
+	failing.On("PublishTransactionHistory", "station1", transaction)
+	working := new(MockTransactionHistoryPublisher)
+	working.On("PublishTransactionHistory", "station1", transaction)
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	publishers := NewPublishers()
+	publishers.SetMetrics(metrics)
+	require.NoError(t, publishers.Register("timescale", failing))
+	require.NoError(t, publishers.Register("file", working))
+
+	err := publishers.PublishTransactionHistory("station1", transaction)
+	require.ErrorContains(t, err, "timescale: database unavailable")
+	working.AssertExpectations(t)
+
+	status := new(MockStationStatusPublisher)
+	status.On("PublishStationStatus", mock.Anything)
+	require.NoError(t, publishers.Register("broken", panickingStatusPublisher{}))
+	require.NoError(t, publishers.Register("prometheus", status))
+
+	assert.NotPanics(t, func() {
+		publishers.PublishStationStatus(connect.Station{ID: "station1"})
+	})
+	// A panicking sink shouldn't stop the others
+	status.AssertExpectations(t)
+
+	// Errors are counted against the sink that returned them, for any event
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.PublishErrors.WithLabelValues("timescale")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.PublishErrors.WithLabelValues("broken")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.PublishErrors.WithLabelValues("prometheus")))
+}
+
+func TestPublishersTransactionPublished(t *testing.T) {
+	transaction := connect.Transaction{ID: "trans1"}
+
+	published := new(MockTransactionHistoryPublisher)
+	published.On("TransactionPublished", transaction).Return(true)
+	unpublished := new(MockTransactionHistoryPublisher)
+	unpublished.On("TransactionPublished", transaction).Return(false)
+
+	assert.True(t, publishersOf(t, published).TransactionPublished(transaction))
+	assert.False(t, publishersOf(t, published, unpublished).TransactionPublished(transaction), "Every sink should have the transaction")
+	assert.True(t, publishersOf(t, new(MockStationStatusPublisher)).TransactionPublished(transaction), "Nothing to publish to without history sinks")
+}
+
+func TestPublishersDefaultNoOp(t *testing.T) {
+	var publishers *Publishers
+
+	assert.NotPanics(t, func() {
+		publishers.PublishStationStatus(connect.Station{ID: "station1"})
+		publishers.PublishTransactionStats("station1", connect.TransactionStats{})
+		publishers.RemoveStation("station1")
+	})
+	assert.NoError(t, publishers.PublishTransactionHistory("station1", connect.Transaction{}))
+	assert.NoError(t, publishers.Close())
+
+	// Without a history sink, the transaction history job has nothing to do
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetAllTransactions", "station1").Return([]connect.Transaction{{ID: "trans1"}}, nil)
+
+	monitor := &StationMonitor{Connect: mockConnectAPI}
+	require.NoError(t, monitor.transactionHistory(context.Background(), connect.Station{ID: "station1"}))
+	mockConnectAPI.AssertNotCalled(t, "GetTransaction", "trans1")
+}
+
+func TestPublishersRegister(t *testing.T) {
+	publishers := NewPublishers()
+
+	assert.Error(t, publishers.Register("nothing", struct{}{}), "Sinks should implement a publisher interface")
+	assert.NoError(t, publishers.Register("prometheus", new(MockStationStatusPublisher)))
+}
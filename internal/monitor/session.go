@@ -0,0 +1,286 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+/**
+* Live tracking of charging sessions. The transaction history job only runs
+* every hour or so, and a session's meter values are only to be had from its
+* transaction, so once a station is seen in a session its active transaction
+* is looked up and polled at the Session interval. Each poll publishes just
+* the meter values sampled since the last one to the session sinks. Once the
+* transaction has a stop time it's finalized: the session sinks get the last
+* of it, and any history sink that still doesn't have it gets the whole
+* transaction.
+*
+* Sessions are only tracked in memory. After a restart the active session is
+* looked up again, and its meter values published from the start, which is
+* harmless as publishing them again overwrites them.
+ */
+
+// How long a station can be seen idle before a session that hasn't finished
+// is given up on, and left to the transaction history job
+const sessionIdleGrace = 5 * time.Minute
+
+// A session being tracked on a station
+type liveSession struct {
+	transactionId string
+	// When the last meter value published was sampled
+	lastSample time.Time
+	// When the station was first seen idle during the session
+	idleSince time.Time
+}
+
+type liveSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*liveSession
+	// When each station was last looked up for an active session
+	lookedUp map[string]time.Time
+}
+
+// Get a copy of the session being tracked on a station, if there is one
+func (s *liveSessions) get(stationId string) (liveSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[stationId]
+	if !ok {
+		return liveSession{}, false
+	}
+	return *session, true
+}
+
+// Start tracking a transaction on a station, returning false if it already
+// is
+func (s *liveSessions) start(stationId string, transactionId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions == nil {
+		s.sessions = map[string]*liveSession{}
+	}
+	if session, ok := s.sessions[stationId]; ok && session.transactionId == transactionId {
+		return false
+	}
+
+	s.sessions[stationId] = &liveSession{transactionId: transactionId}
+	return true
+}
+
+// Whether a transaction is being tracked on any station
+func (s *liveSessions) tracking(transactionId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.transactionId == transactionId {
+			return true
+		}
+	}
+	return false
+}
+
+// Record the meter values published up to
+func (s *liveSessions) published(stationId string, lastSample time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[stationId]; ok {
+		session.lastSample = lastSample
+	}
+}
+
+// Record whether the station is idle, returning how long it's been idle for
+func (s *liveSessions) idle(stationId string, idle bool, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[stationId]
+	switch {
+	case !ok:
+		return 0
+	case !idle:
+		session.idleSince = time.Time{}
+		return 0
+	case session.idleSince.IsZero():
+		session.idleSince = now
+	}
+	return now.Sub(session.idleSince)
+}
+
+// Check whether a station is due to be looked up for an active session. If
+// it is, it's not due again for the given time.
+func (s *liveSessions) lookupDue(stationId string, now time.Time, every time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lookedUp == nil {
+		s.lookedUp = map[string]time.Time{}
+	}
+	if now.Sub(s.lookedUp[stationId]) < every {
+		return false
+	}
+
+	s.lookedUp[stationId] = now
+	return true
+}
+
+// Stop tracking a station's session
+func (s *liveSessions) end(stationId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, stationId)
+}
+
+// Forget a station altogether
+func (s *liveSessions) remove(stationId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, stationId)
+	delete(s.lookedUp, stationId)
+}
+
+// Poll the session on a station, if it's in one. A station without a
+// tracked session is looked up for one once it's seen plugged in or
+// charging, at most once per poll interval.
+func (m *StationMonitor) pollSession(ctx context.Context, station connect.Station) {
+	poll := m.polls.status(station.ID)
+	if poll.paused {
+		return
+	}
+
+	session, tracking := m.sessions.get(station.ID)
+	if !tracking {
+		interval := m.stationIntervals(station.ID).interval(poll.activity)
+		if poll.activity == activityIdle || m.circuitBreakers().blocked(station.ID, endpointTransactions) {
+			return
+		}
+		if !m.sessions.lookupDue(station.ID, time.Now(), interval) || !m.withinBudget("session", station.ID, 1) {
+			return
+		}
+
+		m.runJob(ctx, "session", station, func(ctx context.Context) error {
+			return skipOpenCircuit(m.findSession(ctx, station.ID))
+		})
+		return
+	}
+
+	// Sessions are finished when their transaction says so, but one that
+	// never does is given up on eventually
+	if idle := m.sessions.idle(station.ID, poll.activity == activityIdle, time.Now()); idle > sessionIdleGrace {
+		log.Printf("Station %s has been idle for %s but session %s hasn't finished, leaving it to the transaction history job", station.ID, idle.Round(time.Second), session.transactionId)
+		m.sessions.end(station.ID)
+		return
+	}
+
+	if m.circuitBreakers().blocked(station.ID, endpointTransaction) || !m.withinBudget("session", station.ID, 1) {
+		return
+	}
+
+	m.runJob(ctx, "session", station, func(ctx context.Context) error {
+		return skipOpenCircuit(m.sessionProgress(ctx, station.ID, session))
+	})
+}
+
+// Look up a station's active session. Transactions are listed newest first,
+// so it's the first, if that hasn't stopped.
+func (m *StationMonitor) findSession(ctx context.Context, stationId string) error {
+	var transactions []connect.Transaction
+	err := m.callEndpoint(stationId, endpointTransactions, func() (err error) {
+		transactions, err = m.Connect.GetTransactions(ctx, stationId, 1, 0)
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("Error looking up the active session of station %s: %v", stationId, err)
+		}
+		return err
+	}
+
+	if len(transactions) == 0 || transactions[0].StopAt != "" {
+		return nil
+	}
+
+	if m.sessions.start(stationId, transactions[0].ID) {
+		log.Printf("Tracking session %s on station %s", transactions[0].ID, stationId)
+	}
+	return nil
+}
+
+// Publish the meter values of a tracked session sampled since the last poll,
+// finalizing it if it's finished
+func (m *StationMonitor) sessionProgress(ctx context.Context, stationId string, session liveSession) error {
+	var transaction connect.Transaction
+	err := m.callEndpoint(stationId, endpointTransaction, func() (err error) {
+		transaction, err = m.Connect.GetTransaction(ctx, session.transactionId)
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, ErrCircuitOpen) {
+			log.Printf("Error getting session %s: %v", session.transactionId, err)
+		}
+		return err
+	}
+
+	progress := transaction
+	progress.MeterValues = meterValuesAfter(transaction.MeterValues, session.lastSample)
+	samples := len(progress.MeterValues.Date)
+
+	if transaction.StopAt == "" {
+		if samples == 0 {
+			return nil
+		}
+
+		log.Printf("Publishing %d new meter values of session %s", samples, transaction.ID)
+		if err := m.Publishers.PublishSessionProgress(stationId, progress); err != nil {
+			return err
+		}
+		m.sessions.published(stationId, progress.MeterValues.Date[samples-1])
+		return nil
+	}
+
+	// Finished. Anything that fails here is picked up by the transaction
+	// history job, as the transaction's no longer tracked.
+	log.Printf("Session %s on station %s has finished", transaction.ID, stationId)
+	m.sessions.end(stationId)
+
+	errs := []error{m.Publishers.PublishSessionProgress(stationId, progress)}
+	if !m.Publishers.TransactionPublished(transaction) {
+		errs = append(errs, m.publishTransactionHistory(ctx, stationId, transaction))
+	}
+	return errors.Join(errs...)
+}
+
+// The meter values sampled after a time
+func meterValuesAfter(mv connect.MeterValues, after time.Time) connect.MeterValues {
+	from := 0
+	for from < len(mv.Date) && !mv.Date[from].After(after) {
+		from++
+	}
+
+	return connect.MeterValues{
+		Date:                       mv.Date[from:],
+		CurrentImport:              tail(mv.CurrentImport, from),
+		CurrentOffered:             tail(mv.CurrentOffered, from),
+		EnergyActiveImportRegister: tail(mv.EnergyActiveImportRegister, from),
+		PowerActiveImport:          tail(mv.PowerActiveImport, from),
+		SoC:                        tail(mv.SoC, from),
+		Temperature:                tail(mv.Temperature, from),
+		Voltage:                    tail(mv.Voltage, from),
+	}
+}
+
+// The values of a meter value array from an index on. A measurand the
+// charger hasn't reported has a short array, which gives what it has.
+func tail[T any](values []T, from int) []T {
+	return values[min(from, len(values)):]
+}
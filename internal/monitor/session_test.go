@@ -0,0 +1,164 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// A sink that, like TimescaleDB, takes sessions as they happen and counts a
+// transaction as published once it's seen it finish
+type memorySessions struct {
+	progress []connect.Transaction
+	finished map[string]bool
+}
+
+func (s *memorySessions) PublishSessionProgress(stationId string, transaction connect.Transaction) error {
+	s.progress = append(s.progress, transaction)
+	if transaction.StopAt != "" {
+		s.finished[transaction.ID] = true
+	}
+	return nil
+}
+
+func (s *memorySessions) PublishTransactionHistory(stationId string, transaction connect.Transaction) error {
+	s.finished[transaction.ID] = true
+	return nil
+}
+
+func (s *memorySessions) TransactionPublished(transaction connect.Transaction) bool {
+	return s.finished[transaction.ID]
+}
+
+func (s *memorySessions) Close() error {
+	return nil
+}
+
+// A transaction with a sample a minute for its first n minutes
+func sessionWithSamples(n int, stopAt string) connect.Transaction {
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	transaction := connect.Transaction{ID: "trans1", StartAt: start.Format(time.RFC3339), StopAt: stopAt}
+
+	mv := &transaction.MeterValues
+	for i := range n {
+		mv.Date = append(mv.Date, start.Add(time.Duration(i)*time.Minute))
+		mv.CurrentImport = append(mv.CurrentImport, 32)
+		mv.CurrentOffered = append(mv.CurrentOffered, 40)
+		mv.EnergyActiveImportRegister = append(mv.EnergyActiveImportRegister, i*120)
+		mv.PowerActiveImport = append(mv.PowerActiveImport, 7200)
+		mv.SoC = append(mv.SoC, 50+i)
+		mv.Temperature = append(mv.Temperature, 30)
+		mv.Voltage = append(mv.Voltage, 240)
+	}
+	return transaction
+}
+
+func TestSessionTracking(t *testing.T) {
+	monitor, mockConnectAPI := newPollingMonitor(t, "Charging")
+	station := connect.Station{ID: "station1"}
+	monitor.polls.setActivity("station1", activityCharging)
+
+	sessions := &memorySessions{finished: map[string]bool{}}
+	history := new(MockTransactionHistoryPublisher)
+	history.On("TransactionPublished", mock.Anything).Return(false)
+	history.On("PublishTransactionHistory", "station1", mock.Anything)
+	require.NoError(t, monitor.Publishers.Register("sessions", sessions))
+	require.NoError(t, monitor.Publishers.Register("history", history))
+
+	mockConnectAPI.On("GetTransactions", "station1", 1, 0).Return([]connect.Transaction{{ID: "trans1"}}, nil)
+	mockConnectAPI.On("GetTransaction", "trans1").Return(sessionWithSamples(2, ""), nil).Once()
+	mockConnectAPI.On("GetTransaction", "trans1").Return(sessionWithSamples(2, ""), nil).Once()
+	mockConnectAPI.On("GetTransaction", "trans1").Return(sessionWithSamples(3, ""), nil).Once()
+	mockConnectAPI.On("GetTransaction", "trans1").Return(sessionWithSamples(4, "2025-06-01T18:04:00Z"), nil).Once()
+
+	// The active session is looked up first
+	monitor.pollSession(context.Background(), station)
+	assert.True(t, monitor.sessions.tracking("trans1"))
+	assert.Empty(t, sessions.progress)
+
+	// Then only meter values that haven't been published are
+	for range 3 {
+		monitor.pollSession(context.Background(), station)
+	}
+	require.Len(t, sessions.progress, 2, "A poll without new meter values shouldn't publish anything")
+	assert.Len(t, sessions.progress[0].MeterValues.Date, 2)
+	assert.Len(t, sessions.progress[1].MeterValues.Date, 1)
+	assert.Equal(t, []int{52}, sessions.progress[1].MeterValues.SoC)
+
+	// Until it finishes
+	monitor.pollSession(context.Background(), station)
+	require.Len(t, sessions.progress, 3)
+	assert.Equal(t, "2025-06-01T18:04:00Z", sessions.progress[2].StopAt)
+	assert.Len(t, sessions.progress[2].MeterValues.Date, 1)
+	assert.False(t, monitor.sessions.tracking("trans1"))
+
+	// History sinks that don't take sessions get the whole transaction
+	history.AssertCalled(t, "PublishTransactionHistory", "station1", sessionWithSamples(4, "2025-06-01T18:04:00Z"))
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransactions", 1)
+}
+
+func TestSessionNotLookedUpWhenIdle(t *testing.T) {
+	monitor, mockConnectAPI := newPollingMonitor(t, "Available")
+
+	monitor.pollSession(context.Background(), connect.Station{ID: "station1"})
+	mockConnectAPI.AssertNotCalled(t, "GetTransactions", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSessionLookupWithoutActiveTransaction(t *testing.T) {
+	monitor, mockConnectAPI := newPollingMonitor(t, "SuspendedEV")
+	monitor.polls.setActivity("station1", activityPluggedIn)
+	mockConnectAPI.On("GetTransactions", "station1", 1, 0).Return([]connect.Transaction{{ID: "trans0", StopAt: "2025-06-01T12:00:00Z"}}, nil)
+
+	// Looked up at most once per poll interval
+	monitor.pollSession(context.Background(), connect.Station{ID: "station1"})
+	monitor.pollSession(context.Background(), connect.Station{ID: "station1"})
+
+	assert.False(t, monitor.sessions.tracking("trans0"), "A finished transaction isn't a session in progress")
+	mockConnectAPI.AssertNumberOfCalls(t, "GetTransactions", 1)
+}
+
+func TestTransactionHistorySkipsLiveSessions(t *testing.T) {
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetAllTransactions", "station1").Return([]connect.Transaction{{ID: "trans1"}}, nil)
+
+	mockTransactionHistoryPublisher := new(MockTransactionHistoryPublisher)
+	mockTransactionHistoryPublisher.On("TransactionPublished", connect.Transaction{ID: "trans1"}).Return(false)
+
+	monitor := &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockTransactionHistoryPublisher),
+	}
+	monitor.sessions.start("station1", "trans1")
+
+	require.NoError(t, monitor.transactionHistory(context.Background(), connect.Station{ID: "station1"}))
+	mockConnectAPI.AssertNotCalled(t, "GetTransaction", "trans1")
+}
+
+func TestLiveSessionsIdle(t *testing.T) {
+	var sessions liveSessions
+	now := time.Now()
+	sessions.start("station1", "trans1")
+
+	assert.Zero(t, sessions.idle("station1", true, now))
+	assert.Equal(t, time.Minute, sessions.idle("station1", true, now.Add(time.Minute)))
+
+	// Coming back into use starts the count again
+	assert.Zero(t, sessions.idle("station1", false, now.Add(2*time.Minute)))
+	assert.Zero(t, sessions.idle("station1", true, now.Add(3*time.Minute)))
+}
+
+func TestMeterValuesAfter(t *testing.T) {
+	mv := sessionWithSamples(3, "").MeterValues
+
+	after := meterValuesAfter(mv, mv.Date[0])
+	assert.Equal(t, mv.Date[1:], after.Date)
+	assert.Equal(t, []int{51, 52}, after.SoC)
+
+	assert.Equal(t, mv, meterValuesAfter(mv, time.Time{}))
+	assert.Empty(t, meterValuesAfter(mv, mv.Date[2]).Date)
+}
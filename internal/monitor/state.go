@@ -0,0 +1,107 @@
+package monitor
+
+import (
+	"log"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+// Somewhere to keep what the monitor knows about each station across
+// restarts, so change detection and notification cooldowns carry on where
+// they left off rather than starting from nothing
+type StateStore interface {
+	// The station as it was last polled, if it has been
+	Snapshot(stationId string) (connect.Station, bool, error)
+	SaveSnapshot(station connect.Station) error
+
+	// When a notification was last sent about a station, by a key of the
+	// notifier's choosing
+	LastNotified(stationId string, key string) (time.Time, bool, error)
+	SaveNotified(stationId string, key string, at time.Time) error
+
+	// Whether a finished transaction's history has been published
+	TransactionPublished(transactionId string) (bool, error)
+	SaveTransactionPublished(stationId string, transactionId string) error
+
+	// Forget a station's snapshot and notifications. Published transactions
+	// are kept, in case the station comes back.
+	RemoveStation(stationId string) error
+
+	Close() error
+}
+
+// Publishers of transaction history without a database of their own to
+// check what they've published
+type TransactionHistorySink interface {
+	PublishTransactionHistory(stationId string, transaction connect.Transaction) error
+}
+
+// A transaction history publisher that records what its sink has published
+// in a state store, and answers TransactionPublished from it. Without a state
+// store everything is published again after a restart.
+type StateTrackedPublisher struct {
+	Sink  TransactionHistorySink
+	State StateStore
+}
+
+func (p *StateTrackedPublisher) PublishTransactionHistory(stationId string, transaction connect.Transaction) error {
+	if err := p.Sink.PublishTransactionHistory(stationId, transaction); err != nil {
+		return err
+	}
+
+	// In-progress transactions are published again until they finish
+	if transaction.StopAt == "" || p.State == nil {
+		return nil
+	}
+	return p.State.SaveTransactionPublished(stationId, transaction.ID)
+}
+
+// Publishing again is harmless, so a transaction that can't be checked is
+// treated as unpublished
+func (p *StateTrackedPublisher) TransactionPublished(transaction connect.Transaction) bool {
+	if p.State == nil {
+		return false
+	}
+
+	published, err := p.State.TransactionPublished(transaction.ID)
+	if err != nil {
+		log.Printf("Error checking whether transaction %s is published: %v", transaction.ID, err)
+		return false
+	}
+	return published
+}
+
+// Close the sink, if it can be. The state store is closed by its owner.
+func (p *StateTrackedPublisher) Close() error {
+	if closer, ok := p.Sink.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Hides everything but PublishTransactionHistory, e.g. Close, from a
+// StateTrackedPublisher
+type historyOnly struct {
+	TransactionHistorySink
+}
+
+// Register a sink with the monitor's publishers. A transaction history sink
+// without a database of its own to say what it's published is tracked in the
+// state store, so it isn't sent everything again after a restart.
+func (m *StationMonitor) RegisterPublisher(name string, publisher any) error {
+	sink, ok := publisher.(TransactionHistorySink)
+	if _, tracked := publisher.(TransactionHistoryPublisher); !ok || tracked {
+		return m.Publishers.Register(name, publisher)
+	}
+
+	switch publisher.(type) {
+	case TransactionStatsPublisher, StationStatusPublisher, StationRemovalPublisher, SessionPublisher:
+		// Its other events go straight to it, and it's closed as itself
+		if err := m.Publishers.Register(name, publisher); err != nil {
+			return err
+		}
+		sink = historyOnly{sink}
+	}
+	return m.Publishers.Register(name, &StateTrackedPublisher{Sink: sink, State: m.State})
+}
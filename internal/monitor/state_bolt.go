@@ -0,0 +1,176 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	bolt "go.etcd.io/bbolt"
+	berrors "go.etcd.io/bbolt/errors"
+)
+
+/**
+* A StateStore in a local bbolt file. Each station has a bucket of its own,
+* holding its last snapshot and a nested bucket of notification times.
+* Published transactions are kept in a bucket of their own, keyed by ID, as
+* they're looked up without their station.
+ */
+
+var (
+	stationsBucket     = []byte("stations")
+	transactionsBucket = []byte("transactions")
+	notifiedBucket     = []byte("notified")
+	snapshotKey        = []byte("snapshot")
+)
+
+type BoltStateStore struct {
+	db *bolt.DB
+}
+
+// Open the state store at a path, creating it if it doesn't exist
+func OpenBoltStateStore(path string) (*BoltStateStore, error) {
+	// Don't wait forever if another monitor has the file open
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening state store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{stationsBucket, transactionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initialising state store %s: %w", path, err)
+	}
+
+	return &BoltStateStore{db: db}, nil
+}
+
+func (s *BoltStateStore) Close() error {
+	return s.db.Close()
+}
+
+// The station's bucket, or nil if it has none
+func stationBucket(tx *bolt.Tx, stationId string) *bolt.Bucket {
+	return tx.Bucket(stationsBucket).Bucket([]byte(stationId))
+}
+
+func createStationBucket(tx *bolt.Tx, stationId string) (*bolt.Bucket, error) {
+	return tx.Bucket(stationsBucket).CreateBucketIfNotExists([]byte(stationId))
+}
+
+func (s *BoltStateStore) Snapshot(stationId string) (connect.Station, bool, error) {
+	var station connect.Station
+	found := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := stationBucket(tx, stationId)
+		if bucket == nil {
+			return nil
+		}
+
+		data := bucket.Get(snapshotKey)
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(data, &station)
+	})
+
+	return station, found, err
+}
+
+func (s *BoltStateStore) SaveSnapshot(station connect.Station) error {
+	data, err := json.Marshal(station)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := createStationBucket(tx, station.ID)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(snapshotKey, data)
+	})
+}
+
+func (s *BoltStateStore) LastNotified(stationId string, key string) (time.Time, bool, error) {
+	var at time.Time
+	found := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := stationBucket(tx, stationId)
+		if bucket == nil {
+			return nil
+		}
+		notified := bucket.Bucket(notifiedBucket)
+		if notified == nil {
+			return nil
+		}
+
+		data := notified.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return at.UnmarshalText(data)
+	})
+
+	return at, found, err
+}
+
+func (s *BoltStateStore) SaveNotified(stationId string, key string, at time.Time) error {
+	data, err := at.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := createStationBucket(tx, stationId)
+		if err != nil {
+			return err
+		}
+		notified, err := bucket.CreateBucketIfNotExists(notifiedBucket)
+		if err != nil {
+			return err
+		}
+		return notified.Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStateStore) TransactionPublished(transactionId string) (bool, error) {
+	published := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		published = tx.Bucket(transactionsBucket).Get([]byte(transactionId)) != nil
+		return nil
+	})
+
+	return published, err
+}
+
+func (s *BoltStateStore) SaveTransactionPublished(stationId string, transactionId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(transactionsBucket).Put([]byte(transactionId), []byte(stationId))
+	})
+}
+
+func (s *BoltStateStore) RemoveStation(stationId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(stationsBucket).DeleteBucket([]byte(stationId))
+		if errors.Is(err, berrors.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,168 @@
+package monitor
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestStateStore(t *testing.T, path string) *BoltStateStore {
+	store, err := OpenBoltStateStore(path)
+	require.NoError(t, err)
+	return store
+}
+
+func TestBoltStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	store := openTestStateStore(t, path)
+
+	notifiedAt := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	require.NoError(t, store.SaveSnapshot(stationWith(true, "Charging", "NoError")))
+	require.NoError(t, store.SaveNotified("station1", "phone/session_finished/1", notifiedAt))
+	require.NoError(t, store.SaveTransactionPublished("station1", "trans1"))
+	require.NoError(t, store.Close())
+
+	// Everything should survive a restart
+	store = openTestStateStore(t, path)
+	defer store.Close()
+
+	snapshot, found, err := store.Snapshot("station1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "Charging", snapshot.Connectors[0].Status)
+
+	at, found, err := store.LastNotified("station1", "phone/session_finished/1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, notifiedAt.Equal(at))
+
+	published, err := store.TransactionPublished("trans1")
+	require.NoError(t, err)
+	assert.True(t, published)
+
+	// Removing a station forgets its state, but not its transactions
+	require.NoError(t, store.RemoveStation("station1"))
+	require.NoError(t, store.RemoveStation("station2"), "Removing an unknown station should do nothing")
+
+	_, found, err = store.Snapshot("station1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, found, err = store.LastNotified("station1", "phone/session_finished/1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	published, err = store.TransactionPublished("trans1")
+	require.NoError(t, err)
+	assert.True(t, published)
+}
+
+func TestStationStatsEventsAcrossRestart(t *testing.T) {
+	store := openTestStateStore(t, filepath.Join(t.TempDir(), "state.db"))
+	defer store.Close()
+	require.NoError(t, store.SaveSnapshot(stationWith(true, "Charging", "NoError")))
+
+	mockConnectAPI := new(MockConnectAPI)
+	mockConnectAPI.On("GetStation", "station1").Return(stationWith(true, "Available", "NoError"), nil)
+
+	mockStationStatusPublisher := new(MockStationStatusPublisher)
+	mockStationStatusPublisher.On("PublishStationStatus", mock.Anything)
+
+	monitor := &StationMonitor{
+		Connect:    mockConnectAPI,
+		Publishers: publishersOf(t, mockStationStatusPublisher),
+		Events:     NewEventBus(),
+		State:      store,
+	}
+	events, unsubscribe := monitor.Events.Subscribe("test", 0)
+	defer unsubscribe()
+
+	require.NoError(t, monitor.stationStats(t.Context(), connect.Station{ID: "station1"}))
+	require.Len(t, events, 2, "The poll from before the restart should be the baseline")
+	assert.Equal(t, EventSessionFinished, (<-events).Type)
+	assert.Equal(t, EventVehicleUnplugged, (<-events).Type)
+
+	snapshot, _, err := store.Snapshot("station1")
+	require.NoError(t, err)
+	assert.Equal(t, "Available", snapshot.Connectors[0].Status)
+}
+
+// A sink with nowhere to record what it's published
+type recordingSink struct {
+	published []string
+	err       error
+}
+
+func (r *recordingSink) PublishTransactionHistory(stationId string, transaction connect.Transaction) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.published = append(r.published, transaction.ID)
+	return nil
+}
+
+func TestStateTrackedPublisher(t *testing.T) {
+	store := openTestStateStore(t, filepath.Join(t.TempDir(), "state.db"))
+	defer store.Close()
+
+	sink := &recordingSink{}
+	publisher := &StateTrackedPublisher{Sink: sink, State: store}
+
+	finished := connect.Transaction{ID: "trans1", StopAt: "2025-06-01T19:00:00Z"}
+	inProgress := connect.Transaction{ID: "trans2"}
+
+	require.NoError(t, publisher.PublishTransactionHistory("station1", finished))
+	require.NoError(t, publisher.PublishTransactionHistory("station1", inProgress))
+
+	assert.Equal(t, []string{"trans1", "trans2"}, sink.published)
+	assert.True(t, publisher.TransactionPublished(finished))
+	assert.False(t, publisher.TransactionPublished(inProgress), "In-progress transactions should be published again")
+
+	sink.err = fmt.Errorf("unavailable")
+	failed := connect.Transaction{ID: "trans3", StopAt: "2025-06-01T20:00:00Z"}
+	require.Error(t, publisher.PublishTransactionHistory("station1", failed))
+	assert.False(t, publisher.TransactionPublished(failed))
+}
+
+// A history sink that also takes station statuses, and can be closed
+type statusRecordingSink struct {
+	recordingSink
+	statuses int
+	closed   int
+}
+
+func (r *statusRecordingSink) PublishStationStatus(station connect.Station) {
+	r.statuses++
+}
+
+func (r *statusRecordingSink) Close() error {
+	r.closed++
+	return nil
+}
+
+func TestRegisterPublisherTracksHistorySinks(t *testing.T) {
+	store := openTestStateStore(t, filepath.Join(t.TempDir(), "state.db"))
+	defer store.Close()
+
+	sink := &statusRecordingSink{}
+	monitor := &StationMonitor{Publishers: NewPublishers(), State: store}
+	require.NoError(t, monitor.RegisterPublisher("file", sink))
+
+	finished := connect.Transaction{ID: "trans1", StopAt: "2025-06-01T19:00:00Z"}
+	assert.False(t, monitor.Publishers.TransactionPublished(finished))
+	require.NoError(t, monitor.Publishers.PublishTransactionHistory("station1", finished))
+	assert.True(t, monitor.Publishers.TransactionPublished(finished), "The sink's history should be answered from the state store")
+
+	// Its other events still reach it, and it's only closed once
+	monitor.Publishers.PublishStationStatus(connect.Station{ID: "station1"})
+	require.NoError(t, monitor.Publishers.Close())
+	assert.Equal(t, []string{"trans1"}, sink.published)
+	assert.Equal(t, 1, sink.statuses)
+	assert.Equal(t, 1, sink.closed)
+}
@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+)
+
+// Timeout for each HTTP delivery attempt
+const httpTimeout = 10 * time.Second
+
+var defaultHTTPClient = &http.Client{Timeout: httpTimeout}
+
+// POST a notification to a URL. The body is JSON, either the notification as
+// {"title", "message", "event"} or rendered from a text/template given the
+// Notification. Templates can use the json function to quote values, e.g.
+//
+//	{"text": {{json .Message}}, "station": {{json .Event.StationID}}}
+type WebhookChannel struct {
+	URL      string
+	Template *template.Template
+	Headers  map[string]string
+	Client   *http.Client
+}
+
+// Parse a webhook body template, with the json function available
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(value any) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+	}).Parse(text)
+}
+
+func (w *WebhookChannel) Send(ctx context.Context, notification Notification) error {
+	var body bytes.Buffer
+	if w.Template != nil {
+		if err := w.Template.Execute(&body, notification); err != nil {
+			return fmt.Errorf("error rendering webhook template: %w", err)
+		}
+	} else {
+		err := json.NewEncoder(&body).Encode(struct {
+			Title   string               `json:"title"`
+			Message string               `json:"message"`
+			Event   monitor.StationEvent `json:"event"`
+		}{notification.Title, notification.Message, notification.Event})
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return doRequest(w.Client, req)
+}
+
+// Publish a notification to an ntfy (https://ntfy.sh) topic
+type NtfyChannel struct {
+	// Defaults to https://ntfy.sh
+	Server string
+	Topic  string
+	// Access token for protected topics
+	Token  string
+	Client *http.Client
+}
+
+func (n *NtfyChannel) Send(ctx context.Context, notification Notification) error {
+	server := n.Server
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(server, "/")+"/"+n.Topic, strings.NewReader(notification.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", notification.Title)
+	req.Header.Set("Tags", ntfyTags(notification.Event.Type))
+	if notification.Event.Type == monitor.EventErrorRaised || notification.Event.Type == monitor.EventStationOffline {
+		req.Header.Set("Priority", "high")
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	return doRequest(n.Client, req)
+}
+
+// Emoji shortcodes shown with the notification
+func ntfyTags(eventType monitor.EventType) string {
+	switch eventType {
+	case monitor.EventErrorRaised, monitor.EventStationOffline:
+		return "warning"
+	case monitor.EventSessionFinished:
+		return "white_check_mark"
+	default:
+		return "electric_plug"
+	}
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// Email a notification over SMTP. The connection is upgraded with STARTTLS
+// when the server supports it, which it must for the password to be sent.
+type SMTPChannel struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	// Sends the message, replaceable in tests
+	SendMail func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (s *SMTPChannel) Send(ctx context.Context, notification Notification) error {
+	port := s.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := s.Host + ":" + strconv.Itoa(port)
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	sendMail := s.SendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+
+	// smtp.SendMail can't be cancelled, so give up on waiting for it instead
+	done := make(chan error, 1)
+	go func() {
+		done <- sendMail(addr, auth, s.From, s.To, s.message(notification))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *SMTPChannel) message(notification Notification) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: Grizzl-E: %s\r\n", notification.Title)
+	sent := notification.Event.Time
+	if sent.IsZero() {
+		sent = time.Now()
+	}
+	fmt.Fprintf(&msg, "Date: %s\r\n", sent.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", notification.Message)
+	return msg.Bytes()
+}
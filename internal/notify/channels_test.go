@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"testing"
+
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type capturedRequest struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+func captureServer(t *testing.T, status int) (*httptest.Server, chan capturedRequest) {
+	requests := make(chan capturedRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- capturedRequest{path: r.URL.Path, header: r.Header, body: string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhookChannel(t *testing.T) {
+	server, requests := captureServer(t, http.StatusNoContent)
+	notification := NewNotification(event(monitor.EventSessionFinished, "garage"))
+
+	channel := &WebhookChannel{URL: server.URL, Headers: map[string]string{"X-Token": "secret"}}
+	require.NoError(t, channel.Send(context.Background(), notification))
+
+	req := <-requests
+	assert.Equal(t, "secret", req.header.Get("X-Token"))
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+
+	body := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(req.body), &body))
+	assert.Equal(t, "Charging finished", body["title"])
+	assert.Equal(t, "session_finished", body["event"].(map[string]any)["type"])
+}
+
+func TestWebhookChannelTemplate(t *testing.T) {
+	server, requests := captureServer(t, http.StatusOK)
+	notification := NewNotification(event(monitor.EventSessionFinished, `garage "left"`))
+
+	tmpl, err := ParseWebhookTemplate(`{"text": {{json .Message}}, "type": {{json .Event.Type}}}`)
+	require.NoError(t, err)
+
+	channel := &WebhookChannel{URL: server.URL, Template: tmpl}
+	require.NoError(t, channel.Send(context.Background(), notification))
+
+	assert.JSONEq(t, `{"text": "Station garage \"left\" connector 1 finished charging", "type": "session_finished"}`, (<-requests).body)
+}
+
+func TestWebhookChannelError(t *testing.T) {
+	server, _ := captureServer(t, http.StatusServiceUnavailable)
+
+	channel := &WebhookChannel{URL: server.URL}
+	err := channel.Send(context.Background(), NewNotification(event(monitor.EventSessionFinished, "garage")))
+	assert.ErrorContains(t, err, "503")
+}
+
+func TestNtfyChannel(t *testing.T) {
+	server, requests := captureServer(t, http.StatusOK)
+	fault := event(monitor.EventErrorRaised, "garage")
+	fault.After.ErrorCode = "GroundFailure"
+
+	channel := &NtfyChannel{Server: server.URL + "/", Topic: "my-charger", Token: "tk_secret"}
+	require.NoError(t, channel.Send(context.Background(), NewNotification(fault)))
+
+	req := <-requests
+	assert.Equal(t, "/my-charger", req.path)
+	assert.Equal(t, "Charger fault", req.header.Get("Title"))
+	assert.Equal(t, "high", req.header.Get("Priority"))
+	assert.Equal(t, "Bearer tk_secret", req.header.Get("Authorization"))
+	assert.Equal(t, "Station garage connector 1 reported GroundFailure", req.body)
+}
+
+func TestSMTPChannel(t *testing.T) {
+	var addr, from string
+	var to []string
+	var msg []byte
+
+	channel := &SMTPChannel{
+		Host:     "smtp.example.com",
+		Username: "user",
+		Password: "pass",
+		From:     "charger@example.com",
+		To:       []string{"me@example.com"},
+		SendMail: func(a string, auth smtp.Auth, f string, t []string, m []byte) error {
+			addr, from, to, msg = a, f, t, m
+			return nil
+		},
+	}
+	require.NoError(t, channel.Send(context.Background(), NewNotification(event(monitor.EventStationOffline, "garage"))))
+
+	assert.Equal(t, "smtp.example.com:587", addr)
+	assert.Equal(t, "charger@example.com", from)
+	assert.Equal(t, []string{"me@example.com"}, to)
+	assert.Contains(t, string(msg), "Subject: Grizzl-E: Charger offline\r\n")
+	assert.Contains(t, string(msg), "\r\n\r\nStation garage connector 1 has gone offline\r\n")
+}
@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The notification config file, or the notifications section of the
+// monitor's config file in YAML, e.g.
+//
+//	{
+//	  "channels": {
+//	    "phone": {"type": "ntfy", "topic": "my-charger"},
+//	    "email": {"type": "smtp", "host": "smtp.example.com", "from": "charger@example.com", "to": ["me@example.com"]}
+//	  },
+//	  "routes": [
+//	    {"events": ["session_finished"], "channels": ["phone"]},
+//	    {"events": ["error_raised", "station_offline"], "channels": ["phone", "email"]}
+//	  ],
+//	  "cooldown": "15m",
+//	  "retries": 3
+//	}
+type Config struct {
+	Channels map[string]ChannelConfig `json:"channels" yaml:"channels"`
+	Routes   []Route                  `json:"routes" yaml:"routes"`
+	Cooldown Duration                 `json:"cooldown,omitempty" yaml:"cooldown"`
+	Retries  *int                     `json:"retries,omitempty" yaml:"retries"`
+}
+
+// Settings for one channel. Which fields are used depends on the type.
+type ChannelConfig struct {
+	Type string `json:"type" yaml:"type"`
+
+	// webhook
+	URL      string            `json:"url,omitempty" yaml:"url"`
+	Template string            `json:"template,omitempty" yaml:"template"`
+	Headers  map[string]string `json:"headers,omitempty" yaml:"headers"`
+
+	// ntfy
+	Server string `json:"server,omitempty" yaml:"server"`
+	Topic  string `json:"topic,omitempty" yaml:"topic"`
+	Token  string `json:"token,omitempty" yaml:"token"`
+
+	// smtp
+	Host     string   `json:"host,omitempty" yaml:"host"`
+	Port     int      `json:"port,omitempty" yaml:"port"`
+	Username string   `json:"username,omitempty" yaml:"username"`
+	Password string   `json:"password,omitempty" yaml:"password"`
+	From     string   `json:"from,omitempty" yaml:"from"`
+	To       []string `json:"to,omitempty" yaml:"to"`
+}
+
+// Channel types
+const (
+	ChannelWebhook = "webhook"
+	ChannelNtfy    = "ntfy"
+	ChannelSMTP    = "smtp"
+)
+
+// A time.Duration written as a string, e.g. "15m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("durations must be strings like \"15m\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var text string
+	if err := value.Decode(&text); err != nil {
+		return fmt.Errorf("durations must be strings like \"15m\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load a notification config file and build a Notifier from it
+func LoadNotifier(path string) (*Notifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading notification config: %w", err)
+	}
+
+	config := Config{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing notification config %s: %w", path, err)
+	}
+
+	notifier, err := config.Notifier()
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification config %s: %w", path, err)
+	}
+	return notifier, nil
+}
+
+// Build a Notifier, checking that every channel is complete and every route
+// refers to a channel that exists
+func (c Config) Notifier() (*Notifier, error) {
+	channels := map[string]Channel{}
+	for name, channelConfig := range c.Channels {
+		channel, err := channelConfig.channel()
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", name, err)
+		}
+		channels[name] = channel
+	}
+
+	for i, route := range c.Routes {
+		if len(route.Channels) == 0 {
+			return nil, fmt.Errorf("route %d has no channels", i+1)
+		}
+		for _, name := range route.Channels {
+			if _, ok := channels[name]; !ok {
+				return nil, fmt.Errorf("route %d refers to unknown channel %s", i+1, name)
+			}
+		}
+	}
+
+	notifier := NewNotifier(channels, c.Routes)
+	if c.Cooldown != 0 {
+		notifier.Cooldown = time.Duration(c.Cooldown)
+	}
+	if c.Retries != nil {
+		notifier.Retries = *c.Retries
+	}
+
+	return notifier, nil
+}
+
+func (c ChannelConfig) channel() (Channel, error) {
+	switch c.Type {
+	case ChannelWebhook:
+		if c.URL == "" {
+			return nil, fmt.Errorf("webhook channels need a url")
+		}
+
+		channel := &WebhookChannel{URL: c.URL, Headers: c.Headers}
+		if c.Template != "" {
+			tmpl, err := ParseWebhookTemplate(c.Template)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing template: %w", err)
+			}
+			channel.Template = tmpl
+		}
+		return channel, nil
+
+	case ChannelNtfy:
+		if c.Topic == "" {
+			return nil, fmt.Errorf("ntfy channels need a topic")
+		}
+		return &NtfyChannel{Server: c.Server, Topic: c.Topic, Token: c.Token}, nil
+
+	case ChannelSMTP:
+		if c.Host == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("smtp channels need a host, from and to")
+		}
+		return &SMTPChannel{
+			Host:     c.Host,
+			Port:     c.Port,
+			Username: c.Username,
+			Password: c.Password,
+			From:     c.From,
+			To:       c.To,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown channel type %q", c.Type)
+	}
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "notify.json")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+	return path
+}
+
+func TestLoadNotifier(t *testing.T) {
+	path := writeConfig(t, `{
+		"channels": {
+			"phone": {"type": "ntfy", "topic": "my-charger"},
+			"hook": {"type": "webhook", "url": "https://example.com/hook", "template": "{\"text\": {{json .Message}}}"},
+			"email": {"type": "smtp", "host": "smtp.example.com", "from": "charger@example.com", "to": ["me@example.com"]}
+		},
+		"routes": [
+			{"events": ["session_finished"], "channels": ["phone", "hook"]},
+			{"events": ["error_raised"], "stations": ["garage"], "channels": ["email"]}
+		],
+		"cooldown": "5m",
+		"retries": 0
+	}`)
+
+	notifier, err := LoadNotifier(path)
+	require.NoError(t, err)
+
+	assert.Len(t, notifier.Channels, 3)
+	assert.IsType(t, &NtfyChannel{}, notifier.Channels["phone"])
+	assert.NotNil(t, notifier.Channels["hook"].(*WebhookChannel).Template)
+	assert.Len(t, notifier.Routes, 2)
+	assert.Equal(t, 5*time.Minute, notifier.Cooldown)
+	assert.Equal(t, 0, notifier.Retries)
+}
+
+func TestLoadNotifierInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown type":    `{"channels": {"phone": {"type": "pager"}}}`,
+		"missing topic":   `{"channels": {"phone": {"type": "ntfy"}}}`,
+		"bad template":    `{"channels": {"hook": {"type": "webhook", "url": "https://example.com", "template": "{{.Nope"}}}`,
+		"unknown channel": `{"channels": {"phone": {"type": "ntfy", "topic": "t"}}, "routes": [{"channels": ["email"]}]}`,
+		"bad cooldown":    `{"cooldown": 15}`,
+	}
+
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadNotifier(writeConfig(t, config))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadNotifier(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
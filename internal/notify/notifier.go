@@ -0,0 +1,323 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+)
+
+/**
+* Notifications of station events, e.g. a charging session finishing or a
+* charger faulting, sent to people over channels such as ntfy or email.
+*
+* The Notifier subscribes to the monitor's event bus. Each event is matched
+* against the routing rules to pick the channels it's sent to, with repeats
+* of the same event held back by a cooldown so a flapping charger doesn't
+* flood anyone's phone. Failed deliveries are retried with backoff.
+ */
+
+// Defaults for the Notifier's rate limit and retries
+const (
+	DefaultCooldown     = 15 * time.Minute
+	DefaultRetries      = 3
+	DefaultRetryBackoff = 5 * time.Second
+)
+
+// A notification about a station event, ready to be sent
+type Notification struct {
+	Title   string
+	Message string
+	Event   monitor.StationEvent
+}
+
+// Somewhere notifications can be sent
+type Channel interface {
+	Send(ctx context.Context, notification Notification) error
+}
+
+// Which events are sent to which channels. Empty Events or Stations match
+// every event or station.
+type Route struct {
+	Events   []monitor.EventType `json:"events,omitempty" yaml:"events"`
+	Stations []string            `json:"stations,omitempty" yaml:"stations"`
+	Channels []string            `json:"channels" yaml:"channels"`
+}
+
+func (r Route) matches(event monitor.StationEvent) bool {
+	return (len(r.Events) == 0 || slices.Contains(r.Events, event.Type)) &&
+		(len(r.Stations) == 0 || slices.Contains(r.Stations, event.StationID))
+}
+
+type Notifier struct {
+	Channels map[string]Channel
+	Routes   []Route
+
+	// The shortest time between notifications of the same event type, for
+	// the same station and connector, on a channel
+	Cooldown time.Duration
+	// How many times to retry a failed delivery, waiting RetryBackoff before
+	// the first retry and doubling the wait each time after
+	Retries      int
+	RetryBackoff time.Duration
+
+	// Where the times notifications were sent are kept across restarts, so
+	// a restart doesn't reset the cooldown. Optional.
+	State monitor.StateStore
+
+	// Current time, replaceable in tests
+	Now func() time.Time
+
+	mu       sync.Mutex
+	lastSent map[cooldownKey]time.Time
+
+	deliveries sync.WaitGroup
+}
+
+type cooldownKey struct {
+	channel     string
+	eventType   monitor.EventType
+	stationId   string
+	connectorId int
+}
+
+func NewNotifier(channels map[string]Channel, routes []Route) *Notifier {
+	return &Notifier{
+		Channels:     channels,
+		Routes:       routes,
+		Cooldown:     DefaultCooldown,
+		Retries:      DefaultRetries,
+		RetryBackoff: DefaultRetryBackoff,
+		Now:          time.Now,
+	}
+}
+
+// Send notifications for events until the channel is closed, then wait for
+// deliveries in progress to finish. Cancelling the context stops retries.
+func (n *Notifier) Run(ctx context.Context, events <-chan monitor.StationEvent) {
+	for event := range events {
+		n.Notify(ctx, event)
+	}
+
+	n.deliveries.Wait()
+}
+
+// Replace the channels, routes, cooldown and retries with another
+// notifier's, e.g. one loaded from a changed config. When notifications were
+// last sent is kept, so the cooldown carries on.
+func (n *Notifier) Reconfigure(from *Notifier) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.Channels = from.Channels
+	n.Routes = from.Routes
+	n.Cooldown = from.Cooldown
+	n.Retries = from.Retries
+	n.RetryBackoff = from.RetryBackoff
+}
+
+// Send an event to each channel its routes pick, in the background
+func (n *Notifier) Notify(ctx context.Context, event monitor.StationEvent) {
+	notification := NewNotification(event)
+
+	n.mu.Lock()
+	channels, routes, cooldown, retries, backoff := n.Channels, n.Routes, n.Cooldown, n.Retries, n.RetryBackoff
+	n.mu.Unlock()
+
+	for _, name := range channelsFor(routes, event) {
+		channel, ok := channels[name]
+		if !ok {
+			log.Printf("Notification route refers to unknown channel %s", name)
+			continue
+		}
+
+		claim, ok := n.allow(name, event)
+		if !ok {
+			log.Printf("Not sending %s to %s, it was sent less than %s ago", event, name, cooldown)
+			continue
+		}
+
+		n.deliveries.Add(1)
+		go func() {
+			defer n.deliveries.Done()
+
+			if err := n.deliver(ctx, channel, notification, retries, backoff); err != nil {
+				log.Printf("Error sending %s to %s: %v", event, name, err)
+				n.release(claim)
+				return
+			}
+			n.sent(claim)
+		}()
+	}
+}
+
+// The channels an event is routed to, each only once
+func channelsFor(routes []Route, event monitor.StationEvent) []string {
+	var channels []string
+	for _, route := range routes {
+		if !route.matches(event) {
+			continue
+		}
+		for _, channel := range route.Channels {
+			if !slices.Contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
+}
+
+// A notification's hold on its cooldown while it's being delivered, so the
+// same event isn't sent again in the meantime
+type cooldownClaim struct {
+	key cooldownKey
+	at  time.Time
+	// When it was last sent before, if it ever was
+	previous    time.Time
+	hadPrevious bool
+}
+
+// Check whether a notification can be sent without breaking the cooldown,
+// claiming the cooldown if so. Once it's delivered the claim is saved with
+// sent, or if it can't be, given up with release.
+func (n *Notifier) allow(channel string, event monitor.StationEvent) (cooldownClaim, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.lastSent == nil {
+		n.lastSent = map[cooldownKey]time.Time{}
+	}
+
+	key := cooldownKey{channel: channel, eventType: event.Type, stationId: event.StationID, connectorId: event.ConnectorID}
+	now := n.now()
+
+	last, ok := n.lastSent[key]
+	if !ok && n.State != nil {
+		var err error
+		last, ok, err = n.State.LastNotified(key.stationId, key.stateKey())
+		if err != nil {
+			log.Printf("Error loading when %s was last sent to %s: %v", event, channel, err)
+		}
+	}
+	if ok && now.Sub(last) < n.Cooldown {
+		return cooldownClaim{}, false
+	}
+
+	n.lastSent[key] = now
+	return cooldownClaim{key: key, at: now, previous: last, hadPrevious: ok}, true
+}
+
+// Record a delivered notification as sent, in the state store too
+func (n *Notifier) sent(claim cooldownClaim) {
+	if n.State == nil {
+		return
+	}
+	if err := n.State.SaveNotified(claim.key.stationId, claim.key.stateKey(), claim.at); err != nil {
+		log.Printf("Error saving when %s was sent to %s: %v", claim.key.eventType, claim.key.channel, err)
+	}
+}
+
+// Give up the claim of a notification that couldn't be delivered, so the
+// cooldown is back to when it was last sent, unless it's been claimed again
+func (n *Notifier) release(claim cooldownClaim) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.lastSent[claim.key].Equal(claim.at) {
+		return
+	}
+	if claim.hadPrevious {
+		n.lastSent[claim.key] = claim.previous
+	} else {
+		delete(n.lastSent, claim.key)
+	}
+}
+
+// The key of the cooldown in the station's state
+func (k cooldownKey) stateKey() string {
+	return fmt.Sprintf("%s/%s/%d", k.channel, k.eventType, k.connectorId)
+}
+
+func (n *Notifier) now() time.Time {
+	if n.Now == nil {
+		return time.Now()
+	}
+	return n.Now()
+}
+
+// Send a notification, retrying with backoff if it fails
+func (n *Notifier) deliver(ctx context.Context, channel Channel, notification Notification, retries int, backoff time.Duration) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = channel.Send(ctx, notification)
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		log.Printf("Error sending %s, retrying in %s: %v", notification.Event, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("gave up retrying: %w", err)
+		}
+		backoff *= 2
+	}
+}
+
+// Describe an event for people
+func NewNotification(event monitor.StationEvent) Notification {
+	station := event.StationID
+	if event.StationName != "" {
+		station = fmt.Sprintf("%s (%s)", event.StationName, event.StationID)
+	}
+
+	where := "Station " + station
+	if event.ConnectorID != 0 {
+		where = fmt.Sprintf("Station %s connector %d", station, event.ConnectorID)
+	}
+
+	notification := Notification{Event: event}
+
+	switch event.Type {
+	case monitor.EventVehiclePluggedIn:
+		notification.Title = "Vehicle plugged in"
+		notification.Message = where + " has a vehicle plugged in"
+	case monitor.EventVehicleUnplugged:
+		notification.Title = "Vehicle unplugged"
+		notification.Message = where + " no longer has a vehicle plugged in"
+	case monitor.EventChargingStarted:
+		notification.Title = "Charging started"
+		notification.Message = where + " started charging"
+	case monitor.EventChargingSuspended:
+		notification.Title = "Charging suspended"
+		if event.After.Status == "SuspendedEV" {
+			notification.Message = where + " stopped charging at the vehicle's request"
+		} else {
+			notification.Message = where + " stopped charging at the station's request"
+		}
+	case monitor.EventSessionFinished:
+		notification.Title = "Charging finished"
+		notification.Message = where + " finished charging"
+	case monitor.EventStationOffline:
+		notification.Title = "Charger offline"
+		notification.Message = where + " has gone offline"
+	case monitor.EventStationOnline:
+		notification.Title = "Charger online"
+		notification.Message = where + " is back online"
+	case monitor.EventErrorRaised:
+		notification.Title = "Charger fault"
+		notification.Message = fmt.Sprintf("%s reported %s", where, event.After.ErrorCode)
+	case monitor.EventErrorCleared:
+		notification.Title = "Charger fault cleared"
+		notification.Message = fmt.Sprintf("%s no longer reports %s", where, event.Before.ErrorCode)
+	default:
+		notification.Title = string(event.Type)
+		notification.Message = event.String()
+	}
+
+	return notification
+}
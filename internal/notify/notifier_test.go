@@ -0,0 +1,210 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A channel recording what it's sent, failing the first few sends
+type recordingChannel struct {
+	mu    sync.Mutex
+	fail  int
+	tries int
+	sent  []Notification
+}
+
+func (r *recordingChannel) Send(ctx context.Context, notification Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tries++
+	if r.tries <= r.fail {
+		return fmt.Errorf("unavailable")
+	}
+	r.sent = append(r.sent, notification)
+	return nil
+}
+
+func (r *recordingChannel) Sent() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Notification(nil), r.sent...)
+}
+
+func event(eventType monitor.EventType, stationId string) monitor.StationEvent {
+	return monitor.StationEvent{Type: eventType, StationID: stationId, ConnectorID: 1, Time: time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)}
+}
+
+// Run events through a notifier, waiting for everything to be delivered
+func notifyAll(notifier *Notifier, events ...monitor.StationEvent) {
+	ch := make(chan monitor.StationEvent, len(events))
+	for _, event := range events {
+		ch <- event
+	}
+	close(ch)
+
+	notifier.Run(context.Background(), ch)
+}
+
+func TestNotifierRouting(t *testing.T) {
+	phone := &recordingChannel{}
+	email := &recordingChannel{}
+
+	notifier := NewNotifier(map[string]Channel{"phone": phone, "email": email}, []Route{
+		{Events: []monitor.EventType{monitor.EventSessionFinished}, Channels: []string{"phone"}},
+		{Events: []monitor.EventType{monitor.EventErrorRaised}, Stations: []string{"garage"}, Channels: []string{"phone", "email"}},
+		{Events: []monitor.EventType{monitor.EventErrorRaised}, Channels: []string{"phone"}},
+	})
+
+	notifyAll(notifier,
+		event(monitor.EventSessionFinished, "garage"),
+		event(monitor.EventChargingStarted, "garage"),
+		event(monitor.EventErrorRaised, "garage"),
+		event(monitor.EventErrorRaised, "driveway"),
+	)
+
+	assert.Len(t, phone.Sent(), 3, "Phone should get each routed event once")
+	require.Len(t, email.Sent(), 1)
+	assert.Equal(t, "garage", email.Sent()[0].Event.StationID)
+}
+
+func TestNotifierCooldown(t *testing.T) {
+	phone := &recordingChannel{}
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	notifier := NewNotifier(map[string]Channel{"phone": phone}, []Route{{Channels: []string{"phone"}}})
+	notifier.Now = func() time.Time { return now }
+
+	notifyAll(notifier,
+		event(monitor.EventStationOffline, "garage"),
+		event(monitor.EventStationOffline, "garage"),
+		event(monitor.EventStationOffline, "driveway"),
+	)
+	assert.Len(t, phone.Sent(), 2, "Repeats within the cooldown should be held back")
+
+	now = now.Add(DefaultCooldown)
+	notifyAll(notifier, event(monitor.EventStationOffline, "garage"))
+	assert.Len(t, phone.Sent(), 3)
+}
+
+func TestNotifierRetries(t *testing.T) {
+	flaky := &recordingChannel{fail: 2}
+	down := &recordingChannel{fail: 100}
+
+	notifier := NewNotifier(map[string]Channel{"flaky": flaky, "down": down}, []Route{{Channels: []string{"flaky", "down"}}})
+	notifier.RetryBackoff = time.Millisecond
+
+	notifyAll(notifier, event(monitor.EventSessionFinished, "garage"))
+
+	assert.Len(t, flaky.Sent(), 1, "Delivery should succeed on a retry")
+	assert.Equal(t, 3, flaky.tries)
+	assert.Empty(t, down.Sent())
+	assert.Equal(t, DefaultRetries+1, down.tries, "Delivery should give up after the retries")
+}
+
+func TestNotifierFailedDeliveryKeepsNoCooldown(t *testing.T) {
+	store, err := monitor.OpenBoltStateStore(filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	down := &recordingChannel{fail: DefaultRetries + 1}
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	notifier := NewNotifier(map[string]Channel{"down": down}, []Route{{Channels: []string{"down"}}})
+	notifier.Now = func() time.Time { return now }
+	notifier.RetryBackoff = time.Millisecond
+	notifier.State = store
+
+	notifyAll(notifier, event(monitor.EventStationOffline, "garage"))
+	require.Empty(t, down.Sent())
+
+	_, saved, err := store.LastNotified("garage", "down/station_offline/1")
+	require.NoError(t, err)
+	assert.False(t, saved, "A notification that wasn't delivered shouldn't be saved as sent")
+
+	// So the next one isn't held back
+	notifyAll(notifier, event(monitor.EventStationOffline, "garage"))
+	assert.Len(t, down.Sent(), 1)
+
+	_, saved, err = store.LastNotified("garage", "down/station_offline/1")
+	require.NoError(t, err)
+	assert.True(t, saved)
+}
+
+func TestNotifierReconfigure(t *testing.T) {
+	phone := &recordingChannel{}
+	email := &recordingChannel{}
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	notifier := NewNotifier(map[string]Channel{"phone": phone}, []Route{{Channels: []string{"phone"}}})
+	notifier.Now = func() time.Time { return now }
+	notifyAll(notifier, event(monitor.EventStationOffline, "garage"))
+
+	notifier.Reconfigure(NewNotifier(map[string]Channel{"phone": phone, "email": email}, []Route{{Channels: []string{"phone", "email"}}}))
+	notifyAll(notifier, event(monitor.EventStationOffline, "garage"))
+
+	assert.Len(t, phone.Sent(), 1, "The cooldown should carry on across a reload")
+	assert.Len(t, email.Sent(), 1, "Channels added by the reload should be used")
+
+	// The retry backoff is reloaded too
+	flaky := &recordingChannel{fail: 1}
+	reloaded := NewNotifier(map[string]Channel{"flaky": flaky}, []Route{{Channels: []string{"flaky"}}})
+	reloaded.RetryBackoff = time.Millisecond
+	notifier.Reconfigure(reloaded)
+
+	start := time.Now()
+	notifyAll(notifier, event(monitor.EventSessionFinished, "garage"))
+	assert.Len(t, flaky.Sent(), 1)
+	assert.Less(t, time.Since(start), DefaultRetryBackoff)
+}
+
+func TestNewNotification(t *testing.T) {
+	fault := event(monitor.EventErrorRaised, "garage")
+	fault.After.ErrorCode = "GroundFailure"
+
+	notification := NewNotification(fault)
+	assert.Equal(t, "Charger fault", notification.Title)
+	assert.Equal(t, "Station garage connector 1 reported GroundFailure", notification.Message)
+
+	offline := event(monitor.EventStationOffline, "garage")
+	offline.ConnectorID = 0
+	assert.Equal(t, "Station garage has gone offline", NewNotification(offline).Message)
+
+	named := event(monitor.EventSessionFinished, "CP001")
+	named.StationName = "Garage"
+	assert.Equal(t, "Station Garage (CP001) connector 1 finished charging", NewNotification(named).Message)
+
+	suspended := event(monitor.EventChargingSuspended, "garage")
+	suspended.After.Status = "SuspendedEV"
+	assert.Contains(t, NewNotification(suspended).Message, "vehicle's request")
+}
+
+func TestNotifierCooldownAcrossRestart(t *testing.T) {
+	store, err := monitor.OpenBoltStateStore(filepath.Join(t.TempDir(), "state.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	phone := &recordingChannel{}
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	notifier := NewNotifier(map[string]Channel{"phone": phone}, []Route{{Channels: []string{"phone"}}})
+	notifier.Now = func() time.Time { return now }
+	notifier.State = store
+	notifyAll(notifier, event(monitor.EventStationOffline, "garage"))
+
+	// A new notifier, as after a restart, should remember the cooldown
+	restarted := NewNotifier(notifier.Channels, notifier.Routes)
+	restarted.Now = notifier.Now
+	restarted.State = store
+	notifyAll(restarted, event(monitor.EventStationOffline, "garage"), event(monitor.EventStationOffline, "driveway"))
+
+	assert.Len(t, phone.Sent(), 2)
+}
@@ -4,12 +4,14 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/speshak/grizzl-e-monitor/pkg/ocpp"
 )
 
 // Default address to listen on for Prometheus metrics
@@ -29,6 +31,20 @@ type PrometheusPublisher struct {
 	EnergyCost     *prometheus.GaugeVec
 	AvaliablePower *prometheus.GaugeVec
 	MaxPower       *prometheus.GaugeVec
+
+	OfferedCurrentLimit *prometheus.GaugeVec
+	Authorizations      *prometheus.CounterVec
+
+	// The session in progress on each connector, from its latest meter
+	// values
+	SessionPower          *prometheus.GaugeVec
+	SessionCurrentImport  *prometheus.GaugeVec
+	SessionCurrentOffered *prometheus.GaugeVec
+	SessionVoltage        *prometheus.GaugeVec
+	SessionTemperature    *prometheus.GaugeVec
+	SessionSoC            *prometheus.GaugeVec
+	SessionEnergy         *prometheus.GaugeVec
+	SessionElapsed        *prometheus.GaugeVec
 }
 
 func NewPrometheusPublisher() *PrometheusPublisher {
@@ -97,6 +113,66 @@ func NewPrometheusPublisher() *PrometheusPublisher {
 			Name:      "max_power_kw",
 			//TODO: Add help text when we know what this is
 		}, connectorLabels),
+		OfferedCurrentLimit: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "station",
+			Name:      "offered_current_limit_amps",
+			Help:      "The current limit set on the connector by OCPP charging profiles",
+		}, connectorLabels),
+		Authorizations: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "station",
+			Name:      "authorizations_total",
+			Help:      "RFID tags presented to the station over OCPP, by authorization status",
+		}, []string{"station_id", "status"}),
+		SessionPower: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "power_watts",
+			Help:      "The power being delivered by the connector's session in progress",
+		}, connectorLabels),
+		SessionCurrentImport: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "current_import_amps",
+			Help:      "The current drawn by the vehicle in the connector's session in progress",
+		}, connectorLabels),
+		SessionCurrentOffered: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "current_offered_amps",
+			Help:      "The current offered to the vehicle in the connector's session in progress",
+		}, connectorLabels),
+		SessionVoltage: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "voltage_volts",
+			Help:      "The supply voltage during the connector's session in progress",
+		}, connectorLabels),
+		SessionTemperature: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "temperature_celsius",
+			Help:      "The station's temperature during the connector's session in progress",
+		}, connectorLabels),
+		SessionSoC: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "soc_percent",
+			Help:      "The vehicle's state of charge, if it reports one, in the connector's session in progress",
+		}, connectorLabels),
+		SessionEnergy: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "energy_wh",
+			Help:      "The energy delivered so far in the connector's session in progress",
+		}, connectorLabels),
+		SessionElapsed: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "session",
+			Name:      "elapsed_seconds",
+			Help:      "How long the connector's session in progress has been going, as of its latest meter values",
+		}, connectorLabels),
 	}
 
 	go func() {
@@ -115,6 +191,12 @@ func (p *PrometheusPublisher) PublishStationStatus(station connect.Station) {
 		labels := prometheus.Labels{"station_id": station.ID, "connector": strconv.Itoa(connector.ID)}
 		p.AvaliablePower.With(labels).Set(connector.Power)
 		p.MaxPower.With(labels).Set(connector.MaxPower)
+
+		// Nothing's plugged in, so any session has ended, even if it was
+		// never seen to finish
+		if connector.Status == "Available" {
+			p.clearSession(labels)
+		}
 	}
 }
 
@@ -131,6 +213,130 @@ func (p *PrometheusPublisher) PublishTransactionStats(stationId string, stats co
 	p.TopSession.With(labels).Set(float64(stats.TopSession))
 }
 
+func (p *PrometheusPublisher) PublishChargingLimit(stationId string, connectorId int, limitAmps float64) {
+	p.OfferedCurrentLimit.With(prometheus.Labels{"station_id": stationId, "connector": strconv.Itoa(connectorId)}).Set(limitAmps)
+}
+
+// Drop the limit series once no profile limits the connector, rather than
+// leaving the last limit behind
+func (p *PrometheusPublisher) ClearChargingLimit(stationId string, connectorId int) {
+	p.OfferedCurrentLimit.Delete(prometheus.Labels{"station_id": stationId, "connector": strconv.Itoa(connectorId)})
+}
+
+// Set the connector's session gauges from the latest of the session's meter
+// values, clearing them once it's finished
+func (p *PrometheusPublisher) PublishSessionProgress(stationId string, transaction connect.Transaction) error {
+	labels := prometheus.Labels{"station_id": stationId, "connector": strconv.Itoa(transaction.ConnectorId)}
+	if transaction.StopAt != "" {
+		p.clearSession(labels)
+		return nil
+	}
+
+	mv := transaction.MeterValues
+	last := len(mv.Date) - 1
+	if last < 0 {
+		return nil
+	}
+
+	setLatest(p.SessionPower, labels, mv.PowerActiveImport, last)
+	setLatest(p.SessionCurrentImport, labels, mv.CurrentImport, last)
+	setLatest(p.SessionCurrentOffered, labels, mv.CurrentOffered, last)
+	setLatest(p.SessionVoltage, labels, mv.Voltage, last)
+	setLatest(p.SessionTemperature, labels, mv.Temperature, last)
+	setLatest(p.SessionSoC, labels, mv.SoC, last)
+
+	// The register is usually the meter's reading, which was MeterStart when
+	// the session started
+	if energy, ok := latest(mv.EnergyActiveImportRegister, last); ok {
+		if start := float64(transaction.MeterStart); energy >= start {
+			energy -= start
+		}
+		p.SessionEnergy.With(labels).Set(energy)
+	} else {
+		p.SessionEnergy.Delete(labels)
+	}
+
+	if startAt, err := time.Parse(time.RFC3339, transaction.StartAt); err == nil {
+		p.SessionElapsed.With(labels).Set(mv.Date[last].Sub(startAt).Seconds())
+	}
+
+	return nil
+}
+
+// A meter value array's value at an index, if it has one. A measurand the
+// charger hasn't reported has a short array, without every sample.
+func latest[T int | float64](values []T, i int) (float64, bool) {
+	if i >= len(values) {
+		return 0, false
+	}
+	return float64(values[i]), true
+}
+
+// Set a gauge to a meter value array's latest value, or drop its series if
+// the charger didn't report one, rather than showing a made up 0
+func setLatest[T int | float64](gauge *prometheus.GaugeVec, labels prometheus.Labels, values []T, i int) {
+	if v, ok := latest(values, i); ok {
+		gauge.With(labels).Set(v)
+	} else {
+		gauge.Delete(labels)
+	}
+}
+
+func (p *PrometheusPublisher) sessionGauges() []*prometheus.GaugeVec {
+	return []*prometheus.GaugeVec{
+		p.SessionPower,
+		p.SessionCurrentImport,
+		p.SessionCurrentOffered,
+		p.SessionVoltage,
+		p.SessionTemperature,
+		p.SessionSoC,
+		p.SessionEnergy,
+		p.SessionElapsed,
+	}
+}
+
+// Drop a connector's session series, so a finished session doesn't keep
+// reporting its last values
+func (p *PrometheusPublisher) clearSession(labels prometheus.Labels) {
+	for _, gauge := range p.sessionGauges() {
+		if gauge != nil {
+			gauge.Delete(labels)
+		}
+	}
+}
+
+func (p *PrometheusPublisher) PublishAuthorization(event ocpp.AuthorizationEvent) {
+	p.Authorizations.With(prometheus.Labels{"station_id": event.ChargePointId, "status": event.Status}).Inc()
+}
+
+// Drop every series for a station that's no longer monitored, so it doesn't
+// keep reporting its last values
+func (p *PrometheusPublisher) RemoveStation(stationId string) {
+	labels := prometheus.Labels{"station_id": stationId}
+
+	gauges := []*prometheus.GaugeVec{
+		p.StationSessions,
+		p.TotalEnergy,
+		p.TotalDuration,
+		p.TopSession,
+		p.AveEnergy,
+		p.EnergyCost,
+		p.AvaliablePower,
+		p.MaxPower,
+		p.OfferedCurrentLimit,
+	}
+	gauges = append(gauges, p.sessionGauges()...)
+	for _, gauge := range gauges {
+		if gauge != nil {
+			gauge.DeletePartialMatch(labels)
+		}
+	}
+
+	if p.Authorizations != nil {
+		p.Authorizations.DeletePartialMatch(labels)
+	}
+}
+
 func (p *PrometheusPublisher) Close() error {
 	// Nothing to close for Prometheus
 	return nil
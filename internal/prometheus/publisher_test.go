@@ -2,10 +2,12 @@ package prometheus
 
 import (
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/speshak/grizzl-e-monitor/pkg/ocpp"
 )
 
 type MockPrometheusPublisher struct {
@@ -55,3 +57,149 @@ func TestPublishTransactionHistory(t *testing.T) {
 		t.Fatalf("Expected %v, got %v", expected, actual)
 	}
 }
+
+func TestPublishChargingLimit(t *testing.T) {
+	publisher := &PrometheusPublisher{
+		OfferedCurrentLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "offered_current_limit_amps",
+		}, []string{"station_id", "connector"}),
+	}
+
+	publisher.PublishChargingLimit("station-1", 1, 16)
+
+	actual := testutil.ToFloat64(publisher.OfferedCurrentLimit.WithLabelValues("station-1", "1"))
+	if actual != 16 {
+		t.Fatalf("Expected 16, got %v", actual)
+	}
+
+	publisher.ClearChargingLimit("station-1", 1)
+
+	if count := testutil.CollectAndCount(publisher.OfferedCurrentLimit); count != 0 {
+		t.Fatalf("Expected the limit to be removed, got %d series", count)
+	}
+}
+
+func TestPublishAuthorization(t *testing.T) {
+	publisher := &PrometheusPublisher{
+		Authorizations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "authorizations_total",
+		}, []string{"station_id", "status"}),
+	}
+
+	publisher.PublishAuthorization(ocpp.AuthorizationEvent{ChargePointId: "station-1", IdTag: "TAG1", Status: ocpp.AuthorizationAccepted})
+	publisher.PublishAuthorization(ocpp.AuthorizationEvent{ChargePointId: "station-1", IdTag: "TAG2", Status: ocpp.AuthorizationInvalid})
+	publisher.PublishAuthorization(ocpp.AuthorizationEvent{ChargePointId: "station-1", IdTag: "TAG3", Status: ocpp.AuthorizationInvalid})
+
+	invalid := testutil.ToFloat64(publisher.Authorizations.WithLabelValues("station-1", ocpp.AuthorizationInvalid))
+	if invalid != 2 {
+		t.Fatalf("Expected 2 invalid authorizations, got %v", invalid)
+	}
+}
+
+func TestRemoveStation(t *testing.T) {
+	publisher := &PrometheusPublisher{
+		StationSessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sessions_total",
+		}, []string{"station_id"}),
+		MaxPower: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "max_power_kw",
+		}, []string{"station_id", "connector"}),
+	}
+
+	publisher.StationSessions.WithLabelValues("station-1").Set(10)
+	publisher.StationSessions.WithLabelValues("station-2").Set(5)
+	publisher.MaxPower.WithLabelValues("station-1", "1").Set(9.6)
+	publisher.MaxPower.WithLabelValues("station-1", "2").Set(9.6)
+
+	publisher.RemoveStation("station-1")
+
+	if count := testutil.CollectAndCount(publisher.StationSessions); count != 1 {
+		t.Fatalf("Expected only station-2 sessions to remain, got %d series", count)
+	}
+	if count := testutil.CollectAndCount(publisher.MaxPower); count != 0 {
+		t.Fatalf("Expected every connector of station-1 to be removed, got %d series", count)
+	}
+}
+
+func TestPublishSessionProgress(t *testing.T) {
+	newGauge := func(name string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name}, []string{"station_id", "connector"})
+	}
+	publisher := &PrometheusPublisher{
+		SessionPower:          newGauge("power_watts"),
+		SessionCurrentImport:  newGauge("current_import_amps"),
+		SessionCurrentOffered: newGauge("current_offered_amps"),
+		SessionVoltage:        newGauge("voltage_volts"),
+		SessionTemperature:    newGauge("temperature_celsius"),
+		SessionSoC:            newGauge("soc_percent"),
+		SessionEnergy:         newGauge("energy_wh"),
+		SessionElapsed:        newGauge("elapsed_seconds"),
+	}
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	transaction := connect.Transaction{
+		ID:          "trans1",
+		ConnectorId: 1,
+		StartAt:     start.Format(time.RFC3339),
+		MeterStart:  10000,
+		MeterValues: connect.MeterValues{
+			Date:                       []time.Time{start.Add(10 * time.Minute), start.Add(11 * time.Minute)},
+			CurrentImport:              []float64{31.5, 32},
+			CurrentOffered:             []float64{40, 40},
+			EnergyActiveImportRegister: []int{11200, 11320},
+			PowerActiveImport:          []float64{7560, 7680},
+			SoC:                        []int{54, 55},
+			Temperature:                []float64{31, 32},
+			Voltage:                    []int{240, 240},
+		},
+	}
+
+	if err := publisher.PublishSessionProgress("station-1", transaction); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for gauge, expected := range map[*prometheus.GaugeVec]float64{
+		publisher.SessionPower:          7680,
+		publisher.SessionCurrentImport:  32,
+		publisher.SessionCurrentOffered: 40,
+		publisher.SessionVoltage:        240,
+		publisher.SessionTemperature:    32,
+		publisher.SessionSoC:            55,
+		publisher.SessionEnergy:         1320,
+		publisher.SessionElapsed:        660,
+	} {
+		if actual := testutil.ToFloat64(gauge.WithLabelValues("station-1", "1")); actual != expected {
+			t.Fatalf("Expected %v from the latest meter values, got %v", expected, actual)
+		}
+	}
+
+	// A measurand the charger didn't report in the latest sample drops its
+	// series, rather than reading 0
+	transaction.MeterValues.Date = append(transaction.MeterValues.Date, start.Add(12*time.Minute))
+	transaction.MeterValues.PowerActiveImport = append(transaction.MeterValues.PowerActiveImport, 7700)
+	if err := publisher.PublishSessionProgress("station-1", transaction); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if actual := testutil.ToFloat64(publisher.SessionPower.WithLabelValues("station-1", "1")); actual != 7700 {
+		t.Fatalf("Expected the latest power, got %v", actual)
+	}
+	for _, gauge := range []*prometheus.GaugeVec{publisher.SessionSoC, publisher.SessionTemperature, publisher.SessionEnergy} {
+		if count := testutil.CollectAndCount(gauge); count != 0 {
+			t.Fatalf("Expected no series for an unreported measurand, got %d", count)
+		}
+	}
+
+	// Finishing the session clears its gauges
+	transaction.StopAt = start.Add(time.Hour).Format(time.RFC3339)
+	transaction.MeterValues = connect.MeterValues{}
+	if err := publisher.PublishSessionProgress("station-1", transaction); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, gauge := range publisher.sessionGauges() {
+		if count := testutil.CollectAndCount(gauge); count != 0 {
+			t.Fatalf("Expected the session's gauges to be cleared, got %d series", count)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+* Credentials, e.g. the Connect password and the TimescaleDB URL, kept
+* somewhere other than a plain environment variable: a file mounted from a
+* Docker or Kubernetes secret, or the output of a command such as a password
+* manager's CLI. Secrets are read each time they're needed, so a rotated one
+* is picked up without a restart.
+*
+* Printing a secret describes where it's kept, never its value, so one can't
+* end up in a log by accident.
+ */
+
+// A credential, read from wherever it's kept
+type Secret interface {
+	Value(ctx context.Context) (string, error)
+	// Where the secret is kept
+	String() string
+}
+
+// Default time a command's output is used for before it's run again
+const DefaultCommandTTL = time.Minute
+
+// Find how a secret is provided by environment variables: NAME_FILE names a
+// file holding it, NAME_COMMAND a command that prints it, or NAME holds it.
+// Returns nil if none of them are set.
+func FromEnv(name string) Secret {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		return &File{Path: path}
+	}
+	if command := os.Getenv(name + "_COMMAND"); command != "" {
+		return &Command{Command: command}
+	}
+	if os.Getenv(name) != "" {
+		return Env{Name: name}
+	}
+	return nil
+}
+
+// Check a secret can be read, e.g. at startup, so a missing file or broken
+// command is found straight away rather than on first use
+func Check(ctx context.Context, secret Secret) error {
+	_, err := secret.Value(ctx)
+	return err
+}
+
+// A secret held in an environment variable
+type Env struct {
+	Name string
+}
+
+func (e Env) Value(context.Context) (string, error) {
+	value := os.Getenv(e.Name)
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", e)
+	}
+	return value, nil
+}
+
+func (e Env) String() string {
+	return "environment variable " + e.Name
+}
+
+// A secret given directly, e.g. in the config file
+type Literal struct {
+	value string
+}
+
+func NewLiteral(value string) Literal {
+	return Literal{value: value}
+}
+
+func (l Literal) Value(context.Context) (string, error) {
+	return l.value, nil
+}
+
+func (l Literal) String() string {
+	return "literal value"
+}
+
+func (l Literal) GoString() string {
+	return "secrets.Literal{[redacted]}"
+}
+
+// A secret held in a file, e.g. a mounted Docker or Kubernetes secret. The
+// file is read again whenever it changes, and a trailing newline is dropped.
+type File struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+func (f *File) Value(context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Mounted secrets are replaced by swapping a symlink, which Stat follows
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", f, err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size && f.value != "" {
+		return f.value, nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", f, err)
+	}
+
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", f)
+	}
+
+	f.modTime, f.size, f.value = info.ModTime(), info.Size(), value
+	return value, nil
+}
+
+func (f *File) String() string {
+	return "file " + f.Path
+}
+
+func (f *File) GoString() string {
+	return fmt.Sprintf("&secrets.File{Path: %q}", f.Path)
+}
+
+// A secret printed by a shell command, e.g. a password manager's CLI. The
+// output is used for TTL before the command is run again.
+type Command struct {
+	Command string
+	TTL     time.Duration
+
+	// Current time, replaceable in tests
+	Now func() time.Time
+
+	mu      sync.Mutex
+	fetched time.Time
+	value   string
+}
+
+func (c *Command) Value(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.value != "" && now.Sub(c.fetched) < c.ttl() {
+		return c.value, nil
+	}
+
+	// Only the exit status is reported, as what the command wrote may
+	// include the secret
+	output, err := exec.CommandContext(ctx, "sh", "-c", c.Command).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("error running %s: %s", c, exitErr.ProcessState)
+		}
+		return "", fmt.Errorf("error running %s: %w", c, err)
+	}
+
+	value := strings.TrimRight(string(output), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("%s printed nothing", c)
+	}
+
+	c.fetched, c.value = now, value
+	return value, nil
+}
+
+func (c *Command) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return DefaultCommandTTL
+	}
+	return c.TTL
+}
+
+func (c *Command) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (c *Command) String() string {
+	return fmt.Sprintf("command %q", c.Command)
+}
+
+func (c *Command) GoString() string {
+	return fmt.Sprintf("&secrets.Command{Command: %q}", c.Command)
+}
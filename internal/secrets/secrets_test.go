@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("DB_URL", "postgres://env")
+	t.Setenv("DB_URL_FILE", "")
+	t.Setenv("DB_URL_COMMAND", "")
+	assert.Equal(t, Env{Name: "DB_URL"}, FromEnv("DB_URL"))
+
+	t.Setenv("DB_URL_COMMAND", "echo postgres://command")
+	assert.IsType(t, &Command{}, FromEnv("DB_URL"), "A command should take precedence over the variable")
+
+	t.Setenv("DB_URL_FILE", "/run/secrets/db_url")
+	assert.Equal(t, &File{Path: "/run/secrets/db_url"}, FromEnv("DB_URL"), "A file should take precedence over a command")
+
+	assert.Nil(t, FromEnv("UNSET_SECRET"))
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0600))
+
+	secret := &File{Path: path}
+	value, err := secret.Value(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "first", value, "The trailing newline should be dropped")
+
+	require.NoError(t, os.WriteFile(path, []byte("second-password\n"), 0600))
+	value, err = secret.Value(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "second-password", value, "A rotated secret should be read again")
+
+	require.NoError(t, os.Remove(path))
+	_, err = secret.Value(context.Background())
+	require.ErrorContains(t, err, "error reading file "+path)
+}
+
+func TestCommandCaching(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "runs")
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	secret := &Command{
+		Command: fmt.Sprintf("echo run >> %s; wc -l < %s", counter, counter),
+		TTL:     time.Minute,
+		Now:     func() time.Time { return now },
+	}
+
+	value, err := secret.Value(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1", value)
+
+	value, err = secret.Value(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "1", value, "The output should be reused within the TTL")
+
+	now = now.Add(time.Minute)
+	value, err = secret.Value(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "2", value, "The command should be run again after the TTL")
+}
+
+func TestCommandErrorsDontLeakOutput(t *testing.T) {
+	secret := &Command{Command: "echo $((6*7)); echo $((6*7)) >&2; exit 3"}
+
+	_, err := secret.Value(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 3")
+	assert.NotContains(t, err.Error(), "42")
+}
+
+func TestSecretsArentPrinted(t *testing.T) {
+	t.Setenv("CONNECT_PASSWORD", "hunter2")
+
+	for _, secret := range []Secret{
+		NewLiteral("hunter2"),
+		Env{Name: "CONNECT_PASSWORD"},
+		&File{Path: "/run/secrets/password", value: "hunter2"},
+		&Command{Command: "pass show connect", value: "hunter2"},
+	} {
+		for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+			assert.NotContains(t, fmt.Sprintf(format, secret), "hunter2", "%T printed with %s", secret, format)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package timescale
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Get how far the backfill of a station has got
+func (t *TimescalePublisher) BackfillCheckpoint(stationId string) (int, bool, error) {
+	var page int
+	var complete bool
+
+	err := t.DbClient.QueryRow(`
+		SELECT page, complete FROM backfill_checkpoints
+		WHERE station = $1`,
+		stationId).Scan(&page, &complete)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return page, complete, err
+}
+
+// Record how far the backfill of a station has got
+func (t *TimescalePublisher) SaveBackfillCheckpoint(stationId string, page int, complete bool) error {
+	_, err := t.DbClient.Exec(`
+		INSERT INTO backfill_checkpoints (station, page, complete, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (station) DO UPDATE SET
+			page = EXCLUDED.page,
+			complete = EXCLUDED.complete,
+			updated_at = EXCLUDED.updated_at`,
+		stationId, page, complete)
+
+	return err
+}
@@ -0,0 +1,52 @@
+package timescale
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	publisher := &TimescalePublisher{DbClient: db}
+
+	mock.ExpectQuery("SELECT page, complete FROM backfill_checkpoints").
+		WithArgs("CP001").
+		WillReturnRows(sqlmock.NewRows([]string{"page", "complete"}))
+
+	page, complete, err := publisher.BackfillCheckpoint("CP001")
+	require.NoError(t, err)
+	assert.Equal(t, 0, page, "A station without a checkpoint should start at the beginning")
+	assert.False(t, complete)
+
+	mock.ExpectQuery("SELECT page, complete FROM backfill_checkpoints").
+		WithArgs("CP001").
+		WillReturnRows(sqlmock.NewRows([]string{"page", "complete"}).AddRow(12, true))
+
+	page, complete, err = publisher.BackfillCheckpoint("CP001")
+	require.NoError(t, err)
+	assert.Equal(t, 12, page)
+	assert.True(t, complete)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveBackfillCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	publisher := &TimescalePublisher{DbClient: db}
+
+	mock.ExpectExec("INSERT INTO backfill_checkpoints").
+		WithArgs("CP001", 3, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	require.NoError(t, publisher.SaveBackfillCheckpoint("CP001", 3, false))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
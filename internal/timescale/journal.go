@@ -0,0 +1,58 @@
+package timescale
+
+import (
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/ocpp"
+)
+
+// Record an OCPP frame in the ocpp_journal table
+func (t *TimescalePublisher) Record(entry ocpp.JournalEntry) error {
+	protocol := entry.Protocol
+	if protocol == "" {
+		protocol = ocpp.SubprotocolOCPP16
+	}
+
+	_, err := t.DbClient.Exec(`
+		INSERT INTO ocpp_journal (time, charge_point_id, direction, protocol, frame)
+		VALUES ($1, $2, $3, $4, $5)
+	`,
+		entry.Time,
+		entry.ChargePointId,
+		entry.Direction,
+		protocol,
+		entry.Frame,
+	)
+
+	return err
+}
+
+// Read back the journal for a time range, in the order it was journaled. An empty charge point
+// ID reads the journal for all charge points.
+func (t *TimescalePublisher) JournalEntries(chargePointId string, since time.Time, until time.Time) ([]ocpp.JournalEntry, error) {
+	rows, err := t.DbClient.Query(`
+		SELECT time, charge_point_id, direction, protocol, frame
+		FROM ocpp_journal
+		WHERE ($1 = '' OR charge_point_id = $1) AND time >= $2 AND time < $3
+		ORDER BY time, id
+	`,
+		chargePointId,
+		since,
+		until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ocpp.JournalEntry
+	for rows.Next() {
+		entry := ocpp.JournalEntry{}
+		if err := rows.Scan(&entry.Time, &entry.ChargePointId, &entry.Direction, &entry.Protocol, &entry.Frame); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,62 @@
+package timescale
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/speshak/grizzl-e-monitor/pkg/ocpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordJournalEntry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	publisher := &TimescalePublisher{DbClient: db}
+
+	entry := ocpp.JournalEntry{
+		Time:          time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC),
+		ChargePointId: "CP001",
+		Direction:     ocpp.DirectionInbound,
+		Protocol:      ocpp.SubprotocolOCPP201,
+		Frame:         []byte(`[2, "1", "Heartbeat", {}`),
+	}
+
+	// Frames are stored exactly, even if they're malformed
+	mock.ExpectExec("INSERT INTO ocpp_journal").
+		WithArgs(entry.Time, "CP001", "in", "ocpp2.0.1", []byte(`[2, "1", "Heartbeat", {}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	require.NoError(t, publisher.Record(entry))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestJournalEntries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	publisher := &TimescalePublisher{DbClient: db}
+
+	since := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	until := since.Add(24 * time.Hour)
+	at := since.Add(18 * time.Hour)
+
+	mock.ExpectQuery("SELECT time, charge_point_id, direction, protocol, frame FROM ocpp_journal .* ORDER BY time, id").
+		WithArgs("CP001", since, until).
+		WillReturnRows(sqlmock.NewRows([]string{"time", "charge_point_id", "direction", "protocol", "frame"}).
+			AddRow(at, "CP001", "in", "ocpp1.6", []byte(`[2, "1", "Heartbeat", {}]`)).
+			AddRow(at, "CP001", "out", "ocpp1.6", []byte(`[3,"1",{"currentTime":"2025-06-01T18:00:00Z"}]`)))
+
+	entries, err := publisher.JournalEntries("CP001", since, until)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ocpp.DirectionInbound, entries[0].Direction)
+	assert.Equal(t, ocpp.SubprotocolOCPP16, entries[0].Protocol)
+	assert.Equal(t, `[2, "1", "Heartbeat", {}]`, string(entries[0].Frame))
+	assert.Equal(t, ocpp.DirectionOutbound, entries[1].Direction)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,47 @@
+package timescale
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Take the named lease for holder if it's free or has expired, or renew it if
+// holder already has it. Expiry is by the database's clock, so replicas don't
+// need theirs to agree.
+func (t *TimescalePublisher) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	var current string
+
+	err := t.DbClient.QueryRowContext(ctx, `
+		INSERT INTO leader_lease (name, holder, expires_at)
+		VALUES ($1, $2, NOW() + make_interval(secs => $3))
+		ON CONFLICT (name) DO UPDATE SET
+			holder = EXCLUDED.holder,
+			expires_at = EXCLUDED.expires_at
+		WHERE leader_lease.holder = EXCLUDED.holder
+			OR leader_lease.expires_at < NOW()
+		RETURNING holder`,
+		name, holder, ttl.Seconds()).Scan(&current)
+
+	// Someone else has it
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return current == holder, nil
+}
+
+// Give up the named lease, if holder has it, so another replica can take it
+// straight away rather than waiting for it to expire
+func (t *TimescalePublisher) ReleaseLease(ctx context.Context, name string, holder string) error {
+	_, err := t.DbClient.ExecContext(ctx, `
+		DELETE FROM leader_lease
+		WHERE name = $1 AND holder = $2`,
+		name, holder)
+
+	return err
+}
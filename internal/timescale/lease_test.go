@@ -0,0 +1,53 @@
+package timescale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireLease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	publisher := &TimescalePublisher{DbClient: db}
+
+	mock.ExpectQuery("INSERT INTO leader_lease").
+		WithArgs("monitor", "replica-a", 15.0).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("replica-a"))
+
+	held, err := publisher.AcquireLease(context.Background(), "monitor", "replica-a", 15*time.Second)
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	// The conflicting row isn't updated while another replica's lease is live
+	mock.ExpectQuery("INSERT INTO leader_lease").
+		WithArgs("monitor", "replica-b", 15.0).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+
+	held, err = publisher.AcquireLease(context.Background(), "monitor", "replica-b", 15*time.Second)
+	require.NoError(t, err)
+	assert.False(t, held)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReleaseLease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	publisher := &TimescalePublisher{DbClient: db}
+
+	mock.ExpectExec("DELETE FROM leader_lease").
+		WithArgs("monitor", "replica-a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, publisher.ReleaseLease(context.Background(), "monitor", "replica-a"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
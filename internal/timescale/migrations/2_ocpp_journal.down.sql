@@ -0,0 +1 @@
+DROP TABLE IF EXISTS ocpp_journal;
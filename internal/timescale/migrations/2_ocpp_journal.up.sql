@@ -0,0 +1,10 @@
+CREATE TABLE ocpp_journal (
+	time TIMESTAMPTZ NOT NULL,
+	charge_point_id VARCHAR(48) NOT NULL,
+	direction VARCHAR(3) NOT NULL,
+	frame JSONB NOT NULL
+);
+
+CREATE INDEX ON ocpp_journal (charge_point_id, time);
+
+SELECT create_hypertable('ocpp_journal', 'time');
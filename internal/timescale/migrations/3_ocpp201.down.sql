@@ -0,0 +1,5 @@
+ALTER TABLE ocpp_journal DROP COLUMN IF EXISTS protocol;
+
+ALTER TABLE meter_values ALTER COLUMN transaction_id TYPE VARCHAR(25);
+ALTER TABLE transactions ALTER COLUMN station TYPE VARCHAR(26);
+ALTER TABLE transactions ALTER COLUMN id TYPE VARCHAR(25);
@@ -0,0 +1,8 @@
+-- OCPP 2.0.1 transaction IDs are assigned by the charging station and are
+-- often UUIDs, and OCPP charge point IDs can be up to 48 characters
+ALTER TABLE transactions ALTER COLUMN id TYPE VARCHAR(64);
+ALTER TABLE transactions ALTER COLUMN station TYPE VARCHAR(48);
+ALTER TABLE meter_values ALTER COLUMN transaction_id TYPE VARCHAR(64);
+
+-- Everything journaled so far was OCPP 1.6
+ALTER TABLE ocpp_journal ADD COLUMN protocol VARCHAR(16) NOT NULL DEFAULT 'ocpp1.6';
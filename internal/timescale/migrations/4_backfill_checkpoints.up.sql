@@ -0,0 +1,6 @@
+CREATE TABLE backfill_checkpoints (
+	station VARCHAR(26) PRIMARY KEY,
+	page INTEGER NOT NULL,
+	complete BOOLEAN NOT NULL DEFAULT FALSE,
+	updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,3 @@
+ALTER TABLE ocpp_journal DROP COLUMN IF EXISTS id;
+-- Fails if any frame journaled since isn't valid JSON
+ALTER TABLE ocpp_journal ALTER COLUMN frame TYPE JSONB USING convert_from(frame, 'UTF8')::jsonb;
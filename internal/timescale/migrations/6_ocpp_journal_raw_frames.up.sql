@@ -0,0 +1,4 @@
+-- Frames are kept exactly as they were sent, even if they aren't valid JSON,
+-- and replayed in the order they were journaled
+ALTER TABLE ocpp_journal ALTER COLUMN frame TYPE BYTEA USING convert_to(frame::text, 'UTF8');
+ALTER TABLE ocpp_journal ADD COLUMN id BIGSERIAL;
@@ -1,19 +1,24 @@
 package timescale
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"embed"
+	"fmt"
 	"log"
 
 	"github.com/golang-migrate/migrate/v4"
 	pgmig "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/speshak/grizzl-e-monitor/internal/secrets"
 	"github.com/speshak/grizzl-e-monitor/pkg/connect"
 )
 
 type Config struct {
-	Url string
+	// The database URL, which holds its credentials
+	Url secrets.Secret
 }
 
 type TimescalePublisher struct {
@@ -36,10 +41,12 @@ func (t *TimescalePublisher) RunMigrations() error {
 		return err
 	}
 
+	// The database name is only used in migrate's logs, where the URL's
+	// credentials shouldn't go
 	m, err := migrate.NewWithInstance(
 		"iofs",
 		migrations,
-		t.Config.Url,
+		"timescale",
 		driver,
 	)
 
@@ -59,11 +66,7 @@ func (t *TimescalePublisher) RunMigrations() error {
 }
 
 func NewTimescalePublisher(config *Config) *TimescalePublisher {
-	db, err := sql.Open("postgres", config.Url)
-
-	if err != nil {
-		log.Fatalf("Error opening database connection: %v\n", err)
-	}
+	db := sql.OpenDB(urlConnector{url: config.Url})
 
 	// Create connection and run migrations
 	publisher := &TimescalePublisher{
@@ -71,7 +74,7 @@ func NewTimescalePublisher(config *Config) *TimescalePublisher {
 		Config:   config,
 	}
 
-	err = publisher.RunMigrations()
+	err := publisher.RunMigrations()
 
 	if err != nil {
 		log.Fatalf("Error running migrations: %v\n", err)
@@ -80,16 +83,56 @@ func NewTimescalePublisher(config *Config) *TimescalePublisher {
 	return publisher
 }
 
+// Opens each connection with the database URL as it is at the time, so new
+// connections pick up rotated credentials. Open connections carry on with
+// the credentials they were opened with.
+type urlConnector struct {
+	url secrets.Secret
+}
+
+func (c urlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	url, err := c.url.Value(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading database URL: %w", err)
+	}
+
+	connector, err := pq.NewConnector(url)
+	if err != nil {
+		// pq's errors can quote the URL, credentials and all
+		return nil, fmt.Errorf("invalid database URL from %s", c.url)
+	}
+	return connector.Connect(ctx)
+}
+
+func (c urlConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// Check the database is reachable
+func (t *TimescalePublisher) CheckHealth(ctx context.Context) error {
+	return t.DbClient.PingContext(ctx)
+}
+
 func (t *TimescalePublisher) Close() error {
 	return t.DbClient.Close()
 }
 
+// Publish a transaction and its meter values. They're written in a single
+// database transaction, so an error or shutdown part way through doesn't
+// leave a transaction without some of its meter values.
 func (t *TimescalePublisher) PublishTransactionHistory(stationId string, transaction connect.Transaction) error {
 	log.Printf("Logging Transaction '%s' starting", transaction.ID)
 	log.Printf("%d data points to log", len(transaction.MeterValues.Date))
 
+	tx, err := t.DbClient.Begin()
+	if err != nil {
+		return err
+	}
+	// Does nothing once committed
+	defer tx.Rollback()
+
 	// Insert the transaction
-	_, err := t.DbClient.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO transactions (
 			id, duration, station, startAt, stopAt, status, power, currency, priceKW,
 			priceTotal, meterStart, meterStop, stopReason, averageCurrent, chargingDuration
@@ -114,7 +157,7 @@ func (t *TimescalePublisher) PublishTransactionHistory(stationId string, transac
 		transaction.Duration,
 		transaction.Station,
 		transaction.StartAt,
-		transaction.StopAt,
+		nullIfEmpty(transaction.StopAt),
 		transaction.Status,
 		transaction.Power,
 		transaction.Currency,
@@ -127,15 +170,15 @@ func (t *TimescalePublisher) PublishTransactionHistory(stationId string, transac
 		transaction.ChargingDuration,
 	)
 
-	log.Printf("Transaction '%s' inserted", transaction.ID)
-
 	if err != nil {
-		log.Fatalf("Error inserting transaction: %v", err)
+		log.Printf("Error inserting transaction: %v", err)
 		return err
 	}
 
+	log.Printf("Transaction '%s' inserted", transaction.ID)
+
 	// Prepare the meter statement (we do this a bunch, so it makes sense to prepare it)
-	meter_stmt, err := t.DbClient.Prepare(`
+	meter_stmt, err := tx.Prepare(`
 		INSERT INTO meter_values (
 			date, transaction_id, currentImport, currentOffered,
 			energyActiveImportRegister, powerActiveImport, soC, temperature, voltage
@@ -150,21 +193,22 @@ func (t *TimescalePublisher) PublishTransactionHistory(stationId string, transac
 			voltage = EXCLUDED.voltage
 	`)
 	if err != nil {
-		log.Fatalf("Error preparing meter statement: %v", err)
+		log.Printf("Error preparing meter statement: %v", err)
 		return err
 	}
+	defer meter_stmt.Close()
 
 	for index, metricDate := range transaction.MeterValues.Date {
 		_, err := meter_stmt.Exec(
 			metricDate,
 			transaction.ID,
-			transaction.MeterValues.CurrentImport[index],
-			transaction.MeterValues.CurrentOffered[index],
-			transaction.MeterValues.Voltage[index],
-			transaction.MeterValues.PowerActiveImport[index],
-			transaction.MeterValues.SoC[index],
-			transaction.MeterValues.Temperature[index],
-			transaction.MeterValues.Voltage[index],
+			valueAt(transaction.MeterValues.CurrentImport, index),
+			valueAt(transaction.MeterValues.CurrentOffered, index),
+			valueAt(transaction.MeterValues.EnergyActiveImportRegister, index),
+			valueAt(transaction.MeterValues.PowerActiveImport, index),
+			valueAt(transaction.MeterValues.SoC, index),
+			valueAt(transaction.MeterValues.Temperature, index),
+			valueAt(transaction.MeterValues.Voltage, index),
 		)
 
 		if err != nil {
@@ -172,7 +216,28 @@ func (t *TimescalePublisher) PublishTransactionHistory(stationId string, transac
 		}
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+// Publish a session in progress. The transaction is upserted and meter
+// values are only ever added, so writing it with just its new meter values
+// is enough. Once it's published with its stop time it counts as published.
+func (t *TimescalePublisher) PublishSessionProgress(stationId string, transaction connect.Transaction) error {
+	return t.PublishTransactionHistory(stationId, transaction)
+}
+
+// A meter value by its date's index. The arrays should all be the same
+// length as the dates, but past the end of a short one is stored as NULL.
+func valueAt[T int | float64](values []T, i int) sql.Null[T] {
+	if i >= len(values) {
+		return sql.Null[T]{}
+	}
+	return sql.Null[T]{V: values[i], Valid: true}
+}
+
+// In-progress transactions have no stop time, which is stored as NULL
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
 
 func (t *TimescalePublisher) TransactionPublished(transaction connect.Transaction) bool {
@@ -185,8 +250,10 @@ func (t *TimescalePublisher) TransactionPublished(transaction connect.Transactio
 		AND stopat IS NOT NULL`,
 		transaction.ID).Scan(&count)
 
+	// Publishing again is harmless, so treat the transaction as unpublished
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Error checking whether transaction %s is published: %v", transaction.ID, err)
+		return false
 	}
 
 	return count > 0
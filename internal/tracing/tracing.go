@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+)
+
+// Config holds the OpenTelemetry exporter configuration
+type Config struct {
+	// OTLP/HTTP collector URL, e.g. http://localhost:4318
+	Endpoint       string
+	ServiceName    string
+	ServiceVersion string
+}
+
+// Create a tracer provider that batches spans and exports them over OTLP/HTTP.
+// The caller is responsible for calling Shutdown on the provider to flush any
+// buffered spans.
+func NewTracerProvider(ctx context.Context, config *Config) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(config.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(config.ServiceName),
+		semconv.ServiceVersion(config.ServiceVersion),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
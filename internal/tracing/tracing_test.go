@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTracerProvider(t *testing.T) {
+	// Stand in for an OTLP collector, just count the export requests
+	var exports atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" && r.Method == http.MethodPost {
+			exports.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	provider, err := NewTracerProvider(context.Background(), &Config{
+		Endpoint:       collector.URL,
+		ServiceName:    "grizzl-e-monitor",
+		ServiceVersion: "test",
+	})
+	require.NoError(t, err)
+
+	_, span := provider.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+
+	require.NoError(t, provider.Shutdown(context.Background()))
+	assert.Equal(t, int32(1), exports.Load(), "Span should be exported to the collector")
+}
@@ -1,28 +1,37 @@
 package connect
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // A Connect API client
 type ConnectAPI interface {
 	SetDebug()
-	AssertValidToken() error
-	Login() error
+	SetMetrics(metrics *ClientMetrics)
+	SetRateLimit(interval time.Duration)
+	AssertValidToken(ctx context.Context) error
+	Login(ctx context.Context) error
 	Logout() error
-	GetStations() ([]Station, error)
-	GetStation(id string) (Station, error)
-	GetTransactionStatistics(stationId string) (TransactionStats, error)
-	GetAllTransactions(stationId string) ([]Transaction, error)
-	GetTransactions(stationId string, limit int, offset int) ([]Transaction, error)
-	GetTransaction(id string) (Transaction, error)
+	GetStations(ctx context.Context) ([]Station, error)
+	GetStation(ctx context.Context, id string) (Station, error)
+	GetTransactionStatistics(ctx context.Context, stationId string) (TransactionStats, error)
+	GetAllTransactions(ctx context.Context, stationId string) ([]Transaction, error)
+	GetTransactions(ctx context.Context, stationId string, limit int, offset int) ([]Transaction, error)
+	GetTransaction(ctx context.Context, id string) (Transaction, error)
 	ParseToken() (*jwt.Token, TokenClaims, error)
+	Health() Health
 }
 
 type ConnectAPIClient struct {
@@ -31,6 +40,29 @@ type ConnectAPIClient struct {
 	Token    string
 	Client   *resty.Client
 	PageSize int
+	Tracer   trace.Tracer
+	Metrics  *ClientMetrics
+
+	// Where to get the password each time the client logs in, in place of
+	// Password, so a rotated password is picked up
+	PasswordSource func(ctx context.Context) (string, error)
+
+	health  healthState
+	limiter rateLimiter
+
+	// Guards Token, which requests made at once read while a login writes
+	// it
+	tokenMu sync.RWMutex
+
+	// The login in progress, if there is one, which callers that find the
+	// token expired at the same time all wait on
+	loginMu sync.Mutex
+	login   *loginCall
+}
+
+type loginCall struct {
+	done chan struct{}
+	err  error
 }
 
 type TokenClaims struct {
@@ -51,49 +83,161 @@ func NewConnectAPI(username, password, host string) *ConnectAPIClient {
 		SetHeader("x-app-version", "v0.9.2 (115)").
 		SetHeader("x-application-name", "Grizzl-E Connect")
 
-	return &ConnectAPIClient{
+	c := &ConnectAPIClient{
 		Username: username,
 		Password: password,
 		Client:   client,
 		PageSize: 10,
+		Tracer:   otel.Tracer(TracerName),
 	}
+
+	client.
+		OnBeforeRequest(c.beforeRequest).
+		OnAfterResponse(c.versionCheck).
+		OnSuccess(c.afterRequest).
+		OnError(c.afterFailedRequest)
+
+	return c
 }
 
-// Enable debug mode in the underlying resty client
+// Enable debug mode in the underlying resty client. Credentials are
+// redacted from what it logs.
 func (c *ConnectAPIClient) SetDebug() {
-	c.Client = c.Client.SetDebug(true)
+	c.Client = c.Client.
+		SetDebug(true).
+		OnRequestLog(func(l *resty.RequestLog) error {
+			l.Header, l.Body = redactLog(l.Header, l.Body)
+			return nil
+		}).
+		OnResponseLog(func(l *resty.ResponseLog) error {
+			l.Header, l.Body = redactLog(l.Header, l.Body)
+			return nil
+		})
+}
+
+// Fields of request and response bodies that are never logged
+var redactedFields = []string{"password", "token", "refreshToken"}
+
+// Redact the auth token from headers and credentials from JSON bodies
+func redactLog(header http.Header, body string) (http.Header, string) {
+	if header.Get("Authorization") != "" {
+		header = header.Clone()
+		header.Set("Authorization", "[redacted]")
+	}
+
+	fields := map[string]any{}
+	if json.Unmarshal([]byte(body), &fields) != nil {
+		return header, body
+	}
+
+	redacted := false
+	for _, field := range redactedFields {
+		if _, ok := fields[field]; ok {
+			fields[field] = "[redacted]"
+			redacted = true
+		}
+	}
+	if !redacted {
+		return header, body
+	}
+
+	data, err := json.MarshalIndent(fields, "", "   ")
+	if err != nil {
+		return header, "[redacted]"
+	}
+	return header, string(data)
+}
+
+// Record self-instrumentation metrics for the client
+func (c *ConnectAPIClient) SetMetrics(metrics *ClientMetrics) {
+	c.Metrics = metrics
+}
+
+// Space requests out by at least an interval, however many are made at once,
+// or 0 for no limit. Logins count as requests too.
+func (c *ConnectAPIClient) SetRateLimit(interval time.Duration) {
+	c.limiter.setInterval(interval)
+}
+
+// Get the result of the last login and API version check
+func (c *ConnectAPIClient) Health() Health {
+	return c.health.get()
+}
+
+func (c *ConnectAPIClient) token() string {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.Token
+}
+
+func (c *ConnectAPIClient) setToken(token string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.Token = token
 }
 
 func (c *ConnectAPIClient) ParseToken() (*jwt.Token, TokenClaims, error) {
+	token := c.token()
 	parser := jwt.NewParser()
 	claims := TokenClaims{}
 
 	// We don't need to verify the token, just parse it
-	jwtToken, _, err := parser.ParseUnverified(c.Token, &claims)
+	jwtToken, _, err := parser.ParseUnverified(token, &claims)
 
 	// We only care about the error if we have a token
-	if err != nil && c.Token != "" {
+	if err != nil && token != "" {
 		log.Printf("Error parsing token: %s", err)
 	}
 
 	return jwtToken, claims, err
 }
 
-// Ensure the login token is valid
-func (c *ConnectAPIClient) AssertValidToken() error {
-	jwtToken, claims, _ := c.ParseToken()
-
+// Whether the current token has expired or there isn't one
+func (c *ConnectAPIClient) tokenExpired() bool {
 	// It might make sense to check jwtToken.Valid() here, but becasue we don't
 	// have the HMAC key we can't verify the token, so we just check for
 	// expiration
-	if jwtToken == nil || IsExpired(claims.ExpiresAt) {
+	jwtToken, claims, _ := c.ParseToken()
+	return jwtToken == nil || IsExpired(claims.ExpiresAt)
+}
+
+// Ensure the login token is valid. Concurrent callers share a single login.
+func (c *ConnectAPIClient) AssertValidToken(ctx context.Context) error {
+	c.loginMu.Lock()
+	call := c.login
+	if call == nil {
+		// Checked under the lock, so a login that's just finished is seen
+		if !c.tokenExpired() {
+			c.loginMu.Unlock()
+			return nil
+		}
+
+		call = &loginCall{done: make(chan struct{})}
+		c.login = call
+		c.loginMu.Unlock()
+
 		log.Println("No valid token, logging in")
-		err := c.Login()
-		if err != nil {
-			log.Fatalf("Error logging in: %s", err)
+		call.err = c.Login(ctx)
+
+		c.loginMu.Lock()
+		c.login = nil
+		c.loginMu.Unlock()
+		close(call.done)
+	} else {
+		c.loginMu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 
+	if call.err != nil {
+		log.Printf("Error logging in: %s", call.err)
+		return call.err
+	}
+
 	return nil
 }
 
@@ -103,23 +247,21 @@ func IsExpired(expires *jwt.NumericDate) bool {
 	return time.Until(expires.Time) < 30*time.Second
 }
 
-// Get a resty client with the auth token set
+// Get a request with the auth token set
 // This will log in if no token is set or the token is expired
-func (c *ConnectAPIClient) client() (*resty.Client, error) {
-	err := c.AssertValidToken()
+func (c *ConnectAPIClient) request(ctx context.Context) (*resty.Request, error) {
+	err := c.AssertValidToken(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return c.Client.
-		SetAuthToken(c.Token).
-		OnAfterResponse(VersionCheckMiddleware), nil
+	return c.Client.R().SetContext(ctx).SetAuthToken(c.token()), nil
 }
 
 /**
  * Resty middleware to check the API version in the response headers against the
- * version we're emulating. Wired into the client in the client() function.
+ * version we're emulating. Wired into the client in NewConnectAPI.
  */
 func VersionCheckMiddleware(c *resty.Client, r *resty.Response) error {
 	header := r.Header().Get("X-Application-Version")
@@ -131,48 +273,64 @@ func VersionCheckMiddleware(c *resty.Client, r *resty.Response) error {
 	return AssertApiSupported(header)
 }
 
-func (c *ConnectAPIClient) Login() error {
+func (c *ConnectAPIClient) Login(ctx context.Context) error {
 	// Get login token for future requests
 	log.Printf("Logging in as %s", c.Username)
 	result := LoginResponse{}
 	errorResult := ApiError{}
 
+	password := c.Password
+	if c.PasswordSource != nil {
+		var err error
+		password, err = c.PasswordSource(ctx)
+		if err != nil {
+			err = fmt.Errorf("error reading password: %w", err)
+			c.observeLogin(err)
+			return err
+		}
+	}
+
 	resp, err := c.Client.R().
+		SetContext(ctx).
 		SetBody(map[string]interface{}{
 			"emailOrPhone": c.Username,
-			"password":     c.Password,
+			"password":     password,
 		}).
 		SetResult(&result).
 		SetError(&errorResult).
 		Post("/client/auth/login")
 
 	if err != nil {
+		c.observeLogin(err)
 		return err
 	}
 
 	if resp.IsSuccess() {
-		c.Token = result.Token
+		c.observeLogin(nil)
+		c.setToken(result.Token)
 		return nil
 	}
 
-	return fmt.Errorf("error logging in: %s", errorResult.Message.Message)
+	err = fmt.Errorf("error logging in: %s", errorResult.Message.Message)
+	c.observeLogin(err)
+	return err
 }
 
 func (c *ConnectAPIClient) Logout() error {
 	// TODO: Call the logout endpoint to invalidate the tokens
-	c.Token = ""
+	c.setToken("")
 	return nil
 }
 
-func (c *ConnectAPIClient) GetStations() ([]Station, error) {
+func (c *ConnectAPIClient) GetStations(ctx context.Context) ([]Station, error) {
 	log.Println("Getting stations")
-	client, err := c.client()
+	request, err := c.request(ctx)
 	if err != nil {
 		return nil, err
 	}
 	result := GetStationsResponse{}
 
-	_, err = client.R().
+	_, err = request.
 		SetResult(&result).
 		SetQueryParam("includeShared", "true").
 		Get("/client/stations")
@@ -184,18 +342,19 @@ func (c *ConnectAPIClient) GetStations() ([]Station, error) {
 	return result.Stations, nil
 }
 
-func (c *ConnectAPIClient) GetStation(id string) (Station, error) {
+func (c *ConnectAPIClient) GetStation(ctx context.Context, id string) (Station, error) {
 	log.Printf("Getting station %s", id)
-	client, err := c.client()
+	request, err := c.request(ctx)
 
 	if err != nil {
 		return Station{}, err
 	}
 	result := Station{}
 
-	_, err = client.R().
+	_, err = request.
 		SetResult(&result).
-		Get("/client/stations/" + id)
+		SetPathParam("id", id).
+		Get("/client/stations/{id}")
 
 	if err != nil {
 		return Station{}, err
@@ -204,16 +363,16 @@ func (c *ConnectAPIClient) GetStation(id string) (Station, error) {
 	return result, nil
 }
 
-func (c *ConnectAPIClient) GetTransactionStatistics(stationId string) (TransactionStats, error) {
+func (c *ConnectAPIClient) GetTransactionStatistics(ctx context.Context, stationId string) (TransactionStats, error) {
 	log.Printf("Getting transaction statistics for station %s", stationId)
-	client, err := c.client()
+	request, err := c.request(ctx)
 	if err != nil {
 		return TransactionStats{}, err
 	}
 
 	result := TransactionStats{}
 
-	_, err = client.R().
+	_, err = request.
 		SetResult(&result).
 		SetQueryString("stationId=" + stationId).
 		SetResult(&result).
@@ -226,7 +385,7 @@ func (c *ConnectAPIClient) GetTransactionStatistics(stationId string) (Transacti
 	return result, nil
 }
 
-func (c *ConnectAPIClient) GetAllTransactions(stationId string) ([]Transaction, error) {
+func (c *ConnectAPIClient) GetAllTransactions(ctx context.Context, stationId string) ([]Transaction, error) {
 	log.Printf("Getting all transactions for station %s", stationId)
 	var transactions []Transaction
 
@@ -234,7 +393,7 @@ func (c *ConnectAPIClient) GetAllTransactions(stationId string) ([]Transaction,
 
 	// Get pages of transactions until we get back less than the limit
 	for {
-		page, err := c.GetTransactions(stationId, c.PageSize, offset)
+		page, err := c.GetTransactions(ctx, stationId, c.PageSize, offset)
 
 		if err != nil {
 			return transactions, err
@@ -249,9 +408,9 @@ func (c *ConnectAPIClient) GetAllTransactions(stationId string) ([]Transaction,
 }
 
 // Get a single page of transactions, defined by the limit and offset
-func (c *ConnectAPIClient) GetTransactions(stationId string, limit int, offset int) ([]Transaction, error) {
+func (c *ConnectAPIClient) GetTransactions(ctx context.Context, stationId string, limit int, offset int) ([]Transaction, error) {
 	log.Printf("Getting transactions for station %s", stationId)
-	client, err := c.client()
+	request, err := c.request(ctx)
 
 	if err != nil {
 		return nil, err
@@ -259,7 +418,7 @@ func (c *ConnectAPIClient) GetTransactions(stationId string, limit int, offset i
 
 	result := GetTransactionsResponse{}
 
-	_, err = client.R().
+	_, err = request.
 		SetQueryParams(map[string]string{
 			"stationId": stationId,
 			"limit":     strconv.Itoa(limit),
@@ -275,17 +434,18 @@ func (c *ConnectAPIClient) GetTransactions(stationId string, limit int, offset i
 	return result.Transactions, nil
 }
 
-func (c *ConnectAPIClient) GetTransaction(id string) (Transaction, error) {
+func (c *ConnectAPIClient) GetTransaction(ctx context.Context, id string) (Transaction, error) {
 	log.Printf("Getting transaction %s", id)
-	client, err := c.client()
+	request, err := c.request(ctx)
 	if err != nil {
 		return Transaction{}, err
 	}
 	result := GetTransactionResponse{}
 
-	_, err = client.R().
+	_, err = request.
 		SetResult(&result).
-		Get("/client/transactions/" + id)
+		SetPathParam("id", id).
+		Get("/client/transactions/{id}")
 
 	if err != nil {
 		return Transaction{}, err
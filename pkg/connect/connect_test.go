@@ -1,6 +1,7 @@
 package connect
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
@@ -11,6 +12,9 @@ import (
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
 )
 
 const fakeToken = "deadbeef"
@@ -37,7 +41,7 @@ func TestLogin(t *testing.T) {
 	httpmock.ActivateNonDefault(c.Client.GetClient())
 	SetupHTTPMock()
 
-	err := c.Login()
+	err := c.Login(context.Background())
 
 	require.NoError(t, err, "Error should be nil")
 	assert.NotEmpty(t, c.Token, "Token should not be empty")
@@ -48,7 +52,7 @@ func TestBadLogin(t *testing.T) {
 	httpmock.ActivateNonDefault(c.Client.GetClient())
 	SetupHTTPMock()
 
-	err := c.Login()
+	err := c.Login(context.Background())
 
 	require.Error(t, err, "Error should not be nil")
 	assert.Empty(t, c.Token, "Token should be empty")
@@ -62,7 +66,7 @@ func TestGetStations(t *testing.T) {
 	// Fake token
 	c.Token = fakeToken
 
-	resp, err := c.GetStations()
+	resp, err := c.GetStations(context.Background())
 
 	require.NoError(t, err, "Error should be nil")
 	assert.Len(t, resp, 1, "Response should have 1 station")
@@ -78,14 +82,14 @@ func TestGetStation(t *testing.T) {
 	// Fake token
 	c.Token = fakeToken
 
-	resp, err := c.GetStation("station1")
+	resp, err := c.GetStation(context.Background(), "station1")
 	require.NoError(t, err, "Error should be nil")
 	assert.Equal(t, "station1", resp.ID, "Station ID should match")
 
-	resp, err = c.GetStation("missing")
+	resp, err = c.GetStation(context.Background(), "missing")
 	require.NoError(t, err, "Error should be nil")
 
-	resp, err = c.GetStation("errstation")
+	resp, err = c.GetStation(context.Background(), "errstation")
 	require.NoError(t, err, "Error should be nil")
 }
 
@@ -115,13 +119,32 @@ func TestTransactionStats(t *testing.T) {
 		},
 	)
 
-	resp, err := c.GetTransactionStatistics("station1")
+	resp, err := c.GetTransactionStatistics(context.Background(), "station1")
 
 	require.NoError(t, err, "Error should be nil")
 	assert.Equal(t, statsResp.Sessions, resp.Sessions, "Sessions should match")
 	assert.Equal(t, statsResp.Duration, resp.Duration, "Duration should match")
 }
 
+func TestRequestSpans(t *testing.T) {
+	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+
+	recorder := tracetest.NewSpanRecorder()
+	c.Tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	_, err := c.GetStation(context.Background(), "station1")
+	require.NoError(t, err, "Error should be nil")
+
+	// No token is set, so the client logs in first
+	spans := recorder.Ended()
+	require.Len(t, spans, 2, "One span should be recorded per request")
+	assert.Equal(t, "POST /client/auth/login", spans[0].Name(), "Login should be traced")
+	assert.Equal(t, "GET /client/stations/{id}", spans[1].Name(), "Span name should use the path template")
+	assert.Contains(t, spans[1].Attributes(), semconv.HTTPResponseStatusCode(200))
+}
+
 func TestIsExipred(t *testing.T) {
 	expiredDate := jwt.NewNumericDate(time.Now().Add(time.Hour * -2))
 	futureDate := jwt.NewNumericDate(time.Now().Add(time.Hour))
@@ -138,7 +161,7 @@ func TestTransactionPage(t *testing.T) {
 	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
 	httpmock.ActivateNonDefault(c.Client.GetClient())
 
-	resp, err := c.GetTransactions("station1", 2, 0)
+	resp, err := c.GetTransactions(context.Background(), "station1", 2, 0)
 
 	require.NoError(t, err, "Error should be nil")
 	assert.Len(t, resp, 2, "Response should have 2 transactions")
@@ -149,7 +172,7 @@ func TestAllTransactions(t *testing.T) {
 	httpmock.ActivateNonDefault(c.Client.GetClient())
 
 	c.PageSize = 2
-	resp, err := c.GetAllTransactions("station1")
+	resp, err := c.GetAllTransactions(context.Background(), "station1")
 
 	require.NoError(t, err, "Error should be nil")
 	assert.Len(t, resp, 4, "Response should have 4 transactions")
@@ -159,7 +182,7 @@ func TestSingleTransaction(t *testing.T) {
 	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
 	httpmock.ActivateNonDefault(c.Client.GetClient())
 
-	resp, err := c.GetTransaction("transaction1")
+	resp, err := c.GetTransaction(context.Background(), "transaction1")
 
 	require.NoError(t, err, "Error should be nil")
 	assert.Equal(t, "transaction1", resp.ID, "Transaction should have requested ID")
@@ -169,7 +192,7 @@ func TestSingleBadTransaction(t *testing.T) {
 	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
 	httpmock.ActivateNonDefault(c.Client.GetClient())
 
-	_, err := c.GetTransaction("bogusId")
+	_, err := c.GetTransaction(context.Background(), "bogusId")
 
 	assert.Error(t, err, "Error should not be nil")
 }
@@ -179,7 +202,7 @@ func TestLogout(t *testing.T) {
 	httpmock.ActivateNonDefault(c.Client.GetClient())
 
 	// Login first
-	err := c.Login()
+	err := c.Login(context.Background())
 	require.NoError(t, err, "Error should be nil")
 	assert.NotEmpty(t, c.Token, "Token should not be empty")
 
@@ -233,3 +256,38 @@ func TestVersionCheckMiddleware(t *testing.T) {
 	res = VersionCheckMiddleware(nil, mockResponse)
 	require.NoError(t, res, "API version should be supported")
 }
+
+func TestLoginPasswordSource(t *testing.T) {
+	c := NewConnectAPI("myUser", "", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+
+	password := "badPassword"
+	c.PasswordSource = func(context.Context) (string, error) { return password, nil }
+	require.Error(t, c.Login(context.Background()))
+
+	// Each login reads the password again, picking up a rotated one
+	password = "myPassword"
+	require.NoError(t, c.Login(context.Background()))
+
+	c.PasswordSource = func(context.Context) (string, error) { return "", fmt.Errorf("file missing") }
+	require.ErrorContains(t, c.Login(context.Background()), "error reading password: file missing")
+	assert.Equal(t, "error reading password: file missing", c.Health().LoginError)
+}
+
+func TestRedactLog(t *testing.T) {
+	header := http.Header{"Authorization": []string{"Bearer secret-token"}, "Content-Type": []string{"application/json"}}
+
+	redactedHeader, body := redactLog(header, `{"emailOrPhone": "me@example.com", "password": "hunter2"}`)
+	assert.Equal(t, "[redacted]", redactedHeader.Get("Authorization"))
+	assert.Equal(t, "application/json", redactedHeader.Get("Content-Type"))
+	assert.Equal(t, "Bearer secret-token", header.Get("Authorization"), "The request's own headers should be left alone")
+	assert.NotContains(t, body, "hunter2")
+	assert.Contains(t, body, "me@example.com")
+
+	_, body = redactLog(http.Header{}, `{"token": "secret-token"}`)
+	assert.NotContains(t, body, "secret-token")
+
+	_, body = redactLog(http.Header{}, `{"id": "CP001"}`)
+	assert.Equal(t, `{"id": "CP001"}`, body, "Bodies without credentials should be left as they are")
+}
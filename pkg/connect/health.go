@@ -0,0 +1,63 @@
+package connect
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Whether the client can currently use the Connect API, as seen by its last
+// login and its last response
+type Health struct {
+	// When the client last logged in successfully
+	LastLogin time.Time `json:"last_login,omitzero"`
+	// Error from the last login attempt, if it failed
+	LoginError string `json:"login_error,omitempty"`
+	// Error from the version check of the last response, if it was rejected
+	VersionError string `json:"version_error,omitempty"`
+}
+
+// Healthy if the last login and the last response were both accepted
+func (h Health) Err() error {
+	switch {
+	case h.LoginError != "":
+		return fmt.Errorf("login failing: %s", h.LoginError)
+	case h.VersionError != "":
+		return fmt.Errorf("API version check failing: %s", h.VersionError)
+	default:
+		return nil
+	}
+}
+
+type healthState struct {
+	mu     sync.Mutex
+	health Health
+}
+
+func (s *healthState) get() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health
+}
+
+func (s *healthState) login(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.health.LoginError = err.Error()
+		return
+	}
+	s.health.LoginError = ""
+	s.health.LastLogin = time.Now()
+}
+
+func (s *healthState) versionCheck(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.health.VersionError = ""
+	if err != nil {
+		s.health.VersionError = err.Error()
+	}
+}
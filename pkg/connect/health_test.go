@@ -0,0 +1,40 @@
+package connect
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthLogin(t *testing.T) {
+	c := NewConnectAPI("badUser", "myPassword", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+
+	require.Error(t, c.Login(context.Background()))
+	assert.ErrorContains(t, c.Health().Err(), "login failing")
+
+	c.Username = "myUser"
+	require.NoError(t, c.Login(context.Background()))
+	assert.NoError(t, c.Health().Err(), "A successful login should clear the failure")
+	assert.False(t, c.Health().LastLogin.IsZero())
+}
+
+func TestHealthVersionCheck(t *testing.T) {
+	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+
+	httpmock.RegisterResponder("GET", "https://example.com/client/stations/noversion",
+		httpmock.NewStringResponder(200, "{}"))
+	_, err := c.GetStation(context.Background(), "noversion")
+	require.Error(t, err)
+	assert.ErrorContains(t, c.Health().Err(), "API version check failing")
+
+	_, err = c.GetStation(context.Background(), "station1")
+	require.NoError(t, err)
+	assert.NoError(t, c.Health().Err(), "An accepted response should clear the failure")
+}
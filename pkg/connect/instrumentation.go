@@ -0,0 +1,100 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+* Resty hooks that instrument each Connect API request with an OpenTelemetry
+* span and Prometheus metrics.
+*
+* The span is started before the request is sent and stored in the request
+* context. It is ended by either the success or error hook, exactly one of
+* which resty runs for each request.
+ */
+
+// Name of the tracer used for Connect API spans
+const TracerName = "github.com/speshak/grizzl-e-monitor/pkg/connect"
+
+// Wait for the rate limit, then start a client span for the request. The
+// request URL is still the path template at this point (resty substitutes
+// path params afterwards), so it makes a low cardinality span name and
+// endpoint label.
+func (c *ConnectAPIClient) beforeRequest(_ *resty.Client, r *resty.Request) error {
+	if err := c.limiter.wait(r.Context()); err != nil {
+		return err
+	}
+
+	ctx := context.WithValue(r.Context(), endpointKey{}, r.URL)
+	ctx, _ = c.Tracer.Start(ctx, r.Method+" "+r.URL,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLTemplate(r.URL),
+		),
+	)
+	r.SetContext(ctx)
+
+	return nil
+}
+
+// Finish instrumenting a request after a successful response
+func (c *ConnectAPIClient) afterRequest(_ *resty.Client, resp *resty.Response) {
+	ctx := resp.Request.Context()
+	c.Metrics.observeRequest(resp.Request.Method, endpointFromContext(ctx), resp.StatusCode(), resp.Time())
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode()))
+	span.End()
+}
+
+// Finish instrumenting a failed request, recording the error
+func (c *ConnectAPIClient) afterFailedRequest(r *resty.Request, err error) {
+	ctx := r.Context()
+	if _, ok := ctx.Value(endpointKey{}).(string); !ok {
+		// Given up waiting for the rate limit, so never sent
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+
+	status := 0
+	duration := time.Since(r.Time)
+
+	var respErr *resty.ResponseError
+	if errors.As(err, &respErr) {
+		status = respErr.Response.StatusCode()
+		duration = respErr.Response.Time()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	}
+
+	c.Metrics.observeRequest(r.Method, endpointFromContext(ctx), status, duration)
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+// Check the API version of a response, counting any failures
+func (c *ConnectAPIClient) versionCheck(client *resty.Client, resp *resty.Response) error {
+	err := VersionCheckMiddleware(client, resp)
+	c.health.versionCheck(err)
+
+	if err != nil {
+		c.Metrics.observeVersionCheckFailure()
+	}
+
+	return err
+}
+
+// Record the result of a login attempt
+func (c *ConnectAPIClient) observeLogin(err error) {
+	c.Metrics.observeLogin(err)
+	c.health.login(err)
+}
@@ -0,0 +1,91 @@
+package connect
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+/**
+* Prometheus self-instrumentation for the Connect API client.
+*
+* Metrics are optional, a client without metrics set (the default) records
+* nothing. All methods are safe to call on a nil *ClientMetrics.
+ */
+
+type ClientMetrics struct {
+	RequestDuration      *prometheus.HistogramVec
+	LoginAttempts        *prometheus.CounterVec
+	VersionCheckFailures prometheus.Counter
+}
+
+// Request context key holding the path template of the request
+type endpointKey struct{}
+
+func NewClientMetrics(reg prometheus.Registerer) *ClientMetrics {
+	return &ClientMetrics{
+		RequestDuration: promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "connect",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of Connect API requests",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "endpoint", "status"}),
+		LoginAttempts: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "connect",
+			Name:      "login_attempts_total",
+			Help:      "The number of login attempts, by result",
+		}, []string{"result"}),
+		VersionCheckFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Namespace: "grizzl_e",
+			Subsystem: "connect",
+			Name:      "version_check_failures_total",
+			Help:      "The number of responses rejected by the API version check",
+		}),
+	}
+}
+
+// Record the latency of a request. A status of 0 means no response was received.
+func (m *ClientMetrics) observeRequest(method string, endpoint string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	statusLabel := "error"
+	if status != 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+
+	m.RequestDuration.WithLabelValues(method, endpoint, statusLabel).Observe(duration.Seconds())
+}
+
+func (m *ClientMetrics) observeLogin(err error) {
+	if m == nil {
+		return
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	m.LoginAttempts.WithLabelValues(result).Inc()
+}
+
+func (m *ClientMetrics) observeVersionCheckFailure() {
+	if m == nil {
+		return
+	}
+
+	m.VersionCheckFailures.Inc()
+}
+
+// Get the path template stored in the request context by the request hooks
+func endpointFromContext(ctx context.Context) string {
+	endpoint, _ := ctx.Value(endpointKey{}).(string)
+	return endpoint
+}
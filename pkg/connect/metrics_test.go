@@ -0,0 +1,68 @@
+package connect
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientMetrics(t *testing.T) {
+	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+
+	reg := prometheus.NewRegistry()
+	c.SetMetrics(NewClientMetrics(reg))
+
+	_, err := c.GetStation(context.Background(), "station1")
+	require.NoError(t, err, "Error should be nil")
+
+	assert.InDelta(t, 1.0, testutil.ToFloat64(c.Metrics.LoginAttempts.WithLabelValues("success")), 0, "Login should be counted")
+	assert.Equal(t, 2, testutil.CollectAndCount(c.Metrics.RequestDuration), "Login and station requests should be observed")
+	assert.Equal(t, 1, testutil.CollectAndCount(c.Metrics.RequestDuration.WithLabelValues("GET", "/client/stations/{id}", "200").(prometheus.Histogram)), "Station request should be labelled with the path template")
+	assert.InDelta(t, 0.0, testutil.ToFloat64(c.Metrics.VersionCheckFailures), 0, "Version check should pass")
+}
+
+func TestClientMetricsLoginFailure(t *testing.T) {
+	c := NewConnectAPI("badUser", "myPassword", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+
+	reg := prometheus.NewRegistry()
+	c.SetMetrics(NewClientMetrics(reg))
+
+	err := c.Login(context.Background())
+	require.Error(t, err, "Error should not be nil")
+	assert.InDelta(t, 1.0, testutil.ToFloat64(c.Metrics.LoginAttempts.WithLabelValues("failure")), 0, "Failed login should be counted")
+}
+
+func TestClientMetricsVersionCheck(t *testing.T) {
+	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+
+	reg := prometheus.NewRegistry()
+	c.SetMetrics(NewClientMetrics(reg))
+
+	// A response without the version header fails the version check
+	httpmock.RegisterResponder("GET", "https://example.com/client/stations/noversion",
+		httpmock.NewStringResponder(200, "{}"))
+	_, err := c.GetStation(context.Background(), "noversion")
+
+	require.Error(t, err, "Error should not be nil")
+	assert.InDelta(t, 1.0, testutil.ToFloat64(c.Metrics.VersionCheckFailures), 0, "Version check failure should be counted")
+}
+
+func TestNilClientMetrics(t *testing.T) {
+	var m *ClientMetrics
+
+	// None of these should panic
+	m.observeRequest("GET", "/", 200, 0)
+	m.observeLogin(nil)
+	m.observeVersionCheckFailure()
+}
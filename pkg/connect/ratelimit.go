@@ -0,0 +1,54 @@
+package connect
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Spaces requests out by at least an interval. Concurrent callers are each
+// given the next free slot.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (r *rateLimiter) setInterval(interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interval = interval
+}
+
+// Wait for the next request to be allowed, or the context to be cancelled
+func (r *rateLimiter) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if r.interval <= 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	at := now
+	if r.next.After(now) {
+		at = r.next
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+
+	if delay := at.Sub(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package connect
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := rateLimiter{interval: 20 * time.Millisecond}
+
+	start := time.Now()
+	for range 3 {
+		require.NoError(t, limiter.wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	// Concurrent callers are spaced out too
+	start = time.Now()
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Go(func() { assert.NoError(t, limiter.wait(context.Background())) })
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, limiter.wait(ctx), context.Canceled)
+}
+
+func TestClientRateLimit(t *testing.T) {
+	c := NewConnectAPI("myUser", "myPassword", "https://example.com")
+	httpmock.ActivateNonDefault(c.Client.GetClient())
+	SetupHTTPMock()
+	c.SetRateLimit(20 * time.Millisecond)
+
+	// Logging in is spaced out along with the requests it's made for
+	start := time.Now()
+	_, err := c.GetStation(context.Background(), "station1")
+	require.NoError(t, err)
+	_, err = c.GetStation(context.Background(), "station1")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.GetStation(ctx, "station1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,280 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/**
+* OCPP 1.6J authorization of RFID idTags.
+*
+* Tags are checked against a TagRegistry, which also maps each tag to the
+* person and vehicle it belongs to. The registry is pushed to charge points as
+* their local authorization list, so they can authorize tags while offline.
+ */
+
+// Local Auth List Management actions initiated by the central system
+const (
+	ActionSendLocalList       = "SendLocalList"
+	ActionGetLocalListVersion = "GetLocalListVersion"
+)
+
+// Core profile action initiated by the charge point
+const ActionAuthorize = "Authorize"
+
+// SendLocalList update types
+const (
+	UpdateTypeFull         = "Full"
+	UpdateTypeDifferential = "Differential"
+)
+
+// SendLocalList.conf status values
+const (
+	UpdateStatusAccepted        = "Accepted"
+	UpdateStatusFailed          = "Failed"
+	UpdateStatusNotSupported    = "NotSupported"
+	UpdateStatusVersionMismatch = "VersionMismatch"
+)
+
+type AuthorizeRequest struct {
+	IdTag string `json:"idTag"`
+}
+
+type AuthorizeResponse struct {
+	IdTagInfo IdTagInfo `json:"idTagInfo"`
+}
+
+type AuthorizationData struct {
+	IdTag     string     `json:"idTag"`
+	IdTagInfo *IdTagInfo `json:"idTagInfo,omitempty"`
+}
+
+type SendLocalListRequest struct {
+	ListVersion            int                 `json:"listVersion"`
+	LocalAuthorizationList []AuthorizationData `json:"localAuthorizationList,omitempty"`
+	UpdateType             string              `json:"updateType"`
+}
+
+type SendLocalListResponse struct {
+	Status string `json:"status"`
+}
+
+type GetLocalListVersionRequest struct{}
+
+type GetLocalListVersionResponse struct {
+	ListVersion int `json:"listVersion"`
+}
+
+// An RFID card and who it belongs to
+type Tag struct {
+	IdTag       string     `json:"idTag"`
+	Person      string     `json:"person,omitempty"`
+	Vehicle     string     `json:"vehicle,omitempty"`
+	ParentIdTag string     `json:"parentIdTag,omitempty"`
+	Blocked     bool       `json:"blocked,omitempty"`
+	ExpiryDate  *time.Time `json:"expiryDate,omitempty"`
+}
+
+// The tags allowed to charge. The version is sent to charge points as the
+// local list version, so it must be bumped whenever the tags change.
+type TagRegistry struct {
+	Version int   `json:"version"`
+	Tags    []Tag `json:"tags"`
+
+	byIdTag map[string]Tag
+}
+
+// Load a tag registry from a JSON file
+func LoadTagRegistry(path string) (*TagRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tag registry: %w", err)
+	}
+
+	registry := &TagRegistry{}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, fmt.Errorf("error parsing tag registry %s: %w", path, err)
+	}
+
+	if registry.Version < 1 {
+		return nil, fmt.Errorf("tag registry %s must have a version of at least 1", path)
+	}
+
+	return NewTagRegistry(registry.Version, registry.Tags...), nil
+}
+
+func NewTagRegistry(version int, tags ...Tag) *TagRegistry {
+	registry := &TagRegistry{
+		Version: version,
+		Tags:    tags,
+		byIdTag: map[string]Tag{},
+	}
+
+	for _, tag := range tags {
+		registry.byIdTag[normalizeIdTag(tag.IdTag)] = tag
+	}
+
+	return registry
+}
+
+// idTags are case insensitive
+func normalizeIdTag(idTag string) string {
+	return strings.ToUpper(idTag)
+}
+
+// Look up the tag for an idTag
+func (r *TagRegistry) Lookup(idTag string) (Tag, bool) {
+	tag, ok := r.byIdTag[normalizeIdTag(idTag)]
+	return tag, ok
+}
+
+// Decide whether an idTag may charge at a time. Tags not in the registry are
+// Invalid.
+func (r *TagRegistry) Authorize(idTag string, now time.Time) IdTagInfo {
+	tag, ok := r.Lookup(idTag)
+	if !ok {
+		return IdTagInfo{Status: AuthorizationInvalid}
+	}
+
+	return tag.idTagInfo(now)
+}
+
+func (t Tag) idTagInfo(now time.Time) IdTagInfo {
+	info := IdTagInfo{
+		Status:      AuthorizationAccepted,
+		ExpiryDate:  t.ExpiryDate,
+		ParentIdTag: t.ParentIdTag,
+	}
+
+	switch {
+	case t.Blocked:
+		info.Status = AuthorizationBlocked
+	case t.ExpiryDate != nil && !now.Before(*t.ExpiryDate):
+		info.Status = AuthorizationExpired
+	}
+
+	return info
+}
+
+// The registry as a charge point's local authorization list
+func (r *TagRegistry) LocalList(now time.Time) []AuthorizationData {
+	list := make([]AuthorizationData, 0, len(r.Tags))
+	for _, tag := range r.Tags {
+		info := tag.idTagInfo(now)
+		list = append(list, AuthorizationData{IdTag: tag.IdTag, IdTagInfo: &info})
+	}
+
+	return list
+}
+
+// The outcome of checking an idTag presented at a charge point
+type AuthorizationEvent struct {
+	Time          time.Time
+	ChargePointId string
+	// The message the tag was presented in, e.g. Authorize or StartTransaction
+	Action string
+	IdTag  string
+	Status string
+	// Who the tag belongs to, if it's known
+	Person  string
+	Vehicle string
+}
+
+// Publishes every authorization decision, including rejected tags
+type AuthorizationPublisher interface {
+	PublishAuthorization(event AuthorizationEvent)
+}
+
+// Check an idTag presented in a message from a charge point. Without a tag
+// registry every tag is accepted.
+func (cs *CentralSystem) authorize(cp *ChargePoint, action string, idTag string) IdTagInfo {
+	now := cs.Now()
+
+	event := AuthorizationEvent{
+		Time:          now,
+		ChargePointId: cp.ID,
+		Action:        action,
+		IdTag:         idTag,
+		Status:        AuthorizationAccepted,
+	}
+
+	info := IdTagInfo{Status: AuthorizationAccepted}
+	if cs.Tags != nil {
+		info = cs.Tags.Authorize(idTag, now)
+		event.Status = info.Status
+
+		if tag, ok := cs.Tags.Lookup(idTag); ok {
+			event.Person = tag.Person
+			event.Vehicle = tag.Vehicle
+		} else {
+			log.Printf("Rejected unknown tag %s from charge point %s in %s", idTag, cp.ID, action)
+		}
+	}
+
+	if cs.AuthorizationPublisher != nil {
+		cs.AuthorizationPublisher.PublishAuthorization(event)
+	}
+
+	return info
+}
+
+func (cs *CentralSystem) authorizeRequest(cp *ChargePoint, req AuthorizeRequest) (AuthorizeResponse, error) {
+	return AuthorizeResponse{IdTagInfo: cs.authorize(cp, ActionAuthorize, req.IdTag)}, nil
+}
+
+// Ask a charge point which version of the local authorization list it has
+func (cs *CentralSystem) GetLocalListVersion(ctx context.Context, chargePointId string) (int, error) {
+	resp := GetLocalListVersionResponse{}
+	if err := cs.call16(ctx, chargePointId, ActionGetLocalListVersion, GetLocalListVersionRequest{}, &resp); err != nil {
+		return 0, err
+	}
+
+	return resp.ListVersion, nil
+}
+
+// Replace a charge point's local authorization list with the tag registry
+func (cs *CentralSystem) SendLocalList(ctx context.Context, chargePointId string) error {
+	if cs.Tags == nil {
+		return fmt.Errorf("no tag registry to send to charge point %s", chargePointId)
+	}
+
+	resp := SendLocalListResponse{}
+	err := cs.call16(ctx, chargePointId, ActionSendLocalList, SendLocalListRequest{
+		ListVersion:            cs.Tags.Version,
+		LocalAuthorizationList: cs.Tags.LocalList(cs.Now()),
+		UpdateType:             UpdateTypeFull,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != UpdateStatusAccepted {
+		return fmt.Errorf("local list version %d %w: %s", cs.Tags.Version, ErrRejected, resp.Status)
+	}
+
+	return nil
+}
+
+// Send the tag registry to a charge point if its local list is out of date
+func (cs *CentralSystem) SyncLocalList(ctx context.Context, chargePointId string) error {
+	if cs.Tags == nil {
+		return fmt.Errorf("no tag registry to send to charge point %s", chargePointId)
+	}
+
+	version, err := cs.GetLocalListVersion(ctx, chargePointId)
+	if err != nil {
+		return err
+	}
+
+	if version == cs.Tags.Version {
+		return nil
+	}
+
+	log.Printf("Updating charge point %s local list from version %d to %d", chargePointId, version, cs.Tags.Version)
+	return cs.SendLocalList(ctx, chargePointId)
+}
@@ -0,0 +1,165 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagRegistryAuthorize(t *testing.T) {
+	now := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Hour)
+	expires := now.Add(time.Hour)
+
+	registry := NewTagRegistry(1,
+		Tag{IdTag: "04A1B2C3", Person: "Sam", Vehicle: "Model 3"},
+		Tag{IdTag: "BLOCKED1", Blocked: true},
+		Tag{IdTag: "EXPIRED1", ExpiryDate: &expired},
+		Tag{IdTag: "VALID001", ExpiryDate: &expires, ParentIdTag: "HOUSE"},
+	)
+
+	tests := []struct {
+		idTag  string
+		status string
+	}{
+		{"04A1B2C3", AuthorizationAccepted},
+		{"04a1b2c3", AuthorizationAccepted},
+		{"BLOCKED1", AuthorizationBlocked},
+		{"EXPIRED1", AuthorizationExpired},
+		{"VALID001", AuthorizationAccepted},
+		{"UNKNOWN1", AuthorizationInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.idTag, func(t *testing.T) {
+			assert.Equal(t, tt.status, registry.Authorize(tt.idTag, now).Status)
+		})
+	}
+
+	info := registry.Authorize("VALID001", now)
+	assert.Equal(t, "HOUSE", info.ParentIdTag)
+	assert.Equal(t, &expires, info.ExpiryDate)
+
+	list := registry.LocalList(now)
+	require.Len(t, list, 4)
+	assert.Equal(t, AuthorizationBlocked, list[1].IdTagInfo.Status)
+}
+
+func TestLoadTagRegistry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"version": 3,
+		"tags": [
+			{"idTag": "04A1B2C3", "person": "Sam", "vehicle": "Model 3"}
+		]
+	}`), 0o600))
+
+	registry, err := LoadTagRegistry(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, registry.Version)
+
+	tag, ok := registry.Lookup("04A1B2C3")
+	require.True(t, ok)
+	assert.Equal(t, "Sam", tag.Person)
+	assert.Equal(t, "Model 3", tag.Vehicle)
+}
+
+func TestLoadTagRegistryWithoutVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"tags": []}`), 0o600))
+
+	_, err := LoadTagRegistry(path)
+	assert.Error(t, err)
+}
+
+func TestAuthorization(t *testing.T) {
+	cs, publisher, server := newTestCentralSystem(t)
+	cs.AuthorizationPublisher = publisher
+	cs.Tags = NewTagRegistry(2,
+		Tag{IdTag: "04A1B2C3", Person: "Sam", Vehicle: "Model 3"},
+		Tag{IdTag: "BLOCKED1", Blocked: true},
+	)
+
+	sim := NewChargePointSimulator(t, server, "CP001")
+	defer sim.Close()
+
+	// The local list should be brought up to date once the charge point boots
+	var mu sync.Mutex
+	var sent *SendLocalListRequest
+	sim.Handle(ActionGetLocalListVersion, func(payload json.RawMessage) any {
+		return GetLocalListVersionResponse{ListVersion: 1}
+	})
+	sim.Handle(ActionSendLocalList, func(payload json.RawMessage) any {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = &SendLocalListRequest{}
+		require.NoError(t, json.Unmarshal(payload, sent))
+		return SendLocalListResponse{Status: UpdateStatusAccepted}
+	})
+
+	sim.Call(ActionBootNotification, BootNotificationRequest{ChargePointVendor: "United Chargers", ChargePointModel: "Grizzl-E Smart"}, &BootNotificationResponse{})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent != nil
+	}, time.Second, 10*time.Millisecond, "Local list should be sent after boot")
+	mu.Lock()
+	assert.Equal(t, 2, sent.ListVersion)
+	assert.Equal(t, UpdateTypeFull, sent.UpdateType)
+	assert.Len(t, sent.LocalAuthorizationList, 2)
+	mu.Unlock()
+
+	// Known tag
+	authorized := AuthorizeResponse{}
+	sim.Call(ActionAuthorize, AuthorizeRequest{IdTag: "04A1B2C3"}, &authorized)
+	assert.Equal(t, AuthorizationAccepted, authorized.IdTagInfo.Status)
+
+	event := publisher.LastAuthorization()
+	assert.Equal(t, "CP001", event.ChargePointId)
+	assert.Equal(t, ActionAuthorize, event.Action)
+	assert.Equal(t, "Sam", event.Person)
+	assert.Equal(t, "Model 3", event.Vehicle)
+
+	// Unknown tag
+	sim.Call(ActionAuthorize, AuthorizeRequest{IdTag: "DEADBEEF"}, &authorized)
+	assert.Equal(t, AuthorizationInvalid, authorized.IdTagInfo.Status)
+
+	event = publisher.LastAuthorization()
+	assert.Equal(t, "DEADBEEF", event.IdTag)
+	assert.Equal(t, AuthorizationInvalid, event.Status)
+	assert.Empty(t, event.Person)
+
+	// A blocked tag still gets a transaction ID, the charge point stops it
+	started := StartTransactionResponse{}
+	sim.Call(ActionStartTransaction, StartTransactionRequest{ConnectorId: 1, IdTag: "BLOCKED1", Timestamp: time.Now()}, &started)
+	assert.Equal(t, AuthorizationBlocked, started.IdTagInfo.Status)
+	assert.NotZero(t, started.TransactionId)
+	assert.Equal(t, ActionStartTransaction, publisher.LastAuthorization().Action)
+}
+
+func TestLocalListUpToDate(t *testing.T) {
+	cs, _, server := newTestCentralSystem(t)
+	cs.Tags = NewTagRegistry(2, Tag{IdTag: "04A1B2C3"})
+
+	sim := NewChargePointSimulator(t, server, "CP001")
+	defer sim.Close()
+
+	sim.Handle(ActionGetLocalListVersion, func(payload json.RawMessage) any {
+		return GetLocalListVersionResponse{ListVersion: 2}
+	})
+	sim.Handle(ActionSendLocalList, func(payload json.RawMessage) any {
+		t.Error("Local list should not be sent when the charge point is up to date")
+		return SendLocalListResponse{Status: UpdateStatusAccepted}
+	})
+
+	sim.Call(ActionBootNotification, BootNotificationRequest{ChargePointVendor: "United Chargers", ChargePointModel: "Grizzl-E Smart"}, &BootNotificationResponse{})
+
+	require.NoError(t, cs.SyncLocalList(t.Context(), "CP001"))
+}
@@ -0,0 +1,495 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+/**
+* An OCPP 1.6J central system.
+*
+* Charge points connect over WebSocket to <base URL>/<charge point ID> and send
+* Core profile messages, which are mapped onto connect.Station and
+* connect.Transaction models and handed to the configured publishers.
+ */
+
+type Config struct {
+	// Address the central system listens on, e.g. ":9000"
+	ListenAddress string
+
+	// File the active charging profiles are saved to. Profiles are only kept
+	// in memory if this isn't set.
+	ProfileStorePath string
+
+	// JSON file of the RFID tags allowed to charge. Any tag is accepted if
+	// this isn't set.
+	TagRegistryPath string
+
+	// Where to journal OCPP frames: a file path, or JournalTimescale for the
+	// TimescaleDB ocpp_journal table. Frames aren't journaled if this isn't set.
+	Journal string
+
+	// Base WebSocket URL of an upstream central system, e.g. the Connect
+	// cloud. If set, chargers are proxied to it instead of being handled by
+	// our own central system.
+	UpstreamURL string
+}
+
+// Config.Journal value to journal to TimescaleDB
+const JournalTimescale = "timescale"
+
+// Publishers fed by the central system. These match the publisher interfaces
+// used by the monitor, so the same implementations can be used for both.
+type StationStatusPublisher interface {
+	PublishStationStatus(station connect.Station)
+}
+
+type TransactionHistoryPublisher interface {
+	PublishTransactionHistory(stationId string, transaction connect.Transaction) error
+}
+
+// Publishes sessions as they happen, each time with only the meter values
+// sampled since the last time. The whole transaction goes to the
+// TransactionHistoryPublisher once it's finished.
+type SessionPublisher interface {
+	PublishSessionProgress(stationId string, transaction connect.Transaction) error
+}
+
+// Publishes the current limit each connector is offering under the active
+// charging profiles
+type ChargingLimitPublisher interface {
+	PublishChargingLimit(stationId string, connectorId int, limitAmps float64)
+	ClearChargingLimit(stationId string, connectorId int)
+}
+
+// Handles the payload of a CALL from a charge point, returning the
+// CALLRESULT payload. Returning a *CallError sends that error to the charge
+// point, any other error is sent as an InternalError.
+type CallHandler func(cp *ChargePoint, payload json.RawMessage) (any, error)
+
+type CentralSystem struct {
+	StationStatusPublisher      StationStatusPublisher
+	TransactionHistoryPublisher TransactionHistoryPublisher
+	SessionPublisher            SessionPublisher
+	ChargingLimitPublisher      ChargingLimitPublisher
+	AuthorizationPublisher      AuthorizationPublisher
+
+	// Charging profiles accepted by each charge point
+	Profiles ProfileStore
+
+	// Tags allowed to charge. Any tag is accepted if this isn't set.
+	Tags *TagRegistry
+
+	// Records every frame sent and received, if set
+	Journal Journal
+
+	// Heartbeat interval sent to charge points in the BootNotification response
+	HeartbeatInterval time.Duration
+
+	// How long to wait for a charge point to respond to a call
+	CallTimeout time.Duration
+
+	// Voltage used to convert power limits in charging profiles to current
+	NominalVoltage float64
+
+	// Source of the current time, replaceable in tests
+	Now func() time.Time
+
+	// By subprotocol, then action
+	handlers map[string]map[string]CallHandler
+	upgrader websocket.Upgrader
+
+	mu                sync.Mutex
+	chargePoints      map[string]*ChargePoint
+	nextTransactionId int
+	nextRequestId     int
+}
+
+func NewCentralSystem() *CentralSystem {
+	cs := &CentralSystem{
+		Profiles:          NewMemoryProfileStore(),
+		HeartbeatInterval: 5 * time.Minute,
+		CallTimeout:       30 * time.Second,
+		// Grizzl-E chargers are North American split phase
+		NominalVoltage: 240,
+		Now:            time.Now,
+		upgrader: websocket.Upgrader{
+			// In order of preference, for charge points that offer both
+			Subprotocols: []string{SubprotocolOCPP201, SubprotocolOCPP16},
+		},
+		chargePoints: map[string]*ChargePoint{},
+		// OCPP transaction IDs are integers assigned by the central system.
+		// Seeding from the clock keeps them unique across restarts, so we
+		// don't overwrite previously published transactions.
+		nextTransactionId: int(time.Now().Unix()),
+	}
+
+	cs.handlers = map[string]map[string]CallHandler{
+		SubprotocolOCPP16: {
+			ActionAuthorize:          handle(cs.authorizeRequest),
+			ActionBootNotification:   handle(cs.bootNotification),
+			ActionHeartbeat:          handle(cs.heartbeat),
+			ActionStatusNotification: handle(cs.statusNotification),
+			ActionStartTransaction:   handle(cs.startTransaction),
+			ActionStopTransaction:    handle(cs.stopTransaction),
+			ActionMeterValues:        handle(cs.meterValues),
+		},
+		SubprotocolOCPP201: cs.handlers201(),
+	}
+
+	return cs
+}
+
+// Wrap a typed handler as a CallHandler, decoding the request payload
+func handle[Req any, Resp any](h func(cp *ChargePoint, req Req) (Resp, error)) CallHandler {
+	return func(cp *ChargePoint, payload json.RawMessage) (any, error) {
+		var req Req
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, &CallError{Code: ErrorFormationViolation, Description: err.Error()}
+		}
+
+		return h(cp, req)
+	}
+}
+
+// Get a charge point by ID, creating it if we haven't seen it before
+func (cs *CentralSystem) ChargePoint(id string) *ChargePoint {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cp, ok := cs.chargePoints[id]
+	if !ok {
+		cp = newChargePoint(id)
+		cs.chargePoints[id] = cp
+	}
+
+	return cp
+}
+
+// Upgrade a charge point connection to a WebSocket and handle its messages
+// until it disconnects. The charge point ID is the last element of the path.
+func (cs *CentralSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+	if id == "" || id == "/" || id == "." {
+		http.Error(w, "missing charge point ID", http.StatusNotFound)
+		return
+	}
+
+	if !slices.ContainsFunc(websocket.Subprotocols(r), isSupportedProtocol) {
+		http.Error(w, "unsupported OCPP version", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := cs.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading OCPP connection from %s: %v", id, err)
+		return
+	}
+
+	log.Printf("Charge point %s connected using %s", id, ws.Subprotocol())
+	cp := cs.ChargePoint(id)
+	cp.setProtocol(ws.Subprotocol())
+	cs.serve(cp, ws)
+	log.Printf("Charge point %s disconnected", id)
+}
+
+func isSupportedProtocol(protocol string) bool {
+	return protocol == SubprotocolOCPP16 || protocol == SubprotocolOCPP201
+}
+
+// Read and dispatch messages from a charge point connection
+func (cs *CentralSystem) serve(cp *ChargePoint, ws *websocket.Conn) {
+	defer ws.Close()
+
+	conn := newConnection(ws)
+	conn.onWrite = func(data []byte) {
+		cs.journal(cp, DirectionOutbound, data)
+	}
+	cp.setConnection(conn)
+	defer func() {
+		cp.setConnection(nil)
+		conn.close()
+	}()
+
+	cs.publishStation(cp.setOnline(true))
+	defer func() {
+		cs.publishStation(cp.setOnline(false))
+	}()
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Error reading from charge point %s: %v", cp.ID, err)
+			}
+			return
+		}
+		cs.journal(cp, DirectionInbound, data)
+
+		response, ok := cs.HandleFrame(cp, data)
+		if !ok {
+			continue
+		}
+
+		if err := conn.write(response); err != nil {
+			log.Printf("Error writing to charge point %s: %v", cp.ID, err)
+			return
+		}
+
+		// Anything that makes calls of its own must run off the read loop,
+		// as that's where the responses arrive
+		for _, f := range cp.takeAfterResponse() {
+			go f()
+		}
+	}
+}
+
+// Handle a single frame from a charge point. Returns the response frame, if
+// the frame needs one. Responses to our own calls are handed back to the call.
+func (cs *CentralSystem) HandleFrame(cp *ChargePoint, data []byte) (Message, bool) {
+	msg, err := ParseMessage(data)
+	if err != nil {
+		log.Printf("Error parsing message from charge point %s: %v", cp.ID, err)
+		// We can only respond to a CALL we managed to get a unique ID out of
+		if msg.UniqueId == "" || msg.TypeId != MessageTypeCall {
+			return Message{}, false
+		}
+		return NewCallError(msg.UniqueId, &CallError{Code: ErrorFormationViolation, Description: err.Error()}), true
+	}
+
+	if msg.TypeId != MessageTypeCall {
+		conn := cp.activeConnection()
+		if conn == nil || !conn.resolve(msg) {
+			log.Printf("Ignoring unexpected %d message %s from charge point %s", msg.TypeId, msg.UniqueId, cp.ID)
+		}
+		return Message{}, false
+	}
+
+	return cs.handleCall(cp, msg), true
+}
+
+func (cs *CentralSystem) handleCall(cp *ChargePoint, msg Message) Message {
+	handler, ok := cs.handlers[cp.protocol()][msg.Action]
+	if !ok {
+		log.Printf("Unsupported action %s from charge point %s", msg.Action, cp.ID)
+		return NewCallError(msg.UniqueId, &CallError{
+			Code:        ErrorNotImplemented,
+			Description: fmt.Sprintf("action %s is not implemented", msg.Action),
+		})
+	}
+
+	result, err := handler(cp, msg.Payload)
+	if err != nil {
+		log.Printf("Error handling %s from charge point %s: %v", msg.Action, cp.ID, err)
+
+		var callErr *CallError
+		if !errors.As(err, &callErr) {
+			callErr = &CallError{Code: ErrorInternalError, Description: err.Error()}
+		}
+		return NewCallError(msg.UniqueId, callErr)
+	}
+
+	response, err := NewCallResult(msg.UniqueId, result)
+	if err != nil {
+		return NewCallError(msg.UniqueId, &CallError{Code: ErrorInternalError, Description: err.Error()})
+	}
+
+	return response
+}
+
+func (cs *CentralSystem) publishStation(station connect.Station) {
+	if cs.StationStatusPublisher != nil {
+		cs.StationStatusPublisher.PublishStationStatus(station)
+	}
+}
+
+func (cs *CentralSystem) publishTransaction(cp *ChargePoint, transaction connect.Transaction) {
+	if cs.TransactionHistoryPublisher == nil {
+		return
+	}
+
+	err := cs.TransactionHistoryPublisher.PublishTransactionHistory(cp.ID, transaction)
+	if err != nil {
+		log.Printf("Error publishing transaction %s from charge point %s: %v", transaction.ID, cp.ID, err)
+	}
+}
+
+func (cs *CentralSystem) publishSession(cp *ChargePoint, progress connect.Transaction) {
+	if cs.SessionPublisher == nil {
+		return
+	}
+
+	err := cs.SessionPublisher.PublishSessionProgress(cp.ID, progress)
+	if err != nil {
+		log.Printf("Error publishing session %s from charge point %s: %v", progress.ID, cp.ID, err)
+	}
+}
+
+func (cs *CentralSystem) publishUpdate(cp *ChargePoint, update transactionUpdate) {
+	cs.publishSession(cp, update.Progress)
+	if update.Finished != nil {
+		cs.publishTransaction(cp, *update.Finished)
+	}
+}
+
+// Send a call to a connected charge point and wait for its response. A
+// CALLERROR from the charge point is returned as a *CallError.
+func (cs *CentralSystem) Call(ctx context.Context, chargePointId string, action string, request any, response any) error {
+	conn := cs.ChargePoint(chargePointId).activeConnection()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cs.CallTimeout)
+	defer cancel()
+
+	return conn.call(ctx, action, request, response)
+}
+
+// Work out and publish the current limit on each of a charge point's
+// connectors from its active profiles
+func (cs *CentralSystem) publishChargingLimits(cp *ChargePoint) {
+	if cs.ChargingLimitPublisher == nil {
+		return
+	}
+
+	profiles, err := cs.Profiles.Profiles(cp.ID)
+	if err != nil {
+		log.Printf("Error loading charging profiles for charge point %s: %v", cp.ID, err)
+		return
+	}
+
+	now := cs.Now()
+	for _, connectorId := range cp.connectorIds() {
+		limit, ok := effectiveLimit(profiles, connectorId, cp.activeTransaction(connectorId), now, cs.NominalVoltage)
+		if ok {
+			cs.ChargingLimitPublisher.PublishChargingLimit(cp.ID, connectorId, limit)
+		} else {
+			cs.ChargingLimitPublisher.ClearChargingLimit(cp.ID, connectorId)
+		}
+	}
+}
+
+func (cs *CentralSystem) newTransactionId() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.nextTransactionId++
+	return cs.nextTransactionId
+}
+
+// IDs for OCPP 2.0.1 requests whose results arrive in later calls
+func (cs *CentralSystem) newRequestId() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.nextRequestId++
+	return cs.nextRequestId
+}
+
+// Send a call that only exists in OCPP 1.6
+func (cs *CentralSystem) call16(ctx context.Context, chargePointId string, action string, request any, response any) error {
+	if cs.ChargePoint(chargePointId).protocol() != SubprotocolOCPP16 {
+		return fmt.Errorf("%s %w", action, ErrUnsupportedProtocol)
+	}
+
+	return cs.Call(ctx, chargePointId, action, request, response)
+}
+
+func (cs *CentralSystem) bootNotification(cp *ChargePoint, req BootNotificationRequest) (BootNotificationResponse, error) {
+	log.Printf("Charge point %s booted: %s %s, firmware %s", cp.ID, req.ChargePointVendor, req.ChargePointModel, req.FirmwareVersion)
+	cs.publishStation(cp.boot(req))
+
+	if cs.Tags != nil {
+		cp.runAfterResponse(func() {
+			if err := cs.SyncLocalList(context.Background(), cp.ID); err != nil {
+				log.Printf("Error updating charge point %s local list: %v", cp.ID, err)
+			}
+		})
+	}
+
+	return BootNotificationResponse{
+		Status:      RegistrationAccepted,
+		CurrentTime: cs.Now().UTC().Truncate(time.Second),
+		Interval:    int(cs.HeartbeatInterval.Seconds()),
+	}, nil
+}
+
+func (cs *CentralSystem) heartbeat(cp *ChargePoint, _ HeartbeatRequest) (HeartbeatResponse, error) {
+	// Some charge points can't parse fractional seconds
+	now := cs.Now().UTC().Truncate(time.Second)
+	cp.heartbeat(now)
+
+	// Profiles' periods change over time, so limits are worked out again
+	// with each heartbeat
+	cs.publishChargingLimits(cp)
+
+	return HeartbeatResponse{CurrentTime: now}, nil
+}
+
+func (cs *CentralSystem) statusNotification(cp *ChargePoint, req StatusNotificationRequest) (StatusNotificationResponse, error) {
+	cs.publishStation(cp.updateStatus(req))
+	cs.publishChargingLimits(cp)
+	return StatusNotificationResponse{}, nil
+}
+
+func (cs *CentralSystem) startTransaction(cp *ChargePoint, req StartTransactionRequest) (StartTransactionResponse, error) {
+	// A transaction ID is assigned even if the tag is rejected; the charge
+	// point stops the transaction itself
+	info := cs.authorize(cp, ActionStartTransaction, req.IdTag)
+	transactionId := cs.newTransactionId()
+	log.Printf("Charge point %s started transaction %d on connector %d: tag %s", cp.ID, transactionId, req.ConnectorId, info.Status)
+
+	cs.publishSession(cp, cp.startTransaction(transactionId, req))
+	cs.publishChargingLimits(cp)
+
+	return StartTransactionResponse{
+		IdTagInfo:     info,
+		TransactionId: transactionId,
+	}, nil
+}
+
+func (cs *CentralSystem) stopTransaction(cp *ChargePoint, req StopTransactionRequest) (StopTransactionResponse, error) {
+	log.Printf("Charge point %s stopped transaction %d: %s", cp.ID, req.TransactionId, req.Reason)
+	update := cp.stopTransaction(req)
+	cs.publishUpdate(cp, update)
+	cs.clearTxProfiles(cp, req.TransactionId, update.Finished.ConnectorId)
+	cs.publishChargingLimits(cp)
+
+	if req.IdTag == "" {
+		return StopTransactionResponse{}, nil
+	}
+
+	info := cs.authorize(cp, ActionStopTransaction, req.IdTag)
+	return StopTransactionResponse{IdTagInfo: &info}, nil
+}
+
+func (cs *CentralSystem) meterValues(cp *ChargePoint, req MeterValuesRequest) (MeterValuesResponse, error) {
+	// Meter values outside of a transaction don't map onto anything we publish
+	if req.TransactionId == nil {
+		return MeterValuesResponse{}, nil
+	}
+
+	// Samples are accumulated on the transaction, which is published whole
+	// once it stops. Until then, only the new samples are published.
+	progress, ok := cp.addMeterValues(*req.TransactionId, req.MeterValue)
+	if !ok {
+		log.Printf("Charge point %s sent meter values for unknown transaction %d", cp.ID, *req.TransactionId)
+		return MeterValuesResponse{}, nil
+	}
+
+	cs.publishSession(cp, progress)
+	cs.publishChargingLimits(cp)
+	return MeterValuesResponse{}, nil
+}
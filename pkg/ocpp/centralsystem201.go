@@ -0,0 +1,371 @@
+package ocpp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+/**
+* OCPP 2.0.1 support.
+*
+* Charging stations that negotiate ocpp2.0.1 are handled with their own set
+* of handlers, which map the 2.0.1 messages onto the same station and
+* transaction state as OCPP 1.6:
+*
+*   - TransactionEvent Started/Updated/Ended maps onto a connect.Transaction,
+*     with the transaction ID the station assigns.
+*   - StatusNotification, NotifyEvent and NotifyReport (the device model
+*     report we ask for once the station boots) map onto the connectors of the
+*     connect.Station.
+*
+* Smart charging and local authorization lists are only supported over OCPP
+* 1.6.
+ */
+
+func (cs *CentralSystem) handlers201() map[string]CallHandler {
+	return map[string]CallHandler{
+		ActionAuthorize:          handle(cs.authorize201),
+		ActionBootNotification:   handle(cs.bootNotification201),
+		ActionHeartbeat:          handle(cs.heartbeat),
+		ActionStatusNotification: handle(cs.statusNotification201),
+		ActionTransactionEvent:   handle(cs.transactionEvent),
+		ActionMeterValues:        handle(cs.meterValues201),
+		ActionNotifyEvent:        handle(cs.notifyEvent),
+		ActionNotifyReport:       handle(cs.notifyReport),
+	}
+}
+
+func (cs *CentralSystem) bootNotification201(cp *ChargePoint, req BootNotificationRequest201) (BootNotificationResponse201, error) {
+	station := req.ChargingStation
+	log.Printf("Charging station %s booted (%s): %s %s, firmware %s", cp.ID, req.Reason, station.VendorName, station.Model, station.FirmwareVersion)
+
+	cs.publishStation(cp.boot(req.asOCPP16()))
+
+	// Connector details aren't in any of the messages a station sends on its
+	// own, so ask for its device model
+	cp.runAfterResponse(func() {
+		if err := cs.GetBaseReport(context.Background(), cp.ID, ReportBaseFullInventory); err != nil {
+			log.Printf("Error requesting charging station %s device model: %v", cp.ID, err)
+		}
+	})
+
+	return BootNotificationResponse201{
+		Status:      RegistrationAccepted,
+		CurrentTime: cs.Now().UTC().Truncate(time.Second),
+		Interval:    int(cs.HeartbeatInterval.Seconds()),
+	}, nil
+}
+
+// The equivalent OCPP 1.6 BootNotification, which is what ChargePoint.Boot
+// holds
+func (req BootNotificationRequest201) asOCPP16() BootNotificationRequest {
+	return BootNotificationRequest{
+		ChargePointVendor:       req.ChargingStation.VendorName,
+		ChargePointModel:        req.ChargingStation.Model,
+		ChargePointSerialNumber: req.ChargingStation.SerialNumber,
+		FirmwareVersion:         req.ChargingStation.FirmwareVersion,
+	}
+}
+
+func (cs *CentralSystem) statusNotification201(cp *ChargePoint, req StatusNotificationRequest201) (StatusNotificationResponse201, error) {
+	cs.publishStation(cp.setConnectorStatus(req.EvseId, status201To16(req.ConnectorStatus)))
+	return StatusNotificationResponse201{}, nil
+}
+
+func (cs *CentralSystem) authorize201(cp *ChargePoint, req AuthorizeRequest201) (AuthorizeResponse201, error) {
+	return AuthorizeResponse201{IdTokenInfo: cs.authorizeIdToken(cp, ActionAuthorize, req.IdToken)}, nil
+}
+
+// Tags are authorized against the registry in the same way as OCPP 1.6, and
+// the 1.6 statuses are all valid 2.0.1 statuses
+func (cs *CentralSystem) authorizeIdToken(cp *ChargePoint, action string, idToken IdToken) IdTokenInfo {
+	info := cs.authorize(cp, action, idToken.IdToken)
+	return IdTokenInfo{
+		Status:              info.Status,
+		CacheExpiryDateTime: info.ExpiryDate,
+	}
+}
+
+func (cs *CentralSystem) transactionEvent(cp *ChargePoint, req TransactionEventRequest) (TransactionEventResponse, error) {
+	resp := TransactionEventResponse{}
+	if req.IdToken != nil {
+		info := cs.authorizeIdToken(cp, ActionTransactionEvent, *req.IdToken)
+		resp.IdTokenInfo = &info
+	}
+
+	if req.EventType != TransactionEventUpdated {
+		log.Printf("Charging station %s transaction %s %s: %s", cp.ID, req.TransactionInfo.TransactionId, req.EventType, req.TriggerReason)
+	}
+
+	cs.publishUpdate(cp, cp.transactionEvent(req))
+
+	if req.Evse != nil && req.TransactionInfo.ChargingState != "" {
+		cs.publishStation(cp.setConnectorStatus(req.Evse.Id, status201To16(req.TransactionInfo.ChargingState)))
+	}
+
+	return resp, nil
+}
+
+// Meter values outside of a transaction don't map onto anything we publish,
+// and those during one are sent in TransactionEvents
+func (cs *CentralSystem) meterValues201(cp *ChargePoint, req MeterValuesRequest201) (MeterValuesResponse201, error) {
+	return MeterValuesResponse201{}, nil
+}
+
+func (cs *CentralSystem) notifyEvent(cp *ChargePoint, req NotifyEventRequest) (NotifyEventResponse, error) {
+	for _, event := range req.EventData {
+		log.Printf("Charging station %s event %d: %s.%s = %s %s", cp.ID, event.EventId, event.Component.Name, event.Variable.Name, event.ActualValue, event.TechInfo)
+	}
+
+	cs.publishStation(cp.applyEvents(req.EventData))
+	return NotifyEventResponse{}, nil
+}
+
+func (cs *CentralSystem) notifyReport(cp *ChargePoint, req NotifyReportRequest) (NotifyReportResponse, error) {
+	cs.publishStation(cp.applyReport(req.ReportData))
+	return NotifyReportResponse{}, nil
+}
+
+// Ask an OCPP 2.0.1 charging station to report its device model. The report
+// arrives in NotifyReport calls.
+func (cs *CentralSystem) GetBaseReport(ctx context.Context, chargePointId string, reportBase string) error {
+	if cs.ChargePoint(chargePointId).protocol() != SubprotocolOCPP201 {
+		return fmt.Errorf("GetBaseReport %w", ErrUnsupportedProtocol)
+	}
+
+	resp := GetBaseReportResponse{}
+	err := cs.Call(ctx, chargePointId, ActionGetBaseReport, GetBaseReportRequest{
+		RequestId:  cs.newRequestId(),
+		ReportBase: reportBase,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != "Accepted" {
+		return fmt.Errorf("%s report %w: %s", reportBase, ErrRejected, resp.Status)
+	}
+
+	return nil
+}
+
+// The OCPP 1.6 ChargePointStatus equivalent of a 2.0.1 ConnectorStatus or
+// ChargingState, which is what the monitor and its sinks understand. Those
+// that are the same in both, and any we don't know, are passed through.
+func status201To16(status string) string {
+	switch status {
+	case "Occupied", "EVConnected":
+		return "Preparing"
+	case "Idle":
+		// In a transaction, but with nothing plugged in
+		return "Available"
+	}
+	return status
+}
+
+// Set the status of an EVSE, without touching its error code
+func (cp *ChargePoint) setConnectorStatus(evseId int, status string) connect.Station {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if evseId == chargePointConnectorId {
+		cp.station.Status = status
+	} else {
+		cp.connectorLocked(evseId).Status = status
+	}
+
+	return cp.stationLocked()
+}
+
+// Apply a TransactionEvent. A transaction we haven't seen start (e.g. it
+// started before a restart) is picked up from whichever event we see first.
+func (cp *ChargePoint) transactionEvent(req TransactionEventRequest) transactionUpdate {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	id := req.TransactionInfo.TransactionId
+	transaction, ok := cp.transactions[id]
+	if !ok {
+		transaction = &connect.Transaction{
+			ID:      id,
+			Station: cp.ID,
+			StartAt: req.Timestamp.UTC().Format(time.RFC3339),
+		}
+		cp.transactions[id] = transaction
+	}
+
+	if req.Evse != nil {
+		transaction.ConnectorId = req.Evse.Id
+	}
+	if req.IdToken != nil {
+		transaction.IdTag = req.IdToken.IdToken
+	}
+
+	// There's no meterStart/meterStop in 2.0.1, so they come from the first
+	// and last energy register readings
+	_, started := lastEnergyReading(transaction.MeterValues)
+	before := len(transaction.MeterValues.Date)
+	appendMeterValues(&transaction.MeterValues, meterValues201(req.MeterValue))
+	if energy, ok := firstEnergyReading(transaction.MeterValues, before); ok && !started {
+		transaction.MeterStart = energy
+	}
+
+	if req.EventType != TransactionEventEnded {
+		return transactionUpdate{Progress: transactionProgress(transaction, before)}
+	}
+
+	delete(cp.transactions, id)
+
+	transaction.StopAt = req.Timestamp.UTC().Format(time.RFC3339)
+	transaction.StopReason = req.TransactionInfo.StoppedReason
+	transaction.MeterStop = transaction.MeterStart
+	if energy, ok := lastEnergyReading(transaction.MeterValues); ok {
+		transaction.MeterStop = energy
+	}
+	transaction.Energy = transaction.MeterStop - transaction.MeterStart
+
+	if startAt, err := time.Parse(time.RFC3339, transaction.StartAt); err == nil {
+		transaction.Duration = req.Timestamp.Sub(startAt).Seconds()
+	}
+
+	finished := copyTransaction(transaction)
+	return transactionUpdate{Progress: transactionProgress(transaction, before), Finished: &finished}
+}
+
+// The first non-zero energy register reading from index from onwards. A zero
+// is appended for samples without a reading, so those can't be told apart
+// from a meter reading zero.
+func firstEnergyReading(mv connect.MeterValues, from int) (int, bool) {
+	for _, energy := range tail(mv.EnergyActiveImportRegister, from) {
+		if energy != 0 {
+			return energy, true
+		}
+	}
+	return 0, false
+}
+
+func lastEnergyReading(mv connect.MeterValues) (int, bool) {
+	for i := len(mv.EnergyActiveImportRegister) - 1; i >= 0; i-- {
+		if energy := mv.EnergyActiveImportRegister[i]; energy != 0 {
+			return energy, true
+		}
+	}
+	return 0, false
+}
+
+// Convert OCPP 2.0.1 meter values to their OCPP 1.6 form, applying the unit
+// multipliers
+func meterValues201(values []MeterValue201) []MeterValue {
+	converted := make([]MeterValue, 0, len(values))
+	for _, value := range values {
+		mv := MeterValue{Timestamp: value.Timestamp}
+		for _, sampled := range value.SampledValue {
+			v := sampled.Value
+			unit := ""
+			if sampled.UnitOfMeasure != nil {
+				v *= math.Pow10(sampled.UnitOfMeasure.Multiplier)
+				unit = sampled.UnitOfMeasure.Unit
+			}
+
+			mv.SampledValue = append(mv.SampledValue, SampledValue{
+				Value:     strconv.FormatFloat(v, 'f', -1, 64),
+				Context:   sampled.Context,
+				Measurand: sampled.Measurand,
+				Phase:     sampled.Phase,
+				Location:  sampled.Location,
+				Unit:      unit,
+			})
+		}
+		converted = append(converted, mv)
+	}
+
+	return converted
+}
+
+func (cp *ChargePoint) applyEvents(events []EventData) connect.Station {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for _, event := range events {
+		if event.Variable.Name != VariableProblem {
+			cp.applyVariableLocked(event.Component, event.Variable, event.ActualValue)
+			continue
+		}
+
+		errorCode := "NoError"
+		if event.ActualValue == "true" && !event.Cleared {
+			errorCode = event.TechCode
+			if errorCode == "" {
+				errorCode = event.Component.Name + "Problem"
+			}
+		}
+
+		if evseId := componentEvseId(event.Component); evseId == chargePointConnectorId {
+			cp.station.ErrorCode = errorCode
+		} else {
+			cp.connectorLocked(evseId).ErrorCode = errorCode
+		}
+	}
+
+	return cp.stationLocked()
+}
+
+func (cp *ChargePoint) applyReport(report []ReportData) connect.Station {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for _, data := range report {
+		for _, attribute := range data.VariableAttribute {
+			if attribute.Type == "" || attribute.Type == AttributeActual {
+				cp.applyVariableLocked(data.Component, data.Variable, attribute.Value)
+			}
+		}
+
+		// The most an EVSE can deliver is the limit of its power variable
+		if data.Component.Name == ComponentEVSE && data.Variable.Name == VariablePower &&
+			data.VariableCharacteristics != nil && data.VariableCharacteristics.MaxLimit != nil {
+			cp.connectorLocked(componentEvseId(data.Component)).MaxPower = *data.VariableCharacteristics.MaxLimit / 1000
+		}
+	}
+
+	return cp.stationLocked()
+}
+
+// Map an actual device model value onto the station
+func (cp *ChargePoint) applyVariableLocked(component Component, variable Variable, value string) {
+	evseId := componentEvseId(component)
+
+	switch variable.Name {
+	case VariableAvailabilityState:
+		if component.Name == ComponentChargingStation {
+			cp.station.Status = status201To16(value)
+		} else if evseId != chargePointConnectorId {
+			cp.connectorLocked(evseId).Status = status201To16(value)
+		}
+
+	case VariablePower:
+		// Reported in W, connect.Connector is in kW
+		if power, err := strconv.ParseFloat(value, 64); err == nil && component.Name == ComponentEVSE && evseId != chargePointConnectorId {
+			cp.connectorLocked(evseId).Power = power / 1000
+		}
+
+	case VariableConnectorType:
+		if component.Name == ComponentConnector && evseId != chargePointConnectorId {
+			cp.connectorLocked(evseId).Type = value
+		}
+	}
+}
+
+// The EVSE a component belongs to, 0 for the station as a whole
+func componentEvseId(component Component) int {
+	if component.Evse == nil {
+		return chargePointConnectorId
+	}
+	return component.Evse.Id
+}
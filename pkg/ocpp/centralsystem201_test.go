@@ -0,0 +1,312 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/speshak/grizzl-e-monitor/internal/monitor"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func energySample(at time.Time, wh float64) MeterValue201 {
+	return MeterValue201{
+		Timestamp: at,
+		SampledValue: []SampledValue201{{
+			Value:         wh / 1000,
+			Measurand:     MeasurandEnergyActiveImportRegister,
+			UnitOfMeasure: &UnitOfMeasure{Unit: "kWh"},
+		}},
+	}
+}
+
+func TestNegotiatesOCPP201(t *testing.T) {
+	_, _, server := newTestCentralSystem(t)
+
+	dialer := websocket.Dialer{Subprotocols: []string{SubprotocolOCPP16, SubprotocolOCPP201}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ocpp/CS001", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	assert.Equal(t, SubprotocolOCPP201, ws.Subprotocol(), "OCPP 2.0.1 should be preferred when both are offered")
+}
+
+func TestOCPP201ChargingSessionFlow(t *testing.T) {
+	cs, publisher, server := newTestCentralSystem(t)
+	sim := NewChargingStationSimulator(t, server, "CS001")
+
+	reports := make(chan GetBaseReportRequest, 1)
+	sim.Handle(ActionGetBaseReport, func(payload json.RawMessage) any {
+		req := GetBaseReportRequest{}
+		_ = json.Unmarshal(payload, &req)
+		reports <- req
+		return GetBaseReportResponse{Status: "Accepted"}
+	})
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	// Boot
+	boot := BootNotificationResponse201{}
+	sim.Call(ActionBootNotification, BootNotificationRequest201{
+		ChargingStation: ChargingStation{VendorName: "United Chargers", Model: "Grizzl-E Smart", SerialNumber: "GRZ123", FirmwareVersion: "6.0"},
+		Reason:          "PowerUp",
+	}, &boot)
+	assert.Equal(t, RegistrationAccepted, boot.Status)
+	assert.Equal(t, 300, boot.Interval)
+	assert.Equal(t, "GRZ123", publisher.LastStation().SerialNumber)
+	assert.Equal(t, "Grizzl-E Smart", cs.ChargePoint("CS001").Boot.ChargePointModel)
+
+	// The device model is asked for once booted
+	select {
+	case req := <-reports:
+		assert.Equal(t, ReportBaseFullInventory, req.ReportBase)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Timed out waiting for GetBaseReport")
+	}
+
+	maxPower := 9600.0
+	sim.Call(ActionNotifyReport, NotifyReportRequest{
+		RequestId:   1,
+		GeneratedAt: start,
+		ReportData: []ReportData{
+			{
+				Component:               Component{Name: ComponentEVSE, Evse: &EVSE{Id: 1}},
+				Variable:                Variable{Name: VariablePower},
+				VariableAttribute:       []VariableAttribute{{Type: AttributeActual, Value: "0"}},
+				VariableCharacteristics: &VariableCharacteristics{Unit: "W", DataType: "decimal", MaxLimit: &maxPower},
+			},
+			{
+				Component:         Component{Name: ComponentConnector, Evse: &EVSE{Id: 1}},
+				Variable:          Variable{Name: VariableConnectorType},
+				VariableAttribute: []VariableAttribute{{Value: "cType1"}},
+			},
+		},
+	}, &NotifyReportResponse{})
+
+	station := publisher.LastStation()
+	require.Len(t, station.Connectors, 1)
+	assert.Equal(t, 1, station.Connectors[0].ID)
+	assert.Equal(t, 9.6, station.Connectors[0].MaxPower)
+	assert.Equal(t, "cType1", station.Connectors[0].Type)
+
+	sim.Call(ActionStatusNotification, StatusNotificationRequest201{Timestamp: start, ConnectorStatus: "Occupied", EvseId: 1, ConnectorId: 1}, &StatusNotificationResponse201{})
+	assert.Equal(t, "Preparing", publisher.LastStation().Connectors[0].Status, "2.0.1 statuses should be published as their 1.6 equivalents")
+
+	// Start
+	started := TransactionEventResponse{}
+	sim.Call(ActionTransactionEvent, TransactionEventRequest{
+		EventType:       TransactionEventStarted,
+		Timestamp:       start,
+		TriggerReason:   "Authorized",
+		TransactionInfo: TransactionInfo{TransactionId: "tx-0001", ChargingState: "Charging"},
+		IdToken:         &IdToken{IdToken: "TAG1", Type: "ISO14443"},
+		Evse:            &EVSE{Id: 1},
+		MeterValue:      []MeterValue201{energySample(start, 1000)},
+	}, &started)
+	require.NotNil(t, started.IdTokenInfo)
+	assert.Equal(t, AuthorizationAccepted, started.IdTokenInfo.Status)
+	assert.Equal(t, "Charging", publisher.LastStation().Connectors[0].Status)
+
+	assert.Empty(t, publisher.Transactions, "In-progress transactions should not be published")
+	transaction := publisher.LastSession()
+	assert.Equal(t, "tx-0001", transaction.ID, "The station's transaction ID should be used")
+	assert.Equal(t, "CS001", transaction.Station)
+	assert.Equal(t, "TAG1", transaction.IdTag)
+	assert.Equal(t, 1, transaction.ConnectorId)
+	assert.Equal(t, 1000, transaction.MeterStart)
+	assert.Empty(t, transaction.StopAt)
+
+	// Update, with readings scaled by a multiplier
+	sim.Call(ActionTransactionEvent, TransactionEventRequest{
+		EventType:       TransactionEventUpdated,
+		Timestamp:       start.Add(30 * time.Minute),
+		TriggerReason:   "MeterValuePeriodic",
+		TransactionInfo: TransactionInfo{TransactionId: "tx-0001"},
+		MeterValue: []MeterValue201{{
+			Timestamp: start.Add(30 * time.Minute),
+			SampledValue: []SampledValue201{
+				{Value: 2.5, Measurand: MeasurandEnergyActiveImportRegister, UnitOfMeasure: &UnitOfMeasure{Unit: "Wh", Multiplier: 3}},
+				{Value: 7.2, Measurand: MeasurandPowerActiveImport, UnitOfMeasure: &UnitOfMeasure{Unit: "kW"}},
+				{Value: 30, Measurand: MeasurandCurrentImport, UnitOfMeasure: &UnitOfMeasure{Unit: "A"}},
+			},
+		}},
+	}, &TransactionEventResponse{})
+
+	transaction = publisher.LastSession()
+	require.Len(t, transaction.MeterValues.Date, 1, "Only the new meter values should be published")
+	assert.Equal(t, 2500, transaction.MeterValues.EnergyActiveImportRegister[0])
+	assert.Equal(t, 7200.0, transaction.MeterValues.PowerActiveImport[0])
+	assert.Equal(t, 30.0, transaction.MeterValues.CurrentImport[0])
+
+	// A fault on the EVSE
+	sim.Call(ActionNotifyEvent, NotifyEventRequest{
+		GeneratedAt: start.Add(45 * time.Minute),
+		EventData: []EventData{{
+			EventId:               1,
+			Timestamp:             start.Add(45 * time.Minute),
+			Trigger:               "Alerting",
+			ActualValue:           "true",
+			TechCode:              "GroundFailure",
+			EventNotificationType: "HardWiredNotification",
+			Component:             Component{Name: ComponentEVSE, Evse: &EVSE{Id: 1}},
+			Variable:              Variable{Name: VariableProblem},
+		}},
+	}, &NotifyEventResponse{})
+	assert.Equal(t, "GroundFailure", publisher.LastStation().Connectors[0].ErrorCode)
+
+	// End
+	sim.Call(ActionTransactionEvent, TransactionEventRequest{
+		EventType:       TransactionEventEnded,
+		Timestamp:       start.Add(time.Hour),
+		TriggerReason:   "EVCommunicationLost",
+		TransactionInfo: TransactionInfo{TransactionId: "tx-0001", StoppedReason: "EVDisconnected"},
+		MeterValue:      []MeterValue201{energySample(start.Add(time.Hour), 5000)},
+	}, &TransactionEventResponse{})
+
+	transaction = publisher.LastTransaction()
+	assert.Equal(t, "tx-0001", transaction.ID)
+	assert.Equal(t, "2025-06-01T19:00:00Z", transaction.StopAt)
+	assert.Equal(t, "EVDisconnected", transaction.StopReason)
+	assert.Equal(t, 5000, transaction.MeterStop)
+	assert.Equal(t, 4000, transaction.Energy)
+	assert.Equal(t, 3600.0, transaction.Duration)
+	assert.Len(t, transaction.MeterValues.Date, 3)
+}
+
+func TestStatus201To16(t *testing.T) {
+	// A session as 2.0.1 reports it, in StatusNotifications and the
+	// chargingState of TransactionEvents, should give the same events as
+	// the 1.6 statuses do
+	statuses := []string{"Available", "Occupied", "EVConnected", "Charging", "SuspendedEV", "Charging", "Idle", "Available", "Faulted"}
+	expected := [][]monitor.EventType{
+		nil,
+		{monitor.EventVehiclePluggedIn},
+		nil,
+		{monitor.EventChargingStarted},
+		{monitor.EventChargingSuspended},
+		{monitor.EventChargingStarted},
+		{monitor.EventSessionFinished, monitor.EventVehicleUnplugged},
+		nil,
+		nil,
+	}
+
+	var before connect.Station
+	for i, status := range statuses {
+		after := connect.Station{ID: "CS001", Online: true, Connectors: []connect.Connector{{ID: 1, Status: status201To16(status)}}}
+		if i == 0 {
+			before = after
+		}
+
+		var events []monitor.EventType
+		for _, event := range monitor.DiffStation(before, after, time.Now()) {
+			events = append(events, event.Type)
+		}
+		assert.Equal(t, expected[i], events, "%s after %s", status, statuses[max(0, i-1)])
+		before = after
+	}
+
+	assert.Equal(t, "Faulted", status201To16("Faulted"))
+	assert.Equal(t, "Unknown", status201To16("Unknown"), "Statuses we don't know should be passed through")
+}
+
+func TestReportedAvailabilityState(t *testing.T) {
+	cp := newChargePoint("CS001")
+	station := cp.applyReport([]ReportData{
+		{
+			Component:         Component{Name: ComponentChargingStation},
+			Variable:          Variable{Name: VariableAvailabilityState},
+			VariableAttribute: []VariableAttribute{{Value: "Available"}},
+		},
+		{
+			Component:         Component{Name: ComponentEVSE, Evse: &EVSE{Id: 1}},
+			Variable:          Variable{Name: VariableAvailabilityState},
+			VariableAttribute: []VariableAttribute{{Type: AttributeActual, Value: "Occupied"}},
+		},
+	})
+
+	assert.Equal(t, "Available", station.Status)
+	require.Len(t, station.Connectors, 1)
+	assert.Equal(t, "Preparing", station.Connectors[0].Status, "Reported statuses should be their 1.6 equivalents too")
+}
+
+func TestOCPP201TransactionStartedBeforeRestart(t *testing.T) {
+	_, publisher, server := newTestCentralSystem(t)
+	sim := NewChargingStationSimulator(t, server, "CS001")
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	sim.Call(ActionTransactionEvent, TransactionEventRequest{
+		EventType:       TransactionEventEnded,
+		Timestamp:       start,
+		TriggerReason:   "StopAuthorized",
+		TransactionInfo: TransactionInfo{TransactionId: "tx-0002"},
+		Evse:            &EVSE{Id: 1},
+		MeterValue:      []MeterValue201{energySample(start, 3000)},
+	}, &TransactionEventResponse{})
+
+	transaction := publisher.LastTransaction()
+	assert.Equal(t, "tx-0002", transaction.ID)
+	assert.Equal(t, 3000, transaction.MeterStart)
+	assert.Equal(t, 3000, transaction.MeterStop)
+	assert.Equal(t, 0, transaction.Energy)
+}
+
+func TestOCPP16OnlyCalls(t *testing.T) {
+	cs, _, server := newTestCentralSystem(t)
+	sim := NewChargingStationSimulator(t, server, "CS001")
+	sim.Call(ActionHeartbeat, HeartbeatRequest{}, &HeartbeatResponse{})
+
+	err := cs.SetChargingProfile(context.Background(), "CS001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16)))
+	assert.ErrorIs(t, err, ErrUnsupportedProtocol)
+
+	_, err = cs.GetLocalListVersion(context.Background(), "CS001")
+	assert.ErrorIs(t, err, ErrUnsupportedProtocol)
+}
+
+func TestOCPP201Unsupported16Action(t *testing.T) {
+	_, _, server := newTestCentralSystem(t)
+	sim := NewChargingStationSimulator(t, server, "CS001")
+
+	resp := sim.Send(ActionStartTransaction, StartTransactionRequest{ConnectorId: 1, IdTag: "TAG1"})
+	assert.Equal(t, MessageTypeCallError, resp.TypeId)
+	assert.Equal(t, ErrorNotImplemented, resp.ErrorCode)
+}
+
+func TestOCPP201Replay(t *testing.T) {
+	cs, _, server := newTestCentralSystem(t)
+	journal := &RecordingJournal{}
+	cs.Journal = journal
+
+	sim := NewChargingStationSimulator(t, server, "CS001")
+	sim.Handle(ActionGetBaseReport, func(payload json.RawMessage) any {
+		return GetBaseReportResponse{Status: "Accepted"}
+	})
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	sim.Call(ActionBootNotification, BootNotificationRequest201{ChargingStation: ChargingStation{VendorName: "United Chargers", Model: "Grizzl-E Smart"}, Reason: "PowerUp"}, &BootNotificationResponse201{})
+	sim.Call(ActionTransactionEvent, TransactionEventRequest{
+		EventType:       TransactionEventStarted,
+		Timestamp:       start,
+		TriggerReason:   "Authorized",
+		TransactionInfo: TransactionInfo{TransactionId: "tx-0001"},
+		IdToken:         &IdToken{IdToken: "TAG1", Type: "ISO14443"},
+		Evse:            &EVSE{Id: 1},
+	}, &TransactionEventResponse{})
+	sim.Close()
+
+	entries := journal.Snapshot()
+	require.NotEmpty(t, entries)
+	for _, entry := range entries {
+		assert.Equal(t, SubprotocolOCPP201, entry.Protocol)
+	}
+
+	results := NewCentralSystem().Replay(entries)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.True(t, result.Matches(), "Replayed %s response should match", result.Entry.Frame)
+	}
+}
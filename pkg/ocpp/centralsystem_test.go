@@ -0,0 +1,198 @@
+package ocpp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCentralSystem(t *testing.T) (*CentralSystem, *RecordingPublisher, *httptest.Server) {
+	publisher := &RecordingPublisher{}
+
+	cs := NewCentralSystem()
+	cs.StationStatusPublisher = publisher
+	cs.TransactionHistoryPublisher = publisher
+	cs.SessionPublisher = publisher
+
+	server := httptest.NewServer(cs)
+	t.Cleanup(server.Close)
+
+	return cs, publisher, server
+}
+
+func TestChargingSessionFlow(t *testing.T) {
+	cs, publisher, server := newTestCentralSystem(t)
+	sim := NewChargePointSimulator(t, server, "CP001")
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	// Boot
+	boot := BootNotificationResponse{}
+	sim.Call(ActionBootNotification, BootNotificationRequest{
+		ChargePointVendor:       "United Chargers",
+		ChargePointModel:        "Grizzl-E Smart",
+		ChargePointSerialNumber: "GRZ123",
+		FirmwareVersion:         "5.633",
+	}, &boot)
+	assert.Equal(t, RegistrationAccepted, boot.Status)
+	assert.Equal(t, 300, boot.Interval, "Heartbeat interval should be sent in seconds")
+
+	station := publisher.LastStation()
+	assert.Equal(t, "CP001", station.ID)
+	assert.Equal(t, "GRZ123", station.SerialNumber)
+	assert.True(t, station.Online)
+
+	// Heartbeat
+	heartbeat := HeartbeatResponse{}
+	sim.Call(ActionHeartbeat, HeartbeatRequest{}, &heartbeat)
+	assert.False(t, heartbeat.CurrentTime.IsZero())
+	assert.False(t, cs.ChargePoint("CP001").LastHeartbeat.IsZero())
+
+	// Plug in
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 0, Status: "Available", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 1, Status: "Preparing", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+
+	station = publisher.LastStation()
+	assert.Equal(t, "Available", station.Status)
+	require.Len(t, station.Connectors, 1)
+	assert.Equal(t, 1, station.Connectors[0].ID)
+	assert.Equal(t, "Preparing", station.Connectors[0].Status)
+
+	// Start charging
+	started := StartTransactionResponse{}
+	sim.Call(ActionStartTransaction, StartTransactionRequest{
+		ConnectorId: 1,
+		IdTag:       "TAG1",
+		MeterStart:  1000,
+		Timestamp:   start,
+	}, &started)
+	assert.Equal(t, AuthorizationAccepted, started.IdTagInfo.Status)
+	assert.NotZero(t, started.TransactionId)
+
+	transaction, active := cs.ChargePoint("CP001").Transaction(started.TransactionId)
+	require.True(t, active)
+	assert.Equal(t, "CP001", transaction.Station)
+	assert.Equal(t, "TAG1", transaction.IdTag)
+	assert.Equal(t, "2025-06-01T18:00:00Z", transaction.StartAt)
+	assert.Empty(t, transaction.StopAt, "In-progress transaction should not have a stop time")
+	assert.Empty(t, publisher.Transactions, "In-progress transactions should not be published")
+	assert.Equal(t, transaction.ID, publisher.LastSession().ID, "The session should be published as it starts")
+
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 1, Status: "Charging", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+	assert.Equal(t, "Charging", publisher.LastStation().Connectors[0].Status)
+
+	// Meter values
+	sim.Call(ActionMeterValues, MeterValuesRequest{
+		ConnectorId:   1,
+		TransactionId: &started.TransactionId,
+		MeterValue: []MeterValue{{
+			Timestamp: start.Add(time.Minute),
+			SampledValue: []SampledValue{
+				{Value: "1500", Measurand: MeasurandEnergyActiveImportRegister, Unit: "Wh"},
+				{Value: "7.2", Measurand: MeasurandPowerActiveImport, Unit: "kW"},
+				{Value: "30.1", Measurand: MeasurandCurrentImport, Unit: "A"},
+				{Value: "32", Measurand: MeasurandCurrentOffered, Unit: "A"},
+				{Value: "239", Measurand: MeasurandVoltage, Unit: "V"},
+				{Value: "41.5", Measurand: MeasurandTemperature, Unit: "Celsius"},
+			},
+		}},
+	}, &MeterValuesResponse{})
+
+	transaction, _ = cs.ChargePoint("CP001").Transaction(started.TransactionId)
+	require.Len(t, transaction.MeterValues.Date, 1)
+	assert.Equal(t, transaction.MeterValues, publisher.LastSession().MeterValues, "Meter values should be published as they arrive")
+	assert.Equal(t, 1500, transaction.MeterValues.EnergyActiveImportRegister[0])
+	assert.InDelta(t, 7200.0, transaction.MeterValues.PowerActiveImport[0], 0.001, "kW should be normalised to W")
+	assert.InDelta(t, 30.1, transaction.MeterValues.CurrentImport[0], 0.001)
+	assert.InDelta(t, 32.0, transaction.MeterValues.CurrentOffered[0], 0.001)
+	assert.Equal(t, 239, transaction.MeterValues.Voltage[0])
+	assert.InDelta(t, 41.5, transaction.MeterValues.Temperature[0], 0.001)
+	assert.Empty(t, transaction.MeterValues.SoC, "Unreported measurands should be left out")
+
+	// Stop charging
+	sim.Call(ActionStopTransaction, StopTransactionRequest{
+		IdTag:         "TAG1",
+		MeterStop:     9000,
+		Timestamp:     start.Add(time.Hour),
+		TransactionId: started.TransactionId,
+		Reason:        "EVDisconnected",
+		TransactionData: []MeterValue{{
+			Timestamp:    start.Add(time.Hour),
+			SampledValue: []SampledValue{{Value: "9000"}},
+		}},
+	}, &StopTransactionResponse{})
+
+	require.Len(t, publisher.Transactions, 1, "Transaction should be published once it stops")
+	transaction = publisher.LastTransaction()
+	assert.Equal(t, "CP001", transaction.Station)
+	assert.Equal(t, "2025-06-01T19:00:00Z", transaction.StopAt)
+	assert.Equal(t, "EVDisconnected", transaction.StopReason)
+	assert.Equal(t, 8000, transaction.Energy)
+	assert.InDelta(t, 3600.0, transaction.Duration, 0.001)
+	require.Len(t, transaction.MeterValues.Date, 2, "Transaction data should be appended to the meter values")
+	assert.Equal(t, 9000, transaction.MeterValues.EnergyActiveImportRegister[1], "Meter values default to the energy register")
+
+	progress := publisher.LastSession()
+	assert.Equal(t, "2025-06-01T19:00:00Z", progress.StopAt)
+	assert.Equal(t, []int{9000}, progress.MeterValues.EnergyActiveImportRegister, "Only the new meter values should be published to the session")
+
+	_, active = cs.ChargePoint("CP001").Transaction(started.TransactionId)
+	assert.False(t, active, "Stopped transaction should no longer be active")
+
+	// Disconnect
+	sim.Close()
+	assert.Eventually(t, func() bool {
+		return !publisher.LastStation().Online
+	}, time.Second, 10*time.Millisecond, "Station should be published offline after disconnect")
+}
+
+func TestAppendMeterValuesLateMeasurand(t *testing.T) {
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	var mv connect.MeterValues
+	appendMeterValues(&mv, []MeterValue{
+		{Timestamp: start, SampledValue: []SampledValue{{Value: "1500"}}},
+		{Timestamp: start.Add(time.Minute), SampledValue: []SampledValue{{Value: "1600"}, {Value: "54", Measurand: MeasurandSoC}}},
+		{Timestamp: start.Add(2 * time.Minute), SampledValue: []SampledValue{{Value: "1700"}}},
+	})
+
+	assert.Equal(t, []int{1500, 1600, 1700}, mv.EnergyActiveImportRegister)
+	assert.Equal(t, []int{0, 54, 0}, mv.SoC, "A measurand reported part way through should line up with the dates")
+	assert.Empty(t, mv.Temperature, "A measurand never reported should be left out")
+}
+
+func TestUnknownAction(t *testing.T) {
+	_, _, server := newTestCentralSystem(t)
+	sim := NewChargePointSimulator(t, server, "CP001")
+	defer sim.Close()
+
+	resp := sim.Send("DataTransfer", map[string]string{"vendorId": "test"})
+	assert.Equal(t, MessageTypeCallError, resp.TypeId)
+	assert.Equal(t, ErrorNotImplemented, resp.ErrorCode)
+}
+
+func TestMalformedPayload(t *testing.T) {
+	_, _, server := newTestCentralSystem(t)
+	sim := NewChargePointSimulator(t, server, "CP001")
+	defer sim.Close()
+
+	resp := sim.SendFrame([]any{MessageTypeCall, "bad-1", ActionStartTransaction, map[string]any{"connectorId": "one"}})
+	assert.Equal(t, MessageTypeCallError, resp.TypeId)
+	assert.Equal(t, "bad-1", resp.UniqueId)
+	assert.Equal(t, ErrorFormationViolation, resp.ErrorCode)
+}
+
+func TestUnsupportedSubprotocol(t *testing.T) {
+	_, _, server := newTestCentralSystem(t)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"ocpp1.2"}}
+	_, resp, err := dialer.Dial("ws"+server.URL[len("http"):]+"/ocpp/CP001", nil)
+
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
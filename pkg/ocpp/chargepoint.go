@@ -0,0 +1,393 @@
+package ocpp
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+/**
+* Charge point state, built up from the messages a charge point sends and
+* mapped onto the same connect.Station/connect.Transaction models the cloud
+* API client produces, so the existing publishers can be used unchanged.
+*
+* Energy is reported in Wh and power in W, as OCPP meters do by default.
+ */
+
+// Connector ID used by OCPP to refer to the charge point as a whole
+const chargePointConnectorId = 0
+
+type ChargePoint struct {
+	ID string
+	// The OCPP version negotiated, SubprotocolOCPP16 or SubprotocolOCPP201
+	Protocol      string
+	Boot          BootNotificationRequest
+	LastHeartbeat time.Time
+
+	mu   sync.Mutex
+	conn *connection
+	// Work to start once the response to the current call has been sent
+	afterResponse []func()
+	station       connect.Station
+	// By transaction ID. OCPP 1.6 IDs are integers we assign, OCPP 2.0.1
+	// IDs are strings the charge point assigns.
+	transactions map[string]*connect.Transaction
+}
+
+// The parts of a running transaction that smart charging schedules depend on
+type activeTransaction struct {
+	id      int
+	startAt time.Time
+}
+
+func newChargePoint(id string) *ChargePoint {
+	return &ChargePoint{
+		ID:       id,
+		Protocol: SubprotocolOCPP16,
+		station: connect.Station{
+			ID:       id,
+			Identity: id,
+		},
+		transactions: map[string]*connect.Transaction{},
+	}
+}
+
+// A copy of the charge point's current state as a station
+func (cp *ChargePoint) Station() connect.Station {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.stationLocked()
+}
+
+func (cp *ChargePoint) stationLocked() connect.Station {
+	station := cp.station
+	station.Connectors = append([]connect.Connector(nil), cp.station.Connectors...)
+	return station
+}
+
+// A copy of an active transaction, if there is one with the given ID
+func (cp *ChargePoint) Transaction(transactionId int) (connect.Transaction, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	transaction, ok := cp.transactions[strconv.Itoa(transactionId)]
+	if !ok {
+		return connect.Transaction{}, false
+	}
+
+	return copyTransaction(transaction), true
+}
+
+// The transaction running on a connector, if any
+func (cp *ChargePoint) activeTransaction(connectorId int) *activeTransaction {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for id, transaction := range cp.transactions {
+		if transaction.ConnectorId != connectorId {
+			continue
+		}
+
+		// Only OCPP 1.6 transactions can be targeted by a TxProfile, and
+		// those have integer IDs
+		txId, _ := strconv.Atoi(id)
+		startAt, _ := time.Parse(time.RFC3339, transaction.StartAt)
+		return &activeTransaction{id: txId, startAt: startAt}
+	}
+
+	return nil
+}
+
+// The connector IDs we've seen status for
+func (cp *ChargePoint) connectorIds() []int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	ids := make([]int, 0, len(cp.station.Connectors))
+	for _, connector := range cp.station.Connectors {
+		ids = append(ids, connector.ID)
+	}
+	return ids
+}
+
+func (cp *ChargePoint) activeConnection() *connection {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.conn
+}
+
+func (cp *ChargePoint) setConnection(conn *connection) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.conn = conn
+}
+
+// Run f once the response to the call being handled has been sent, e.g. to
+// make our own calls that must follow a BootNotification being accepted
+func (cp *ChargePoint) runAfterResponse(f func()) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.afterResponse = append(cp.afterResponse, f)
+}
+
+func (cp *ChargePoint) takeAfterResponse() []func() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	funcs := cp.afterResponse
+	cp.afterResponse = nil
+	return funcs
+}
+
+func (cp *ChargePoint) protocol() string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	return cp.Protocol
+}
+
+func (cp *ChargePoint) setProtocol(protocol string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.Protocol = protocol
+}
+
+func (cp *ChargePoint) setOnline(online bool) connect.Station {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.station.Online = online
+	return cp.stationLocked()
+}
+
+func (cp *ChargePoint) boot(req BootNotificationRequest) connect.Station {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.Boot = req
+	cp.station.Online = true
+	cp.station.SerialNumber = req.ChargePointSerialNumber
+	if cp.station.SerialNumber == "" {
+		cp.station.SerialNumber = req.ChargeBoxSerialNumber
+	}
+
+	return cp.stationLocked()
+}
+
+func (cp *ChargePoint) heartbeat(now time.Time) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.LastHeartbeat = now
+}
+
+// Apply a StatusNotification. Connector 0 is the charge point itself.
+func (cp *ChargePoint) updateStatus(req StatusNotificationRequest) connect.Station {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if req.ConnectorId == chargePointConnectorId {
+		cp.station.Status = req.Status
+		cp.station.ErrorCode = req.ErrorCode
+		return cp.stationLocked()
+	}
+
+	connector := cp.connectorLocked(req.ConnectorId)
+	connector.Status = req.Status
+	connector.ErrorCode = req.ErrorCode
+
+	return cp.stationLocked()
+}
+
+// The station's connector with the given ID, adding it if we haven't seen it
+// before. The pointer is only valid until the next connector is added.
+func (cp *ChargePoint) connectorLocked(connectorId int) *connect.Connector {
+	for i := range cp.station.Connectors {
+		if cp.station.Connectors[i].ID == connectorId {
+			return &cp.station.Connectors[i]
+		}
+	}
+
+	cp.station.Connectors = append(cp.station.Connectors, connect.Connector{ID: connectorId})
+	sort.Slice(cp.station.Connectors, func(i, j int) bool {
+		return cp.station.Connectors[i].ID < cp.station.Connectors[j].ID
+	})
+
+	return cp.connectorLocked(connectorId)
+}
+
+func (cp *ChargePoint) startTransaction(transactionId int, req StartTransactionRequest) connect.Transaction {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	transaction := &connect.Transaction{
+		ID:          strconv.Itoa(transactionId),
+		Station:     cp.ID,
+		IdTag:       req.IdTag,
+		ConnectorId: req.ConnectorId,
+		StartAt:     req.Timestamp.UTC().Format(time.RFC3339),
+		MeterStart:  req.MeterStart,
+	}
+	cp.transactions[transaction.ID] = transaction
+
+	return copyTransaction(transaction)
+}
+
+// Add meter values to an active transaction, returning the transaction with
+// just the values added. Returns false if the transaction isn't known.
+func (cp *ChargePoint) addMeterValues(transactionId int, values []MeterValue) (connect.Transaction, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	transaction, ok := cp.transactions[strconv.Itoa(transactionId)]
+	if !ok {
+		return connect.Transaction{}, false
+	}
+
+	before := len(transaction.MeterValues.Date)
+	appendMeterValues(&transaction.MeterValues, values)
+	return transactionProgress(transaction, before), true
+}
+
+// A change to a transaction: its progress since the last change, and the
+// whole transaction once it's finished
+type transactionUpdate struct {
+	// The transaction with only the meter values added by the change
+	Progress connect.Transaction
+	// Only set once the transaction has finished
+	Finished *connect.Transaction
+}
+
+// Finish a transaction, removing it from the active transactions. A
+// transaction we haven't seen start (e.g. it started before a restart) is
+// recorded with what the stop message tells us.
+func (cp *ChargePoint) stopTransaction(req StopTransactionRequest) transactionUpdate {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	id := strconv.Itoa(req.TransactionId)
+	transaction, ok := cp.transactions[id]
+	if !ok {
+		transaction = &connect.Transaction{
+			ID:         id,
+			Station:    cp.ID,
+			IdTag:      req.IdTag,
+			MeterStart: req.MeterStop,
+		}
+	}
+	delete(cp.transactions, id)
+
+	before := len(transaction.MeterValues.Date)
+	appendMeterValues(&transaction.MeterValues, req.TransactionData)
+
+	transaction.StopAt = req.Timestamp.UTC().Format(time.RFC3339)
+	transaction.StopReason = req.Reason
+	transaction.MeterStop = req.MeterStop
+	transaction.Energy = req.MeterStop - transaction.MeterStart
+
+	if startAt, err := time.Parse(time.RFC3339, transaction.StartAt); err == nil {
+		transaction.Duration = req.Timestamp.Sub(startAt).Seconds()
+	}
+
+	finished := copyTransaction(transaction)
+	return transactionUpdate{Progress: transactionProgress(transaction, before), Finished: &finished}
+}
+
+// Append OCPP meter values to the connect model. connect.MeterValues is a set
+// of parallel arrays, lined up with the dates. A measurand the charge point
+// hasn't reported during the transaction is left out, so its array is shorter
+// than the dates, rather than filled with zeros that look like readings. Once
+// one has been reported, samples without it have 0.
+func appendMeterValues(mv *connect.MeterValues, values []MeterValue) {
+	for _, value := range values {
+		sample := map[string]float64{}
+		for _, sampled := range value.SampledValue {
+			measurand := sampled.Measurand
+			if measurand == "" {
+				// The OCPP default measurand
+				measurand = MeasurandEnergyActiveImportRegister
+			}
+
+			v, err := strconv.ParseFloat(sampled.Value, 64)
+			if err != nil {
+				continue
+			}
+
+			// Normalise to W/Wh
+			if sampled.Unit == "kW" || sampled.Unit == "kWh" {
+				v *= 1000
+			}
+
+			sample[measurand] = v
+		}
+
+		index := len(mv.Date)
+		mv.Date = append(mv.Date, value.Timestamp)
+		mv.CurrentImport = appendMeasurand(mv.CurrentImport, index, sample, MeasurandCurrentImport)
+		mv.CurrentOffered = appendMeasurand(mv.CurrentOffered, index, sample, MeasurandCurrentOffered)
+		mv.EnergyActiveImportRegister = appendMeasurand(mv.EnergyActiveImportRegister, index, sample, MeasurandEnergyActiveImportRegister)
+		mv.PowerActiveImport = appendMeasurand(mv.PowerActiveImport, index, sample, MeasurandPowerActiveImport)
+		mv.SoC = appendMeasurand(mv.SoC, index, sample, MeasurandSoC)
+		mv.Temperature = appendMeasurand(mv.Temperature, index, sample, MeasurandTemperature)
+		mv.Voltage = appendMeasurand(mv.Voltage, index, sample, MeasurandVoltage)
+	}
+}
+
+// Append a sample's value of a measurand at an index of the dates, padding
+// with 0 for earlier samples, unless the measurand has never been reported.
+// Whole-number measurands are rounded.
+func appendMeasurand[T int | float64](values []T, index int, sample map[string]float64, measurand string) []T {
+	v, reported := sample[measurand]
+	if !reported && len(values) == 0 {
+		return values
+	}
+	var zero T
+	if _, whole := any(zero).(int); whole {
+		v = math.Round(v)
+	}
+	for len(values) < index {
+		values = append(values, 0)
+	}
+	return append(values, T(v))
+}
+
+// The values of a meter value array from an index on, or none if it's
+// shorter than that
+func tail[T any](values []T, from int) []T {
+	return values[min(from, len(values)):]
+}
+
+// Copy a transaction so callers can't race with later updates to the meter
+// value arrays
+func copyTransaction(transaction *connect.Transaction) connect.Transaction {
+	return transactionProgress(transaction, 0)
+}
+
+// Copy a transaction with only its meter values from index from on, so a
+// session's progress can be published without the samples already published
+func transactionProgress(transaction *connect.Transaction, from int) connect.Transaction {
+	t := *transaction
+	mv := transaction.MeterValues
+
+	t.MeterValues = connect.MeterValues{
+		Date:                       append([]time.Time(nil), mv.Date[from:]...),
+		CurrentImport:              append([]float64(nil), tail(mv.CurrentImport, from)...),
+		CurrentOffered:             append([]float64(nil), tail(mv.CurrentOffered, from)...),
+		EnergyActiveImportRegister: append([]int(nil), tail(mv.EnergyActiveImportRegister, from)...),
+		PowerActiveImport:          append([]float64(nil), tail(mv.PowerActiveImport, from)...),
+		SoC:                        append([]int(nil), tail(mv.SoC, from)...),
+		Temperature:                append([]float64(nil), tail(mv.Temperature, from)...),
+		Voltage:                    append([]int(nil), tail(mv.Voltage, from)...),
+	}
+
+	return t
+}
@@ -0,0 +1,139 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrNotConnected = errors.New("charge point is not connected")
+
+// A call the charge point's OCPP version has no equivalent for
+var ErrUnsupportedProtocol = errors.New("is not supported by the charge point's OCPP version")
+
+// A charge point's WebSocket connection. Responses to our own calls arrive on
+// the same read loop as calls from the charge point, so outstanding calls are
+// tracked by unique ID until the read loop hands their response back.
+type connection struct {
+	ws *websocket.Conn
+
+	// Called with every frame written, for the journal
+	onWrite func(data []byte)
+
+	// gorilla/websocket allows one concurrent writer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan Message
+	closed  bool
+
+	nextId atomic.Uint64
+}
+
+func newConnection(ws *websocket.Conn) *connection {
+	return &connection{
+		ws:      ws,
+		pending: map[string]chan Message{},
+	}
+}
+
+func (c *connection) write(msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.onWrite != nil {
+		c.onWrite(data)
+	}
+
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// Send a CALL and wait for the response. A CALLERROR is returned as a
+// *CallError.
+func (c *connection) call(ctx context.Context, action string, request any, response any) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	uniqueId := strconv.FormatUint(c.nextId.Add(1), 10)
+	result := make(chan Message, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrNotConnected
+	}
+	c.pending[uniqueId] = result
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, uniqueId)
+		c.mu.Unlock()
+	}()
+
+	err = c.write(Message{TypeId: MessageTypeCall, UniqueId: uniqueId, Action: action, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("error sending %s: %w", action, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for %s response: %w", action, ctx.Err())
+
+	case msg, ok := <-result:
+		if !ok {
+			return ErrNotConnected
+		}
+		if msg.TypeId == MessageTypeCallError {
+			return &CallError{Code: msg.ErrorCode, Description: msg.ErrorDescription}
+		}
+		if response == nil {
+			return nil
+		}
+		if err := json.Unmarshal(msg.Payload, response); err != nil {
+			return fmt.Errorf("malformed %s response: %w", action, err)
+		}
+		return nil
+	}
+}
+
+// Hand a CALLRESULT/CALLERROR to the call waiting for it. Returns false if
+// nothing is waiting for it.
+func (c *connection) resolve(msg Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, ok := c.pending[msg.UniqueId]
+	if !ok {
+		return false
+	}
+
+	delete(c.pending, msg.UniqueId)
+	result <- msg
+	return true
+}
+
+// Fail any outstanding calls once the connection has gone away
+func (c *connection) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for uniqueId, result := range c.pending {
+		close(result)
+		delete(c.pending, uniqueId)
+	}
+}
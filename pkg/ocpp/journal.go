@@ -0,0 +1,383 @@
+package ocpp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/**
+* A journal of every OCPP-J frame sent to and received from charge points,
+* for debugging integrations and replaying recorded sessions through the
+* central system.
+ */
+
+// Frame directions, relative to the central system
+const (
+	DirectionInbound  = "in"
+	DirectionOutbound = "out"
+)
+
+type JournalEntry struct {
+	Time          time.Time `json:"time"`
+	ChargePointId string    `json:"chargePointId"`
+	Direction     string    `json:"direction"`
+	// The OCPP version the charge point was using. Entries written before
+	// OCPP 2.0.1 was supported don't have one, and are OCPP 1.6.
+	Protocol string `json:"protocol,omitempty"`
+	// The frame exactly as it was sent, even if it isn't valid JSON, as
+	// buggy charge points are what the journal is for
+	Frame []byte `json:"-"`
+}
+
+// How an entry is written to a file journal. The frame is kept as a string,
+// or as base64 if it isn't valid UTF-8. Entries written before frames were
+// kept exactly have the frame as JSON.
+type journalLine struct {
+	Time          time.Time       `json:"time"`
+	ChargePointId string          `json:"chargePointId"`
+	Direction     string          `json:"direction"`
+	Protocol      string          `json:"protocol,omitempty"`
+	Frame         json.RawMessage `json:"frame,omitempty"`
+	FrameBase64   []byte          `json:"frameBase64,omitempty"`
+}
+
+func (e JournalEntry) MarshalJSON() ([]byte, error) {
+	line := journalLine{
+		Time:          e.Time,
+		ChargePointId: e.ChargePointId,
+		Direction:     e.Direction,
+		Protocol:      e.Protocol,
+	}
+
+	if utf8.Valid(e.Frame) {
+		frame, err := json.Marshal(string(e.Frame))
+		if err != nil {
+			return nil, err
+		}
+		line.Frame = frame
+	} else {
+		line.FrameBase64 = e.Frame
+	}
+
+	return json.Marshal(line)
+}
+
+func (e *JournalEntry) UnmarshalJSON(data []byte) error {
+	line := journalLine{}
+	if err := json.Unmarshal(data, &line); err != nil {
+		return err
+	}
+
+	*e = JournalEntry{
+		Time:          line.Time,
+		ChargePointId: line.ChargePointId,
+		Direction:     line.Direction,
+		Protocol:      line.Protocol,
+		Frame:         line.FrameBase64,
+	}
+
+	switch {
+	case line.FrameBase64 != nil:
+	case len(line.Frame) > 0 && line.Frame[0] == '"':
+		var frame string
+		if err := json.Unmarshal(line.Frame, &frame); err != nil {
+			return err
+		}
+		e.Frame = []byte(frame)
+	default:
+		e.Frame = []byte(line.Frame)
+	}
+
+	return nil
+}
+
+type Journal interface {
+	Record(entry JournalEntry) error
+}
+
+// Defaults for FileJournal rotation
+const (
+	DefaultJournalMaxBytes   = 100 * 1024 * 1024
+	DefaultJournalMaxBackups = 5
+)
+
+// A Journal written to a file as JSON lines. Once the file grows past
+// MaxBytes it is rotated to <path>.1, <path>.1 to <path>.2 and so on, keeping
+// MaxBackups old files.
+type FileJournal struct {
+	Path       string
+	MaxBytes   int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewFileJournal(path string) (*FileJournal, error) {
+	j := &FileJournal{
+		Path:       path,
+		MaxBytes:   DefaultJournalMaxBytes,
+		MaxBackups: DefaultJournalMaxBackups,
+	}
+
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *FileJournal) open() error {
+	file, err := os.OpenFile(j.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening OCPP journal: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening OCPP journal: %w", err)
+	}
+
+	j.file = file
+	j.size = info.Size()
+	return nil
+}
+
+func (j *FileJournal) Record(entry JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.size > 0 && j.size+int64(len(line)) > j.MaxBytes {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	return err
+}
+
+func (j *FileJournal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("error rotating OCPP journal: %w", err)
+	}
+
+	// Drop the oldest backup and shuffle the rest up
+	os.Remove(fmt.Sprintf("%s.%d", j.Path, j.MaxBackups))
+	for i := j.MaxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", j.Path, i), fmt.Sprintf("%s.%d", j.Path, i+1))
+	}
+
+	if j.MaxBackups > 0 {
+		if err := os.Rename(j.Path, j.Path+".1"); err != nil {
+			return fmt.Errorf("error rotating OCPP journal: %w", err)
+		}
+	} else if err := os.Remove(j.Path); err != nil {
+		return fmt.Errorf("error rotating OCPP journal: %w", err)
+	}
+
+	return j.open()
+}
+
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// Read journal entries written by a FileJournal
+func ReadJournal(r io.Reader) ([]JournalEntry, error) {
+	var entries []JournalEntry
+
+	scanner := bufio.NewScanner(r)
+	// Frames such as a full local list can be longer than the default limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("malformed journal entry on line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Record a frame, if journaling is enabled
+func (cs *CentralSystem) journal(cp *ChargePoint, direction string, frame []byte) {
+	recordFrame(cs.Journal, cs.Now(), cp, direction, frame)
+}
+
+func recordFrame(journal Journal, at time.Time, cp *ChargePoint, direction string, frame []byte) {
+	if journal == nil {
+		return
+	}
+
+	err := journal.Record(JournalEntry{
+		Time:          at.UTC(),
+		ChargePointId: cp.ID,
+		Direction:     direction,
+		Protocol:      cp.protocol(),
+		Frame:         append([]byte(nil), frame...),
+	})
+	if err != nil {
+		log.Printf("Error journaling OCPP frame for charge point %s: %v", cp.ID, err)
+	}
+}
+
+// The outcome of replaying an inbound call from a journal
+type ReplayResult struct {
+	Entry JournalEntry
+	// The response the central system gave when replaying the call
+	Response Message
+	// The response recorded in the journal, if there is one
+	Recorded *Message
+}
+
+// Whether the replayed response matches the recorded response
+func (r ReplayResult) Matches() bool {
+	if r.Recorded == nil {
+		return false
+	}
+
+	replayed, err := json.Marshal(r.Response)
+	if err != nil {
+		return false
+	}
+	recorded, err := json.Marshal(*r.Recorded)
+	if err != nil {
+		return false
+	}
+
+	return jsonEqual(replayed, recorded)
+}
+
+func jsonEqual(a, b []byte) bool {
+	var av, bv any
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+
+	an, _ := json.Marshal(av)
+	bn, _ := json.Marshal(bv)
+	return string(an) == string(bn)
+}
+
+// Feed the inbound frames of a journal back through the central system's
+// handlers, as if the charge points had sent them again. The clock is set to
+// the time each call was originally answered and recorded transaction IDs are
+// reused, so replaying a journal produces the same state and responses every
+// time. Publishers see the replayed messages as they would live ones.
+func (cs *CentralSystem) Replay(entries []JournalEntry) []ReplayResult {
+	recorded := recordedResponses(entries)
+
+	now := cs.Now
+	defer func() { cs.Now = now }()
+
+	var results []ReplayResult
+	for i, entry := range entries {
+		if entry.Direction != DirectionInbound {
+			continue
+		}
+
+		entryTime := entry.Time
+		var recordedResponse *Message
+		if response, ok := recorded[i]; ok {
+			entryTime = response.Time
+			recordedResponse = &response.msg
+		}
+		cs.Now = func() time.Time { return entryTime }
+
+		cp := cs.ChargePoint(entry.ChargePointId)
+		if entry.Protocol != "" {
+			cp.setProtocol(entry.Protocol)
+		}
+		msg, _ := ParseMessage(entry.Frame)
+
+		if msg.Action == ActionStartTransaction && recordedResponse != nil {
+			cs.reuseTransactionId(*recordedResponse)
+		}
+
+		response, ok := cs.HandleFrame(cp, entry.Frame)
+		if !ok {
+			continue
+		}
+
+		results = append(results, ReplayResult{
+			Entry:    entry,
+			Response: response,
+			Recorded: recordedResponse,
+		})
+	}
+
+	return results
+}
+
+type recordedResponse struct {
+	JournalEntry
+	msg Message
+}
+
+// Pair each inbound call with the response sent to it, by the index of the
+// call. Charge points may reuse unique IDs after rebooting, so a call is
+// paired with the first response to its unique ID after it.
+func recordedResponses(entries []JournalEntry) map[int]recordedResponse {
+	type callKey struct{ chargePointId, uniqueId string }
+	waiting := map[callKey]int{}
+	responses := map[int]recordedResponse{}
+
+	for i, entry := range entries {
+		msg, err := ParseMessage(entry.Frame)
+		if err != nil {
+			continue
+		}
+		key := callKey{entry.ChargePointId, msg.UniqueId}
+
+		switch {
+		case entry.Direction == DirectionInbound && msg.TypeId == MessageTypeCall:
+			waiting[key] = i
+		case entry.Direction == DirectionOutbound && msg.TypeId != MessageTypeCall:
+			if call, ok := waiting[key]; ok {
+				responses[call] = recordedResponse{JournalEntry: entry, msg: msg}
+				delete(waiting, key)
+			}
+		}
+	}
+
+	return responses
+}
+
+// Make the next transaction ID the one from a recorded StartTransaction
+// response
+func (cs *CentralSystem) reuseTransactionId(recorded Message) {
+	resp := StartTransactionResponse{}
+	if recorded.TypeId != MessageTypeCallResult || json.Unmarshal(recorded.Payload, &resp) != nil {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.nextTransactionId = resp.TransactionId - 1
+}
@@ -0,0 +1,199 @@
+package ocpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A journal kept in memory
+type RecordingJournal struct {
+	mu      sync.Mutex
+	Entries []JournalEntry
+}
+
+func (j *RecordingJournal) Record(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Entries = append(j.Entries, entry)
+	return nil
+}
+
+func (j *RecordingJournal) Snapshot() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]JournalEntry(nil), j.Entries...)
+}
+
+func TestFileJournalRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ocpp.jsonl")
+
+	journal, err := NewFileJournal(path)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	journal.MaxBytes = 200
+	journal.MaxBackups = 2
+
+	entry := JournalEntry{
+		Time:          time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC),
+		ChargePointId: "CP001",
+		Direction:     DirectionInbound,
+		Frame:         json.RawMessage(`[2,"1","Heartbeat",{}]`),
+	}
+
+	// Each entry is ~110 bytes, so every entry after the first rotates
+	for range 5 {
+		require.NoError(t, journal.Record(entry))
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+
+		entries, err := ReadJournal(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "%s should hold one entry", name)
+		assert.Equal(t, entry.ChargePointId, entries[0].ChargePointId)
+		assert.Equal(t, entry.Frame, entries[0].Frame)
+	}
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "Only MaxBackups old files should be kept")
+}
+
+func TestFileJournalKeepsExactFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ocpp.jsonl")
+
+	journal, err := NewFileJournal(path)
+	require.NoError(t, err)
+	defer journal.Close()
+
+	frames := [][]byte{
+		[]byte(`[2, "1", "Heartbeat", {"b": 1, "a": 2}]`),
+		[]byte(`[2,"2","Heartbeat",{`),
+		{'[', 0xff, 0xfe, ']'},
+	}
+	for _, frame := range frames {
+		require.NoError(t, journal.Record(JournalEntry{ChargePointId: "CP001", Direction: DirectionInbound, Frame: frame}))
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	entries, err := ReadJournal(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, entries, len(frames))
+	for i, frame := range frames {
+		assert.Equal(t, frame, entries[i].Frame, "Frame %d should be kept byte for byte", i)
+	}
+}
+
+func TestReadJournalWithJSONFrames(t *testing.T) {
+	// As written before frames were kept exactly
+	entries, err := ReadJournal(bytes.NewReader([]byte(`{"time":"2025-06-01T18:00:00Z","chargePointId":"CP001","direction":"in","frame":[2,"1","Heartbeat",{}]}` + "\n")))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, `[2,"1","Heartbeat",{}]`, string(entries[0].Frame))
+}
+
+func TestReadJournalMalformed(t *testing.T) {
+	_, err := ReadJournal(bytes.NewReader([]byte("{\"time\":\"2025-06-01T18:00:00Z\"}\nnot json\n")))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+// Run a charging session with the journal enabled
+func recordSession(t *testing.T) []JournalEntry {
+	cs, _, server := newTestCentralSystem(t)
+	journal := &RecordingJournal{}
+	cs.Journal = journal
+
+	sim := NewChargePointSimulator(t, server, "CP001")
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	sim.Call(ActionBootNotification, BootNotificationRequest{ChargePointVendor: "United Chargers", ChargePointModel: "Grizzl-E Smart"}, &BootNotificationResponse{})
+	sim.Call(ActionHeartbeat, HeartbeatRequest{}, &HeartbeatResponse{})
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 1, Status: "Charging", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+
+	started := StartTransactionResponse{}
+	sim.Call(ActionStartTransaction, StartTransactionRequest{ConnectorId: 1, IdTag: "TAG1", MeterStart: 1000, Timestamp: start}, &started)
+	sim.Call(ActionStopTransaction, StopTransactionRequest{TransactionId: started.TransactionId, MeterStop: 5000, Timestamp: start.Add(time.Hour)}, &StopTransactionResponse{})
+	sim.Send("DataTransfer", map[string]string{"vendorId": "test"})
+	sim.Close()
+
+	return journal.Snapshot()
+}
+
+func TestJournalRecordsFrames(t *testing.T) {
+	entries := recordSession(t)
+
+	// Six calls, each with a response
+	require.Len(t, entries, 12)
+	for i, entry := range entries {
+		assert.Equal(t, "CP001", entry.ChargePointId)
+		assert.False(t, entry.Time.IsZero())
+
+		msg, err := ParseMessage(entry.Frame)
+		require.NoError(t, err)
+		if i%2 == 0 {
+			assert.Equal(t, DirectionInbound, entry.Direction)
+			assert.Equal(t, MessageTypeCall, msg.TypeId)
+		} else {
+			assert.Equal(t, DirectionOutbound, entry.Direction)
+			assert.NotEqual(t, MessageTypeCall, msg.TypeId)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	entries := recordSession(t)
+
+	publisher := &RecordingPublisher{}
+	cs := NewCentralSystem()
+	cs.TransactionHistoryPublisher = publisher
+
+	results := cs.Replay(entries)
+	require.Len(t, results, 6)
+	for _, result := range results {
+		msg, _ := ParseMessage(result.Entry.Frame)
+		assert.True(t, result.Matches(), "Replayed %s response should match the recorded response", msg.Action)
+	}
+
+	require.Len(t, publisher.Transactions, 1)
+	transaction := publisher.LastTransaction()
+	assert.Equal(t, 4000, transaction.Energy)
+
+	// The recorded transaction ID is reused
+	recordedStart := Message{}
+	for _, result := range results {
+		if msg, _ := ParseMessage(result.Entry.Frame); msg.Action == ActionStartTransaction {
+			recordedStart = *result.Recorded
+		}
+	}
+	resp := StartTransactionResponse{}
+	require.NoError(t, json.Unmarshal(recordedStart.Payload, &resp))
+	assert.Equal(t, strconv.Itoa(resp.TransactionId), transaction.ID)
+}
+
+func TestReplayReusedUniqueIds(t *testing.T) {
+	at := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	entries := []JournalEntry{
+		{Time: at, ChargePointId: "CP001", Direction: DirectionInbound, Frame: json.RawMessage(`[2,"1","Heartbeat",{}]`)},
+		{Time: at, ChargePointId: "CP001", Direction: DirectionOutbound, Frame: json.RawMessage(`[3,"1",{"currentTime":"2025-06-01T18:00:00Z"}]`)},
+		// The charge point rebooted and started its IDs again
+		{Time: at.Add(time.Hour), ChargePointId: "CP001", Direction: DirectionInbound, Frame: json.RawMessage(`[2,"1","Heartbeat",{}]`)},
+		{Time: at.Add(time.Hour), ChargePointId: "CP001", Direction: DirectionOutbound, Frame: json.RawMessage(`[3,"1",{"currentTime":"2025-06-01T19:00:00Z"}]`)},
+	}
+
+	results := NewCentralSystem().Replay(entries)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Matches())
+	assert.True(t, results[1].Matches())
+}
@@ -0,0 +1,158 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/**
+* OCPP-J RPC framing.
+*
+* Every OCPP-J WebSocket frame is a JSON array whose first element is the
+* message type:
+*
+*	CALL:       [2, "<uniqueId>", "<action>", {payload}]
+*	CALLRESULT: [3, "<uniqueId>", {payload}]
+*	CALLERROR:  [4, "<uniqueId>", "<errorCode>", "<errorDescription>", {errorDetails}]
+ */
+
+// OCPP-J message type IDs
+const (
+	MessageTypeCall       = 2
+	MessageTypeCallResult = 3
+	MessageTypeCallError  = 4
+)
+
+// CALLERROR error codes
+const (
+	ErrorNotImplemented                = "NotImplemented"
+	ErrorNotSupported                  = "NotSupported"
+	ErrorInternalError                 = "InternalError"
+	ErrorProtocolError                 = "ProtocolError"
+	ErrorSecurityError                 = "SecurityError"
+	ErrorFormationViolation            = "FormationViolation"
+	ErrorPropertyConstraintViolation   = "PropertyConstraintViolation"
+	ErrorOccurrenceConstraintViolation = "OccurenceConstraintViolation" // Sic, as spelled in the OCPP-J spec
+	ErrorTypeConstraintViolation       = "TypeConstraintViolation"
+	ErrorGenericError                  = "GenericError"
+)
+
+// A single OCPP-J frame. Only the fields relevant to the message type are set.
+type Message struct {
+	TypeId   int
+	UniqueId string
+
+	// CALL only
+	Action string
+
+	// CALL and CALLRESULT
+	Payload json.RawMessage
+
+	// CALLERROR only
+	ErrorCode        string
+	ErrorDescription string
+	ErrorDetails     json.RawMessage
+}
+
+// An error to be returned to the charge point as a CALLERROR
+type CallError struct {
+	Code        string
+	Description string
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// Parse an OCPP-J frame. If the frame is malformed after the unique ID, the
+// returned message still carries the unique ID so a CALLERROR can be sent.
+func ParseMessage(data []byte) (Message, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Message{}, fmt.Errorf("malformed OCPP-J frame: %w", err)
+	}
+
+	if len(fields) < 3 {
+		return Message{}, fmt.Errorf("malformed OCPP-J frame: expected at least 3 elements, got %d", len(fields))
+	}
+
+	msg := Message{}
+	if err := json.Unmarshal(fields[0], &msg.TypeId); err != nil {
+		return Message{}, fmt.Errorf("malformed OCPP-J message type: %w", err)
+	}
+	if err := json.Unmarshal(fields[1], &msg.UniqueId); err != nil {
+		return Message{}, fmt.Errorf("malformed OCPP-J unique ID: %w", err)
+	}
+
+	switch msg.TypeId {
+	case MessageTypeCall:
+		if len(fields) != 4 {
+			return msg, fmt.Errorf("malformed CALL: expected 4 elements, got %d", len(fields))
+		}
+		if err := json.Unmarshal(fields[2], &msg.Action); err != nil {
+			return msg, fmt.Errorf("malformed CALL action: %w", err)
+		}
+		msg.Payload = fields[3]
+
+	case MessageTypeCallResult:
+		msg.Payload = fields[2]
+
+	case MessageTypeCallError:
+		if len(fields) != 5 {
+			return msg, fmt.Errorf("malformed CALLERROR: expected 5 elements, got %d", len(fields))
+		}
+		if err := json.Unmarshal(fields[2], &msg.ErrorCode); err != nil {
+			return msg, fmt.Errorf("malformed CALLERROR code: %w", err)
+		}
+		if err := json.Unmarshal(fields[3], &msg.ErrorDescription); err != nil {
+			return msg, fmt.Errorf("malformed CALLERROR description: %w", err)
+		}
+		msg.ErrorDetails = fields[4]
+
+	default:
+		return msg, fmt.Errorf("unknown OCPP-J message type %d", msg.TypeId)
+	}
+
+	return msg, nil
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	switch m.TypeId {
+	case MessageTypeCall:
+		return json.Marshal([]any{m.TypeId, m.UniqueId, m.Action, emptyObjectIfNil(m.Payload)})
+	case MessageTypeCallResult:
+		return json.Marshal([]any{m.TypeId, m.UniqueId, emptyObjectIfNil(m.Payload)})
+	case MessageTypeCallError:
+		return json.Marshal([]any{m.TypeId, m.UniqueId, m.ErrorCode, m.ErrorDescription, emptyObjectIfNil(m.ErrorDetails)})
+	}
+
+	return nil, fmt.Errorf("unknown OCPP-J message type %d", m.TypeId)
+}
+
+// Build a CALLRESULT frame for a call
+func NewCallResult(uniqueId string, payload any) (Message, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{TypeId: MessageTypeCallResult, UniqueId: uniqueId, Payload: data}, nil
+}
+
+// Build a CALLERROR frame for a call
+func NewCallError(uniqueId string, err *CallError) Message {
+	return Message{
+		TypeId:           MessageTypeCallError,
+		UniqueId:         uniqueId,
+		ErrorCode:        err.Code,
+		ErrorDescription: err.Description,
+	}
+}
+
+// OCPP-J requires payloads and error details to be objects, never null
+func emptyObjectIfNil(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("{}")
+	}
+	return raw
+}
@@ -0,0 +1,86 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCall(t *testing.T) {
+	msg, err := ParseMessage([]byte(`[2, "19223201", "BootNotification", {"chargePointVendor": "VendorX", "chargePointModel": "SingleSocketCharger"}]`))
+
+	require.NoError(t, err)
+	assert.Equal(t, MessageTypeCall, msg.TypeId)
+	assert.Equal(t, "19223201", msg.UniqueId)
+	assert.Equal(t, ActionBootNotification, msg.Action)
+	assert.JSONEq(t, `{"chargePointVendor": "VendorX", "chargePointModel": "SingleSocketCharger"}`, string(msg.Payload))
+}
+
+func TestParseCallResult(t *testing.T) {
+	msg, err := ParseMessage([]byte(`[3, "19223201", {"status": "Accepted"}]`))
+
+	require.NoError(t, err)
+	assert.Equal(t, MessageTypeCallResult, msg.TypeId)
+	assert.JSONEq(t, `{"status": "Accepted"}`, string(msg.Payload))
+}
+
+func TestParseCallError(t *testing.T) {
+	msg, err := ParseMessage([]byte(`[4, "19223201", "NotImplemented", "Unknown action", {}]`))
+
+	require.NoError(t, err)
+	assert.Equal(t, MessageTypeCallError, msg.TypeId)
+	assert.Equal(t, ErrorNotImplemented, msg.ErrorCode)
+	assert.Equal(t, "Unknown action", msg.ErrorDescription)
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    string
+		uniqueId string
+	}{
+		{"NotJSON", `not json`, ""},
+		{"NotArray", `{"foo": "bar"}`, ""},
+		{"TooShort", `[2, "1"]`, ""},
+		{"UnknownType", `[7, "1", {}]`, "1"},
+		{"CallMissingPayload", `[2, "1", "Heartbeat"]`, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ParseMessage([]byte(tt.frame))
+
+			require.Error(t, err)
+			assert.Equal(t, tt.uniqueId, msg.UniqueId)
+		})
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	frames := []Message{
+		{TypeId: MessageTypeCall, UniqueId: "1", Action: ActionHeartbeat, Payload: json.RawMessage(`{}`)},
+		{TypeId: MessageTypeCallResult, UniqueId: "1", Payload: json.RawMessage(`{"currentTime":"2025-01-01T00:00:00Z"}`)},
+		NewCallError("1", &CallError{Code: ErrorGenericError, Description: "oops"}),
+	}
+
+	for _, frame := range frames {
+		data, err := json.Marshal(frame)
+		require.NoError(t, err)
+
+		parsed, err := ParseMessage(data)
+		require.NoError(t, err)
+		assert.Equal(t, frame.TypeId, parsed.TypeId)
+		assert.Equal(t, frame.UniqueId, parsed.UniqueId)
+		assert.Equal(t, frame.Action, parsed.Action)
+		assert.Equal(t, frame.ErrorCode, parsed.ErrorCode)
+	}
+}
+
+func TestMarshalNilPayload(t *testing.T) {
+	data, err := json.Marshal(Message{TypeId: MessageTypeCallResult, UniqueId: "1"})
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `[3, "1", {}]`, string(data), "A nil payload should be sent as an empty object")
+}
@@ -0,0 +1,184 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Storage for the charging profiles active on each charge point, so we know
+// what limits are in force across restarts
+type ProfileStore interface {
+	// The active profiles for a charge point, keyed by connector ID
+	Profiles(chargePointId string) (map[int][]ChargingProfile, error)
+
+	// Record a profile the charge point accepted
+	SetProfile(chargePointId string, connectorId int, profile ChargingProfile) error
+
+	// Remove the profiles a ClearChargingProfile request cleared
+	ClearProfiles(chargePointId string, criteria ClearChargingProfileRequest) error
+}
+
+// Profiles by charge point ID, then connector ID
+type profileSet map[string]map[int][]ChargingProfile
+
+// Add a profile, replacing any profile with the same ID, or with the same
+// purpose and stack level on the same connector, as the charge point does
+func (s profileSet) set(chargePointId string, connectorId int, profile ChargingProfile) {
+	connectors, ok := s[chargePointId]
+	if !ok {
+		connectors = map[int][]ChargingProfile{}
+		s[chargePointId] = connectors
+	}
+
+	for id, profiles := range connectors {
+		connectors[id] = slices.DeleteFunc(profiles, func(p ChargingProfile) bool {
+			return p.ChargingProfileId == profile.ChargingProfileId ||
+				(id == connectorId && p.ChargingProfilePurpose == profile.ChargingProfilePurpose && p.StackLevel == profile.StackLevel)
+		})
+	}
+
+	connectors[connectorId] = append(connectors[connectorId], profile)
+}
+
+// Remove matching profiles. A profile ID matches on its own, otherwise a
+// profile must match all of the other criteria given.
+func (s profileSet) clear(chargePointId string, criteria ClearChargingProfileRequest) {
+	for id, profiles := range s[chargePointId] {
+		s[chargePointId][id] = slices.DeleteFunc(profiles, func(p ChargingProfile) bool {
+			if criteria.Id != nil {
+				return p.ChargingProfileId == *criteria.Id
+			}
+
+			return (criteria.ConnectorId == nil || *criteria.ConnectorId == id) &&
+				(criteria.ChargingProfilePurpose == "" || criteria.ChargingProfilePurpose == p.ChargingProfilePurpose) &&
+				(criteria.StackLevel == nil || *criteria.StackLevel == p.StackLevel)
+		})
+	}
+}
+
+func (s profileSet) profiles(chargePointId string) map[int][]ChargingProfile {
+	ret := map[int][]ChargingProfile{}
+	for id, profiles := range s[chargePointId] {
+		if len(profiles) > 0 {
+			ret[id] = slices.Clone(profiles)
+		}
+	}
+	return ret
+}
+
+// A ProfileStore that only lasts as long as the process
+type MemoryProfileStore struct {
+	mu  sync.Mutex
+	set profileSet
+}
+
+func NewMemoryProfileStore() *MemoryProfileStore {
+	return &MemoryProfileStore{set: profileSet{}}
+}
+
+func (m *MemoryProfileStore) Profiles(chargePointId string) (map[int][]ChargingProfile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.set.profiles(chargePointId), nil
+}
+
+func (m *MemoryProfileStore) SetProfile(chargePointId string, connectorId int, profile ChargingProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set.set(chargePointId, connectorId, profile)
+	return nil
+}
+
+func (m *MemoryProfileStore) ClearProfiles(chargePointId string, criteria ClearChargingProfileRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set.clear(chargePointId, criteria)
+	return nil
+}
+
+// A ProfileStore persisted to a JSON file, rewritten on every change
+type FileProfileStore struct {
+	Path string
+
+	mu  sync.Mutex
+	set profileSet
+}
+
+// Open a file profile store, loading any profiles already saved to it
+func NewFileProfileStore(path string) (*FileProfileStore, error) {
+	store := &FileProfileStore{Path: path, set: profileSet{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading charging profiles: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &store.set); err != nil {
+		return nil, fmt.Errorf("error parsing charging profiles from %s: %w", path, err)
+	}
+
+	return store, nil
+}
+
+func (f *FileProfileStore) Profiles(chargePointId string) (map[int][]ChargingProfile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.set.profiles(chargePointId), nil
+}
+
+func (f *FileProfileStore) SetProfile(chargePointId string, connectorId int, profile ChargingProfile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.set.set(chargePointId, connectorId, profile)
+	return f.save()
+}
+
+func (f *FileProfileStore) ClearProfiles(chargePointId string, criteria ClearChargingProfileRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.set.clear(chargePointId, criteria)
+	return f.save()
+}
+
+// Write the profiles to a temporary file and move it into place, so a crash
+// mid-write can't lose the existing profiles
+func (f *FileProfileStore) save() error {
+	data, err := json.MarshalIndent(f.set, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("error saving charging profiles: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving charging profiles: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving charging profiles: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return fmt.Errorf("error saving charging profiles: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package ocpp
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileReplacement(t *testing.T) {
+	store := NewMemoryProfileStore()
+
+	require.NoError(t, store.SetProfile("CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16))))
+	require.NoError(t, store.SetProfile("CP001", 1, NewTxDefaultProfile(2, 1, Limit(0, 10))))
+
+	// Same purpose and stack level on the same connector replaces
+	require.NoError(t, store.SetProfile("CP001", 1, NewTxDefaultProfile(3, 0, Limit(0, 12))))
+
+	// Same ID replaces, even on another connector
+	require.NoError(t, store.SetProfile("CP001", 0, NewTxDefaultProfile(2, 4, Limit(0, 8))))
+
+	profiles, err := store.Profiles("CP001")
+	require.NoError(t, err)
+	require.Len(t, profiles[1], 1)
+	assert.Equal(t, 3, profiles[1][0].ChargingProfileId)
+	require.Len(t, profiles[0], 1)
+	assert.Equal(t, 2, profiles[0][0].ChargingProfileId)
+
+	other, err := store.Profiles("CP002")
+	require.NoError(t, err)
+	assert.Empty(t, other, "Profiles should be kept per charge point")
+}
+
+func TestClearProfiles(t *testing.T) {
+	connectorOne := 1
+	stackLevel := 2
+	profileId := 3
+
+	tests := []struct {
+		name      string
+		criteria  ClearChargingProfileRequest
+		remaining []int
+	}{
+		{"All", ClearChargingProfileRequest{}, nil},
+		{"ById", ClearChargingProfileRequest{Id: &profileId}, []int{1, 2}},
+		{"ByConnector", ClearChargingProfileRequest{ConnectorId: &connectorOne}, []int{3}},
+		{"ByPurpose", ClearChargingProfileRequest{ChargingProfilePurpose: TxProfile}, []int{1, 3}},
+		{"ByConnectorAndStackLevel", ClearChargingProfileRequest{ConnectorId: &connectorOne, StackLevel: &stackLevel}, []int{1, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryProfileStore()
+			require.NoError(t, store.SetProfile("CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16))))
+			require.NoError(t, store.SetProfile("CP001", 1, NewTxProfile(2, 42, 2, Limit(0, 10))))
+			require.NoError(t, store.SetProfile("CP001", 0, NewTxDefaultProfile(3, 2, Limit(0, 32))))
+
+			require.NoError(t, store.ClearProfiles("CP001", tt.criteria))
+
+			profiles, err := store.Profiles("CP001")
+			require.NoError(t, err)
+
+			var remaining []int
+			for _, connector := range []int{0, 1} {
+				for _, profile := range profiles[connector] {
+					remaining = append(remaining, profile.ChargingProfileId)
+				}
+			}
+			assert.ElementsMatch(t, tt.remaining, remaining)
+		})
+	}
+}
+
+func TestFileProfileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+
+	store, err := NewFileProfileStore(path)
+	require.NoError(t, err, "A missing file should be treated as no profiles")
+
+	require.NoError(t, store.SetProfile("CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16))))
+	require.NoError(t, store.SetProfile("CP001", 1, NewTxProfile(2, 42, 1, Limit(0, 10))))
+	require.NoError(t, store.ClearProfiles("CP001", ClearChargingProfileRequest{ChargingProfilePurpose: TxProfile}))
+
+	reopened, err := NewFileProfileStore(path)
+	require.NoError(t, err)
+
+	profiles, err := reopened.Profiles("CP001")
+	require.NoError(t, err)
+	require.Len(t, profiles[1], 1)
+	assert.Equal(t, TxDefaultProfile, profiles[1][0].ChargingProfilePurpose)
+	assert.Equal(t, 16.0, profiles[1][0].ChargingSchedule.ChargingSchedulePeriod[0].Limit)
+}
@@ -0,0 +1,470 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+)
+
+/**
+* A transparent OCPP proxy.
+*
+* Charge points connect to the proxy as they would a central system, and every
+* frame is forwarded unchanged to and from the upstream central system (the
+* Grizzl-E Connect cloud), so the Connect app keeps working. The traffic is
+* tapped along the way and mapped onto the same connect.Station and
+* connect.Transaction models as the central system, and published as it
+* arrives: sessions a few meter values at a time, and the whole transaction
+* once it's finished.
+ */
+
+type Proxy struct {
+	// Base WebSocket URL of the upstream central system. Charge points are
+	// connected to <Upstream>/<charge point ID>.
+	Upstream string
+
+	StationStatusPublisher      StationStatusPublisher
+	TransactionHistoryPublisher TransactionHistoryPublisher
+	SessionPublisher            SessionPublisher
+
+	// Records every frame passed through, if set. Directions are relative to
+	// the upstream central system.
+	Journal Journal
+
+	// Source of the current time, replaceable in tests
+	Now func() time.Time
+
+	dialer *websocket.Dialer
+
+	mu           sync.Mutex
+	chargePoints map[string]*ChargePoint
+}
+
+func NewProxy(upstream string) *Proxy {
+	return &Proxy{
+		Upstream: strings.TrimSuffix(upstream, "/"),
+		Now:      time.Now,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 30 * time.Second,
+		},
+		chargePoints: map[string]*ChargePoint{},
+	}
+}
+
+// Get a charge point by ID, creating it if we haven't seen it before
+func (p *Proxy) ChargePoint(id string) *ChargePoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cp, ok := p.chargePoints[id]
+	if !ok {
+		cp = newChargePoint(id)
+		p.chargePoints[id] = cp
+	}
+
+	return cp
+}
+
+// Connect a charge point to the upstream central system and pass frames
+// between them until either side disconnects. The charge point ID is the last
+// element of the path.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+	if id == "" || id == "/" || id == "." {
+		http.Error(w, "missing charge point ID", http.StatusNotFound)
+		return
+	}
+
+	subprotocols := websocket.Subprotocols(r)
+	if !slices.ContainsFunc(subprotocols, isSupportedProtocol) {
+		http.Error(w, "unsupported OCPP version", http.StatusBadRequest)
+		return
+	}
+
+	// The charge point's credentials are for the upstream, not us
+	header := http.Header{}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		header.Set("Authorization", auth)
+	}
+
+	dialer := *p.dialer
+	dialer.Subprotocols = subprotocols
+	upstream, _, err := dialer.DialContext(r.Context(), p.Upstream+"/"+url.PathEscape(id), header)
+	if err != nil {
+		log.Printf("Error connecting charge point %s upstream: %v", id, err)
+		http.Error(w, "upstream central system unavailable", http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	// Agree to whatever the upstream agreed to
+	upgrader := websocket.Upgrader{}
+	if upstream.Subprotocol() != "" {
+		upgrader.Subprotocols = []string{upstream.Subprotocol()}
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading OCPP connection from %s: %v", id, err)
+		return
+	}
+	defer ws.Close()
+
+	log.Printf("Charge point %s connected using %s, proxying to %s", id, ws.Subprotocol(), p.Upstream)
+	cp := p.ChargePoint(id)
+	if ws.Subprotocol() != "" {
+		cp.setProtocol(ws.Subprotocol())
+	}
+	p.serve(cp, ws, upstream)
+	log.Printf("Charge point %s disconnected", id)
+}
+
+// A proxied charge point connection
+type proxySession struct {
+	cp *ChargePoint
+
+	mu sync.Mutex
+	// StartTransaction calls waiting for the upstream to assign a
+	// transaction ID, by unique ID
+	starts map[string]StartTransactionRequest
+}
+
+func (p *Proxy) serve(cp *ChargePoint, ws *websocket.Conn, upstream *websocket.Conn) {
+	session := &proxySession{
+		cp:     cp,
+		starts: map[string]StartTransactionRequest{},
+	}
+
+	// Publishing is queued, so forwarding never waits on a slow sink
+	publishes := newPublishQueue()
+	defer publishes.close()
+
+	online := cp.setOnline(true)
+	publishes.push(func() { p.publishStation(online) })
+	defer func() {
+		offline := cp.setOnline(false)
+		publishes.push(func() { p.publishStation(offline) })
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pump(cp, ws, upstream, DirectionInbound, publishes, func(msg Message) func() { return p.tapChargePoint(session, msg) })
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pump(cp, upstream, ws, DirectionOutbound, publishes, func(msg Message) func() { return p.tapUpstream(session, msg) })
+		done <- struct{}{}
+	}()
+
+	// Once either side goes away, take the other down with it
+	<-done
+	ws.Close()
+	upstream.Close()
+	<-done
+}
+
+// Forward frames from one side to the other until either fails. Each frame is
+// tapped before it's sent on, so the state it reports is in place before the
+// other side can respond to it. Publishing is left to the func the tap
+// returns, which is queued once the frame has been sent, so it doesn't hold up
+// the traffic.
+func (p *Proxy) pump(cp *ChargePoint, from *websocket.Conn, to *websocket.Conn, direction string, publishes *publishQueue, tap func(Message) func()) {
+	for {
+		messageType, data, err := from.ReadMessage()
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				to.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeErr.Code, closeErr.Text), time.Now().Add(time.Second))
+			} else if !isClosedConnError(err) {
+				log.Printf("Error reading %s frame for charge point %s: %v", direction, cp.ID, err)
+			}
+			return
+		}
+
+		// Journaled in the background too, so a slow journal doesn't hold up
+		// the traffic. It's queued before the frame is sent on, so a call is
+		// always journaled ahead of its response.
+		if p.Journal != nil {
+			at := p.Now()
+			publishes.push(func() { recordFrame(p.Journal, at, cp, direction, data) })
+		}
+
+		var publish func()
+		if msg, err := ParseMessage(data); err != nil {
+			log.Printf("Error parsing %s frame for charge point %s: %v", direction, cp.ID, err)
+		} else {
+			publish = tap(msg)
+		}
+
+		if err := to.WriteMessage(messageType, data); err != nil {
+			if !isClosedConnError(err) {
+				log.Printf("Error forwarding %s frame for charge point %s: %v", direction, cp.ID, err)
+			}
+			return
+		}
+
+		if publish != nil {
+			publishes.push(publish)
+		}
+	}
+}
+
+// Runs publishes one at a time, in the order they're pushed, on a goroutine
+// of its own. The queue isn't bounded, as dropping a publish would lose a
+// status change or a finished transaction.
+type publishQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	closed  bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func newPublishQueue() *publishQueue {
+	q := &publishQueue{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *publishQueue) push(publish func()) {
+	q.mu.Lock()
+	q.pending = append(q.pending, publish)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run anything still queued, then stop
+func (q *publishQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	<-q.done
+}
+
+func (q *publishQueue) run() {
+	defer close(q.done)
+
+	for {
+		q.mu.Lock()
+		pending, closed := q.pending, q.closed
+		q.pending = nil
+		q.mu.Unlock()
+
+		for _, publish := range pending {
+			publish()
+		}
+		if len(pending) == 0 {
+			if closed {
+				return
+			}
+			<-q.wake
+		}
+	}
+}
+
+// Reading from or writing to a connection the other pump has closed
+func isClosedConnError(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
+
+// Track the state reported in calls from the charge point
+func (p *Proxy) tapChargePoint(s *proxySession, msg Message) func() {
+	if msg.TypeId != MessageTypeCall {
+		return nil
+	}
+
+	cp := s.cp
+	if cp.protocol() == SubprotocolOCPP201 {
+		return p.tapChargePoint201(cp, msg)
+	}
+
+	switch msg.Action {
+	case ActionBootNotification:
+		if req, ok := decodeTapped[BootNotificationRequest](cp, msg); ok {
+			station := cp.boot(req)
+			return func() { p.publishStation(station) }
+		}
+
+	case ActionHeartbeat:
+		cp.heartbeat(p.Now().UTC())
+
+	case ActionStatusNotification:
+		if req, ok := decodeTapped[StatusNotificationRequest](cp, msg); ok {
+			station := cp.updateStatus(req)
+			return func() { p.publishStation(station) }
+		}
+
+	case ActionStartTransaction:
+		// The transaction ID comes from the upstream's response
+		if req, ok := decodeTapped[StartTransactionRequest](cp, msg); ok {
+			s.mu.Lock()
+			s.starts[msg.UniqueId] = req
+			s.mu.Unlock()
+		}
+
+	case ActionMeterValues:
+		req, ok := decodeTapped[MeterValuesRequest](cp, msg)
+		if !ok || req.TransactionId == nil {
+			return nil
+		}
+		if progress, ok := cp.addMeterValues(*req.TransactionId, req.MeterValue); ok {
+			return func() { p.publishSession(cp, progress) }
+		}
+
+	case ActionStopTransaction:
+		if req, ok := decodeTapped[StopTransactionRequest](cp, msg); ok {
+			update := cp.stopTransaction(req)
+			return func() { p.publishUpdate(cp, update) }
+		}
+	}
+
+	return nil
+}
+
+// OCPP 2.0.1 charging stations assign their own transaction IDs, so
+// everything can be picked up from their calls
+func (p *Proxy) tapChargePoint201(cp *ChargePoint, msg Message) func() {
+	switch msg.Action {
+	case ActionBootNotification:
+		if req, ok := decodeTapped[BootNotificationRequest201](cp, msg); ok {
+			station := cp.boot(req.asOCPP16())
+			return func() { p.publishStation(station) }
+		}
+
+	case ActionHeartbeat:
+		cp.heartbeat(p.Now().UTC())
+
+	case ActionStatusNotification:
+		if req, ok := decodeTapped[StatusNotificationRequest201](cp, msg); ok {
+			station := cp.setConnectorStatus(req.EvseId, status201To16(req.ConnectorStatus))
+			return func() { p.publishStation(station) }
+		}
+
+	case ActionTransactionEvent:
+		req, ok := decodeTapped[TransactionEventRequest](cp, msg)
+		if !ok {
+			return nil
+		}
+		update := cp.transactionEvent(req)
+		var station *connect.Station
+		if req.Evse != nil && req.TransactionInfo.ChargingState != "" {
+			updated := cp.setConnectorStatus(req.Evse.Id, status201To16(req.TransactionInfo.ChargingState))
+			station = &updated
+		}
+		return func() {
+			p.publishUpdate(cp, update)
+			if station != nil {
+				p.publishStation(*station)
+			}
+		}
+
+	case ActionNotifyEvent:
+		if req, ok := decodeTapped[NotifyEventRequest](cp, msg); ok {
+			station := cp.applyEvents(req.EventData)
+			return func() { p.publishStation(station) }
+		}
+
+	case ActionNotifyReport:
+		if req, ok := decodeTapped[NotifyReportRequest](cp, msg); ok {
+			station := cp.applyReport(req.ReportData)
+			return func() { p.publishStation(station) }
+		}
+	}
+
+	return nil
+}
+
+// Pick up the transaction IDs the upstream assigns
+func (p *Proxy) tapUpstream(s *proxySession, msg Message) func() {
+	if msg.TypeId == MessageTypeCall {
+		return nil
+	}
+
+	s.mu.Lock()
+	req, ok := s.starts[msg.UniqueId]
+	delete(s.starts, msg.UniqueId)
+	s.mu.Unlock()
+
+	if !ok || msg.TypeId != MessageTypeCallResult {
+		return nil
+	}
+
+	resp := StartTransactionResponse{}
+	if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+		log.Printf("Error parsing upstream StartTransaction response for charge point %s: %v", s.cp.ID, err)
+		return nil
+	}
+
+	log.Printf("Charge point %s started transaction %d on connector %d: tag %s", s.cp.ID, resp.TransactionId, req.ConnectorId, resp.IdTagInfo.Status)
+	transaction := s.cp.startTransaction(resp.TransactionId, req)
+	return func() { p.publishSession(s.cp, transaction) }
+}
+
+func decodeTapped[Req any](cp *ChargePoint, msg Message) (Req, bool) {
+	var req Req
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		log.Printf("Error parsing %s from charge point %s: %v", msg.Action, cp.ID, err)
+		return req, false
+	}
+
+	return req, true
+}
+
+func (p *Proxy) publishStation(station connect.Station) {
+	if p.StationStatusPublisher != nil {
+		p.StationStatusPublisher.PublishStationStatus(station)
+	}
+}
+
+func (p *Proxy) publishTransaction(cp *ChargePoint, transaction connect.Transaction) {
+	if p.TransactionHistoryPublisher == nil {
+		return
+	}
+
+	err := p.TransactionHistoryPublisher.PublishTransactionHistory(cp.ID, transaction)
+	if err != nil {
+		log.Printf("Error publishing transaction %s from charge point %s: %v", transaction.ID, cp.ID, err)
+	}
+}
+
+func (p *Proxy) publishSession(cp *ChargePoint, progress connect.Transaction) {
+	if p.SessionPublisher == nil {
+		return
+	}
+
+	err := p.SessionPublisher.PublishSessionProgress(cp.ID, progress)
+	if err != nil {
+		log.Printf("Error publishing session %s from charge point %s: %v", progress.ID, cp.ID, err)
+	}
+}
+
+func (p *Proxy) publishUpdate(cp *ChargePoint, update transactionUpdate) {
+	p.publishSession(cp, update.Progress)
+	if update.Finished != nil {
+		p.publishTransaction(cp, *update.Finished)
+	}
+}
@@ -0,0 +1,234 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A proxy in front of a central system standing in for the Connect cloud
+func newTestProxy(t *testing.T) (*Proxy, *RecordingPublisher, *httptest.Server, *CentralSystem, *RecordingJournal) {
+	upstream := NewCentralSystem()
+	upstreamJournal := &RecordingJournal{}
+	upstream.Journal = upstreamJournal
+	upstreamServer := httptest.NewServer(upstream)
+	t.Cleanup(upstreamServer.Close)
+
+	publisher := &RecordingPublisher{}
+	proxy := NewProxy("ws" + strings.TrimPrefix(upstreamServer.URL, "http") + "/ocpp/")
+	proxy.StationStatusPublisher = publisher
+	proxy.TransactionHistoryPublisher = publisher
+	proxy.SessionPublisher = publisher
+
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+
+	return proxy, publisher, server, upstream, upstreamJournal
+}
+
+func TestProxyChargingSession(t *testing.T) {
+	_, publisher, server, upstream, upstreamJournal := newTestProxy(t)
+	sim := NewChargePointSimulator(t, server, "CP001")
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	boot := BootNotificationResponse{}
+	sim.Call(ActionBootNotification, BootNotificationRequest{ChargePointVendor: "United Chargers", ChargePointModel: "Grizzl-E Smart"}, &boot)
+	assert.Equal(t, RegistrationAccepted, boot.Status)
+
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 1, Status: "Charging", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+	require.Eventually(t, func() bool {
+		station := publisher.LastStation()
+		return len(station.Connectors) == 1 && station.Connectors[0].Status == "Charging"
+	}, time.Second, 10*time.Millisecond, "Status should be published as it's reported")
+
+	// The upstream assigns the transaction ID
+	started := StartTransactionResponse{}
+	sim.Call(ActionStartTransaction, StartTransactionRequest{ConnectorId: 1, IdTag: "TAG1", MeterStart: 1000, Timestamp: start}, &started)
+	_, ok := upstream.ChargePoint("CP001").Transaction(started.TransactionId)
+	require.True(t, ok, "Upstream should have started the transaction")
+
+	sim.Call(ActionMeterValues, MeterValuesRequest{
+		ConnectorId:   1,
+		TransactionId: &started.TransactionId,
+		MeterValue: []MeterValue{{
+			Timestamp:    start.Add(30 * time.Minute),
+			SampledValue: []SampledValue{{Value: "2500", Measurand: MeasurandEnergyActiveImportRegister, Unit: "Wh"}},
+		}},
+	}, &MeterValuesResponse{})
+	require.Eventually(t, func() bool {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		for _, progress := range publisher.Sessions {
+			if len(progress.MeterValues.Date) == 1 && progress.StopAt == "" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond, "Meter values should be published before the transaction stops")
+
+	sim.Call(ActionStopTransaction, StopTransactionRequest{TransactionId: started.TransactionId, MeterStop: 5000, Timestamp: start.Add(time.Hour)}, &StopTransactionResponse{})
+	require.Eventually(t, func() bool {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		return len(publisher.Transactions) == 1
+	}, time.Second, 10*time.Millisecond, "Transaction should be published once it stops")
+
+	transaction := publisher.LastTransaction()
+	assert.Len(t, transaction.MeterValues.Date, 1)
+	assert.Empty(t, publisher.LastSession().MeterValues.Date, "Meter values already published shouldn't be published to the session again")
+	assert.Equal(t, 4000, transaction.Energy)
+	assert.Equal(t, strconv.Itoa(started.TransactionId), transaction.ID, "Proxy should use the upstream's transaction ID")
+
+	sim.Close()
+	require.Eventually(t, func() bool {
+		return !publisher.LastStation().Online
+	}, time.Second, 10*time.Millisecond, "Station should be published offline once it disconnects")
+
+	// Every frame reached the upstream as the charge point sent it
+	var inbound []string
+	for _, entry := range upstreamJournal.Snapshot() {
+		if entry.Direction == DirectionInbound {
+			inbound = append(inbound, string(entry.Frame))
+		}
+	}
+	require.Len(t, inbound, 5)
+	assert.Contains(t, inbound[0], `"sim-1","BootNotification"`)
+	assert.Contains(t, inbound[4], `"sim-5","StopTransaction"`)
+}
+
+func TestProxyUpstreamCalls(t *testing.T) {
+	_, _, server, upstream, _ := newTestProxy(t)
+	sim := NewChargePointSimulator(t, server, "CP001")
+
+	var set SetChargingProfileRequest
+	sim.Handle(ActionSetChargingProfile, func(payload json.RawMessage) any {
+		_ = json.Unmarshal(payload, &set)
+		return SetChargingProfileResponse{Status: ChargingProfileAccepted}
+	})
+
+	// Calls from the upstream only work once it knows the charge point is
+	// connected
+	sim.Call(ActionHeartbeat, HeartbeatRequest{}, &HeartbeatResponse{})
+
+	err := upstream.SetChargingProfile(context.Background(), "CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16)))
+	require.NoError(t, err, "Upstream calls should reach the charge point")
+	assert.Equal(t, 1, set.ConnectorId)
+}
+
+func TestProxyUpstreamUnavailable(t *testing.T) {
+	proxy := NewProxy("ws://127.0.0.1:1/ocpp")
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{SubprotocolOCPP16}}
+	_, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ocpp/CP001", nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestProxyOCPP201(t *testing.T) {
+	_, publisher, server, _, _ := newTestProxy(t)
+	sim := NewChargingStationSimulator(t, server, "CS001")
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	sim.Call(ActionTransactionEvent, TransactionEventRequest{
+		EventType:       TransactionEventStarted,
+		Timestamp:       start,
+		TriggerReason:   "Authorized",
+		TransactionInfo: TransactionInfo{TransactionId: "tx-0001", ChargingState: "Charging"},
+		Evse:            &EVSE{Id: 1},
+		MeterValue:      []MeterValue201{energySample(start, 1000)},
+	}, &TransactionEventResponse{})
+
+	require.Eventually(t, func() bool {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		return len(publisher.Sessions) == 1 && len(publisher.Stations) == 2
+	}, time.Second, 10*time.Millisecond, "Session and connector status should be published")
+
+	transaction := publisher.LastSession()
+	assert.Equal(t, "tx-0001", transaction.ID)
+	assert.Equal(t, 1000, transaction.MeterStart)
+	assert.Equal(t, "Charging", publisher.LastStation().Connectors[0].Status)
+}
+
+// A station status sink that blocks until it's released
+type blockingStatusPublisher struct {
+	release chan struct{}
+	*RecordingPublisher
+}
+
+func (p blockingStatusPublisher) PublishStationStatus(station connect.Station) {
+	<-p.release
+	p.RecordingPublisher.PublishStationStatus(station)
+}
+
+func TestProxySlowPublisher(t *testing.T) {
+	proxy, publisher, server, _, _ := newTestProxy(t)
+	release := make(chan struct{})
+	proxy.StationStatusPublisher = blockingStatusPublisher{release, publisher}
+	sim := NewChargePointSimulator(t, server, "CP001")
+
+	// Frames keep flowing while the sink is stuck
+	sim.Call(ActionBootNotification, BootNotificationRequest{ChargePointVendor: "United Chargers", ChargePointModel: "Grizzl-E Smart"}, &BootNotificationResponse{})
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 1, Status: "Preparing", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 1, Status: "Charging", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+
+	close(release)
+	require.Eventually(t, func() bool {
+		publisher.mu.Lock()
+		defer publisher.mu.Unlock()
+		return len(publisher.Stations) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	// Published in the order they were reported
+	assert.Equal(t, "Charging", publisher.LastStation().Connectors[0].Status)
+	sim.Close()
+}
+
+// A journal that blocks until it's released
+type blockingJournal struct {
+	release chan struct{}
+	*RecordingJournal
+}
+
+func (j blockingJournal) Record(entry JournalEntry) error {
+	<-j.release
+	return j.RecordingJournal.Record(entry)
+}
+
+func TestProxySlowJournal(t *testing.T) {
+	proxy, _, server, _, _ := newTestProxy(t)
+	release := make(chan struct{})
+	journal := &RecordingJournal{}
+	proxy.Journal = blockingJournal{release, journal}
+	sim := NewChargePointSimulator(t, server, "CP001")
+
+	// Frames keep flowing while the journal is stuck
+	sim.Call(ActionBootNotification, BootNotificationRequest{ChargePointVendor: "United Chargers", ChargePointModel: "Grizzl-E Smart"}, &BootNotificationResponse{})
+	sim.Call(ActionHeartbeat, HeartbeatRequest{}, &HeartbeatResponse{})
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return len(journal.Snapshot()) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	// Each call is journaled ahead of its response
+	entries := journal.Snapshot()
+	for i, direction := range []string{DirectionInbound, DirectionOutbound, DirectionInbound, DirectionOutbound} {
+		assert.Equal(t, direction, entries[i].Direction)
+	}
+	sim.Close()
+}
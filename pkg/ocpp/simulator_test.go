@@ -0,0 +1,232 @@
+package ocpp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/speshak/grizzl-e-monitor/pkg/connect"
+	"github.com/stretchr/testify/require"
+)
+
+// Handles a call from the central system, returning the CALLRESULT payload
+type SimulatorHandler func(payload json.RawMessage) any
+
+// A minimal OCPP 1.6J charge point for exercising the central system
+type ChargePointSimulator struct {
+	t      *testing.T
+	ws     *websocket.Conn
+	nextId int
+
+	writeMu   sync.Mutex
+	mu        sync.Mutex
+	handlers  map[string]SimulatorHandler
+	responses chan Message
+}
+
+// Connect a simulated charge point to a central system test server
+func NewChargePointSimulator(t *testing.T, server *httptest.Server, id string) *ChargePointSimulator {
+	return newSimulator(t, server, id, SubprotocolOCPP16)
+}
+
+// Connect a simulated OCPP 2.0.1 charging station to a central system test
+// server
+func NewChargingStationSimulator(t *testing.T, server *httptest.Server, id string) *ChargePointSimulator {
+	return newSimulator(t, server, id, SubprotocolOCPP201)
+}
+
+func newSimulator(t *testing.T, server *httptest.Server, id string, protocol string) *ChargePointSimulator {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ocpp/" + id
+	dialer := websocket.Dialer{Subprotocols: []string{protocol}}
+
+	ws, resp, err := dialer.Dial(url, nil)
+	require.NoError(t, err, "Charge point should connect")
+	require.Equal(t, protocol, resp.Header.Get("Sec-WebSocket-Protocol"), "Central system should accept %s", protocol)
+
+	s := &ChargePointSimulator{
+		t:         t,
+		ws:        ws,
+		handlers:  map[string]SimulatorHandler{},
+		responses: make(chan Message, 1),
+	}
+	go s.readLoop()
+
+	return s
+}
+
+// Respond to calls for an action from the central system
+func (s *ChargePointSimulator) Handle(action string, handler SimulatorHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[action] = handler
+}
+
+// Answer calls from the central system, and pass responses to our own calls
+// back to SendFrame
+func (s *ChargePointSimulator) readLoop() {
+	defer close(s.responses)
+
+	for {
+		_, data, err := s.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg, err := ParseMessage(data)
+		if err != nil {
+			continue
+		}
+
+		if msg.TypeId != MessageTypeCall {
+			s.responses <- msg
+			continue
+		}
+
+		s.mu.Lock()
+		handler, ok := s.handlers[msg.Action]
+		s.mu.Unlock()
+
+		response := NewCallError(msg.UniqueId, &CallError{Code: ErrorNotImplemented})
+		if ok {
+			response, _ = NewCallResult(msg.UniqueId, handler(msg.Payload))
+		}
+		_ = s.write(response)
+	}
+}
+
+func (s *ChargePointSimulator) write(frame any) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.ws.WriteJSON(frame)
+}
+
+// Send a CALL and wait for its response frame
+func (s *ChargePointSimulator) Send(action string, request any) Message {
+	s.nextId++
+	payload, err := json.Marshal(request)
+	require.NoError(s.t, err)
+
+	return s.SendFrame(Message{
+		TypeId:   MessageTypeCall,
+		UniqueId: fmt.Sprintf("sim-%d", s.nextId),
+		Action:   action,
+		Payload:  payload,
+	})
+}
+
+// Send a raw frame and wait for the response frame
+func (s *ChargePointSimulator) SendFrame(frame any) Message {
+	require.NoError(s.t, s.write(frame))
+
+	select {
+	case msg, ok := <-s.responses:
+		require.True(s.t, ok, "Connection closed waiting for a response")
+		return msg
+	case <-time.After(5 * time.Second):
+		require.FailNow(s.t, "Timed out waiting for a response")
+		return Message{}
+	}
+}
+
+// Send a CALL and decode the CALLRESULT payload into response
+func (s *ChargePointSimulator) Call(action string, request any, response any) {
+	msg := s.Send(action, request)
+	require.Equal(s.t, MessageTypeCallResult, msg.TypeId, "%s should succeed, got %s: %s", action, msg.ErrorCode, msg.ErrorDescription)
+	require.NoError(s.t, json.Unmarshal(msg.Payload, response))
+}
+
+func (s *ChargePointSimulator) Close() {
+	s.writeMu.Lock()
+	_ = s.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	s.writeMu.Unlock()
+	_ = s.ws.Close()
+}
+
+// Publishers that record everything they're given
+type RecordingPublisher struct {
+	mu           sync.Mutex
+	Stations     []connect.Station
+	Transactions []connect.Transaction
+	Sessions     []connect.Transaction
+	Limits       map[string]float64
+
+	Authorizations []AuthorizationEvent
+}
+
+func (p *RecordingPublisher) PublishStationStatus(station connect.Station) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Stations = append(p.Stations, station)
+}
+
+func (p *RecordingPublisher) PublishTransactionHistory(stationId string, transaction connect.Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Transactions = append(p.Transactions, transaction)
+	return nil
+}
+
+func (p *RecordingPublisher) PublishSessionProgress(stationId string, transaction connect.Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Sessions = append(p.Sessions, transaction)
+	return nil
+}
+
+func (p *RecordingPublisher) PublishChargingLimit(stationId string, connectorId int, limitAmps float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Limits == nil {
+		p.Limits = map[string]float64{}
+	}
+	p.Limits[fmt.Sprintf("%s/%d", stationId, connectorId)] = limitAmps
+}
+
+func (p *RecordingPublisher) ClearChargingLimit(stationId string, connectorId int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.Limits, fmt.Sprintf("%s/%d", stationId, connectorId))
+}
+
+func (p *RecordingPublisher) PublishAuthorization(event AuthorizationEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Authorizations = append(p.Authorizations, event)
+}
+
+func (p *RecordingPublisher) LastAuthorization() AuthorizationEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Authorizations[len(p.Authorizations)-1]
+}
+
+// The published limit for a connector, if there is one
+func (p *RecordingPublisher) Limit(stationId string, connectorId int) (float64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	limit, ok := p.Limits[fmt.Sprintf("%s/%d", stationId, connectorId)]
+	return limit, ok
+}
+
+func (p *RecordingPublisher) LastStation() connect.Station {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Stations[len(p.Stations)-1]
+}
+
+func (p *RecordingPublisher) LastTransaction() connect.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Transactions[len(p.Transactions)-1]
+}
+
+func (p *RecordingPublisher) LastSession() connect.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Sessions[len(p.Sessions)-1]
+}
@@ -0,0 +1,392 @@
+package ocpp
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+/**
+* OCPP 1.6J Smart Charging profile messages, sent by the central system to
+* limit how much current a charge point offers.
+*
+* A charge point combines its profiles into a single limit: the highest stack
+* level TxProfile for the running transaction wins, falling back to the
+* highest stack level TxDefaultProfile, and the result is capped by any
+* ChargePointMaxProfile.
+ */
+
+// Smart Charging actions initiated by the central system
+const (
+	ActionSetChargingProfile   = "SetChargingProfile"
+	ActionClearChargingProfile = "ClearChargingProfile"
+	ActionGetCompositeSchedule = "GetCompositeSchedule"
+)
+
+// ChargingProfile purposes
+const (
+	ChargePointMaxProfile = "ChargePointMaxProfile"
+	TxDefaultProfile      = "TxDefaultProfile"
+	TxProfile             = "TxProfile"
+)
+
+// ChargingProfile kinds
+const (
+	ChargingProfileKindAbsolute  = "Absolute"
+	ChargingProfileKindRecurring = "Recurring"
+	ChargingProfileKindRelative  = "Relative"
+)
+
+// Recurrency kinds for Recurring profiles
+const (
+	RecurrencyKindDaily  = "Daily"
+	RecurrencyKindWeekly = "Weekly"
+)
+
+// ChargingSchedule rate units
+const (
+	ChargingRateUnitAmps  = "A"
+	ChargingRateUnitWatts = "W"
+)
+
+// SetChargingProfile.conf status values
+const (
+	ChargingProfileAccepted     = "Accepted"
+	ChargingProfileRejected     = "Rejected"
+	ChargingProfileNotSupported = "NotSupported"
+)
+
+// ClearChargingProfile.conf status values
+const (
+	ClearChargingProfileAccepted = "Accepted"
+	ClearChargingProfileUnknown  = "Unknown"
+)
+
+// GetCompositeSchedule.conf status values
+const (
+	GetCompositeScheduleAccepted = "Accepted"
+	GetCompositeScheduleRejected = "Rejected"
+)
+
+// Number of phases when a schedule period doesn't say. OCPP assumes 3, but
+// Grizzl-Es are single phase, across the split-phase nominal voltage.
+const defaultNumberPhases = 1
+
+var ErrRejected = errors.New("rejected by charge point")
+
+type ChargingProfile struct {
+	ChargingProfileId      int              `json:"chargingProfileId"`
+	TransactionId          *int             `json:"transactionId,omitempty"`
+	StackLevel             int              `json:"stackLevel"`
+	ChargingProfilePurpose string           `json:"chargingProfilePurpose"`
+	ChargingProfileKind    string           `json:"chargingProfileKind"`
+	RecurrencyKind         string           `json:"recurrencyKind,omitempty"`
+	ValidFrom              *time.Time       `json:"validFrom,omitempty"`
+	ValidTo                *time.Time       `json:"validTo,omitempty"`
+	ChargingSchedule       ChargingSchedule `json:"chargingSchedule"`
+}
+
+type ChargingSchedule struct {
+	Duration               *int                     `json:"duration,omitempty"`
+	StartSchedule          *time.Time               `json:"startSchedule,omitempty"`
+	ChargingRateUnit       string                   `json:"chargingRateUnit"`
+	ChargingSchedulePeriod []ChargingSchedulePeriod `json:"chargingSchedulePeriod"`
+	MinChargingRate        *float64                 `json:"minChargingRate,omitempty"`
+}
+
+type ChargingSchedulePeriod struct {
+	// Seconds from the start of the schedule
+	StartPeriod  int     `json:"startPeriod"`
+	Limit        float64 `json:"limit"`
+	NumberPhases *int    `json:"numberPhases,omitempty"`
+}
+
+type SetChargingProfileRequest struct {
+	ConnectorId        int             `json:"connectorId"`
+	CsChargingProfiles ChargingProfile `json:"csChargingProfiles"`
+}
+
+type SetChargingProfileResponse struct {
+	Status string `json:"status"`
+}
+
+// Every field is optional; profiles matching all of the fields given are
+// cleared
+type ClearChargingProfileRequest struct {
+	Id                     *int   `json:"id,omitempty"`
+	ConnectorId            *int   `json:"connectorId,omitempty"`
+	ChargingProfilePurpose string `json:"chargingProfilePurpose,omitempty"`
+	StackLevel             *int   `json:"stackLevel,omitempty"`
+}
+
+type ClearChargingProfileResponse struct {
+	Status string `json:"status"`
+}
+
+type GetCompositeScheduleRequest struct {
+	ConnectorId      int    `json:"connectorId"`
+	Duration         int    `json:"duration"`
+	ChargingRateUnit string `json:"chargingRateUnit,omitempty"`
+}
+
+type GetCompositeScheduleResponse struct {
+	Status           string            `json:"status"`
+	ConnectorId      *int              `json:"connectorId,omitempty"`
+	ScheduleStart    *time.Time        `json:"scheduleStart,omitempty"`
+	ChargingSchedule *ChargingSchedule `json:"chargingSchedule,omitempty"`
+}
+
+// A schedule period limiting the charge rate from offset after the start of
+// the schedule
+func Limit(offset time.Duration, limit float64) ChargingSchedulePeriod {
+	return ChargingSchedulePeriod{StartPeriod: int(offset.Seconds()), Limit: limit}
+}
+
+// A TxDefaultProfile, applying to every transaction on the connector it is set
+// on, limited in amps. The schedule starts when each transaction starts.
+func NewTxDefaultProfile(profileId int, stackLevel int, periods ...ChargingSchedulePeriod) ChargingProfile {
+	return ChargingProfile{
+		ChargingProfileId:      profileId,
+		StackLevel:             stackLevel,
+		ChargingProfilePurpose: TxDefaultProfile,
+		ChargingProfileKind:    ChargingProfileKindRelative,
+		ChargingSchedule: ChargingSchedule{
+			ChargingRateUnit:       ChargingRateUnitAmps,
+			ChargingSchedulePeriod: periods,
+		},
+	}
+}
+
+// A TxDefaultProfile repeating every day, limited in amps. Periods are offsets
+// from the time of day of start, e.g. to limit current during peak hours.
+func NewDailyTxDefaultProfile(profileId int, stackLevel int, start time.Time, periods ...ChargingSchedulePeriod) ChargingProfile {
+	profile := NewTxDefaultProfile(profileId, stackLevel, periods...)
+	profile.ChargingProfileKind = ChargingProfileKindRecurring
+	profile.RecurrencyKind = RecurrencyKindDaily
+	profile.ChargingSchedule.StartSchedule = &start
+
+	return profile
+}
+
+// A TxProfile, applying only to the given running transaction, limited in
+// amps. The schedule starts when the transaction started.
+func NewTxProfile(profileId int, transactionId int, stackLevel int, periods ...ChargingSchedulePeriod) ChargingProfile {
+	return ChargingProfile{
+		ChargingProfileId:      profileId,
+		TransactionId:          &transactionId,
+		StackLevel:             stackLevel,
+		ChargingProfilePurpose: TxProfile,
+		ChargingProfileKind:    ChargingProfileKindRelative,
+		ChargingSchedule: ChargingSchedule{
+			ChargingRateUnit:       ChargingRateUnitAmps,
+			ChargingSchedulePeriod: periods,
+		},
+	}
+}
+
+// The schedule period in force at a time, if the profile is active then.
+// Relative schedules start at relativeStart: when the transaction started, or
+// now if there's no transaction, so the first period applies.
+func (p ChargingProfile) periodAt(at time.Time, relativeStart time.Time) (ChargingSchedulePeriod, bool) {
+	if p.ValidFrom != nil && at.Before(*p.ValidFrom) {
+		return ChargingSchedulePeriod{}, false
+	}
+	if p.ValidTo != nil && !at.Before(*p.ValidTo) {
+		return ChargingSchedulePeriod{}, false
+	}
+
+	start := relativeStart
+	if p.ChargingProfileKind != ChargingProfileKindRelative && p.ChargingSchedule.StartSchedule != nil {
+		start = *p.ChargingSchedule.StartSchedule
+	}
+
+	elapsed := at.Sub(start)
+	if elapsed < 0 {
+		return ChargingSchedulePeriod{}, false
+	}
+
+	if p.ChargingProfileKind == ChargingProfileKindRecurring {
+		cycle := 24 * time.Hour
+		if p.RecurrencyKind == RecurrencyKindWeekly {
+			cycle = 7 * 24 * time.Hour
+		}
+		elapsed %= cycle
+	}
+
+	if p.ChargingSchedule.Duration != nil && elapsed >= time.Duration(*p.ChargingSchedule.Duration)*time.Second {
+		return ChargingSchedulePeriod{}, false
+	}
+
+	var period ChargingSchedulePeriod
+	found := false
+	for _, candidate := range p.ChargingSchedule.ChargingSchedulePeriod {
+		if time.Duration(candidate.StartPeriod)*time.Second <= elapsed && (!found || candidate.StartPeriod >= period.StartPeriod) {
+			period = candidate
+			found = true
+		}
+	}
+
+	return period, found
+}
+
+// The profile's limit at a time in amps. Power limits are converted using the
+// nominal voltage and the period's number of phases.
+func (p ChargingProfile) limitAt(at time.Time, relativeStart time.Time, voltage float64) (float64, bool) {
+	period, ok := p.periodAt(at, relativeStart)
+	if !ok {
+		return 0, false
+	}
+
+	if p.ChargingSchedule.ChargingRateUnit != ChargingRateUnitWatts {
+		return period.Limit, true
+	}
+
+	phases := defaultNumberPhases
+	if period.NumberPhases != nil {
+		phases = *period.NumberPhases
+	}
+
+	return period.Limit / (voltage * float64(phases)), true
+}
+
+// Work out the current limit a connector is offering at a time from the
+// charge point's profiles, keyed by connector ID, the way the charge point
+// would. transaction is the transaction running on the connector, if any.
+func effectiveLimit(profiles map[int][]ChargingProfile, connectorId int, transaction *activeTransaction, at time.Time, voltage float64) (float64, bool) {
+	relativeStart := at
+	if transaction != nil {
+		relativeStart = transaction.startAt
+	}
+
+	// The highest stack level active profile of a purpose. Connector specific
+	// profiles override charge point wide (connector 0) ones at the same level.
+	highest := func(purpose string, connectors ...int) (float64, bool) {
+		var candidates []ChargingProfile
+		for _, id := range connectors {
+			for _, profile := range profiles[id] {
+				if profile.ChargingProfilePurpose != purpose {
+					continue
+				}
+				if purpose == TxProfile && (transaction == nil || profile.TransactionId == nil || *profile.TransactionId != transaction.id) {
+					continue
+				}
+				candidates = append(candidates, profile)
+			}
+		}
+
+		// Stable sort keeps connector specific profiles ahead at equal levels
+		slices.SortStableFunc(candidates, func(a, b ChargingProfile) int {
+			return cmp.Compare(b.StackLevel, a.StackLevel)
+		})
+
+		for _, profile := range candidates {
+			if limit, ok := profile.limitAt(at, relativeStart, voltage); ok {
+				return limit, true
+			}
+		}
+		return 0, false
+	}
+
+	limit, ok := highest(TxProfile, connectorId)
+	if !ok {
+		limit, ok = highest(TxDefaultProfile, connectorId, chargePointConnectorId)
+	}
+
+	if max, maxOk := highest(ChargePointMaxProfile, chargePointConnectorId); maxOk && (!ok || max < limit) {
+		return max, true
+	}
+
+	return limit, ok
+}
+
+// Set a charging profile on a connector (0 for the whole charge point). Once
+// the charge point accepts it, the profile is saved and the new limit
+// published.
+func (cs *CentralSystem) SetChargingProfile(ctx context.Context, chargePointId string, connectorId int, profile ChargingProfile) error {
+	resp := SetChargingProfileResponse{}
+	err := cs.call16(ctx, chargePointId, ActionSetChargingProfile, SetChargingProfileRequest{
+		ConnectorId:        connectorId,
+		CsChargingProfiles: profile,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != ChargingProfileAccepted {
+		return fmt.Errorf("charging profile %d %w: %s", profile.ChargingProfileId, ErrRejected, resp.Status)
+	}
+
+	if err := cs.Profiles.SetProfile(chargePointId, connectorId, profile); err != nil {
+		return err
+	}
+
+	cs.publishChargingLimits(cs.ChargePoint(chargePointId))
+	return nil
+}
+
+// Clear the charging profiles matching the criteria. The saved profiles are
+// cleared even if the charge point didn't know of any matching profiles, so
+// we don't hang on to limits it has forgotten.
+func (cs *CentralSystem) ClearChargingProfile(ctx context.Context, chargePointId string, criteria ClearChargingProfileRequest) error {
+	resp := ClearChargingProfileResponse{}
+	if err := cs.call16(ctx, chargePointId, ActionClearChargingProfile, criteria, &resp); err != nil {
+		return err
+	}
+
+	if err := cs.Profiles.ClearProfiles(chargePointId, criteria); err != nil {
+		return err
+	}
+
+	cs.publishChargingLimits(cs.ChargePoint(chargePointId))
+	return nil
+}
+
+// Forget the TxProfiles of a transaction that's stopped, as the charge point
+// does: those for the transaction, and any on its connector that don't name
+// one
+func (cs *CentralSystem) clearTxProfiles(cp *ChargePoint, transactionId int, connectorId int) {
+	profiles, err := cs.Profiles.Profiles(cp.ID)
+	if err != nil {
+		log.Printf("Error loading charging profiles for charge point %s: %v", cp.ID, err)
+		return
+	}
+
+	for id, connectorProfiles := range profiles {
+		for _, profile := range connectorProfiles {
+			if profile.ChargingProfilePurpose != TxProfile {
+				continue
+			}
+			if (profile.TransactionId == nil && id != connectorId) || (profile.TransactionId != nil && *profile.TransactionId != transactionId) {
+				continue
+			}
+
+			if err := cs.Profiles.ClearProfiles(cp.ID, ClearChargingProfileRequest{Id: &profile.ChargingProfileId}); err != nil {
+				log.Printf("Error clearing charging profile %d of charge point %s: %v", profile.ChargingProfileId, cp.ID, err)
+			}
+		}
+	}
+}
+
+// Ask the charge point for the schedule it will follow on a connector over
+// the given duration, combining all of its profiles, in amps
+func (cs *CentralSystem) GetCompositeSchedule(ctx context.Context, chargePointId string, connectorId int, duration time.Duration) (GetCompositeScheduleResponse, error) {
+	resp := GetCompositeScheduleResponse{}
+	err := cs.call16(ctx, chargePointId, ActionGetCompositeSchedule, GetCompositeScheduleRequest{
+		ConnectorId:      connectorId,
+		Duration:         int(duration.Seconds()),
+		ChargingRateUnit: ChargingRateUnitAmps,
+	}, &resp)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.Status != GetCompositeScheduleAccepted {
+		return resp, fmt.Errorf("composite schedule %w: %s", ErrRejected, resp.Status)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,246 @@
+package ocpp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Connect a charge point with a single connector, ready for smart charging
+func connectSmartChargingSimulator(t *testing.T) (*CentralSystem, *RecordingPublisher, *ChargePointSimulator) {
+	cs, publisher, server := newTestCentralSystem(t)
+	cs.ChargingLimitPublisher = publisher
+
+	sim := NewChargePointSimulator(t, server, "CP001")
+	t.Cleanup(sim.Close)
+
+	sim.Call(ActionBootNotification, BootNotificationRequest{ChargePointVendor: "United Chargers", ChargePointModel: "Grizzl-E Smart"}, &BootNotificationResponse{})
+	sim.Call(ActionStatusNotification, StatusNotificationRequest{ConnectorId: 1, Status: "Available", ErrorCode: "NoError"}, &StatusNotificationResponse{})
+
+	return cs, publisher, sim
+}
+
+func TestSetAndClearChargingProfile(t *testing.T) {
+	cs, publisher, sim := connectSmartChargingSimulator(t)
+
+	var set SetChargingProfileRequest
+	sim.Handle(ActionSetChargingProfile, func(payload json.RawMessage) any {
+		require.NoError(t, json.Unmarshal(payload, &set))
+		return SetChargingProfileResponse{Status: ChargingProfileAccepted}
+	})
+
+	var clear ClearChargingProfileRequest
+	sim.Handle(ActionClearChargingProfile, func(payload json.RawMessage) any {
+		require.NoError(t, json.Unmarshal(payload, &clear))
+		return ClearChargingProfileResponse{Status: ClearChargingProfileAccepted}
+	})
+
+	err := cs.SetChargingProfile(context.Background(), "CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16)))
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, set.ConnectorId)
+	assert.Equal(t, TxDefaultProfile, set.CsChargingProfiles.ChargingProfilePurpose)
+	assert.Equal(t, ChargingRateUnitAmps, set.CsChargingProfiles.ChargingSchedule.ChargingRateUnit)
+
+	profiles, err := cs.Profiles.Profiles("CP001")
+	require.NoError(t, err)
+	assert.Len(t, profiles[1], 1, "Accepted profile should be saved")
+
+	limit, ok := publisher.Limit("CP001", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 16.0, limit)
+
+	profileId := 1
+	err = cs.ClearChargingProfile(context.Background(), "CP001", ClearChargingProfileRequest{Id: &profileId})
+	require.NoError(t, err)
+
+	require.NotNil(t, clear.Id)
+	assert.Equal(t, 1, *clear.Id)
+
+	profiles, err = cs.Profiles.Profiles("CP001")
+	require.NoError(t, err)
+	assert.Empty(t, profiles)
+
+	_, ok = publisher.Limit("CP001", 1)
+	assert.False(t, ok, "Limit should be cleared with the profile")
+}
+
+func TestChargingLimitFollowsSchedule(t *testing.T) {
+	cs, publisher, sim := connectSmartChargingSimulator(t)
+	sim.Handle(ActionSetChargingProfile, func(payload json.RawMessage) any {
+		return SetChargingProfileResponse{Status: ChargingProfileAccepted}
+	})
+
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+	var elapsed atomic.Int64
+	cs.Now = func() time.Time { return start.Add(time.Duration(elapsed.Load())) }
+
+	started := StartTransactionResponse{}
+	sim.Call(ActionStartTransaction, StartTransactionRequest{ConnectorId: 1, IdTag: "TAG1", Timestamp: start}, &started)
+	require.NoError(t, cs.SetChargingProfile(context.Background(), "CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16), Limit(30*time.Minute, 24))))
+
+	limit, _ := publisher.Limit("CP001", 1)
+	assert.Equal(t, 16.0, limit)
+
+	// The next period starts without anything being sent to the charge
+	// point, and is picked up on its next heartbeat
+	elapsed.Store(int64(40 * time.Minute))
+	sim.Call(ActionHeartbeat, HeartbeatRequest{}, &HeartbeatResponse{})
+
+	limit, _ = publisher.Limit("CP001", 1)
+	assert.Equal(t, 24.0, limit)
+}
+
+func TestStopTransactionClearsTxProfiles(t *testing.T) {
+	cs, publisher, sim := connectSmartChargingSimulator(t)
+	sim.Handle(ActionSetChargingProfile, func(payload json.RawMessage) any {
+		return SetChargingProfileResponse{Status: ChargingProfileAccepted}
+	})
+
+	started := StartTransactionResponse{}
+	sim.Call(ActionStartTransaction, StartTransactionRequest{ConnectorId: 1, IdTag: "TAG1", Timestamp: time.Now()}, &started)
+	require.NoError(t, cs.SetChargingProfile(context.Background(), "CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16))))
+	require.NoError(t, cs.SetChargingProfile(context.Background(), "CP001", 1, NewTxProfile(2, started.TransactionId, 0, Limit(0, 10))))
+
+	limit, _ := publisher.Limit("CP001", 1)
+	assert.Equal(t, 10.0, limit)
+
+	sim.Call(ActionStopTransaction, StopTransactionRequest{TransactionId: started.TransactionId, Timestamp: time.Now(), Reason: "Local"}, &StopTransactionResponse{})
+
+	profiles, err := cs.Profiles.Profiles("CP001")
+	require.NoError(t, err)
+	require.Len(t, profiles[1], 1, "The transaction's profile should be cleared when it stops")
+	assert.Equal(t, TxDefaultProfile, profiles[1][0].ChargingProfilePurpose)
+
+	limit, _ = publisher.Limit("CP001", 1)
+	assert.Equal(t, 16.0, limit)
+}
+
+func TestSetChargingProfileRejected(t *testing.T) {
+	cs, publisher, sim := connectSmartChargingSimulator(t)
+
+	sim.Handle(ActionSetChargingProfile, func(payload json.RawMessage) any {
+		return SetChargingProfileResponse{Status: ChargingProfileRejected}
+	})
+
+	err := cs.SetChargingProfile(context.Background(), "CP001", 1, NewTxDefaultProfile(1, 0, Limit(0, 16)))
+	assert.ErrorIs(t, err, ErrRejected)
+
+	profiles, err := cs.Profiles.Profiles("CP001")
+	require.NoError(t, err)
+	assert.Empty(t, profiles, "Rejected profile should not be saved")
+
+	_, ok := publisher.Limit("CP001", 1)
+	assert.False(t, ok)
+}
+
+func TestGetCompositeSchedule(t *testing.T) {
+	cs, _, sim := connectSmartChargingSimulator(t)
+
+	var req GetCompositeScheduleRequest
+	sim.Handle(ActionGetCompositeSchedule, func(payload json.RawMessage) any {
+		require.NoError(t, json.Unmarshal(payload, &req))
+		return GetCompositeScheduleResponse{
+			Status: GetCompositeScheduleAccepted,
+			ChargingSchedule: &ChargingSchedule{
+				ChargingRateUnit:       ChargingRateUnitAmps,
+				ChargingSchedulePeriod: []ChargingSchedulePeriod{Limit(0, 24)},
+			},
+		}
+	})
+
+	schedule, err := cs.GetCompositeSchedule(context.Background(), "CP001", 1, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, req.ConnectorId)
+	assert.Equal(t, 3600, req.Duration)
+	require.NotNil(t, schedule.ChargingSchedule)
+	assert.Equal(t, 24.0, schedule.ChargingSchedule.ChargingSchedulePeriod[0].Limit)
+}
+
+func TestCallErrors(t *testing.T) {
+	cs, _, sim := connectSmartChargingSimulator(t)
+
+	t.Run("NotConnected", func(t *testing.T) {
+		err := cs.SetChargingProfile(context.Background(), "CP999", 1, NewTxDefaultProfile(1, 0, Limit(0, 16)))
+		assert.ErrorIs(t, err, ErrNotConnected)
+	})
+
+	t.Run("CallError", func(t *testing.T) {
+		// The simulator answers actions it has no handler for with NotImplemented
+		_, err := cs.GetCompositeSchedule(context.Background(), "CP001", 1, time.Hour)
+
+		var callErr *CallError
+		require.True(t, errors.As(err, &callErr))
+		assert.Equal(t, ErrorNotImplemented, callErr.Code)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		cs.CallTimeout = 50 * time.Millisecond
+		sim.Handle(ActionClearChargingProfile, func(payload json.RawMessage) any {
+			time.Sleep(200 * time.Millisecond)
+			return ClearChargingProfileResponse{Status: ClearChargingProfileAccepted}
+		})
+
+		err := cs.ClearChargingProfile(context.Background(), "CP001", ClearChargingProfileRequest{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestEffectiveLimit(t *testing.T) {
+	now := time.Date(2025, 6, 1, 18, 30, 0, 0, time.UTC)
+	transaction := &activeTransaction{id: 42, startAt: now.Add(-time.Hour)}
+	threePhases := 3
+
+	peak := NewDailyTxDefaultProfile(5, 1, time.Date(2025, 1, 1, 17, 0, 0, 0, time.UTC), Limit(0, 8), Limit(4*time.Hour, 32))
+	expired := NewTxDefaultProfile(6, 5, Limit(0, 6))
+	validTo := now.Add(-time.Minute)
+	expired.ValidTo = &validTo
+
+	watts := NewTxDefaultProfile(7, 0, ChargingSchedulePeriod{Limit: 7200, NumberPhases: &threePhases})
+	watts.ChargingSchedule.ChargingRateUnit = ChargingRateUnitWatts
+
+	singlePhaseWatts := NewTxDefaultProfile(9, 0, ChargingSchedulePeriod{Limit: 7200})
+	singlePhaseWatts.ChargingSchedule.ChargingRateUnit = ChargingRateUnitWatts
+
+	maxProfile := NewTxDefaultProfile(8, 0, Limit(0, 20))
+	maxProfile.ChargingProfilePurpose = ChargePointMaxProfile
+
+	tests := []struct {
+		name        string
+		profiles    map[int][]ChargingProfile
+		transaction *activeTransaction
+		limit       float64
+		ok          bool
+	}{
+		{"NoProfiles", map[int][]ChargingProfile{}, nil, 0, false},
+		{"TxDefault", map[int][]ChargingProfile{1: {NewTxDefaultProfile(1, 0, Limit(0, 16))}}, nil, 16, true},
+		{"ChargePointWideTxDefault", map[int][]ChargingProfile{0: {NewTxDefaultProfile(1, 0, Limit(0, 16))}}, nil, 16, true},
+		{"RelativePeriodsFromTransactionStart", map[int][]ChargingProfile{1: {NewTxDefaultProfile(1, 0, Limit(0, 16), Limit(30*time.Minute, 24))}}, transaction, 24, true},
+		{"HighestStackLevel", map[int][]ChargingProfile{1: {NewTxDefaultProfile(1, 0, Limit(0, 16)), NewTxDefaultProfile(2, 3, Limit(0, 10))}}, nil, 10, true},
+		{"ConnectorOverridesChargePoint", map[int][]ChargingProfile{0: {NewTxDefaultProfile(1, 0, Limit(0, 16))}, 1: {NewTxDefaultProfile(2, 0, Limit(0, 12))}}, nil, 12, true},
+		{"TxProfileForTransaction", map[int][]ChargingProfile{1: {NewTxDefaultProfile(1, 0, Limit(0, 16)), NewTxProfile(2, 42, 0, Limit(0, 30))}}, transaction, 30, true},
+		{"TxProfileForOtherTransaction", map[int][]ChargingProfile{1: {NewTxDefaultProfile(1, 0, Limit(0, 16)), NewTxProfile(2, 7, 0, Limit(0, 30))}}, transaction, 16, true},
+		{"ChargePointMaxCaps", map[int][]ChargingProfile{0: {maxProfile}, 1: {NewTxDefaultProfile(1, 0, Limit(0, 32))}}, nil, 20, true},
+		{"ChargePointMaxAlone", map[int][]ChargingProfile{0: {maxProfile}}, nil, 20, true},
+		{"DailyRecurring", map[int][]ChargingProfile{1: {peak}}, nil, 8, true},
+		{"ExpiredIgnored", map[int][]ChargingProfile{1: {NewTxDefaultProfile(1, 0, Limit(0, 16)), expired}}, nil, 16, true},
+		{"WattsConverted", map[int][]ChargingProfile{1: {watts}}, nil, 10, true},
+		{"WattsSinglePhaseByDefault", map[int][]ChargingProfile{1: {singlePhaseWatts}}, nil, 30, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, ok := effectiveLimit(tt.profiles, 1, tt.transaction, now, 240)
+
+			assert.Equal(t, tt.ok, ok)
+			assert.InDelta(t, tt.limit, limit, 0.001)
+		})
+	}
+}
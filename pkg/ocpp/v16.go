@@ -0,0 +1,139 @@
+package ocpp
+
+import "time"
+
+/**
+* OCPP 1.6J message payloads for the Core profile messages sent by a charge
+* point. Field names follow the OCPP 1.6 JSON schemas.
+ */
+
+// WebSocket subprotocol for OCPP 1.6J
+const SubprotocolOCPP16 = "ocpp1.6"
+
+// Core profile actions initiated by the charge point
+const (
+	ActionBootNotification   = "BootNotification"
+	ActionHeartbeat          = "Heartbeat"
+	ActionStatusNotification = "StatusNotification"
+	ActionStartTransaction   = "StartTransaction"
+	ActionStopTransaction    = "StopTransaction"
+	ActionMeterValues        = "MeterValues"
+)
+
+// BootNotification.conf status values
+const (
+	RegistrationAccepted = "Accepted"
+	RegistrationPending  = "Pending"
+	RegistrationRejected = "Rejected"
+)
+
+// IdTagInfo status values
+const (
+	AuthorizationAccepted     = "Accepted"
+	AuthorizationBlocked      = "Blocked"
+	AuthorizationExpired      = "Expired"
+	AuthorizationInvalid      = "Invalid"
+	AuthorizationConcurrentTx = "ConcurrentTx"
+)
+
+// SampledValue measurands that map onto connect.MeterValues
+const (
+	MeasurandCurrentImport              = "Current.Import"
+	MeasurandCurrentOffered             = "Current.Offered"
+	MeasurandEnergyActiveImportRegister = "Energy.Active.Import.Register"
+	MeasurandPowerActiveImport          = "Power.Active.Import"
+	MeasurandSoC                        = "SoC"
+	MeasurandTemperature                = "Temperature"
+	MeasurandVoltage                    = "Voltage"
+)
+
+type BootNotificationRequest struct {
+	ChargePointVendor       string `json:"chargePointVendor"`
+	ChargePointModel        string `json:"chargePointModel"`
+	ChargePointSerialNumber string `json:"chargePointSerialNumber,omitempty"`
+	ChargeBoxSerialNumber   string `json:"chargeBoxSerialNumber,omitempty"`
+	FirmwareVersion         string `json:"firmwareVersion,omitempty"`
+	Iccid                   string `json:"iccid,omitempty"`
+	Imsi                    string `json:"imsi,omitempty"`
+	MeterType               string `json:"meterType,omitempty"`
+	MeterSerialNumber       string `json:"meterSerialNumber,omitempty"`
+}
+
+type BootNotificationResponse struct {
+	Status      string    `json:"status"`
+	CurrentTime time.Time `json:"currentTime"`
+	Interval    int       `json:"interval"`
+}
+
+type HeartbeatRequest struct{}
+
+type HeartbeatResponse struct {
+	CurrentTime time.Time `json:"currentTime"`
+}
+
+type StatusNotificationRequest struct {
+	ConnectorId     int        `json:"connectorId"`
+	ErrorCode       string     `json:"errorCode"`
+	Info            string     `json:"info,omitempty"`
+	Status          string     `json:"status"`
+	Timestamp       *time.Time `json:"timestamp,omitempty"`
+	VendorId        string     `json:"vendorId,omitempty"`
+	VendorErrorCode string     `json:"vendorErrorCode,omitempty"`
+}
+
+type StatusNotificationResponse struct{}
+
+type IdTagInfo struct {
+	ExpiryDate  *time.Time `json:"expiryDate,omitempty"`
+	ParentIdTag string     `json:"parentIdTag,omitempty"`
+	Status      string     `json:"status"`
+}
+
+type StartTransactionRequest struct {
+	ConnectorId   int       `json:"connectorId"`
+	IdTag         string    `json:"idTag"`
+	MeterStart    int       `json:"meterStart"`
+	ReservationId *int      `json:"reservationId,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type StartTransactionResponse struct {
+	IdTagInfo     IdTagInfo `json:"idTagInfo"`
+	TransactionId int       `json:"transactionId"`
+}
+
+type StopTransactionRequest struct {
+	IdTag           string       `json:"idTag,omitempty"`
+	MeterStop       int          `json:"meterStop"`
+	Timestamp       time.Time    `json:"timestamp"`
+	TransactionId   int          `json:"transactionId"`
+	Reason          string       `json:"reason,omitempty"`
+	TransactionData []MeterValue `json:"transactionData,omitempty"`
+}
+
+type StopTransactionResponse struct {
+	IdTagInfo *IdTagInfo `json:"idTagInfo,omitempty"`
+}
+
+type MeterValuesRequest struct {
+	ConnectorId   int          `json:"connectorId"`
+	TransactionId *int         `json:"transactionId,omitempty"`
+	MeterValue    []MeterValue `json:"meterValue"`
+}
+
+type MeterValuesResponse struct{}
+
+type MeterValue struct {
+	Timestamp    time.Time      `json:"timestamp"`
+	SampledValue []SampledValue `json:"sampledValue"`
+}
+
+type SampledValue struct {
+	Value     string `json:"value"`
+	Context   string `json:"context,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Measurand string `json:"measurand,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+}
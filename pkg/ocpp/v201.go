@@ -0,0 +1,241 @@
+package ocpp
+
+import "time"
+
+/**
+* OCPP 2.0.1 message payloads for the messages a charging station sends to
+* report its state, and the device model report we ask it for. Field names
+* follow the OCPP 2.0.1 JSON schemas.
+*
+* OCPP 2.0.1 calls the charge point a charging station, made up of EVSEs
+* that each have one or more connectors. Grizzl-E chargers have a single
+* connector per EVSE, so an EVSE maps onto a connect.Connector.
+ */
+
+// WebSocket subprotocol for OCPP 2.0.1
+const SubprotocolOCPP201 = "ocpp2.0.1"
+
+// OCPP 2.0.1 actions. BootNotification, Heartbeat, StatusNotification,
+// Authorize and MeterValues share their names with OCPP 1.6.
+const (
+	ActionTransactionEvent = "TransactionEvent"
+	ActionNotifyEvent      = "NotifyEvent"
+	ActionNotifyReport     = "NotifyReport"
+	ActionGetBaseReport    = "GetBaseReport"
+)
+
+// TransactionEvent eventType values
+const (
+	TransactionEventStarted = "Started"
+	TransactionEventUpdated = "Updated"
+	TransactionEventEnded   = "Ended"
+)
+
+// GetBaseReport reportBase values
+const (
+	ReportBaseConfigurationInventory = "ConfigurationInventory"
+	ReportBaseFullInventory          = "FullInventory"
+	ReportBaseSummaryInventory       = "SummaryInventory"
+)
+
+// Device model components and variables that map onto connect.Station
+const (
+	ComponentChargingStation = "ChargingStation"
+	ComponentEVSE            = "EVSE"
+	ComponentConnector       = "Connector"
+
+	VariableAvailabilityState = "AvailabilityState"
+	VariablePower             = "Power"
+	VariableConnectorType     = "ConnectorType"
+	VariableProblem           = "Problem"
+)
+
+// VariableAttribute types
+const (
+	AttributeActual = "Actual"
+	AttributeTarget = "Target"
+	AttributeMinSet = "MinSet"
+	AttributeMaxSet = "MaxSet"
+)
+
+type ChargingStation struct {
+	SerialNumber    string `json:"serialNumber,omitempty"`
+	Model           string `json:"model"`
+	VendorName      string `json:"vendorName"`
+	FirmwareVersion string `json:"firmwareVersion,omitempty"`
+}
+
+type BootNotificationRequest201 struct {
+	ChargingStation ChargingStation `json:"chargingStation"`
+	Reason          string          `json:"reason"`
+}
+
+type BootNotificationResponse201 struct {
+	CurrentTime time.Time `json:"currentTime"`
+	Interval    int       `json:"interval"`
+	Status      string    `json:"status"`
+}
+
+type StatusNotificationRequest201 struct {
+	Timestamp       time.Time `json:"timestamp"`
+	ConnectorStatus string    `json:"connectorStatus"`
+	EvseId          int       `json:"evseId"`
+	ConnectorId     int       `json:"connectorId"`
+}
+
+type StatusNotificationResponse201 struct{}
+
+type IdToken struct {
+	IdToken string `json:"idToken"`
+	Type    string `json:"type"`
+}
+
+type IdTokenInfo struct {
+	Status              string     `json:"status"`
+	CacheExpiryDateTime *time.Time `json:"cacheExpiryDateTime,omitempty"`
+	GroupIdToken        *IdToken   `json:"groupIdToken,omitempty"`
+}
+
+type AuthorizeRequest201 struct {
+	IdToken IdToken `json:"idToken"`
+}
+
+type AuthorizeResponse201 struct {
+	IdTokenInfo IdTokenInfo `json:"idTokenInfo"`
+}
+
+type EVSE struct {
+	Id          int  `json:"id"`
+	ConnectorId *int `json:"connectorId,omitempty"`
+}
+
+type TransactionInfo struct {
+	TransactionId     string `json:"transactionId"`
+	ChargingState     string `json:"chargingState,omitempty"`
+	TimeSpentCharging *int   `json:"timeSpentCharging,omitempty"`
+	StoppedReason     string `json:"stoppedReason,omitempty"`
+	RemoteStartId     *int   `json:"remoteStartId,omitempty"`
+}
+
+type TransactionEventRequest struct {
+	EventType          string          `json:"eventType"`
+	Timestamp          time.Time       `json:"timestamp"`
+	TriggerReason      string          `json:"triggerReason"`
+	SeqNo              int             `json:"seqNo"`
+	Offline            bool            `json:"offline,omitempty"`
+	NumberOfPhasesUsed *int            `json:"numberOfPhasesUsed,omitempty"`
+	CableMaxCurrent    *int            `json:"cableMaxCurrent,omitempty"`
+	ReservationId      *int            `json:"reservationId,omitempty"`
+	TransactionInfo    TransactionInfo `json:"transactionInfo"`
+	IdToken            *IdToken        `json:"idToken,omitempty"`
+	Evse               *EVSE           `json:"evse,omitempty"`
+	MeterValue         []MeterValue201 `json:"meterValue,omitempty"`
+}
+
+type TransactionEventResponse struct {
+	TotalCost   *float64     `json:"totalCost,omitempty"`
+	IdTokenInfo *IdTokenInfo `json:"idTokenInfo,omitempty"`
+}
+
+type MeterValuesRequest201 struct {
+	EvseId     int             `json:"evseId"`
+	MeterValue []MeterValue201 `json:"meterValue"`
+}
+
+type MeterValuesResponse201 struct{}
+
+type MeterValue201 struct {
+	Timestamp    time.Time         `json:"timestamp"`
+	SampledValue []SampledValue201 `json:"sampledValue"`
+}
+
+// Unlike OCPP 1.6, values are numbers, with the unit scaled by a power of 10
+type SampledValue201 struct {
+	Value         float64        `json:"value"`
+	Context       string         `json:"context,omitempty"`
+	Measurand     string         `json:"measurand,omitempty"`
+	Phase         string         `json:"phase,omitempty"`
+	Location      string         `json:"location,omitempty"`
+	UnitOfMeasure *UnitOfMeasure `json:"unitOfMeasure,omitempty"`
+}
+
+type UnitOfMeasure struct {
+	Unit       string `json:"unit,omitempty"`
+	Multiplier int    `json:"multiplier,omitempty"`
+}
+
+// A device model component, e.g. an EVSE or connector
+type Component struct {
+	Name     string `json:"name"`
+	Instance string `json:"instance,omitempty"`
+	Evse     *EVSE  `json:"evse,omitempty"`
+}
+
+type Variable struct {
+	Name     string `json:"name"`
+	Instance string `json:"instance,omitempty"`
+}
+
+type EventData struct {
+	EventId               int       `json:"eventId"`
+	Timestamp             time.Time `json:"timestamp"`
+	Trigger               string    `json:"trigger"`
+	Cause                 *int      `json:"cause,omitempty"`
+	ActualValue           string    `json:"actualValue"`
+	TechCode              string    `json:"techCode,omitempty"`
+	TechInfo              string    `json:"techInfo,omitempty"`
+	Cleared               bool      `json:"cleared,omitempty"`
+	TransactionId         string    `json:"transactionId,omitempty"`
+	VariableMonitoringId  *int      `json:"variableMonitoringId,omitempty"`
+	EventNotificationType string    `json:"eventNotificationType"`
+	Component             Component `json:"component"`
+	Variable              Variable  `json:"variable"`
+}
+
+type NotifyEventRequest struct {
+	GeneratedAt time.Time   `json:"generatedAt"`
+	Tbc         bool        `json:"tbc,omitempty"`
+	SeqNo       int         `json:"seqNo"`
+	EventData   []EventData `json:"eventData"`
+}
+
+type NotifyEventResponse struct{}
+
+type GetBaseReportRequest struct {
+	RequestId  int    `json:"requestId"`
+	ReportBase string `json:"reportBase"`
+}
+
+type GetBaseReportResponse struct {
+	Status string `json:"status"`
+}
+
+type VariableAttribute struct {
+	Type       string `json:"type,omitempty"`
+	Value      string `json:"value,omitempty"`
+	Mutability string `json:"mutability,omitempty"`
+}
+
+type VariableCharacteristics struct {
+	Unit     string   `json:"unit,omitempty"`
+	DataType string   `json:"dataType"`
+	MinLimit *float64 `json:"minLimit,omitempty"`
+	MaxLimit *float64 `json:"maxLimit,omitempty"`
+}
+
+type ReportData struct {
+	Component               Component                `json:"component"`
+	Variable                Variable                 `json:"variable"`
+	VariableAttribute       []VariableAttribute      `json:"variableAttribute"`
+	VariableCharacteristics *VariableCharacteristics `json:"variableCharacteristics,omitempty"`
+}
+
+type NotifyReportRequest struct {
+	RequestId   int          `json:"requestId"`
+	GeneratedAt time.Time    `json:"generatedAt"`
+	Tbc         bool         `json:"tbc,omitempty"`
+	SeqNo       int          `json:"seqNo"`
+	ReportData  []ReportData `json:"reportData,omitempty"`
+}
+
+type NotifyReportResponse struct{}